@@ -0,0 +1,37 @@
+// Command mocksora runs a fake Sora backend for local development.
+//
+//	go run ./cmd/mocksora -addr 127.0.0.1:8090 -script mock.json
+//
+// Point the server at it with sora.base_url = "http://<addr>/backend" and
+// sora.sentinel_url = "http://<addr>/backend-api/sentinel/req". The optional script is a JSON
+// mocksora.Options, e.g. {"polls": 5, "scenarios": [{"match": "fail", "fail": "policy"}]}.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"soranow/internal/mocksora"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8090", "Listen address")
+	script := flag.String("script", "", "Path to a JSON script of polls and scenarios")
+	flag.Parse()
+
+	var opts *mocksora.Options
+	if *script != "" {
+		var err error
+		if opts, err = mocksora.LoadOptions(*script); err != nil {
+			log.Fatalf("Failed to load script: %v", err)
+		}
+		log.Printf("Loaded %d scenarios from %s", len(opts.Scenarios), *script)
+	}
+
+	log.Printf("Mock Sora backend: http://%s%s", *addr, mocksora.BasePath)
+	log.Printf("Mock sentinel:     http://%s%s", *addr, mocksora.SentinelPath)
+	if err := http.ListenAndServe(*addr, mocksora.NewServer(opts)); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,42 +25,7 @@ func main() {
 	configPath := flag.String("config", "config/setting.toml", "Path to config file")
 	flag.Parse()
 
-	// Load configuration
-	cfg, err := config.LoadConfig(*configPath)
-	if err != nil {
-		// Try default paths
-		defaultPaths := []string{
-			"config/setting.toml",
-			"../config/setting.toml",
-			"../../config/setting.toml",
-		}
-		for _, p := range defaultPaths {
-			cfg, err = config.LoadConfig(p)
-			if err == nil {
-				break
-			}
-		}
-		if err != nil {
-			log.Printf("Warning: Could not load config file, using defaults: %v", err)
-			cfg = &config.Config{
-				Global: config.GlobalConfig{
-					APIKey:        "han1234",
-					AdminUsername: "admin",
-					AdminPassword: "admin",
-				},
-				Server: config.ServerConfig{
-					Host: "0.0.0.0",
-					Port: 8000,
-				},
-				Cache: config.CacheConfig{
-					Enabled: false,
-					Timeout: 600,
-				},
-			}
-		}
-	}
-
-	// Initialize database
+	// Initialize database first: admin overrides stored there are the top config layer
 	dbPath := "data/soranow.db"
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
@@ -65,25 +35,62 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	defer db.Close()
 
 	if err := db.InitSchema(); err != nil {
 		log.Fatalf("Failed to initialize database schema: %v", err)
 	}
 
+	// Load configuration: defaults < TOML file < SORANOW_* env vars < admin overrides
+	manager := config.NewManager(resolveConfigPath(*configPath), db)
+	if err := services.MigrateSystemConfigOverrides(db); err != nil {
+		log.Fatalf("Failed to migrate admin settings: %v", err)
+	}
+	if err := manager.Reload(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := services.SeedPresets(db); err != nil {
+		log.Printf("Default templates and styles not stored: %v", err)
+	}
+	cfg := manager.Get()
+	log.Printf("Config loaded from %s", manager.Path())
+
+	// Initialize clock so daily counters and reports use the configured timezone
+	clock := services.NewClock(cfg.Timezone.TimezoneOffset)
+	services.SetDefaultClock(clock)
+	log.Printf("Timezone: UTC%+d (today is %s)", clock.OffsetHours(), clock.Today())
+
 	// Initialize services
 	loadBalancer := services.NewLoadBalancer()
 	concurrencyManager := services.NewConcurrencyManager()
 	tokenManager := services.NewTokenManager(db, loadBalancer, concurrencyManager)
 
+	// Initialize proxy manager shared by all outbound Sora traffic
+	proxyManager := services.NewProxyManager("data")
+	if err := proxyManager.SetBindingStore(db); err != nil {
+		log.Printf("Proxy bindings not restored: %v", err)
+	}
+	tokenManager.SetProxyManager(proxyManager)
+
+	// TLS sessions shared by token management and the Sora upstream
+	sessionManager := services.NewSessionManager(cfg.Sora.Timeout)
+
+	// Prompt pre-check applied before a token is picked
+	moderator := services.NewModerator()
+
+	// Initialize webhook dispatcher for task and token health notifications
+	webhooks := services.NewWebhookDispatcher(db)
+	tokenManager.SetWebhookDispatcher(webhooks)
+
 	// Initialize file cache
 	cacheDir := "data/cache"
 	os.MkdirAll(cacheDir, 0755)
-	cacheBaseURL := cfg.Cache.BaseURL
-	if cacheBaseURL == "" {
-		cacheBaseURL = fmt.Sprintf("http://%s:%d", cfg.Server.Host, cfg.Server.Port)
+	fileCache := services.NewFileCache(cacheDir, cfg.Cache.Timeout, cacheBaseURL(cfg))
+
+	// Character source clips are kept apart from the cache and never expire
+	var characterArchive *services.FileCache
+	if cfg.Character.ArchiveDir != "" {
+		characterArchive = services.NewFileCache(cfg.Character.ArchiveDir, 0, "")
 	}
-	fileCache := services.NewFileCache(cacheDir, cfg.Cache.Timeout, cacheBaseURL)
 
 	// Initialize watermark remover
 	watermarkRemover := services.NewWatermarkRemover(
@@ -92,21 +99,11 @@ func main() {
 		cfg.WatermarkFree.CustomParseToken,
 		cfg.WatermarkFree.FallbackOnFailure,
 	)
+	watermarkRemover.SetProxyManager(proxyManager)
 
 	// Initialize scheduler for background tasks
 	scheduler := services.NewScheduler()
 
-	// Schedule cache cleanup (every 10 minutes)
-	if cfg.Cache.Enabled {
-		scheduler.AddTask("cache_cleanup", 10*time.Minute, func() {
-			cleaned := fileCache.Cleanup()
-			if cleaned > 0 {
-				log.Printf("Cache cleanup: removed %d expired files", cleaned)
-			}
-		})
-		log.Println("Cache cleanup task scheduled")
-	}
-
 	// Schedule cooldown cleanup (every minute)
 	scheduler.AddTask("cooldown_cleanup", 1*time.Minute, func() {
 		cleared, _ := tokenManager.ClearExpiredCooldowns()
@@ -115,16 +112,105 @@ func main() {
 		}
 	})
 
-	// Schedule error token check (every 5 minutes)
-	if cfg.Admin.ErrorBanThreshold > 0 {
-		scheduler.AddTask("error_token_check", 5*time.Minute, func() {
-			disabled, _ := tokenManager.CheckAndDisableErrorTokens(cfg.Admin.ErrorBanThreshold)
-			if disabled > 0 {
-				log.Printf("Disabled %d tokens due to consecutive errors", disabled)
+	// Reset daily token counters at midnight in the configured timezone
+	scheduleDailyReset := func(clock *services.Clock) {
+		scheduler.AddDailyTask("daily_counter_reset", clock, func() {
+			reset, err := tokenManager.ResetDailyCounters()
+			if err != nil {
+				log.Printf("Daily counter reset failed: %v", err)
+				return
 			}
+			log.Printf("Daily counter reset: %d tokens rolled over to %s", reset, clock.Today())
 		})
-		log.Println("Error token check task scheduled")
 	}
+	scheduleDailyReset(clock)
+
+	// Schedule error token check (every 5 minutes); the threshold is read on each run
+	scheduler.AddTask("error_token_check", 5*time.Minute, func() {
+		threshold := manager.Get().Admin.ErrorBanThreshold
+		if threshold <= 0 {
+			return
+		}
+		disabled, _ := tokenManager.CheckAndDisableErrorTokens(threshold)
+		if disabled > 0 {
+			log.Printf("Disabled %d tokens due to consecutive errors", disabled)
+		}
+	})
+
+	// Apply the configuration to the subsystems now and after every reload
+	applyConfig := func(cfg *config.Config) {
+		if err := services.SyncSystemConfig(db, cfg); err != nil {
+			log.Printf("Failed to sync system config: %v", err)
+		}
+
+		if offset := cfg.Timezone.TimezoneOffset; offset != services.DefaultClock().OffsetHours() {
+			clock := services.NewClock(offset)
+			services.SetDefaultClock(clock)
+			scheduleDailyReset(clock)
+			log.Printf("Timezone changed to UTC%+d", offset)
+		}
+
+		// Sora upstream; sessions are recreated with a new timeout on next use
+		sessionManager.SetTimeout(cfg.Sora.Timeout)
+		tokenManager.SetBaseURL(cfg.Sora.BaseURL)
+		services.DefaultPowPool().SetWorkers(cfg.Sora.PowWorkers)
+
+		// Prompt moderation; a broken rules file keeps the previous rules
+		if err := moderator.Configure(cfg.Moderation); err != nil {
+			log.Printf("Moderation rules not applied: %v", err)
+		}
+
+		// TLS profile and user agents; sessions are recreated with the new profile on next use
+		fp, err := services.NewFingerprint(cfg.Fingerprint.TLSProfile, cfg.Fingerprint.UserAgents,
+			cfg.Fingerprint.AppUserAgents, cfg.Fingerprint.HeaderOrder)
+		if err != nil {
+			log.Printf("Fingerprint not applied: %v", err)
+		} else {
+			services.SetDefaultFingerprint(fp)
+		}
+
+		fileCache.Configure(cfg.Cache.Timeout, cacheBaseURL(cfg))
+		watermarkRemover.Configure(
+			cfg.WatermarkFree.ParseMethod,
+			cfg.WatermarkFree.CustomParseURL,
+			cfg.WatermarkFree.CustomParseToken,
+			cfg.WatermarkFree.FallbackOnFailure,
+		)
+
+		// Proxy pool, with periodic health probing while it is enabled
+		proxyManager.SetEnabled(cfg.Proxy.ProxyEnabled)
+		proxyManager.SetSingleProxy(cfg.Proxy.ProxyURL)
+		proxyManager.SetHealthPolicy(cfg.Proxy.MaxFailures, time.Duration(cfg.Proxy.EjectDuration)*time.Second, cfg.Proxy.HealthCheckURL)
+		proxyManager.SetPoolFile(cfg.Proxy.PoolFile)
+		proxyManager.SetPoolEnabled(cfg.Proxy.PoolEnabled)
+		if cfg.Proxy.ProxyEnabled && cfg.Proxy.PoolEnabled {
+			scheduler.AddTask("proxy_health_check", time.Duration(cfg.Proxy.HealthCheckInterval)*time.Second, func() {
+				healthy := proxyManager.CheckHealth(context.Background())
+				log.Printf("Proxy health check: %d/%d healthy", healthy, proxyManager.GetPoolCount())
+			})
+		} else {
+			scheduler.RemoveTask("proxy_health_check")
+		}
+
+		webhooks.SetHTTPClient(&http.Client{Timeout: time.Duration(cfg.Webhook.Timeout) * time.Second})
+		webhooks.SetRetryPolicy(cfg.Webhook.MaxAttempts, time.Duration(cfg.Webhook.RetryBackoff)*time.Second)
+
+		// Cache cleanup (every 10 minutes) only while caching is enabled
+		if cfg.Cache.Enabled {
+			if !scheduler.IsRunning("cache_cleanup") {
+				scheduler.AddTask("cache_cleanup", 10*time.Minute, func() {
+					cleaned := fileCache.Cleanup()
+					if cleaned > 0 {
+						log.Printf("Cache cleanup: removed %d expired files", cleaned)
+					}
+				})
+			}
+		} else {
+			scheduler.RemoveTask("cache_cleanup")
+		}
+	}
+	applyConfig(cfg)
+	manager.Subscribe(applyConfig)
 
 	// Load active tokens into load balancer
 	tokenManager.RefreshLoadBalancer()
@@ -133,21 +219,171 @@ func main() {
 	// Log service status
 	log.Printf("File cache: enabled=%v, dir=%s", cfg.Cache.Enabled, cacheDir)
 	log.Printf("Watermark remover: enabled=%v, method=%s", watermarkRemover.IsEnabled(), cfg.WatermarkFree.ParseMethod)
+	log.Printf("Sora backend: %s (timeout %ds, %d retries)", cfg.Sora.BaseURL, cfg.Sora.Timeout, cfg.Sora.MaxRetries)
+	log.Printf("TLS profile: %s (%d user agents)", services.DefaultFingerprint().Profile, len(services.DefaultFingerprint().UserAgents))
+	if enabled, poolEnabled, _, count := proxyManager.GetConfig(); enabled && poolEnabled {
+		log.Printf("Proxy pool: %d proxies from %s", count, proxyManager.PoolFile())
+	}
 
 	// Set up router
 	if !cfg.Debug.Enabled {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := api.SetupRouter(db, loadBalancer, concurrencyManager)
+	routerOpts := &api.RouterOptions{
+		TokenManager:     tokenManager,
+		Webhooks:         webhooks,
+		SessionManager:   sessionManager,
+		Config:           manager,
+		ProxyManager:     proxyManager,
+		Moderator:        moderator,
+		CharacterArchive: characterArchive,
+		FileCache:        fileCache,
+	}
+	router := api.SetupRouterWithOptions(db, loadBalancer, concurrencyManager, routerOpts)
+	generationHandler := routerOpts.GenerationHandler
+
+	// Advance characters whose cameo is still processing, finalizing queued ones
+	characterWatcher := routerOpts.CharacterWatcher
+	scheduler.AddTask("character_watch", services.CharacterWatchInterval, func() {
+		characterWatcher.Check()
+	})
+
+	// Reconcile characters with Sora for every token while a sync interval is set
+	scheduleCharacterSync := func(cfg *config.Config) {
+		if cfg.Character.SyncInterval <= 0 {
+			scheduler.RemoveTask("character_sync")
+			return
+		}
+		scheduler.AddTask("character_sync", time.Duration(cfg.Character.SyncInterval)*time.Minute, func() {
+			if _, err := characterWatcher.Sync(0, false); err != nil {
+				log.Printf("Character sync failed: %v", err)
+			}
+		})
+	}
+	scheduleCharacterSync(manager.Get())
+	manager.Subscribe(scheduleCharacterSync)
+
+	// Resume tasks checkpointed by the previous shutdown
+	if _, err := generationHandler.ResumeTasks(); err != nil {
+		log.Printf("Failed to resume tasks: %v", err)
+	}
+	// Story jobs wait for their resumed clip tasks
+	if _, err := routerOpts.StoryRenderer.Resume(); err != nil {
+		log.Printf("Failed to resume story jobs: %v", err)
+	}
 
 	// Start server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("Starting server on %s", addr)
-	if err := router.Run(addr); err != nil {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Failed to start server: %v", err)
+	case <-ctx.Done():
 	}
+	stop()
+
+	shutdownTimeout := time.Duration(manager.Get().Server.ShutdownTimeout) * time.Second
+	log.Printf("Shutting down, waiting up to %v for %d running tasks...", shutdownTimeout, generationHandler.RunningCount())
+
+	// Stop accepting new generations, then stop the listener while running tasks drain
+	generationHandler.BeginDrain()
+	shutdownDone := make(chan error, 1)
+	go func() {
+		// Allow handlers of checkpointed tasks time to write their responses
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout+10*time.Second)
+		defer cancel()
+		shutdownDone <- srv.Shutdown(shutdownCtx)
+	}()
 
-	// Cleanup on shutdown
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+	if checkpointed := generationHandler.Drain(drainCtx); checkpointed > 0 {
+		log.Printf("Checkpointed %d unfinished tasks for resume on next start", checkpointed)
+	}
+	cancelDrain()
+
+	// Story jobs stop once their clip tasks are checkpointed; wait before the database closes
+	storiesDone := make(chan struct{})
+	go func() {
+		routerOpts.StoryRenderer.Wait()
+		close(storiesDone)
+	}()
+	select {
+	case <-storiesDone:
+	case <-time.After(shutdownTimeout):
+		log.Println("Gave up waiting for story jobs")
+	}
+
+	if err := <-shutdownDone; err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+
+	// Cleanup background workers
 	scheduler.Stop()
+	sessionManager.Stop()
+
+	webhooksDone := make(chan struct{})
+	go func() {
+		webhooks.Wait()
+		close(webhooksDone)
+	}()
+	select {
+	case <-webhooksDone:
+	case <-time.After(10 * time.Second):
+		log.Println("Gave up waiting for pending webhook deliveries")
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	log.Println("Server stopped")
+}
+
+// resolveConfigPath returns the config file to use. An explicit -config is used as is;
+// otherwise the first existing default location is picked.
+func resolveConfigPath(path string) string {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+	if explicit {
+		return path
+	}
+
+	for _, p := range []string{
+		"config/setting.toml",
+		"../config/setting.toml",
+		"../../config/setting.toml",
+	} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return path
+}
+
+// cacheBaseURL returns the public URL prefix of cached files
+func cacheBaseURL(cfg *config.Config) string {
+	if cfg.Cache.BaseURL != "" {
+		return cfg.Cache.BaseURL
+	}
+	return fmt.Sprintf("http://%s:%d", cfg.Server.Host, cfg.Server.Port)
 }
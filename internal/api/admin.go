@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"soranow/internal/config"
 	"soranow/internal/database"
 	"soranow/internal/models"
 	"soranow/internal/services"
@@ -19,16 +21,68 @@ type AdminHandler struct {
 	loadBalancer *services.LoadBalancer
 	concurrency  *services.ConcurrencyManager
 	tokenManager *services.TokenManager
+
+	generationHandler *services.GenerationHandler
+	configManager     *config.Manager
+	proxyManager      *services.ProxyManager
+	sentinels         *services.SentinelCache
 }
 
 // NewAdminHandler creates a new AdminHandler
 func NewAdminHandler(db *database.DB, lb *services.LoadBalancer, cm *services.ConcurrencyManager) *AdminHandler {
+	return newAdminHandler(db, lb, cm, services.NewTokenManager(db, lb, cm))
+}
+
+// newAdminHandler creates an AdminHandler that shares the given token manager
+func newAdminHandler(db *database.DB, lb *services.LoadBalancer, cm *services.ConcurrencyManager, tm *services.TokenManager) *AdminHandler {
 	return &AdminHandler{
 		db:           db,
 		loadBalancer: lb,
 		concurrency:  cm,
-		tokenManager: services.NewTokenManager(db, lb, cm),
+		tokenManager: tm,
+	}
+}
+
+// SetGenerationHandler sets the generation handler used to cancel running tasks
+func (h *AdminHandler) SetGenerationHandler(gh *services.GenerationHandler) {
+	h.generationHandler = gh
+}
+
+// SetConfigManager makes config updates persist as overrides and hot-reload
+func (h *AdminHandler) SetConfigManager(m *config.Manager) {
+	h.configManager = m
+}
+
+// SetProxyManager sets the proxy pool used for token tests and conversions
+func (h *AdminHandler) SetProxyManager(pm *services.ProxyManager) {
+	h.proxyManager = pm
+}
+
+// SetSentinelCache sets the sentinel cache reported by the sentinel stats endpoint
+func (h *AdminHandler) SetSentinelCache(cache *services.SentinelCache) {
+	h.sentinels = cache
+}
+
+// saveConfig stores the system config and, with a config manager, the layered
+// overrides for the changed keys. Returns a *config.ValidationError for invalid values.
+func (h *AdminHandler) saveConfig(cfg *models.SystemConfig, overrides map[string]interface{}) error {
+	if h.configManager != nil {
+		// Overrides are the top layer, so cfg matches the effective config once they validate
+		if err := services.ApplyConfigOverrides(h.db, h.configManager, overrides); err != nil {
+			return err
+		}
 	}
+	return h.db.UpdateSystemConfig(cfg)
+}
+
+// configErrorResponse writes the response for a failed config save
+func configErrorResponse(c *gin.Context, err error, message string) {
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "details": verr.Errors})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
 }
 
 // LoginRequest represents login request body
@@ -51,6 +105,8 @@ type UpdateTokenRequest struct {
 	RefreshToken     *string `json:"refresh_token"`
 	ClientID         *string `json:"client_id"`
 	ProxyURL         *string `json:"proxy_url"`
+	TLSProfile       *string `json:"tls_profile"`
+	UserAgent        *string `json:"user_agent"`
 	Remark           *string `json:"remark"`
 	IsActive         *bool   `json:"is_active"`
 	ImageEnabled     *bool   `json:"image_enabled"`
@@ -66,6 +122,7 @@ type UpdateConfigRequest struct {
 	AdminPassword        *string `json:"admin_password"`
 	ProxyEnabled         *bool   `json:"proxy_enabled"`
 	ProxyURL             *string `json:"proxy_url"`
+	ProxyPoolEnabled     *bool   `json:"proxy_pool_enabled"`
 	CacheEnabled         *bool   `json:"cache_enabled"`
 	CacheTimeout         *int    `json:"cache_timeout"`
 	CacheBaseURL         *string `json:"cache_base_url"`
@@ -194,6 +251,7 @@ func (h *AdminHandler) HandleDeleteToken(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete token"})
 		return
 	}
+	h.proxyManager.Unbind(id)
 
 	// Refresh load balancer
 	h.refreshLoadBalancer()
@@ -238,6 +296,12 @@ func (h *AdminHandler) HandleUpdateToken(c *gin.Context) {
 	if req.ProxyURL != nil {
 		token.ProxyURL = *req.ProxyURL
 	}
+	if req.TLSProfile != nil {
+		token.TLSProfile = strings.TrimSpace(*req.TLSProfile)
+	}
+	if req.UserAgent != nil {
+		token.UserAgent = strings.TrimSpace(*req.UserAgent)
+	}
 	if req.Remark != nil {
 		token.Remark = *req.Remark
 	}
@@ -257,6 +321,11 @@ func (h *AdminHandler) HandleUpdateToken(c *gin.Context) {
 		token.VideoConcurrency = *req.VideoConcurrency
 	}
 
+	if err := services.ValidateTokenFingerprint(token); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := h.db.UpdateToken(token); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update token"})
 		return
@@ -276,7 +345,14 @@ func (h *AdminHandler) HandleGetConfig(c *gin.Context) {
 		return
 	}
 
+	// The proxy pool switch lives only in the layered config
+	poolEnabled := false
+	if h.configManager != nil {
+		poolEnabled = h.configManager.Get().Proxy.PoolEnabled
+	}
+
 	c.JSON(http.StatusOK, gin.H{
+		"proxy_pool_enabled":     poolEnabled,
 		"api_key":                cfg.APIKey,
 		"admin_username":         cfg.AdminUsername,
 		"proxy_enabled":          cfg.ProxyEnabled,
@@ -312,11 +388,14 @@ func (h *AdminHandler) HandleUpdateConfig(c *gin.Context) {
 	}
 
 	// Update fields if provided
+	overrides := make(map[string]interface{})
 	if req.APIKey != nil {
 		cfg.APIKey = *req.APIKey
+		overrides["global.api_key"] = *req.APIKey
 	}
 	if req.AdminUsername != nil {
 		cfg.AdminUsername = *req.AdminUsername
+		overrides["global.admin_username"] = *req.AdminUsername
 	}
 	if req.AdminPassword != nil {
 		hash, err := hashPassword(*req.AdminPassword)
@@ -328,58 +407,148 @@ func (h *AdminHandler) HandleUpdateConfig(c *gin.Context) {
 	}
 	if req.ProxyEnabled != nil {
 		cfg.ProxyEnabled = *req.ProxyEnabled
+		overrides["proxy.proxy_enabled"] = *req.ProxyEnabled
 	}
 	if req.ProxyURL != nil {
 		cfg.ProxyURL = *req.ProxyURL
+		overrides["proxy.proxy_url"] = *req.ProxyURL
+	}
+	if req.ProxyPoolEnabled != nil {
+		overrides["proxy.pool_enabled"] = *req.ProxyPoolEnabled
 	}
 	if req.CacheEnabled != nil {
 		cfg.CacheEnabled = *req.CacheEnabled
+		overrides["cache.enabled"] = *req.CacheEnabled
 	}
 	if req.CacheTimeout != nil {
 		cfg.CacheTimeout = *req.CacheTimeout
+		overrides["cache.timeout"] = *req.CacheTimeout
 	}
 	if req.CacheBaseURL != nil {
 		cfg.CacheBaseURL = *req.CacheBaseURL
+		overrides["cache.base_url"] = *req.CacheBaseURL
 	}
 	if req.ImageTimeout != nil {
 		cfg.ImageTimeout = *req.ImageTimeout
+		overrides["generation.image_timeout"] = *req.ImageTimeout
 	}
 	if req.VideoTimeout != nil {
 		cfg.VideoTimeout = *req.VideoTimeout
+		overrides["generation.video_timeout"] = *req.VideoTimeout
 	}
 	if req.ErrorBanThreshold != nil {
 		cfg.ErrorBanThreshold = *req.ErrorBanThreshold
+		overrides["admin.error_ban_threshold"] = *req.ErrorBanThreshold
 	}
 	if req.TaskRetryEnabled != nil {
 		cfg.TaskRetryEnabled = *req.TaskRetryEnabled
+		overrides["admin.task_retry_enabled"] = *req.TaskRetryEnabled
 	}
 	if req.TaskMaxRetries != nil {
 		cfg.TaskMaxRetries = *req.TaskMaxRetries
+		overrides["admin.task_max_retries"] = *req.TaskMaxRetries
 	}
 	if req.AutoDisable401 != nil {
 		cfg.AutoDisable401 = *req.AutoDisable401
+		overrides["admin.auto_disable_on_401"] = *req.AutoDisable401
 	}
 	if req.WatermarkFreeEnabled != nil {
 		cfg.WatermarkFreeEnabled = *req.WatermarkFreeEnabled
+		overrides["watermark_free.watermark_free_enabled"] = *req.WatermarkFreeEnabled
 	}
 	if req.WatermarkParseMethod != nil {
 		cfg.WatermarkParseMethod = *req.WatermarkParseMethod
+		overrides["watermark_free.parse_method"] = *req.WatermarkParseMethod
 	}
 	if req.WatermarkFallback != nil {
 		cfg.WatermarkFallback = *req.WatermarkFallback
+		overrides["watermark_free.fallback_on_failure"] = *req.WatermarkFallback
 	}
 	if req.CallMode != nil {
 		cfg.CallMode = *req.CallMode
+		overrides["call_logic.call_mode"] = *req.CallMode
 	}
 
-	if err := h.db.UpdateSystemConfig(cfg); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update config"})
+	if err := h.saveConfig(cfg, overrides); err != nil {
+		configErrorResponse(c, err, "Failed to update config")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Config updated"})
 }
 
+// HandleGetEffectiveConfig returns the layered configuration and where each value comes from
+func (h *AdminHandler) HandleGetEffectiveConfig(c *gin.Context) {
+	if h.configManager == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "配置管理未启用"})
+		return
+	}
+
+	values := h.configManager.Get().Flatten()
+	for key, value := range values {
+		if s, ok := value.(string); ok && s != "" && config.IsSecret(key) {
+			values[key] = maskSecret(s)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"path":    h.configManager.Path(),
+		"config":  values,
+		"sources": h.configManager.Sources(),
+	})
+}
+
+// HandleReloadConfig re-reads the TOML file, environment and overrides
+func (h *AdminHandler) HandleReloadConfig(c *gin.Context) {
+	if h.configManager == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "配置管理未启用"})
+		return
+	}
+
+	if err := h.configManager.Reload(); err != nil {
+		configErrorResponse(c, err, "重新加载配置失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "配置已重新加载",
+	})
+}
+
+// HandleDeleteConfigOverride removes an admin override so the key falls back to the file, env or default
+func (h *AdminHandler) HandleDeleteConfigOverride(c *gin.Context) {
+	if h.configManager == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "配置管理未启用"})
+		return
+	}
+
+	key := c.Param("key")
+	if !config.IsKey(key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未知的配置项"})
+		return
+	}
+
+	if err := services.ResetConfigOverride(h.db, h.configManager, key); err != nil {
+		configErrorResponse(c, err, "删除配置覆盖失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "配置覆盖已删除",
+	})
+}
+
+// maskSecret keeps only the first and last characters of a credential
+func maskSecret(s string) string {
+	if len(s) <= 8 {
+		return "****"
+	}
+	return s[:4] + "****" + s[len(s)-4:]
+}
+
 // refreshLoadBalancer refreshes the load balancer with current tokens
 func (h *AdminHandler) refreshLoadBalancer() {
 	if h.loadBalancer == nil {
@@ -430,6 +599,9 @@ func (h *AdminHandler) HandleGetStats(c *gin.Context) {
 		return
 	}
 
+	clock := services.DefaultClock()
+	today := clock.Today()
+
 	var totalTokens, activeTokens int
 	var totalImages, totalVideos, totalErrors int
 	var todayImages, todayVideos, todayErrors int
@@ -442,20 +614,25 @@ func (h *AdminHandler) HandleGetStats(c *gin.Context) {
 		totalImages += t.TotalImageCount
 		totalVideos += t.TotalVideoCount
 		totalErrors += t.TotalErrorCount
-		todayImages += t.TodayImageCount
-		todayVideos += t.TodayVideoCount
-		todayErrors += t.TodayErrorCount
+		// Counters from a previous day have not been reset yet
+		if t.TodayDate == today {
+			todayImages += t.TodayImageCount
+			todayVideos += t.TodayVideoCount
+			todayErrors += t.TodayErrorCount
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"total_tokens":  totalTokens,
-		"active_tokens": activeTokens,
-		"total_images":  totalImages,
-		"total_videos":  totalVideos,
-		"total_errors":  totalErrors,
-		"today_images":  todayImages,
-		"today_videos":  todayVideos,
-		"today_errors":  todayErrors,
+		"total_tokens":    totalTokens,
+		"active_tokens":   activeTokens,
+		"total_images":    totalImages,
+		"total_videos":    totalVideos,
+		"total_errors":    totalErrors,
+		"today_images":    todayImages,
+		"today_videos":    todayVideos,
+		"today_errors":    todayErrors,
+		"date":            today,
+		"timezone_offset": clock.OffsetHours(),
 	})
 }
 
@@ -494,12 +671,14 @@ func (h *AdminHandler) HandleUpdateTokenRefreshConfig(c *gin.Context) {
 		return
 	}
 
+	overrides := make(map[string]interface{})
 	if req.ATAutoRefreshEnabled != nil {
 		cfg.TokenAutoRefresh = *req.ATAutoRefreshEnabled
+		overrides["token_refresh.at_auto_refresh_enabled"] = *req.ATAutoRefreshEnabled
 	}
 
-	if err := h.db.UpdateSystemConfig(cfg); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update config"})
+	if err := h.saveConfig(cfg, overrides); err != nil {
+		configErrorResponse(c, err, "Failed to update config")
 		return
 	}
 
@@ -532,6 +711,16 @@ func (h *AdminHandler) HandleGetLogs(c *gin.Context) {
 		logs = []*models.RequestLog{}
 	}
 
+	// Render timestamps in the configured timezone
+	clock := services.DefaultClock()
+	for _, l := range logs {
+		l.CreatedAt = clock.In(l.CreatedAt)
+		if l.UpdatedAt != nil {
+			updatedAt := clock.In(*l.UpdatedAt)
+			l.UpdatedAt = &updatedAt
+		}
+	}
+
 	c.JSON(http.StatusOK, logs)
 }
 
@@ -925,12 +1114,14 @@ func (h *AdminHandler) HandleUpdatePassword(c *gin.Context) {
 
 	// Update password
 	cfg.AdminPasswordHash = newHash
+	overrides := make(map[string]interface{})
 	if req.Username != "" {
 		cfg.AdminUsername = req.Username
+		overrides["global.admin_username"] = req.Username
 	}
 
-	if err := h.db.UpdateSystemConfig(cfg); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存配置失败"})
+	if err := h.saveConfig(cfg, overrides); err != nil {
+		configErrorResponse(c, err, "保存配置失败")
 		return
 	}
 
@@ -966,8 +1157,8 @@ func (h *AdminHandler) HandleUpdateAPIKey(c *gin.Context) {
 
 	cfg.APIKey = req.NewAPIKey
 
-	if err := h.db.UpdateSystemConfig(cfg); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API key"})
+	if err := h.saveConfig(cfg, map[string]interface{}{"global.api_key": req.NewAPIKey}); err != nil {
+		configErrorResponse(c, err, "Failed to update API key")
 		return
 	}
 
@@ -981,30 +1172,77 @@ func (h *AdminHandler) HandleUpdateAPIKey(c *gin.Context) {
 
 // TestProxyRequest represents proxy test request
 type TestProxyRequest struct {
-	TestURL string `json:"test_url"`
+	ProxyURL string `json:"proxy_url"` // Defaults to the configured proxy
+	TestURL  string `json:"test_url"`
 }
 
-// HandleTestProxy tests proxy connection
+// HandleTestProxy dials the test URL through the proxy and reports reachability,
+// exit IP, latency and Cloudflare challenge status
 func (h *AdminHandler) HandleTestProxy(c *gin.Context) {
 	var req TestProxyRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	proxyURL := strings.TrimSpace(req.ProxyURL)
+	if proxyURL == "" {
+		proxyURL = globalProxy(h.db, h.proxyManager)
 	}
 
-	testURL := req.TestURL
-	if testURL == "" {
-		testURL = "https://sora.chatgpt.com"
+	result := services.CheckProxy(proxyURL, strings.TrimSpace(req.TestURL))
+
+	success := result.Reachable && result.Error == "" && !result.CloudflareChallenge
+	message := "代理连接成功"
+	switch {
+	case !result.Reachable:
+		message = "代理连接失败"
+	case result.StatusCode == 0:
+		message = "TLS 握手失败"
+	case result.CloudflareChallenge:
+		message = "代理可用，但触发了 Cloudflare 验证"
+	case result.Error != "":
+		message = "代理可用，但无法获取出口 IP"
 	}
 
-	// For now, just return success - actual implementation would test proxy
+	c.JSON(http.StatusOK, gin.H{
+		"success":              success,
+		"message":              message,
+		"proxy_url":            result.ProxyURL,
+		"test_url":             result.TestURL,
+		"reachable":            result.Reachable,
+		"status_code":          result.StatusCode,
+		"latency_ms":           result.LatencyMs,
+		"exit_ip":              result.ExitIP,
+		"tls_ok":               result.TLSOK,
+		"cloudflare_challenge": result.CloudflareChallenge,
+		"error":                result.Error,
+	})
+}
+
+// HandleGetTLSProfiles lists the available TLS profiles and the default fingerprint
+func (h *AdminHandler) HandleGetTLSProfiles(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
-		"message":  "代理连接成功",
-		"test_url": testURL,
+		"profiles": services.TLSProfiles(),
+		"default":  services.DefaultFingerprint(),
 	})
 }
 
+// HandleGetSentinelStats reports sentinel token reuse and proof-of-work solve times
+func (h *AdminHandler) HandleGetSentinelStats(c *gin.Context) {
+	resp := gin.H{
+		"success": true,
+		"pow":     services.DefaultPowPool().Stats(),
+	}
+	if h.sentinels != nil {
+		resp["cache"] = h.sentinels.Stats()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
 // ========== Task Management ==========
 
 // HandleCancelTask cancels a running task
@@ -1015,7 +1253,41 @@ func (h *AdminHandler) HandleCancelTask(c *gin.Context) {
 		return
 	}
 
-	// For now, just return success - actual implementation would cancel the task
+	// Running in this process: cancel the poll, the generation goroutine records the result
+	if h.generationHandler != nil && h.generationHandler.CancelTask(taskID) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "任务已取消",
+		})
+		return
+	}
+
+	task, err := h.db.GetTaskByTaskID(taskID)
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if task.Status != models.TaskStatusProcessing {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("任务已结束 (%s)", task.Status)})
+		return
+	}
+
+	// Orphaned task (e.g. left over from a restart): just mark it cancelled
+	if h.generationHandler != nil {
+		err = h.generationHandler.MarkTaskCancelled(task)
+	} else {
+		task.Status = models.TaskStatusCancelled
+		err = h.db.UpdateTask(task)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "任务已取消",
@@ -1033,14 +1305,13 @@ func (h *AdminHandler) HandleTestToken(c *gin.Context) {
 		return
 	}
 
-	// Get proxy URL from config
-	cfg, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if cfg != nil && cfg.ProxyEnabled {
-		proxyURL = cfg.ProxyURL
+	token, err := h.db.GetTokenByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
 	}
 
-	result, err := h.tokenManager.TestToken(id, proxyURL)
+	result, err := h.tokenManager.TestToken(id, services.TokenProxy(h.db, h.proxyManager, token))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1075,12 +1346,7 @@ func (h *AdminHandler) HandleConvertST2AT(c *gin.Context) {
 		return
 	}
 
-	// Get proxy URL from config
-	cfg, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if cfg != nil && cfg.ProxyEnabled {
-		proxyURL = cfg.ProxyURL
-	}
+	proxyURL := globalProxy(h.db, h.proxyManager)
 
 	result, err := h.tokenManager.ConvertSTToAT(req.ST, proxyURL)
 	if err != nil {
@@ -1104,12 +1370,7 @@ func (h *AdminHandler) HandleConvertRT2AT(c *gin.Context) {
 		return
 	}
 
-	// Get proxy URL from config
-	cfg, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if cfg != nil && cfg.ProxyEnabled {
-		proxyURL = cfg.ProxyURL
-	}
+	proxyURL := globalProxy(h.db, h.proxyManager)
 
 	result, err := h.tokenManager.ConvertRTToAT(req.RT, req.ClientID, proxyURL)
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"soranow/internal/config"
 	"soranow/internal/database"
 	"soranow/internal/models"
 	"soranow/internal/services"
@@ -311,3 +312,179 @@ func TestAdminAuthMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func setupConfigManager(t *testing.T, db *database.DB) *config.Manager {
+	manager := config.NewManager("", db)
+	manager.SetLookupEnv(func(string) (string, bool) { return "", false })
+	if err := manager.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	return manager
+}
+
+func TestAdminHandler_UpdateConfig_Overrides(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	defer db.Close()
+
+	manager := setupConfigManager(t, db)
+	adminHandler := NewAdminHandler(db, nil, nil)
+	adminHandler.SetConfigManager(manager)
+	router := gin.New()
+	router.PUT("/api/config", adminHandler.HandleUpdateConfig)
+	router.GET("/api/config/effective", adminHandler.HandleGetEffectiveConfig)
+	router.DELETE("/api/config/overrides/:key", adminHandler.HandleDeleteConfigOverride)
+
+	body, _ := json.Marshal(map[string]interface{}{"video_timeout": 1800})
+	req := httptest.NewRequest("PUT", "/api/config", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if manager.Get().Generation.VideoTimeout != 1800 {
+		t.Errorf("Expected hot-reloaded video_timeout 1800, got %d", manager.Get().Generation.VideoTimeout)
+	}
+	if cfg, _ := db.GetSystemConfig(); cfg.VideoTimeout != 1800 {
+		t.Errorf("Expected system config video_timeout 1800, got %d", cfg.VideoTimeout)
+	}
+
+	// Effective config reports the source and masks secrets
+	req = httptest.NewRequest("GET", "/api/config/effective", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var effective struct {
+		Config  map[string]interface{} `json:"config"`
+		Sources map[string]string      `json:"sources"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &effective)
+	if effective.Sources["generation.video_timeout"] != config.SourceDB {
+		t.Errorf("Expected db source, got %q", effective.Sources["generation.video_timeout"])
+	}
+	if effective.Config["global.api_key"] == "han1234" {
+		t.Error("Expected api_key to be masked")
+	}
+
+	// Deleting the override falls back to the default
+	req = httptest.NewRequest("DELETE", "/api/config/overrides/generation.video_timeout", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if manager.Get().Generation.VideoTimeout != 3000 {
+		t.Errorf("Expected default video_timeout 3000, got %d", manager.Get().Generation.VideoTimeout)
+	}
+}
+
+func TestAdminHandler_UpdateConfig_InvalidValue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	defer db.Close()
+
+	manager := setupConfigManager(t, db)
+	adminHandler := NewAdminHandler(db, nil, nil)
+	adminHandler.SetConfigManager(manager)
+	router := gin.New()
+	router.PUT("/api/config", adminHandler.HandleUpdateConfig)
+
+	body, _ := json.Marshal(map[string]interface{}{"image_timeout": -5})
+	req := httptest.NewRequest("PUT", "/api/config", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if manager.Get().Generation.ImageTimeout != 300 {
+		t.Errorf("Expected image_timeout to stay 300, got %d", manager.Get().Generation.ImageTimeout)
+	}
+	if cfg, _ := db.GetSystemConfig(); cfg.ImageTimeout != 300 {
+		t.Errorf("Expected system config to be unchanged, got %d", cfg.ImageTimeout)
+	}
+}
+
+func TestAdminHandler_TestProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	defer db.Close()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ip":"203.0.113.9"}`))
+	}))
+	defer target.Close()
+	prev := services.ProxyExitIPURL
+	services.ProxyExitIPURL = target.URL
+	defer func() { services.ProxyExitIPURL = prev }()
+
+	adminHandler := NewAdminHandler(db, nil, nil)
+	router := gin.New()
+	router.POST("/api/proxy/test", adminHandler.HandleTestProxy)
+
+	// No proxy configured: tests the direct connection
+	body, _ := json.Marshal(TestProxyRequest{TestURL: target.URL})
+	req := httptest.NewRequest("POST", "/api/proxy/test", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["success"] != true || resp["exit_ip"] != "203.0.113.9" {
+		t.Errorf("Expected successful direct test, got %v", resp)
+	}
+
+	// A proxy that refuses connections must not report success
+	body, _ = json.Marshal(TestProxyRequest{ProxyURL: "http://127.0.0.1:1", TestURL: target.URL})
+	req = httptest.NewRequest("POST", "/api/proxy/test", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	resp = nil
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["success"] != false || resp["reachable"] != false || resp["error"] == "" {
+		t.Errorf("Expected failed proxy test, got %v", resp)
+	}
+}
+
+func TestAdminHandler_UpdateToken_Fingerprint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.CreateToken(&models.Token{Token: "fp_token", Email: "fp@example.com", IsActive: true})
+
+	adminHandler := NewAdminHandler(db, services.NewLoadBalancer(), nil)
+	router := gin.New()
+	router.PUT("/api/tokens/:id", adminHandler.HandleUpdateToken)
+
+	update := func(fields map[string]interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(fields)
+		req := httptest.NewRequest("PUT", "/api/tokens/1", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := update(map[string]interface{}{"tls_profile": "chrome_131"}); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	token, _ := db.GetTokenByID(1)
+	if token.TLSProfile != "chrome_131" {
+		t.Errorf("Expected TLS profile chrome_131, got %q", token.TLSProfile)
+	}
+
+	if w := update(map[string]interface{}{"tls_profile": "netscape_4"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown profile, got %d", w.Code)
+	}
+	token, _ = db.GetTokenByID(1)
+	if token.TLSProfile != "chrome_131" {
+		t.Errorf("Invalid profile must not be saved, got %q", token.TLSProfile)
+	}
+}
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"soranow/internal/database"
@@ -14,18 +17,49 @@ import (
 
 // CharacterHandler handles character-related API requests
 type CharacterHandler struct {
-	db         *database.DB
-	soraClient *services.SoraClient
+	db           *database.DB
+	soraClient   services.SoraAPI
+	proxyManager *services.ProxyManager
+	watcher      *services.CharacterWatcher
+	archive      *services.FileCache
+
+	uploadMu      sync.RWMutex
+	maxUploadSize int64
+	uploadDir     string
 }
 
 // NewCharacterHandler creates a new CharacterHandler
-func NewCharacterHandler(db *database.DB, soraClient *services.SoraClient) *CharacterHandler {
+func NewCharacterHandler(db *database.DB, soraClient services.SoraAPI) *CharacterHandler {
 	return &CharacterHandler{
 		db:         db,
 		soraClient: soraClient,
 	}
 }
 
+// SetProxyManager sets the proxy pool used for tokens without their own proxy
+func (h *CharacterHandler) SetProxyManager(pm *services.ProxyManager) {
+	h.proxyManager = pm
+}
+
+// SetCharacterWatcher sets the watcher that also advances processing cameos in the background
+func (h *CharacterHandler) SetCharacterWatcher(w *services.CharacterWatcher) {
+	h.watcher = w
+}
+
+// characterWatcher returns the watcher, creating one when none was set
+func (h *CharacterHandler) characterWatcher() *services.CharacterWatcher {
+	if h.watcher == nil {
+		h.watcher = services.NewCharacterWatcher(h.db, h.soraClient)
+		h.watcher.SetProxyManager(h.proxyManager)
+	}
+	return h.watcher
+}
+
+// SetSourceArchive sets the storage source clips are kept in for re-creation
+func (h *CharacterHandler) SetSourceArchive(archive *services.FileCache) {
+	h.archive = archive
+}
+
 // HandleGetCharacters returns all characters
 func (h *CharacterHandler) HandleGetCharacters(c *gin.Context) {
 	characters, err := h.db.GetAllCharacters()
@@ -59,28 +93,105 @@ func (h *CharacterHandler) HandleGetCharacter(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"character": character})
 }
 
+// characterUploadRequest holds the settings shared by the JSON and multipart uploads
+type characterUploadRequest struct {
+	TokenID    int64  `json:"token_id" binding:"required"`
+	Timestamps string `json:"timestamps"` // e.g., "0-5" for 0 to 5 seconds
+	Username   string `json:"username" binding:"required"`
+
+	// Finalize automatically once the cameo is ready, with these settings
+	AutoFinalize         bool   `json:"auto_finalize"`
+	DisplayName          string `json:"display_name"`
+	InstructionSet       string `json:"instruction_set"`
+	SafetyInstructionSet string `json:"safety_instruction_set"`
+	Visibility           string `json:"visibility"`
+}
+
+// validate fills in the defaults and checks the settings
+func (r *characterUploadRequest) validate() error {
+	if r.TokenID <= 0 || r.Username == "" {
+		return errors.New("token_id and username are required")
+	}
+	if r.Timestamps == "" {
+		r.Timestamps = "0-5"
+	}
+	if r.DisplayName == "" {
+		r.DisplayName = r.Username
+	}
+	if r.Visibility == "" {
+		r.Visibility = models.CharacterVisibilityPrivate
+	}
+	if r.Visibility != models.CharacterVisibilityPrivate && r.Visibility != models.CharacterVisibilityPublic {
+		return errors.New("visibility must be private or public")
+	}
+	return nil
+}
+
+// requestToken returns the token named by a request, responding with the error if it cannot be loaded
+func (h *CharacterHandler) requestToken(c *gin.Context, tokenID int64) (*models.Token, bool) {
+	token, err := h.db.GetTokenByID(tokenID)
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return token, true
+}
+
+// saveUploadedCharacter archives the source clip, stores the character of an
+// uploaded cameo and responds with it
+func (h *CharacterHandler) saveUploadedCharacter(c *gin.Context, req *characterUploadRequest, cameoID string, source io.Reader, contentType string) {
+	character := &models.Character{
+		CameoID:              cameoID,
+		Username:             req.Username,
+		DisplayName:          req.DisplayName,
+		InstructionSet:       req.InstructionSet,
+		SafetyInstructionSet: req.SafetyInstructionSet,
+		Visibility:           req.Visibility,
+		Status:               models.CharacterStatusProcessing,
+		TokenID:              req.TokenID,
+		AutoFinalize:         req.AutoFinalize,
+		Timestamps:           req.Timestamps,
+	}
+	h.archiveSource(character, source, contentType)
+
+	charID, err := h.db.CreateCharacter(character)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save character: " + err.Error()})
+		return
+	}
+
+	character.ID = charID
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"character": character,
+		"cameo_id":  cameoID,
+	})
+}
+
 // HandleUploadCharacterVideo handles video upload for character creation
 func (h *CharacterHandler) HandleUploadCharacterVideo(c *gin.Context) {
 	var req struct {
-		TokenID    int64  `json:"token_id" binding:"required"`
-		VideoData  string `json:"video_data" binding:"required"` // Base64 encoded video
-		Timestamps string `json:"timestamps"`                    // e.g., "0-5" for 0 to 5 seconds
-		Username   string `json:"username" binding:"required"`
+		characterUploadRequest
+		VideoData string `json:"video_data" binding:"required"` // Base64 encoded video
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Get the token
-	token, err := h.db.GetTokenByID(req.TokenID)
-	if err != nil {
-		if err == database.ErrNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	token, ok := h.requestToken(c, req.TokenID)
+	if !ok {
 		return
 	}
 
@@ -90,53 +201,24 @@ func (h *CharacterHandler) HandleUploadCharacterVideo(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid video data encoding"})
 		return
 	}
-
-	// Get proxy URL from system config
-	config, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if config != nil && config.ProxyEnabled {
-		proxyURL = config.ProxyURL
-	}
-	// Token-specific proxy takes precedence
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
-	}
-
-	// Upload video and create cameo
-	timestamps := req.Timestamps
-	if timestamps == "" {
-		timestamps = "0-5"
-	}
-
-	cameoID, err := h.soraClient.UploadCharacterVideo(videoData, token.Token, timestamps, proxyURL)
+	contentType, err := services.SniffVideoType(videoData)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload video: " + err.Error()})
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Create character record in database
-	character := &models.Character{
-		CameoID:     cameoID,
-		Username:    req.Username,
-		DisplayName: req.Username,
-		Visibility:  models.CharacterVisibilityPrivate,
-		Status:      models.CharacterStatusProcessing,
-		TokenID:     req.TokenID,
-	}
+	// Token-specific proxy takes precedence over the global proxy/pool
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
 
-	charID, err := h.db.CreateCharacter(character)
+	// Upload video and create cameo
+	cameoID, err := h.soraClient.UploadCharacterFile(bytes.NewReader(videoData), int64(len(videoData)),
+		contentType, token.Token, req.Timestamps, proxyURL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save character: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload video: " + err.Error()})
 		return
 	}
 
-	character.ID = charID
-
-	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
-		"character": character,
-		"cameo_id":  cameoID,
-	})
+	h.saveUploadedCharacter(c, &req.characterUploadRequest, cameoID, bytes.NewReader(videoData), contentType)
 }
 
 // HandleGetCameoStatus gets the processing status of a cameo
@@ -158,40 +240,14 @@ func (h *CharacterHandler) HandleGetCameoStatus(c *gin.Context) {
 		return
 	}
 
-	// Get the token
-	token, err := h.db.GetTokenByID(character.TokenID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get token: " + err.Error()})
-		return
-	}
-
-	// Get proxy URL
-	config, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if config != nil && config.ProxyEnabled {
-		proxyURL = config.ProxyURL
-	}
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
-	}
-
-	// Get cameo status from Sora API
-	status, profileURL, err := h.soraClient.GetCameoStatus(character.CameoID, token.Token, proxyURL)
-	if err != nil {
+	if err := h.characterWatcher().Refresh(character); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get cameo status: " + err.Error()})
 		return
 	}
 
-	// Update character if status changed
-	if status != character.Status || profileURL != character.ProfileURL {
-		character.Status = status
-		character.ProfileURL = profileURL
-		h.db.UpdateCharacter(character)
-	}
-
 	c.JSON(http.StatusOK, gin.H{
-		"status":      status,
-		"profile_url": profileURL,
+		"status":      character.Status,
+		"profile_url": character.ProfileURL,
 		"character":   character,
 	})
 }
@@ -226,15 +282,8 @@ func (h *CharacterHandler) HandleCheckUsername(c *gin.Context) {
 		return
 	}
 
-	// Get proxy URL
-	config, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if config != nil && config.ProxyEnabled {
-		proxyURL = config.ProxyURL
-	}
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
-	}
+	// Token-specific proxy takes precedence over the global proxy/pool
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
 
 	// Check with Sora API
 	available, err := h.soraClient.CheckUsernameAvailable(username, token.Token, proxyURL)
@@ -280,15 +329,8 @@ func (h *CharacterHandler) HandleFinalizeCharacter(c *gin.Context) {
 		return
 	}
 
-	// Get proxy URL
-	config, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if config != nil && config.ProxyEnabled {
-		proxyURL = config.ProxyURL
-	}
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
-	}
+	// Token-specific proxy takes precedence over the global proxy/pool
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
 
 	visibility := req.Visibility
 	if visibility == "" {
@@ -359,15 +401,8 @@ func (h *CharacterHandler) HandleDeleteCharacter(c *gin.Context) {
 		return
 	}
 
-	// Get proxy URL
-	config, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if config != nil && config.ProxyEnabled {
-		proxyURL = config.ProxyURL
-	}
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
-	}
+	// Token-specific proxy takes precedence over the global proxy/pool
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
 
 	// Delete from Sora API (use character_id if finalized, otherwise cameo_id)
 	deleteID := character.CharacterID
@@ -380,6 +415,24 @@ func (h *CharacterHandler) HandleDeleteCharacter(c *gin.Context) {
 		// The character might already be deleted on Sora's side
 	}
 
+	// A recreation not finalized yet still holds the previous cameo
+	if character.ReplacedCameoID != "" {
+		if previous, err := h.db.GetTokenByID(character.ReplacedTokenID); err == nil {
+			h.soraClient.DeleteCharacter(character.ReplacedCameoID, previous.Token, services.TokenProxy(h.db, h.proxyManager, previous))
+		}
+	}
+
+	if character.SourceFile != "" && h.archive != nil {
+		h.archive.Delete(character.SourceFile)
+	}
+
+	// Replicas on other tokens go with the character
+	if replicas, err := h.db.GetCharacterReplicas(id); err == nil {
+		for _, rep := range replicas {
+			h.deleteReplicaCameo(rep)
+		}
+	}
+
 	// Delete from local database
 	if err := h.db.DeleteCharacter(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete character: " + err.Error()})
@@ -415,15 +468,8 @@ func (h *CharacterHandler) HandleSearchCharacters(c *gin.Context) {
 		return
 	}
 
-	// Get proxy URL
-	config, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if config != nil && config.ProxyEnabled {
-		proxyURL = config.ProxyURL
-	}
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
-	}
+	// Token-specific proxy takes precedence over the global proxy/pool
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
 
 	// Search with Sora API
 	characters, err := h.soraClient.SearchCharacter(query, token.Token, proxyURL)
@@ -437,92 +483,39 @@ func (h *CharacterHandler) HandleSearchCharacters(c *gin.Context) {
 	})
 }
 
-// HandleSyncCharacters syncs characters from Sora API to local database
+// HandleSyncCharacters reconciles the local characters with Sora for every
+// active token, or only for token_id when given, and reports the differences.
+// With dry_run nothing is written.
 func (h *CharacterHandler) HandleSyncCharacters(c *gin.Context) {
 	var req struct {
-		TokenID int64 `json:"token_id" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		TokenID int64 `json:"token_id"`
+		DryRun  bool  `json:"dry_run"`
 	}
 
-	// Get the token
-	token, err := h.db.GetTokenByID(req.TokenID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get token"})
-		return
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	// Get proxy URL
-	config, _ := h.db.GetSystemConfig()
-	proxyURL := ""
-	if config != nil && config.ProxyEnabled {
-		proxyURL = config.ProxyURL
-	}
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
+	if req.TokenID > 0 {
+		if _, ok := h.requestToken(c, req.TokenID); !ok {
+			return
+		}
 	}
 
-	// Get characters from Sora API
-	remoteChars, err := h.soraClient.GetMyCharacters(token.Token, proxyURL)
+	result, err := h.characterWatcher().Sync(req.TokenID, req.DryRun)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get characters: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sync characters: " + err.Error()})
 		return
 	}
 
-	synced := 0
-	for _, rc := range remoteChars {
-		cameoID, _ := rc["cameo_id"].(string)
-		if cameoID == "" {
-			cameoID, _ = rc["id"].(string)
-		}
-		if cameoID == "" {
-			continue
-		}
-
-		// Check if already exists
-		_, err := h.db.GetCharacterByCameoID(cameoID)
-		if err == nil {
-			continue // Already exists
-		}
-
-		// Create new character
-		username, _ := rc["username"].(string)
-		displayName, _ := rc["display_name"].(string)
-		if displayName == "" {
-			displayName = username
-		}
-		profileURL, _ := rc["profile_url"].(string)
-		characterID, _ := rc["character_id"].(string)
-		status, _ := rc["status"].(string)
-		if status == "" {
-			status = models.CharacterStatusFinalized
-		}
-
-		char := &models.Character{
-			CameoID:     cameoID,
-			CharacterID: characterID,
-			Username:    username,
-			DisplayName: displayName,
-			ProfileURL:  profileURL,
-			Visibility:  models.CharacterVisibilityPrivate,
-			Status:      status,
-			TokenID:     req.TokenID,
-		}
-
-		if _, err := h.db.CreateCharacter(char); err == nil {
-			synced++
-		}
-	}
-
+	// synced and total keep the fields the web client reads
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"synced":  synced,
-		"total":   len(remoteChars),
+		"success": len(result.Errors) == 0,
+		"synced":  result.Created,
+		"total":   result.Remote,
+		"result":  result,
 	})
 }
-
-// Unused import placeholder
-var _ = io.EOF
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// sourceExtensions maps the video types Sora accepts to archive file extensions
+var sourceExtensions = map[string]string{
+	services.VideoTypeMP4:       ".mp4",
+	services.VideoTypeQuickTime: ".mov",
+	services.VideoTypeWebM:      ".webm",
+}
+
+// archiveSource keeps the source clip of a character so it can be recreated on
+// another token. The upload has already succeeded, so a failure is only logged.
+func (h *CharacterHandler) archiveSource(char *models.Character, source io.Reader, contentType string) {
+	if h.archive == nil || source == nil {
+		return
+	}
+	filename := "sources/" + char.CameoID + sourceExtensions[contentType]
+	size, err := h.archive.SaveFrom(filename, source)
+	if err != nil {
+		log.Printf("[Characters] Failed to archive the source clip of @%s: %v", char.Username, err)
+		return
+	}
+	char.SourceFile = filename
+	char.SourceType = contentType
+	char.SourceSize = size
+}
+
+// openSource opens the archived source clip of a character, nil if there is none
+func (h *CharacterHandler) openSource(char *models.Character) (io.ReadSeekCloser, error) {
+	if h.archive == nil || char.SourceFile == "" {
+		return nil, nil
+	}
+	return h.archive.Open(char.SourceFile)
+}
+
+// HandleRecreateCharacter creates a character again on a token from its archived
+// source clip, e.g. after its token expired. The upload is replayed with the stored
+// timestamps and the character is finalized with its stored settings once Sora has
+// processed the clip. The previous cameo is deleted right before the new one is
+// finalized, since it holds the username.
+func (h *CharacterHandler) HandleRecreateCharacter(c *gin.Context) {
+	character, ok := h.characterParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TokenID  int64  `json:"token_id" binding:"required"`
+		Username string `json:"username"` // Defaults to the current username
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source, err := h.openSource(character)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open source clip: " + err.Error()})
+		return
+	}
+	if source == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "character has no archived source clip"})
+		return
+	}
+	defer source.Close()
+
+	token, ok := h.requestToken(c, req.TokenID)
+	if !ok {
+		return
+	}
+
+	timestamps := character.Timestamps
+	if timestamps == "" {
+		timestamps = "0-5"
+	}
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
+	cameoID, err := h.soraClient.UploadCharacterFile(source, character.SourceSize, character.SourceType, token.Token, timestamps, proxyURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload video: " + err.Error()})
+		return
+	}
+
+	// The previous cameo keeps the username until the watcher finalizes the new one,
+	// so a failed upload leaves it in place. A cameo replaced by an unfinished
+	// recreation is still the one to delete; the unfinished cameo is dropped.
+	currentID := character.CharacterID
+	if currentID == "" {
+		currentID = character.CameoID
+	}
+	if character.ReplacedCameoID != "" {
+		if previous, err := h.db.GetTokenByID(character.TokenID); err == nil {
+			h.soraClient.DeleteCharacter(currentID, previous.Token, services.TokenProxy(h.db, h.proxyManager, previous))
+		}
+	} else if character.Status != models.CharacterStatusOrphaned {
+		character.ReplacedCameoID = currentID
+		character.ReplacedTokenID = character.TokenID
+	}
+
+	if req.Username != "" {
+		character.Username = req.Username
+	}
+	if character.Visibility == "" {
+		character.Visibility = models.CharacterVisibilityPrivate
+	}
+	character.CameoID = cameoID
+	character.CharacterID = ""
+	character.ProfileURL = ""
+	character.TokenID = token.ID
+	character.Timestamps = timestamps
+	character.Status = models.CharacterStatusProcessing
+	character.ErrorMessage = ""
+	character.AutoFinalize = true
+	if err := h.db.UpdateCharacter(character); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save character: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"character": character,
+		"cameo_id":  cameoID,
+	})
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+func TestCharacterHandler_RecreateFromArchive(t *testing.T) {
+	router, h, db, mock, _ := setupCharacterUploadRouter(t)
+	archive := services.NewFileCache(t.TempDir(), 0, "")
+	h.SetSourceArchive(archive)
+	router.POST("/api/characters/:id/recreate", h.HandleRecreateCharacter)
+	router.POST("/api/characters/:id/replicas", h.HandleReplicateCharacter)
+	router.DELETE("/api/characters/:id", h.HandleDeleteCharacter)
+	otherID, _ := db.CreateToken(&models.Token{Token: "at_other", Email: "b@example.com", IsActive: true})
+
+	video := append(append([]byte{}, webmHeader...), bytes.Repeat([]byte{0x42}, 2048)...)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, multipartUpload(t, map[string]string{
+		"token_id": "1", "username": "kitty", "timestamps": "1-4", "instruction_set": "always smiling",
+	}, video))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	var upload struct {
+		Character *models.Character `json:"character"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &upload)
+	char := upload.Character
+	if char.SourceFile == "" || char.SourceSize != int64(len(video)) || char.SourceType != services.VideoTypeWebM || char.Timestamps != "1-4" {
+		t.Fatalf("Expected source clip archived, got %+v", char)
+	}
+	if stored, _ := archive.Get(char.SourceFile); !bytes.Equal(stored, video) {
+		t.Errorf("Expected archived clip to match the upload, got %d bytes", len(stored))
+	}
+
+	// Recreating replays the upload on the other token and queues the finalize
+	body := strings.NewReader(`{"token_id": 2, "username": "kitty_again"}`)
+	req := httptest.NewRequest("POST", "/api/characters/1/recreate", body)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	stored, _ := db.GetCharacterByID(char.ID)
+	if stored.TokenID != otherID || stored.CameoID == char.CameoID || stored.Username != "kitty_again" ||
+		!stored.AutoFinalize || stored.Status != models.CharacterStatusProcessing || stored.InstructionSet != "always smiling" {
+		t.Errorf("Expected character recreated on token %d, got %+v", otherID, stored)
+	}
+	if u, ok := mock.CameoUpload(stored.CameoID); !ok || u.Received != int64(len(video)) || u.FileType != services.VideoTypeWebM {
+		t.Errorf("Expected archived clip replayed, got %+v", u)
+	}
+	if n := mock.Calls("DELETE /backend/cameo/" + char.CameoID); n != 0 || stored.ReplacedCameoID != char.CameoID {
+		t.Errorf("Expected the previous cameo kept until the new one is ready, got %d deletes and %+v", n, stored)
+	}
+
+	// The watcher deletes the previous cameo right before finalizing the new one
+	watcher := services.NewCharacterWatcher(db, h.soraClient)
+	for i := 0; i < 3 && stored.Status != models.CharacterStatusFinalized; i++ {
+		watcher.Check()
+		stored, _ = db.GetCharacterByID(char.ID)
+	}
+	if stored.Status != models.CharacterStatusFinalized || stored.ReplacedCameoID != "" {
+		t.Fatalf("Expected recreated character finalized, got %+v", stored)
+	}
+	if n := mock.Calls("DELETE /backend/cameo/" + char.CameoID); n != 1 {
+		t.Errorf("Expected the previous cameo deleted, got %d calls", n)
+	}
+
+	// Replicas fall back to the archived clip as well
+	req = multipartUpload(t, map[string]string{"token_ids": "1"}, nil)
+	req.URL.Path = "/api/characters/1/replicas"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	// Deleting the character removes its archive
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/characters/1", nil))
+	if w.Code != http.StatusOK || archive.Exists(char.SourceFile) {
+		t.Errorf("Expected archived clip removed with the character, got %d", w.Code)
+	}
+}
+
+func TestCharacterHandler_RecreateWithoutArchive(t *testing.T) {
+	router, h, db, _, tokenID := setupCharacterUploadRouter(t)
+	router.POST("/api/characters/:id/recreate", h.HandleRecreateCharacter)
+	db.CreateCharacter(&models.Character{CameoID: "cameo_old", Username: "old", Status: models.CharacterStatusFinalized, TokenID: tokenID})
+
+	req := httptest.NewRequest("POST", "/api/characters/1/recreate", strings.NewReader(`{"token_id": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
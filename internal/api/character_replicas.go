@@ -0,0 +1,282 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// replicaUsername derives the username of a replica; Sora usernames are
+// unique across accounts, so each replica needs its own
+func replicaUsername(username string, tokenID int64) string {
+	return fmt.Sprintf("%s_%d", username, tokenID)
+}
+
+// characterParam loads the character named by the :id parameter, responding with the error if it cannot be loaded
+func (h *CharacterHandler) characterParam(c *gin.Context) (*models.Character, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid character ID"})
+		return nil, false
+	}
+	character, err := h.db.GetCharacterByID(id)
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "character not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return character, true
+}
+
+// HandleGetCharacterReplicas returns the replicas of a character
+func (h *CharacterHandler) HandleGetCharacterReplicas(c *gin.Context) {
+	character, ok := h.characterParam(c)
+	if !ok {
+		return
+	}
+	replicas, err := h.db.GetCharacterReplicas(character.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"replicas": replicas})
+}
+
+// HandleReplicateCharacter copies a character to other tokens of the pool. Sora
+// cannot share a cameo between accounts, so the source clip is uploaded again
+// (multipart "video" part, or the archived source clip when it is left out) to
+// every token in "token_ids" (comma separated or repeated). The replicas are
+// finalized by the character watcher once ready, after which private @mentions
+// of the character can run on those tokens.
+func (h *CharacterHandler) HandleReplicateCharacter(c *gin.Context) {
+	character, ok := h.characterParam(c)
+	if !ok {
+		return
+	}
+	if character.Status != models.CharacterStatusFinalized {
+		c.JSON(http.StatusConflict, gin.H{"error": "only finalized characters can be replicated"})
+		return
+	}
+
+	maxSize, dir := h.uploadLimits()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expected multipart/form-data: " + err.Error()})
+		return
+	}
+
+	var timestamps string
+	var tokenIDs []int64
+	var video *spooledVideo
+	defer func() {
+		if video != nil {
+			video.Close()
+		}
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.uploadError(c, err)
+			return
+		}
+
+		if part.FormName() == "video" {
+			if video != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "only one video may be uploaded"})
+				return
+			}
+			if video, err = spoolVideo(part, dir, maxSize); err != nil {
+				h.uploadError(c, err)
+				return
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+		if err != nil {
+			h.uploadError(c, err)
+			return
+		}
+		switch part.FormName() {
+		case "token_ids":
+			for _, field := range strings.Split(string(value), ",") {
+				if field = strings.TrimSpace(field); field == "" {
+					continue
+				}
+				id, err := strconv.ParseInt(field, 10, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid token_ids: %q", field)})
+					return
+				}
+				tokenIDs = append(tokenIDs, id)
+			}
+		case "timestamps":
+			timestamps = strings.TrimSpace(string(value))
+		}
+	}
+
+	// Without an uploaded clip the archived source clip is used
+	var clip io.ReadSeeker
+	var clipSize int64
+	var clipType string
+	if video != nil {
+		clip, clipSize, clipType = video.file, video.size, video.contentType
+	} else {
+		source, err := h.openSource(character)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open source clip: " + err.Error()})
+			return
+		}
+		if source == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "video file is required, the character has no archived source clip"})
+			return
+		}
+		defer source.Close()
+		clip, clipSize, clipType = source, character.SourceSize, character.SourceType
+		if timestamps == "" {
+			timestamps = character.Timestamps
+		}
+	}
+	if timestamps == "" {
+		timestamps = "0-5"
+	}
+	if len(tokenIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token_ids is required"})
+		return
+	}
+
+	existing, err := h.db.GetCharacterReplicas(character.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Failed and orphaned replicas are replaced, so their tokens can be retried
+	held := map[int64]bool{character.TokenID: true}
+	stale := map[int64]*models.CharacterReplica{}
+	for _, rep := range existing {
+		switch rep.Status {
+		case models.CharacterStatusProcessing, models.CharacterStatusReady, models.CharacterStatusFinalized:
+			held[rep.TokenID] = true
+		default:
+			stale[rep.TokenID] = rep
+		}
+	}
+
+	replicas := []*models.CharacterReplica{}
+	errs := map[string]string{}
+	for _, tokenID := range tokenIDs {
+		key := strconv.FormatInt(tokenID, 10)
+		if held[tokenID] {
+			errs[key] = "token already holds this character"
+			continue
+		}
+		held[tokenID] = true
+
+		token, err := h.db.GetTokenByID(tokenID)
+		if err != nil {
+			errs[key] = "token not found"
+			continue
+		}
+		if _, err := clip.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
+		cameoID, err := h.soraClient.UploadCharacterFile(clip, clipSize, clipType, token.Token, timestamps, proxyURL)
+		if err != nil {
+			errs[key] = "failed to upload video: " + err.Error()
+			continue
+		}
+
+		rep := &models.CharacterReplica{
+			CharacterID: character.ID,
+			TokenID:     tokenID,
+			CameoID:     cameoID,
+			Username:    replicaUsername(character.Username, tokenID),
+			Status:      models.CharacterStatusProcessing,
+		}
+		if old := stale[tokenID]; old != nil {
+			if err := h.db.DeleteCharacterReplica(old.ID); err != nil {
+				errs[key] = "failed to replace replica: " + err.Error()
+				continue
+			}
+		}
+		if rep.ID, err = h.db.CreateCharacterReplica(rep); err != nil {
+			errs[key] = "failed to save replica: " + err.Error()
+			continue
+		}
+		replicas = append(replicas, rep)
+	}
+
+	status := http.StatusOK
+	if len(replicas) == 0 {
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{
+		"success":  len(replicas) > 0,
+		"replicas": replicas,
+		"errors":   errs,
+	})
+}
+
+// HandleDeleteCharacterReplica deletes a replica from Sora and the database
+func (h *CharacterHandler) HandleDeleteCharacterReplica(c *gin.Context) {
+	character, ok := h.characterParam(c)
+	if !ok {
+		return
+	}
+	replicaID, err := strconv.ParseInt(c.Param("replica_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid replica ID"})
+		return
+	}
+	rep, err := h.db.GetCharacterReplicaByID(replicaID)
+	if err != nil || rep.CharacterID != character.ID {
+		if err == nil || err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "replica not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.deleteReplicaCameo(rep)
+	if err := h.db.DeleteCharacterReplica(rep.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete replica: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "replica deleted",
+	})
+}
+
+// deleteReplicaCameo deletes the cameo of a replica on its token. Failures are
+// ignored like for characters, the cameo may already be gone.
+func (h *CharacterHandler) deleteReplicaCameo(rep *models.CharacterReplica) {
+	token, err := h.db.GetTokenByID(rep.TokenID)
+	if err != nil {
+		return
+	}
+	deleteID := rep.SoraID
+	if deleteID == "" {
+		deleteID = rep.CameoID
+	}
+	h.soraClient.DeleteCharacter(deleteID, token.Token, services.TokenProxy(h.db, h.proxyManager, token))
+}
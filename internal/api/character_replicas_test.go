@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"soranow/internal/models"
+)
+
+func TestCharacterHandler_Replicas(t *testing.T) {
+	router, h, db, mock, ownerID := setupCharacterUploadRouter(t)
+	router.GET("/api/characters/:id/replicas", h.HandleGetCharacterReplicas)
+	router.POST("/api/characters/:id/replicas", h.HandleReplicateCharacter)
+	router.DELETE("/api/characters/:id/replicas/:replica_id", h.HandleDeleteCharacterReplica)
+	otherID, _ := db.CreateToken(&models.Token{Token: "at_other", Email: "b@example.com", IsActive: true})
+
+	charID, _ := db.CreateCharacter(&models.Character{CameoID: "cameo_kitty", CharacterID: "ch_kitty", Username: "kitty",
+		Status: models.CharacterStatusFinalized, TokenID: ownerID})
+
+	video := append(append([]byte{}, webmHeader...), bytes.Repeat([]byte{0x42}, 1024)...)
+	req := multipartUpload(t, map[string]string{"token_ids": "1, 2, 99"}, video)
+	req.URL.Path = "/api/characters/1/replicas"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Replicas []*models.CharacterReplica `json:"replicas"`
+		Errors   map[string]string          `json:"errors"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Replicas) != 1 || resp.Replicas[0].TokenID != otherID || resp.Replicas[0].Username != "kitty_2" {
+		t.Fatalf("Expected 1 replica on token %d, got %+v", otherID, resp.Replicas)
+	}
+	if len(resp.Errors) != 2 || resp.Errors["1"] == "" || resp.Errors["99"] == "" {
+		t.Errorf("Expected owner and unknown token rejected, got %v", resp.Errors)
+	}
+	if upload, ok := mock.CameoUpload(resp.Replicas[0].CameoID); !ok || upload.Received != int64(len(video)) {
+		t.Errorf("Expected clip uploaded to the other token, got %+v", upload)
+	}
+
+	// A failed replica does not block a retry on its token
+	failed := resp.Replicas[0]
+	failed.Status = models.CharacterStatusFailed
+	db.UpdateCharacterReplica(failed)
+	req = multipartUpload(t, map[string]string{"token_ids": "2"}, video)
+	req.URL.Path = "/api/characters/1/replicas"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the failed replica retried, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	replicas, _ := db.GetCharacterReplicas(charID)
+	if len(replicas) != 1 || replicas[0].Status != models.CharacterStatusProcessing || replicas[0].CameoID == failed.CameoID {
+		t.Fatalf("Expected the failed replica replaced, got %+v", replicas)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/characters/1/replicas/"+strconv.FormatInt(replicas[0].ID, 10), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if replicas, _ := db.GetCharacterReplicas(charID); len(replicas) != 0 {
+		t.Errorf("Expected replica deleted, got %d", len(replicas))
+	}
+}
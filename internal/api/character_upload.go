@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/services"
+)
+
+const (
+	defaultMaxCharacterUpload = 100 << 20
+	defaultCharacterUploadDir = "data/uploads"
+
+	// maxUploadFieldSize caps each non-file field of a multipart upload
+	maxUploadFieldSize = 64 << 10
+)
+
+// errUploadTooLarge is returned when the video exceeds the upload limit
+var errUploadTooLarge = errors.New("video exceeds the upload size limit")
+
+// SetUploadLimits sets the largest accepted video (bytes) and the directory
+// multipart uploads are spooled to
+func (h *CharacterHandler) SetUploadLimits(maxSize int64, dir string) {
+	h.uploadMu.Lock()
+	defer h.uploadMu.Unlock()
+	h.maxUploadSize = maxSize
+	h.uploadDir = dir
+}
+
+func (h *CharacterHandler) uploadLimits() (int64, string) {
+	h.uploadMu.RLock()
+	defer h.uploadMu.RUnlock()
+	maxSize, dir := h.maxUploadSize, h.uploadDir
+	if maxSize <= 0 {
+		maxSize = defaultMaxCharacterUpload
+	}
+	if dir == "" {
+		dir = defaultCharacterUploadDir
+	}
+	return maxSize, dir
+}
+
+// spooledVideo is a character video written to a temporary file
+type spooledVideo struct {
+	file        *os.File
+	size        int64
+	contentType string
+}
+
+// Close removes the temporary file
+func (v *spooledVideo) Close() {
+	v.file.Close()
+	os.Remove(v.file.Name())
+}
+
+// HandleUploadCharacterFile handles a multipart/form-data character upload. The
+// "video" part is streamed to disk, checked to be an mp4, mov or webm file, and
+// streamed from there to Sora; the other parts are the characterUploadRequest fields.
+func (h *CharacterHandler) HandleUploadCharacterFile(c *gin.Context) {
+	maxSize, dir := h.uploadLimits()
+
+	// Leave room for the other fields and the multipart framing
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expected multipart/form-data: " + err.Error()})
+		return
+	}
+
+	var req characterUploadRequest
+	var video *spooledVideo
+	defer func() {
+		if video != nil {
+			video.Close()
+		}
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.uploadError(c, err)
+			return
+		}
+
+		if part.FormName() == "video" {
+			if video != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "only one video may be uploaded"})
+				return
+			}
+			if video, err = spoolVideo(part, dir, maxSize); err != nil {
+				h.uploadError(c, err)
+				return
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+		if err != nil {
+			h.uploadError(c, err)
+			return
+		}
+		if err := req.setField(part.FormName(), string(value)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if video == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "video file is required"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, ok := h.requestToken(c, req.TokenID)
+	if !ok {
+		return
+	}
+
+	// Token-specific proxy takes precedence over the global proxy/pool
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
+
+	if _, err := video.file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cameoID, err := h.soraClient.UploadCharacterFile(video.file, video.size, video.contentType, token.Token, req.Timestamps, proxyURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload video: " + err.Error()})
+		return
+	}
+
+	if _, err := video.file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.saveUploadedCharacter(c, &req, cameoID, video.file, video.contentType)
+}
+
+// uploadError responds to a failure while reading the upload
+func (h *CharacterHandler) uploadError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUploadTooLarge), errors.As(err, &maxBytesErr):
+		maxSize, _ := h.uploadLimits()
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("video exceeds the %d MB upload limit", maxSize>>20)})
+	case errors.Is(err, services.ErrUnsupportedVideo):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read upload: " + err.Error()})
+	}
+}
+
+// spoolVideo writes the part to a temporary file in dir, sniffing its type from
+// the first bytes and stopping once it grows past maxSize
+func spoolVideo(part *multipart.Part, dir string, maxSize int64) (*spooledVideo, error) {
+	header := make([]byte, services.VideoSniffLen)
+	n, err := io.ReadFull(part, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return nil, services.ErrUnsupportedVideo
+		}
+		return nil, err
+	}
+	header = header[:n]
+	contentType, err := services.SniffVideoType(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dir, "character-*")
+	if err != nil {
+		return nil, err
+	}
+	video := &spooledVideo{file: file, contentType: contentType}
+
+	written, err := io.Copy(file, io.MultiReader(bytes.NewReader(header), io.LimitReader(part, maxSize+1-int64(n))))
+	if err == nil && written > maxSize {
+		err = errUploadTooLarge
+	}
+	if err != nil {
+		video.Close()
+		return nil, err
+	}
+	video.size = written
+	return video, nil
+}
+
+// setField sets a field of the request from its multipart form value
+func (r *characterUploadRequest) setField(name, value string) error {
+	var err error
+	switch name {
+	case "token_id":
+		r.TokenID, err = strconv.ParseInt(value, 10, 64)
+	case "timestamps":
+		r.Timestamps = value
+	case "username":
+		r.Username = value
+	case "auto_finalize":
+		r.AutoFinalize, err = strconv.ParseBool(value)
+	case "display_name":
+		r.DisplayName = value
+	case "instruction_set":
+		r.InstructionSet = value
+	case "safety_instruction_set":
+		r.SafetyInstructionSet = value
+	case "visibility":
+		r.Visibility = value
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// webmHeader is the start of an EBML header with the webm DocType
+var webmHeader = []byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x86, 0x81, 0x01, 0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'}
+
+func setupCharacterUploadRouter(t *testing.T) (*gin.Engine, *CharacterHandler, *database.DB, *mocksora.Server, int64) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	tokenID, _ := db.CreateToken(&models.Token{Token: "at_upload", Email: "a@example.com", IsActive: true})
+
+	mock := mocksora.NewServer(&mocksora.Options{})
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	client := services.NewSoraClient(server.URL+mocksora.BasePath, 30, nil)
+	client.SetSentinelURL(server.URL + mocksora.SentinelPath)
+	t.Cleanup(client.Close)
+
+	h := NewCharacterHandler(db, client)
+	h.SetUploadLimits(1<<20, t.TempDir())
+	router := gin.New()
+	router.POST("/api/characters/upload", h.HandleUploadCharacterVideo)
+	router.POST("/api/characters/upload/file", h.HandleUploadCharacterFile)
+	return router, h, db, mock, tokenID
+}
+
+func multipartUpload(t *testing.T, fields map[string]string, video []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	if video != nil {
+		part, _ := w.CreateFormFile("video", "clip.bin")
+		part.Write(video)
+	}
+	w.Close()
+	req := httptest.NewRequest("POST", "/api/characters/upload/file", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestCharacterHandler_UploadFile(t *testing.T) {
+	router, h, db, mock, tokenID := setupCharacterUploadRouter(t)
+	_, dir := h.uploadLimits()
+
+	video := append(append([]byte{}, webmHeader...), bytes.Repeat([]byte{0x42}, 4096)...)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, multipartUpload(t, map[string]string{
+		"token_id": "1", "username": "kitty", "visibility": "public", "auto_finalize": "true",
+	}, video))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		CameoID   string            `json:"cameo_id"`
+		Character *models.Character `json:"character"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	upload, ok := mock.CameoUpload(resp.CameoID)
+	if !ok || upload.FileType != services.VideoTypeWebM || upload.Received != int64(len(video)) {
+		t.Errorf("Expected %d bytes of video/webm at Sora, got %+v", len(video), upload)
+	}
+	stored, err := db.GetCharacterByCameoID(resp.CameoID)
+	if err != nil || stored.TokenID != tokenID || !stored.AutoFinalize || stored.Visibility != models.CharacterVisibilityPublic {
+		t.Errorf("Expected stored character queued for finalize, got %+v (%v)", stored, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected spooled upload to be removed, found %d files", len(entries))
+	}
+}
+
+func TestCharacterHandler_UploadFileRejected(t *testing.T) {
+	router, _, _, mock, _ := setupCharacterUploadRouter(t)
+	fields := map[string]string{"token_id": "1", "username": "kitty"}
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		video  []byte
+		status int
+	}{
+		{"unsupported type", fields, []byte("#!/bin/sh\necho not a video\n"), http.StatusUnsupportedMediaType},
+		{"too large", fields, append(append([]byte{}, webmHeader...), make([]byte, 1<<20)...), http.StatusRequestEntityTooLarge},
+		{"missing video", fields, nil, http.StatusBadRequest},
+		{"bad visibility", map[string]string{"token_id": "1", "username": "kitty", "visibility": "friends"}, webmHeader, http.StatusBadRequest},
+		{"unknown token", map[string]string{"token_id": "99", "username": "kitty"}, webmHeader, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, multipartUpload(t, tt.fields, tt.video))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d. Body: %s", tt.name, tt.status, w.Code, w.Body.String())
+		}
+	}
+	if n := mock.Calls("POST /backend/cameo/upload/init"); n != 0 {
+		t.Errorf("Expected rejected uploads not to reach Sora, got %d", n)
+	}
+}
+
+func TestCharacterHandler_UploadJSONSniffsType(t *testing.T) {
+	router, _, _, mock, _ := setupCharacterUploadRouter(t)
+
+	mov := append([]byte{0, 0, 0, 0x14, 'f', 't', 'y', 'p', 'q', 't', ' ', ' '}, make([]byte, 64)...)
+	for _, tt := range []struct {
+		video  []byte
+		status int
+	}{
+		{mov, http.StatusOK},
+		{[]byte("plain text"), http.StatusUnsupportedMediaType},
+	} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"token_id": 1, "username": "movie", "video_data": base64.StdEncoding.EncodeToString(tt.video),
+		})
+		req := httptest.NewRequest("POST", "/api/characters/upload", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Fatalf("Expected status %d, got %d. Body: %s", tt.status, w.Code, w.Body.String())
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var resp struct {
+			CameoID string `json:"cameo_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if upload, _ := mock.CameoUpload(resp.CameoID); upload.FileType != services.VideoTypeQuickTime {
+			t.Errorf("Expected video/quicktime, got %+v", upload)
+		}
+	}
+}
+
+func TestHandleSyncCharacters_Counts(t *testing.T) {
+	router, h, db, _, _ := setupCharacterUploadRouter(t)
+	router.POST("/api/characters/sync", h.HandleSyncCharacters)
+
+	// A cameo that exists only on Sora is created locally by the sync
+	cameoID, err := h.soraClient.UploadCharacterVideo([]byte("mp4!"), "at_upload", "0-5", "")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	for i := 0; i < 2; i++ { // The mock cameo is ready after two polls
+		h.soraClient.GetCameoStatus(cameoID, "at_upload", "")
+	}
+	if _, _, err := h.soraClient.FinalizeCharacter(cameoID, "remote", "remote", "", "", models.CharacterVisibilityPrivate, "at_upload", ""); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/characters/sync", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Success bool `json:"success"`
+		Synced  int  `json:"synced"`
+		Total   int  `json:"total"`
+		Result  struct {
+			Created int `json:"created"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if !resp.Success || resp.Synced != 1 || resp.Total != 1 || resp.Result.Created != 1 {
+		t.Errorf("Expected 1 synced of 1 with the detailed result, got %s", w.Body.String())
+	}
+	if chars, _ := db.GetAllCharacters(); len(chars) != 1 {
+		t.Errorf("Expected the remote character to be created, got %d", len(chars))
+	}
+}
@@ -3,9 +3,12 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -160,6 +163,18 @@ func GetContentString(content interface{}) string {
 
 // HandleChatCompletions handles the /v1/chat/completions endpoint
 func (h *Handler) HandleChatCompletions(c *gin.Context) {
+	// Reject new generations while shutting down
+	if h.generationHandler.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: services.ErrShuttingDown.Error(),
+				Type:    "server_error",
+				Code:    "service_unavailable",
+			},
+		})
+		return
+	}
+
 	var req ChatCompletionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -184,7 +199,13 @@ func (h *Handler) HandleChatCompletions(c *gin.Context) {
 
 	// Parse multimodal content
 	parsed := ParseMessagesContent(req.Messages)
-	if parsed.Prompt == "" {
+
+	// Templates become the storyboard, styles are appended to the prompt
+	var ok bool
+	if parsed.Prompt, req.Storyboard, ok = h.applyPresets(c, req.presetOptions(), parsed.Prompt, req.Storyboard, req.Model); !ok {
+		return
+	}
+	if parsed.Prompt == "" && req.Storyboard == nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetail{
 				Message: "No user message found in messages",
@@ -204,6 +225,18 @@ func (h *Handler) HandleChatCompletions(c *gin.Context) {
 	}
 }
 
+// generate starts the generation of a chat completion request
+func (h *Handler) generate(ctx context.Context, req ChatCompletionRequest, parsed *ParsedContent, stream bool, eventChan chan<- services.StreamEvent) (*services.GenerationResult, error) {
+	if req.Storyboard != nil {
+		return h.generationHandler.GenerateStoryboard(ctx, req.Storyboard, req.Model, stream, eventChan)
+	}
+	return h.generationHandler.GenerateWithMedia(
+		ctx, parsed.Prompt, req.Model,
+		parsed.ImageData, parsed.VideoData, parsed.RemixTargetID,
+		stream, eventChan,
+	)
+}
+
 // handleNonStreamingResponse handles non-streaming chat completion
 func (h *Handler) handleNonStreamingResponse(c *gin.Context, req ChatCompletionRequest, parsed *ParsedContent, responseID string) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Minute)
@@ -212,20 +245,25 @@ func (h *Handler) handleNonStreamingResponse(c *gin.Context, req ChatCompletionR
 	isVideo := IsVideoModel(req.Model)
 
 	// Start generation with multimodal support
-	result, err := h.generationHandler.GenerateWithMedia(
-		ctx, parsed.Prompt, req.Model,
-		parsed.ImageData, parsed.VideoData, parsed.RemixTargetID,
-		false, nil,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
+	result, err := h.generate(ctx, req, parsed, false, nil)
+	if errors.Is(err, services.ErrShuttingDown) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
 			Error: ErrorDetail{
-				Message: fmt.Sprintf("Generation failed: %v", err),
+				Message: err.Error(),
 				Type:    "server_error",
+				Code:    "service_unavailable",
 			},
 		})
 		return
 	}
+	if err != nil {
+		status, resp := generationErrorResponse(err)
+		if soraErr, ok := services.AsSoraError(err); ok && soraErr.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(soraErr.RetryAfter.Seconds()))))
+		}
+		c.JSON(status, resp)
+		return
+	}
 
 	// Format result as markdown
 	content := services.FormatResultAsMarkdown(result, isVideo)
@@ -255,6 +293,38 @@ func (h *Handler) handleNonStreamingResponse(c *gin.Context, req ChatCompletionR
 	c.JSON(http.StatusOK, response)
 }
 
+// generationErrorResponse maps a generation error to an OpenAI error and HTTP status.
+// Failures of the upstream account (auth, Cloudflare, 5xx) are server errors, as the
+// client cannot fix them; quota and rate limits keep OpenAI's 429 semantics.
+func generationErrorResponse(err error) (int, ErrorResponse) {
+	message := fmt.Sprintf("Generation failed: %v", err)
+	soraErr, ok := services.AsSoraError(err)
+	if !ok {
+		return http.StatusInternalServerError, ErrorResponse{Error: ErrorDetail{Message: message, Type: "server_error"}}
+	}
+
+	status, errType, code := http.StatusBadGateway, "server_error", "upstream_error"
+	switch soraErr.Kind {
+	case services.SoraErrorContentPolicy:
+		status, errType, code = http.StatusBadRequest, "invalid_request_error", "content_policy_violation"
+	case services.SoraErrorInvalidRequest:
+		status, errType, code = http.StatusBadRequest, "invalid_request_error", "invalid_request"
+	case services.SoraErrorNotFound:
+		status, errType, code = http.StatusNotFound, "invalid_request_error", "not_found"
+	case services.SoraErrorQuota:
+		status, errType, code = http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota"
+	case services.SoraErrorRateLimit:
+		status, errType, code = http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded"
+	case services.SoraErrorAuth, services.SoraErrorForbidden:
+		status, code = http.StatusServiceUnavailable, "upstream_auth_failed"
+	case services.SoraErrorCloudflare, services.SoraErrorSentinel:
+		status, code = http.StatusServiceUnavailable, "upstream_blocked"
+	case services.SoraErrorTaskFailed:
+		status, code = http.StatusInternalServerError, "generation_failed"
+	}
+	return status, ErrorResponse{Error: ErrorDetail{Message: message, Type: errType, Code: code}}
+}
+
 // handleStreamingResponse handles streaming chat completion (SSE)
 func (h *Handler) handleStreamingResponse(c *gin.Context, req ChatCompletionRequest, parsed *ParsedContent, responseID string) {
 	c.Header("Content-Type", "text/event-stream")
@@ -304,11 +374,7 @@ func (h *Handler) handleStreamingResponse(c *gin.Context, req ChatCompletionRequ
 	errChan := make(chan error, 1)
 
 	go func() {
-		result, err := h.generationHandler.GenerateWithMedia(
-			ctx, parsed.Prompt, req.Model,
-			parsed.ImageData, parsed.VideoData, parsed.RemixTargetID,
-			true, eventChan,
-		)
+		result, err := h.generate(ctx, req, parsed, true, eventChan)
 		if err != nil {
 			errChan <- err
 		} else {
@@ -321,7 +387,7 @@ func (h *Handler) handleStreamingResponse(c *gin.Context, req ChatCompletionRequ
 	for {
 		select {
 		case <-ctx.Done():
-			h.sendErrorChunk(c.Writer, flusher, responseID, req.Model, "Request timeout")
+			h.sendErrorChunk(c.Writer, flusher, responseID, req.Model, errors.New("Request timeout"))
 			return
 
 		case event, ok := <-eventChan:
@@ -352,12 +418,12 @@ func (h *Handler) handleStreamingResponse(c *gin.Context, req ChatCompletionRequ
 				}
 
 			case "error":
-				h.sendErrorChunk(c.Writer, flusher, responseID, req.Model, event.Error)
+				h.sendErrorChunk(c.Writer, flusher, responseID, req.Model, errors.New(event.Error))
 				return
 			}
 
 		case err := <-errChan:
-			h.sendErrorChunk(c.Writer, flusher, responseID, req.Model, err.Error())
+			h.sendErrorChunk(c.Writer, flusher, responseID, req.Model, err)
 			return
 
 		case result := <-resultChan:
@@ -410,22 +476,40 @@ func (h *Handler) sendSSEEvent(w http.ResponseWriter, flusher http.Flusher, data
 	flusher.Flush()
 }
 
-// sendErrorChunk sends an error as SSE chunk
-func (h *Handler) sendErrorChunk(w http.ResponseWriter, flusher http.Flusher, responseID, model, errMsg string) {
-	errorChunk := ChatCompletionResponse{
-		ID:      responseID,
-		Object:  "chat.completion.chunk",
-		Created: time.Now().Unix(),
-		Model:   model,
-		Choices: []Choice{
-			{
-				Index: 0,
-				Delta: &ChatMessage{
-					Content: fmt.Sprintf("\n\n**错误**: %s\n", errMsg),
+// streamError is the error of a failed stream, with the status a non-streaming
+// request would have failed with
+type streamError struct {
+	ErrorDetail
+	Status int `json:"status"`
+}
+
+// streamErrorChunk is the last chunk of a failed stream
+type streamErrorChunk struct {
+	ChatCompletionResponse
+	Error streamError `json:"error"`
+}
+
+// sendErrorChunk sends an error as SSE chunk. The chunk shows the message as
+// content and carries the error of generationErrorResponse for clients.
+func (h *Handler) sendErrorChunk(w http.ResponseWriter, flusher http.Flusher, responseID, model string, err error) {
+	status, resp := generationErrorResponse(err)
+	errorChunk := streamErrorChunk{
+		ChatCompletionResponse: ChatCompletionResponse{
+			ID:      responseID,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   model,
+			Choices: []Choice{
+				{
+					Index: 0,
+					Delta: &ChatMessage{
+						Content: fmt.Sprintf("\n\n**错误**: %s\n", err.Error()),
+					},
+					FinishReason: "stop",
 				},
-				FinishReason: "stop",
 			},
 		},
+		Error: streamError{ErrorDetail: resp.Error, Status: status},
 	}
 	h.sendSSEEvent(w, flusher, errorChunk)
 	fmt.Fprintf(w, "data: [DONE]\n\n")
@@ -1,7 +1,15 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"soranow/internal/services"
 )
 
 func TestExtractPromptFromMessages(t *testing.T) {
@@ -188,3 +196,56 @@ func TestExtractRemixTargetID(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerationErrorResponse(t *testing.T) {
+	tests := []struct {
+		err     error
+		status  int
+		errType string
+		code    string
+	}{
+		{&services.SoraError{Kind: services.SoraErrorContentPolicy}, http.StatusBadRequest, "invalid_request_error", "content_policy_violation"},
+		{&services.SoraError{Kind: services.SoraErrorQuota}, http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota"},
+		{&services.SoraError{Kind: services.SoraErrorRateLimit}, http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded"},
+		{&services.SoraError{Kind: services.SoraErrorAuth}, http.StatusServiceUnavailable, "server_error", "upstream_auth_failed"},
+		{&services.SoraError{Kind: services.SoraErrorCloudflare}, http.StatusServiceUnavailable, "server_error", "upstream_blocked"},
+		{&services.SoraError{Kind: services.SoraErrorUpstream}, http.StatusBadGateway, "server_error", "upstream_error"},
+		{fmt.Errorf("failed to start generation: %w", &services.SoraError{Kind: services.SoraErrorInvalidRequest}), http.StatusBadRequest, "invalid_request_error", "invalid_request"},
+		{errors.New("generation timeout"), http.StatusInternalServerError, "server_error", ""},
+	}
+	for _, tt := range tests {
+		status, resp := generationErrorResponse(tt.err)
+		if status != tt.status || resp.Error.Type != tt.errType || resp.Error.Code != tt.code {
+			t.Errorf("%v: expected %d %s/%s, got %d %s/%s", tt.err, tt.status, tt.errType, tt.code, status, resp.Error.Type, resp.Error.Code)
+		}
+	}
+}
+
+func TestSendErrorChunk(t *testing.T) {
+	h := &Handler{}
+	w := httptest.NewRecorder()
+	h.sendErrorChunk(w, w, "chatcmpl-1", "sora2-landscape-10s", &services.SoraError{Kind: services.SoraErrorContentPolicy, Message: "blocked"})
+
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if len(events) != 2 || events[1] != "data: [DONE]" {
+		t.Fatalf("Expected an error chunk and [DONE], got %q", w.Body.String())
+	}
+	var chunk struct {
+		Choices []Choice `json:"choices"`
+		Error   struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+			Status  int    `json:"status"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(events[0], "data: ")), &chunk); err != nil {
+		t.Fatalf("Invalid chunk %q: %v", events[0], err)
+	}
+	if chunk.Error.Code != "content_policy_violation" || chunk.Error.Type != "invalid_request_error" || chunk.Error.Status != http.StatusBadRequest {
+		t.Errorf("Unexpected error %+v", chunk.Error)
+	}
+	if len(chunk.Choices) != 1 || chunk.Choices[0].FinishReason != "stop" || !strings.Contains(fmt.Sprint(chunk.Choices[0].Delta.Content), "blocked") {
+		t.Errorf("Expected the message as content, got %+v", chunk.Choices)
+	}
+}
@@ -14,25 +14,61 @@ import (
 )
 
 type GenerateHandler struct {
-	db         *database.DB
-	soraClient *services.SoraClient
+	db           *database.DB
+	soraClient   services.SoraAPI
+	poller       *services.TaskPoller
+	proxyManager *services.ProxyManager
+	moderator    services.PromptChecker
 }
 
-func NewGenerateHandler(db *database.DB) *GenerateHandler {
+func NewGenerateHandler(db *database.DB, soraClient services.SoraAPI) *GenerateHandler {
 	return &GenerateHandler{
 		db:         db,
-		soraClient: services.NewSoraClient("", 120, nil),
+		soraClient: soraClient,
+		poller:     services.NewTaskPoller(soraClient),
 	}
 }
 
+// SetTaskPoller sets the poller shared with the generation handler
+func (h *GenerateHandler) SetTaskPoller(p *services.TaskPoller) {
+	if p != nil {
+		h.poller = p
+	}
+}
+
+// SetModerator sets the check prompts must pass before they are sent to Sora
+func (h *GenerateHandler) SetModerator(m services.PromptChecker) {
+	h.moderator = m
+}
+
+// checkPrompt screens a prompt, writing the error response when it is rejected
+func (h *GenerateHandler) checkPrompt(c *gin.Context, prompt string) bool {
+	if h.moderator == nil {
+		return true
+	}
+	if err := h.moderator.CheckPrompt(c.Request.Context(), prompt); err != nil {
+		status, _ := generationErrorResponse(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// SetProxyManager sets the proxy pool used for tokens without their own proxy
+func (h *GenerateHandler) SetProxyManager(pm *services.ProxyManager) {
+	h.proxyManager = pm
+}
+
+// GenerateVideoRequest needs a prompt unless a template gives the storyboard
 type GenerateVideoRequest struct {
 	TokenID        int      `json:"token_id" binding:"required"`
-	Prompt         string   `json:"prompt" binding:"required"`
+	Prompt         string   `json:"prompt"`
 	Duration       int      `json:"duration"`
 	AspectRatio    string   `json:"aspect_ratio"`
 	Model          string   `json:"model"`
 	CameoIDs       []string `json:"cameo_ids"`
 	ReferenceImage string   `json:"reference_image"`
+	services.PresetOptions
 }
 
 type GenerateImageRequest struct {
@@ -40,6 +76,22 @@ type GenerateImageRequest struct {
 	Prompt  string `json:"prompt" binding:"required"`
 	Size    string `json:"size"`
 	Model   string `json:"model"`
+	services.PresetOptions
+}
+
+// applyPresets expands the template and style of a request, writing the error
+// response on failure
+func (h *GenerateHandler) applyPresets(c *gin.Context, opts *services.PresetOptions, prompt string) (string, *services.Storyboard, bool) {
+	if opts.TemplateID == "" && opts.Style == "" {
+		return prompt, nil, true
+	}
+	prompt, sb, err := services.ApplyPresets(h.db, opts, prompt, nil)
+	if err != nil {
+		status, _ := generationErrorResponse(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return "", nil, false
+	}
+	return prompt, sb, true
 }
 
 func (h *GenerateHandler) getTokenAndProxy(tokenID int) (string, string, error) {
@@ -48,12 +100,7 @@ func (h *GenerateHandler) getTokenAndProxy(tokenID int) (string, string, error)
 		return "", "", err
 	}
 
-	proxyURL := token.ProxyURL
-	if proxyURL == "" {
-		if cfg, err := h.db.GetSystemConfig(); err == nil && cfg.ProxyEnabled {
-			proxyURL = cfg.ProxyURL
-		}
-	}
+	proxyURL := services.TokenProxy(h.db, h.proxyManager, token)
 
 	accessToken := token.Token
 
@@ -96,6 +143,32 @@ func (h *GenerateHandler) HandleGenerateVideo(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if strings.TrimSpace(req.Prompt) == "" && req.TemplateID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prompt 不能为空"})
+		return
+	}
+	prompt, storyboard, ok := h.applyPresets(c, &req.PresetOptions, req.Prompt)
+	if !ok {
+		return
+	}
+
+	nFrames := h.durationToFrames(req.Duration)
+	if storyboard != nil {
+		// Without a duration the template takes the shortest video holding it;
+		// the last shot is extended to fill the video
+		if req.Duration <= 0 {
+			nFrames = services.ClipFrames(storyboard.Frames())
+		}
+		storyboard.Fill(nFrames)
+		if err := storyboard.Validate(nFrames); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		prompt = storyboard.Prompt()
+	}
+	if !h.checkPrompt(c, prompt) {
+		return
+	}
 
 	accessToken, proxyURL, err := h.getTokenAndProxy(req.TokenID)
 	if err != nil {
@@ -108,17 +181,19 @@ func (h *GenerateHandler) HandleGenerateVideo(c *gin.Context) {
 		orientation = "portrait"
 	}
 
-	nFrames := h.durationToFrames(req.Duration)
-
 	var taskID string
-	if len(req.CameoIDs) > 0 {
+	if storyboard != nil {
+		taskID, err = h.soraClient.GenerateStructuredStoryboard(
+			storyboard, accessToken, orientation, nFrames, req.CameoIDs, proxyURL,
+		)
+	} else if len(req.CameoIDs) > 0 {
 		taskID, err = h.soraClient.GenerateVideoWithCameo(
-			req.Prompt, accessToken, orientation, "",
+			prompt, accessToken, orientation, "",
 			nFrames, "", "sy_8", "small", req.CameoIDs, proxyURL,
 		)
 	} else {
 		taskID, err = h.soraClient.GenerateVideo(
-			req.Prompt, accessToken, orientation, "",
+			prompt, accessToken, orientation, "",
 			nFrames, "", "sy_8", "small", proxyURL,
 		)
 	}
@@ -140,6 +215,14 @@ func (h *GenerateHandler) HandleGenerateImage(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.TemplateID != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "图片生成不支持模板"})
+		return
+	}
+	prompt, _, ok := h.applyPresets(c, &req.PresetOptions, req.Prompt)
+	if !ok || !h.checkPrompt(c, prompt) {
+		return
+	}
 
 	accessToken, proxyURL, err := h.getTokenAndProxy(req.TokenID)
 	if err != nil {
@@ -156,7 +239,7 @@ func (h *GenerateHandler) HandleGenerateImage(c *gin.Context) {
 	}
 
 	taskID, err := h.soraClient.GenerateImage(
-		req.Prompt, accessToken, width, height, "", proxyURL,
+		prompt, accessToken, width, height, "", proxyURL,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -166,6 +249,8 @@ func (h *GenerateHandler) HandleGenerateImage(c *gin.Context) {
 	// Poll for image result (images are fast)
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
 	defer cancel()
+	release := h.poller.Watch(accessToken)
+	defer release()
 
 	for {
 		select {
@@ -176,7 +261,7 @@ func (h *GenerateHandler) HandleGenerateImage(c *gin.Context) {
 		}
 
 		time.Sleep(3 * time.Second)
-		task, err := h.soraClient.FindTaskInImageTasks(taskID, accessToken, proxyURL)
+		task, err := h.poller.FindRecentTask(taskID, accessToken, proxyURL, 3*time.Second)
 		if err != nil || task == nil {
 			continue
 		}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// rejectingChecker rejects prompts containing a word
+type rejectingChecker struct{ word string }
+
+func (r rejectingChecker) CheckPrompt(ctx context.Context, prompt string) error {
+	if strings.Contains(prompt, r.word) {
+		return &services.SoraError{Kind: services.SoraErrorContentPolicy, Code: services.ContentPolicyCode, Message: "prompt rejected"}
+	}
+	return nil
+}
+
+func TestGenerateHandler_Moderation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+	db.CreateToken(&models.Token{Token: "at_generate", Email: "g@example.com", IsActive: true})
+
+	mock := mocksora.NewServer(&mocksora.Options{})
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	client := services.NewSoraClient(server.URL+mocksora.BasePath, 30, nil)
+	client.SetSentinelURL(server.URL + mocksora.SentinelPath)
+	defer client.Close()
+
+	h := NewGenerateHandler(db, client)
+	h.SetModerator(rejectingChecker{word: "forbidden"})
+	router := gin.New()
+	router.POST("/api/generate/video", h.HandleGenerateVideo)
+	router.POST("/api/generate/image", h.HandleGenerateImage)
+
+	for _, path := range []string{"/api/generate/video", "/api/generate/image"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"token_id": 1, "prompt": "a forbidden cat"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "prompt rejected") {
+			t.Errorf("%s: expected 400 for a rejected prompt, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+	if got := mock.Calls("POST /backend/nf/create") + mock.Calls("POST /backend/video_gen"); got != 0 {
+		t.Errorf("Expected rejected prompts not sent to Sora, got %d generations", got)
+	}
+}
@@ -16,22 +16,28 @@ type Handler struct {
 	concurrency       *services.ConcurrencyManager
 	tokenManager      *services.TokenManager
 	generationHandler *services.GenerationHandler
+	storyRenderer     *services.StoryRenderer
 }
 
 // NewHandler creates a new Handler instance
 func NewHandler(db *database.DB, lb *services.LoadBalancer, cm *services.ConcurrencyManager) *Handler {
-	tm := services.NewTokenManager(db, lb, cm)
+	return newHandler(db, lb, cm, services.NewTokenManager(db, lb, cm), nil)
+}
 
+// newHandler creates a Handler that shares the given token manager and Sora upstream
+func newHandler(db *database.DB, lb *services.LoadBalancer, cm *services.ConcurrencyManager, tm *services.TokenManager, sora services.SoraAPI) *Handler {
 	// Get config for generation handler
 	var genCfg *services.GenerationConfig
 	if cfg, err := db.GetSystemConfig(); err == nil {
 		genCfg = &services.GenerationConfig{
-			ImageTimeout:  cfg.ImageTimeout,
-			VideoTimeout:  cfg.VideoTimeout,
-			PollInterval:  2500 * time.Millisecond,
-			WatermarkFree: cfg.WatermarkFreeEnabled,
-			CacheEnabled:  cfg.CacheEnabled,
-			CacheBaseURL:  cfg.CacheBaseURL,
+			ImageTimeout:    cfg.ImageTimeout,
+			VideoTimeout:    cfg.VideoTimeout,
+			PollInterval:    2500 * time.Millisecond,
+			MaxPollInterval: 10 * time.Second,
+			MaxPollAttempts: 600,
+			WatermarkFree:   cfg.WatermarkFreeEnabled,
+			CacheEnabled:    cfg.CacheEnabled,
+			CacheBaseURL:    cfg.CacheBaseURL,
 		}
 	}
 
@@ -40,10 +46,15 @@ func NewHandler(db *database.DB, lb *services.LoadBalancer, cm *services.Concurr
 		loadBalancer:      lb,
 		concurrency:       cm,
 		tokenManager:      tm,
-		generationHandler: services.NewGenerationHandler(db, lb, tm, genCfg),
+		generationHandler: services.NewGenerationHandler(db, lb, tm, genCfg, sora),
 	}
 }
 
+// SetStoryRenderer sets the renderer of story jobs; without it /v1/stories is unavailable
+func (h *Handler) SetStoryRenderer(r *services.StoryRenderer) {
+	h.storyRenderer = r
+}
+
 // Model represents an OpenAI-compatible model
 type Model struct {
 	ID      string `json:"id"`
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -90,3 +91,27 @@ func TestHandleModels_ResponseFormat(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleChatCompletions_Draining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	handler := NewHandler(db, nil, nil)
+	handler.generationHandler.BeginDrain()
+
+	router := gin.New()
+	router.POST("/v1/chat/completions", handler.HandleChatCompletions)
+
+	body := `{"model": "sora-image", "messages": [{"role": "user", "content": "a cat"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
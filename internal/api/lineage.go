@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// IterationRequest is the request of /v1/tasks/:task_id/remix and /extend
+type IterationRequest struct {
+	Prompt string `json:"prompt" binding:"required"`
+	Model  string `json:"model"` // Defaults to the model of the parent task
+}
+
+// IterationResponse is the result of a remix or extension
+type IterationResponse struct {
+	ID           string   `json:"id"` // Sora task ID of the new video
+	Object       string   `json:"object"`
+	Created      int64    `json:"created"`
+	Model        string   `json:"model"`
+	Status       string   `json:"status"`
+	Operation    string   `json:"operation"` // remix or extend
+	ParentID     string   `json:"parent_id"` // Task the video derives from
+	GenerationID string   `json:"generation_id,omitempty"`
+	URLs         []string `json:"urls"`
+}
+
+// HandleRemixTask handles POST /v1/tasks/:task_id/remix: a new video remixing
+// the video of a completed task, recorded as its child
+func (h *Handler) HandleRemixTask(c *gin.Context) {
+	h.handleIteration(c, models.TaskOperationRemix, h.generationHandler.Remix)
+}
+
+// HandleExtendTask handles POST /v1/tasks/:task_id/extend: a new video
+// continuing the video of a completed task, recorded as its child
+func (h *Handler) HandleExtendTask(c *gin.Context) {
+	h.handleIteration(c, models.TaskOperationExtend, h.generationHandler.Extend)
+}
+
+type iterateFunc func(ctx context.Context, parentTaskID, prompt, model string, stream bool, eventChan chan<- services.StreamEvent) (*services.GenerationResult, error)
+
+// handleIteration runs a remix or extension and waits for the video
+func (h *Handler) handleIteration(c *gin.Context, operation string, iterate iterateFunc) {
+	if h.generationHandler.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: services.ErrShuttingDown.Error(),
+				Type:    "server_error",
+				Code:    "service_unavailable",
+			},
+		})
+		return
+	}
+
+	var req IterationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid request: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	if req.Model != "" && !IsVideoModel(req.Model) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Remixes and extensions need a video model, got %s", req.Model),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Minute)
+	defer cancel()
+
+	parentID := c.Param("task_id")
+	result, err := iterate(ctx, parentID, req.Prompt, req.Model, false, nil)
+	if errors.Is(err, services.ErrShuttingDown) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "server_error",
+				Code:    "service_unavailable",
+			},
+		})
+		return
+	}
+	if err != nil {
+		status, resp := generationErrorResponse(err)
+		c.JSON(status, resp)
+		return
+	}
+
+	model := req.Model
+	if task, err := h.db.GetTaskByTaskID(result.TaskID); err == nil {
+		model = task.Model
+	}
+	c.JSON(http.StatusOK, IterationResponse{
+		ID:           result.TaskID,
+		Object:       "video." + operation,
+		Created:      time.Now().Unix(),
+		Model:        model,
+		Status:       result.Status,
+		Operation:    operation,
+		ParentID:     parentID,
+		GenerationID: result.GenerationID,
+		URLs:         result.URLs,
+	})
+}
+
+// HandleTaskLineage handles GET /v1/tasks/:task_id/lineage: the tasks the video
+// derives from and the tree of all remixes and extensions of its original video
+func (h *Handler) HandleTaskLineage(c *gin.Context) {
+	lineage, err := h.generationHandler.Lineage(c.Param("task_id"))
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "Task not found",
+				Type:    "invalid_request_error",
+				Code:    "not_found",
+			},
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, lineage)
+}
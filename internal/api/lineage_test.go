@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+func TestHandleTaskLineage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, task := range []*models.Task{
+		{TaskID: "task_root", Model: "sora2-landscape-10s", Prompt: "a cat", Status: models.TaskStatusCompleted},
+		{TaskID: "task_remix", Model: "sora2-landscape-10s", Prompt: "a dog", Status: models.TaskStatusCompleted, ParentTaskID: "task_root", Operation: models.TaskOperationRemix},
+		{TaskID: "task_extend", Model: "sora2-landscape-10s", Prompt: "it runs", Status: models.TaskStatusProcessing, ParentTaskID: "task_remix", Operation: models.TaskOperationExtend},
+	} {
+		if _, err := db.CreateTask(task); err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+	}
+
+	handler := NewHandler(db, services.NewLoadBalancer(), nil)
+	router := gin.New()
+	router.POST("/v1/tasks/:task_id/remix", handler.HandleRemixTask)
+	router.POST("/v1/tasks/:task_id/extend", handler.HandleExtendTask)
+	router.GET("/v1/tasks/:task_id/lineage", handler.HandleTaskLineage)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/v1/tasks/task_extend/lineage", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var lineage services.TaskLineage
+	if err := json.Unmarshal(w.Body.Bytes(), &lineage); err != nil {
+		t.Fatalf("Failed to parse lineage: %v", err)
+	}
+	if len(lineage.Ancestors) != 2 || lineage.Ancestors[0].TaskID != "task_root" || lineage.Tree.TaskID != "task_root" {
+		t.Errorf("Unexpected lineage %s", w.Body.String())
+	}
+	if len(lineage.Tree.Children) != 1 || len(lineage.Tree.Children[0].Children) != 1 ||
+		lineage.Tree.Children[0].Children[0].Operation != models.TaskOperationExtend {
+		t.Errorf("Unexpected tree %s", w.Body.String())
+	}
+
+	if w := do("GET", "/v1/tasks/task_missing/lineage", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+
+	tests := []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/v1/tasks/task_missing/remix", `{"prompt": "x"}`, http.StatusNotFound},
+		{"/v1/tasks/task_root/remix", `{}`, http.StatusBadRequest},
+		{"/v1/tasks/task_root/remix", `{"prompt": "x", "model": "sora-image"}`, http.StatusBadRequest},
+		{"/v1/tasks/task_root/remix", `{"prompt": "x"}`, http.StatusBadRequest}, // No generation ID recorded
+		{"/v1/tasks/task_extend/extend", `{"prompt": "x"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := do("POST", tt.path, tt.body); w.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d: %s", tt.path, tt.body, tt.code, w.Code, w.Body.String())
+		}
+	}
+}
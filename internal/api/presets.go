@@ -0,0 +1,266 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// applyPresets expands the template and style of a generation request, writing
+// the error response on failure. A template shorter than the video model is
+// padded to its length; an empty model leaves the template as it is.
+func (h *Handler) applyPresets(c *gin.Context, opts *services.PresetOptions, prompt string, sb *services.Storyboard, model string) (string, *services.Storyboard, bool) {
+	if opts.TemplateID == "" && opts.Style == "" {
+		return prompt, sb, true
+	}
+	prompt, sb, err := services.ApplyPresets(h.db, opts, prompt, sb)
+	if err != nil {
+		status, resp := generationErrorResponse(err)
+		c.JSON(status, resp)
+		return "", nil, false
+	}
+	if modelCfg := services.ParseModel(model); opts.TemplateID != "" && model != "" && modelCfg.IsVideo {
+		sb.Fill(modelCfg.NFrames)
+	}
+	return prompt, sb, true
+}
+
+// PresetHandler handles prompt template and style preset API requests
+type PresetHandler struct {
+	db *database.DB
+}
+
+// NewPresetHandler creates a new PresetHandler
+func NewPresetHandler(db *database.DB) *PresetHandler {
+	return &PresetHandler{db: db}
+}
+
+// HandleGetTemplates returns all prompt templates
+func (h *PresetHandler) HandleGetTemplates(c *gin.Context) {
+	templates, err := h.db.GetPromptTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if templates == nil {
+		templates = []*models.PromptTemplate{}
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// HandleGetTemplate returns one prompt template
+func (h *PresetHandler) HandleGetTemplate(c *gin.Context) {
+	if tpl := h.getTemplate(c); tpl != nil {
+		c.JSON(http.StatusOK, gin.H{"template": tpl})
+	}
+}
+
+// HandleCreateTemplate creates a prompt template
+func (h *PresetHandler) HandleCreateTemplate(c *gin.Context) {
+	var tpl models.PromptTemplate
+	if err := c.ShouldBindJSON(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateTemplate(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.db.GetPromptTemplate(tpl.ID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "模板 ID 已存在: " + tpl.ID})
+		return
+	}
+	h.saveTemplate(c, &tpl)
+}
+
+// HandleUpdateTemplate replaces a prompt template; the ID of the path wins over the body
+func (h *PresetHandler) HandleUpdateTemplate(c *gin.Context) {
+	existing := h.getTemplate(c)
+	if existing == nil {
+		return
+	}
+	var tpl models.PromptTemplate
+	if err := c.ShouldBindJSON(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tpl.ID = existing.ID
+	if err := services.ValidateTemplate(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.saveTemplate(c, &tpl)
+}
+
+// HandleDeleteTemplate deletes a prompt template
+func (h *PresetHandler) HandleDeleteTemplate(c *gin.Context) {
+	tpl := h.getTemplate(c)
+	if tpl == nil {
+		return
+	}
+	if err := h.db.DeletePromptTemplate(tpl.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// getTemplate loads the template of the :id param, writing the error response on failure
+func (h *PresetHandler) getTemplate(c *gin.Context) *models.PromptTemplate {
+	tpl, err := h.db.GetPromptTemplate(c.Param("id"))
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return tpl
+}
+
+// saveTemplate stores the template and responds with it
+func (h *PresetHandler) saveTemplate(c *gin.Context, tpl *models.PromptTemplate) {
+	if err := h.db.SavePromptTemplate(tpl); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	saved, err := h.db.GetPromptTemplate(tpl.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "template": saved})
+}
+
+// HandleGetStyles returns all style presets
+func (h *PresetHandler) HandleGetStyles(c *gin.Context) {
+	styles, err := h.db.GetStylePresets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if styles == nil {
+		styles = []*models.StylePreset{}
+	}
+	c.JSON(http.StatusOK, gin.H{"styles": styles})
+}
+
+// HandleCreateStyle creates a style preset
+func (h *PresetHandler) HandleCreateStyle(c *gin.Context) {
+	var style models.StylePreset
+	if err := c.ShouldBindJSON(&style); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateStyle(&style); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.db.GetStylePreset(style.ID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "风格 ID 已存在: " + style.ID})
+		return
+	}
+	h.saveStyle(c, &style)
+}
+
+// HandleUpdateStyle replaces a style preset; the ID of the path wins over the body
+func (h *PresetHandler) HandleUpdateStyle(c *gin.Context) {
+	existing := h.getStyle(c)
+	if existing == nil {
+		return
+	}
+	var style models.StylePreset
+	if err := c.ShouldBindJSON(&style); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	style.ID = existing.ID
+	if err := services.ValidateStyle(&style); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.saveStyle(c, &style)
+}
+
+// HandleDeleteStyle deletes a style preset; templates using it fall back to no style
+func (h *PresetHandler) HandleDeleteStyle(c *gin.Context) {
+	style := h.getStyle(c)
+	if style == nil {
+		return
+	}
+	if err := h.db.DeleteStylePreset(style.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// getStyle loads the style of the :id param, writing the error response on failure
+func (h *PresetHandler) getStyle(c *gin.Context) *models.StylePreset {
+	style, err := h.db.GetStylePreset(c.Param("id"))
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "style not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return style
+}
+
+// saveStyle stores the style and responds with it
+func (h *PresetHandler) saveStyle(c *gin.Context, style *models.StylePreset) {
+	if err := h.db.SaveStylePreset(style); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	saved, err := h.db.GetStylePreset(style.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "style": saved})
+}
+
+// HandleExportPresets downloads all templates and styles as JSON
+func (h *PresetHandler) HandleExportPresets(c *gin.Context) {
+	bundle, err := services.ExportPresets(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filename := "soranow-presets-" + time.Now().Format("20060102") + ".json"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.JSON(http.StatusOK, bundle)
+}
+
+// HandleImportPresets imports templates and styles exported by HandleExportPresets.
+// Entries replace those with the same ID; ?replace=true deletes all others first.
+func (h *PresetHandler) HandleImportPresets(c *gin.Context) {
+	var bundle services.PresetBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if bundle.Version > services.PresetBundleVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的预设版本"})
+		return
+	}
+	if len(bundle.Templates) == 0 && len(bundle.Styles) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有找到模板或风格"})
+		return
+	}
+
+	result, err := services.ImportPresets(h.db, &bundle, c.Query("replace") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": len(result.Failed) == 0, "result": result})
+}
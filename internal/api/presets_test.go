@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+func TestPresetHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	h := NewPresetHandler(db)
+	router := gin.New()
+	router.GET("/api/templates", h.HandleGetTemplates)
+	router.POST("/api/templates", h.HandleCreateTemplate)
+	router.PUT("/api/templates/:id", h.HandleUpdateTemplate)
+	router.DELETE("/api/templates/:id", h.HandleDeleteTemplate)
+	router.POST("/api/styles", h.HandleCreateStyle)
+	router.GET("/api/presets/export", h.HandleExportPresets)
+	router.POST("/api/presets/import", h.HandleImportPresets)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	template := `{"id": "beach", "category": "travel", "name": "Beach", "shots": [{"duration": 5, "prompt": "waves at {time}"}],
+		"variables": [{"name": "time", "default": "dawn"}]}`
+	if w := do("POST", "/api/templates", template); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/api/templates", template); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate ID, got %d", w.Code)
+	}
+	if w := do("POST", "/api/templates", `{"id": "x", "name": "X", "shots": []}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without shots, got %d", w.Code)
+	}
+	if w := do("PUT", "/api/templates/missing", template); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+	w := do("PUT", "/api/templates/beach", `{"id": "other", "name": "Beach 2", "shots": [{"duration": 10, "prompt": "waves"}]}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"beach"`) || !strings.Contains(w.Body.String(), "Beach 2") {
+		t.Errorf("Expected the template to be updated in place, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/api/styles", `{"id": "anime", "name": "Anime", "prompt_suffix": "anime style"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do("GET", "/api/presets/export", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("Expected a JSON download, got %d", w.Code)
+	}
+	var bundle services.PresetBundle
+	if err := json.Unmarshal(w.Body.Bytes(), &bundle); err != nil || len(bundle.Templates) != 1 || len(bundle.Styles) != 1 {
+		t.Fatalf("Unexpected export %s: %v", w.Body.String(), err)
+	}
+
+	if w := do("DELETE", "/api/templates/beach", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+	export := w.Body.String()
+	if w := do("POST", "/api/presets/import", export); w.Code != http.StatusOK {
+		t.Errorf("Expected the export to import, got %d: %s", w.Code, w.Body.String())
+	}
+	if tpl, err := db.GetPromptTemplate("beach"); err != nil || tpl.Name != "Beach 2" {
+		t.Errorf("Expected the imported template, got %+v: %v", tpl, err)
+	}
+	if w := do("POST", "/api/presets/import", `{"version": 99, "styles": [{"id": "a", "name": "A", "prompt_suffix": "a"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown version, got %d", w.Code)
+	}
+}
+
+func TestGenerationPresets_Rejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	handler := NewHandler(db, nil, nil)
+	router := gin.New()
+	router.POST("/v1/storyboards", handler.HandleStoryboard)
+	router.POST("/v1/chat/completions", handler.HandleChatCompletions)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want string
+	}{
+		{"unknown template", "/v1/storyboards", `{"model": "sora2-landscape-10s", "template_id": "missing"}`, "模板"},
+		{"unknown style", "/v1/chat/completions", `{"model": "sora2-landscape-10s", "messages": [{"role": "user", "content": "a cat"}], "style": "missing"}`, "风格"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: expected 400 mentioning %s, got %d: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGenerateHandler_Presets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+	db.CreateToken(&models.Token{Token: "at_generate", Email: "g@example.com", IsActive: true})
+	db.SaveStylePreset(&models.StylePreset{ID: "anime", Name: "Anime", PromptSuffix: "anime style"})
+	db.SavePromptTemplate(&models.PromptTemplate{
+		ID: "beach", Name: "Beach", Shots: []models.TemplateShot{{Duration: 5, Prompt: "waves"}, {Duration: 5, Prompt: "sunset"}},
+	})
+	db.SavePromptTemplate(&models.PromptTemplate{
+		ID: "hook", Name: "Hook", Shots: []models.TemplateShot{{Duration: 2.5, Prompt: "a sudden reveal"}},
+	})
+	db.SavePromptTemplate(&models.PromptTemplate{
+		ID: "long", Name: "Long", Shots: []models.TemplateShot{{Duration: 10, Prompt: "dawn"}, {Duration: 10, Prompt: "dusk"}},
+	})
+
+	mock := mocksora.NewServer(&mocksora.Options{})
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	client := services.NewSoraClient(server.URL+mocksora.BasePath, 30, nil)
+	client.SetSentinelURL(server.URL + mocksora.SentinelPath)
+	defer client.Close()
+
+	h := NewGenerateHandler(db, client)
+	router := gin.New()
+	router.POST("/api/generate/video", h.HandleGenerateVideo)
+	router.POST("/api/generate/image", h.HandleGenerateImage)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"template", "/api/generate/video", `{"token_id": 1, "template_id": "beach", "style": "anime"}`, http.StatusOK},
+		{"no prompt", "/api/generate/video", `{"token_id": 1}`, http.StatusBadRequest},
+		{"unknown style", "/api/generate/video", `{"token_id": 1, "prompt": "a cat", "style": "missing"}`, http.StatusBadRequest},
+		{"short template", "/api/generate/video", `{"token_id": 1, "template_id": "hook"}`, http.StatusOK},
+		{"padded template", "/api/generate/video", `{"token_id": 1, "template_id": "beach", "duration": 15}`, http.StatusOK},
+		{"template too long", "/api/generate/video", `{"token_id": 1, "template_id": "long", "duration": 10}`, http.StatusBadRequest},
+		{"image template", "/api/generate/image", `{"token_id": 1, "prompt": "a cat", "template_id": "beach"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.code, w.Code, w.Body.String())
+		}
+	}
+	if got := mock.Calls("POST /backend/nf/create/storyboard"); got != 3 {
+		t.Errorf("Expected the template rendered as a storyboard, got %d storyboard generations", got)
+	}
+}
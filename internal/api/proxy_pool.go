@@ -0,0 +1,173 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/services"
+)
+
+// maxProxyPoolUpload limits the size of an uploaded proxy list
+const maxProxyPoolUpload = 1 << 20
+
+// globalProxy returns the proxy for requests without a token-specific proxy:
+// the next pool proxy if a proxy manager is set, otherwise the system config proxy
+func globalProxy(db *database.DB, pm *services.ProxyManager) string {
+	if pm != nil {
+		return pm.GetProxyURL()
+	}
+	if cfg, err := db.GetSystemConfig(); err == nil && cfg.ProxyEnabled {
+		return cfg.ProxyURL
+	}
+	return ""
+}
+
+// ProxyPoolHandler handles proxy pool admin endpoints
+type ProxyPoolHandler struct {
+	proxyManager *services.ProxyManager
+}
+
+// NewProxyPoolHandler creates a new ProxyPoolHandler
+func NewProxyPoolHandler(pm *services.ProxyManager) *ProxyPoolHandler {
+	return &ProxyPoolHandler{proxyManager: pm}
+}
+
+// UploadProxyPoolRequest represents proxy list upload request
+type UploadProxyPoolRequest struct {
+	Content string `json:"content"`
+}
+
+// TestProxyPoolRequest represents proxy pool test request
+type TestProxyPoolRequest struct {
+	ProxyURL string `json:"proxy_url"`
+}
+
+// HandleGetProxyPool returns the pool configuration and per-proxy statistics
+func (h *ProxyPoolHandler) HandleGetProxyPool(c *gin.Context) {
+	enabled, poolEnabled, _, count := h.proxyManager.GetConfig()
+	stats := h.proxyManager.Stats()
+
+	healthy := 0
+	for _, st := range stats {
+		if st.Healthy {
+			healthy++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"proxy_enabled": enabled,
+		"pool_enabled":  poolEnabled,
+		"pool_file":     h.proxyManager.PoolFile(),
+		"count":         count,
+		"healthy":       healthy,
+		"proxies":       stats,
+		"bindings":      h.proxyManager.Bindings(),
+	})
+}
+
+// HandleUploadProxyPool replaces the proxy list (JSON content or multipart "file") and reloads the pool
+func (h *ProxyPoolHandler) HandleUploadProxyPool(c *gin.Context) {
+	var content string
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请选择代理文件"})
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, maxProxyPoolUpload+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		content = string(data)
+	} else {
+		var req UploadProxyPoolRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		content = req.Content
+	}
+
+	if len(content) > maxProxyPoolUpload {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "代理文件过大"})
+		return
+	}
+
+	count, err := h.proxyManager.SavePool(content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "代理池已更新",
+		"count":   count,
+	})
+}
+
+// HandleReloadProxyPool reloads the pool from the proxy file
+func (h *ProxyPoolHandler) HandleReloadProxyPool(c *gin.Context) {
+	count, err := h.proxyManager.ReloadPool()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "代理池已重新加载",
+		"count":   count,
+	})
+}
+
+// HandleTestProxyPool probes one proxy (proxy_url) or runs a health check of the whole pool
+func (h *ProxyPoolHandler) HandleTestProxyPool(c *gin.Context) {
+	var req TestProxyPoolRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	if req.ProxyURL != "" {
+		latency, err := h.proxyManager.Probe(c.Request.Context(), req.ProxyURL)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success":   false,
+				"proxy_url": req.ProxyURL,
+				"error":     err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"proxy_url":  req.ProxyURL,
+			"latency_ms": latency.Milliseconds(),
+		})
+		return
+	}
+
+	start := time.Now()
+	healthy := h.proxyManager.CheckHealth(c.Request.Context())
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"healthy":     healthy,
+		"count":       h.proxyManager.GetPoolCount(),
+		"duration_ms": time.Since(start).Milliseconds(),
+		"proxies":     h.proxyManager.Stats(),
+	})
+}
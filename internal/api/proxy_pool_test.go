@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/services"
+)
+
+func setupProxyPoolRouter(t *testing.T) (*gin.Engine, *services.ProxyManager) {
+	gin.SetMode(gin.TestMode)
+
+	pm := services.NewProxyManager(t.TempDir())
+	pm.SetEnabled(true)
+	pm.SetPoolEnabled(true)
+	pm.SetProber(func(ctx context.Context, proxyURL string) (time.Duration, error) {
+		if proxyURL == "http://2.2.2.2:8080" {
+			return 0, errors.New("connection refused")
+		}
+		return 50 * time.Millisecond, nil
+	})
+
+	h := NewProxyPoolHandler(pm)
+	router := gin.New()
+	router.GET("/api/proxy/pool", h.HandleGetProxyPool)
+	router.POST("/api/proxy/pool", h.HandleUploadProxyPool)
+	router.POST("/api/proxy/pool/reload", h.HandleReloadProxyPool)
+	router.POST("/api/proxy/pool/test", h.HandleTestProxyPool)
+	return router, pm
+}
+
+func TestProxyPoolHandler_UploadAndList(t *testing.T) {
+	router, pm := setupProxyPoolRouter(t)
+
+	body, _ := json.Marshal(UploadProxyPoolRequest{Content: "1.1.1.1:8080\n2.2.2.2:8080\n"})
+	req := httptest.NewRequest("POST", "/api/proxy/pool", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if pm.GetPoolCount() != 2 {
+		t.Errorf("Expected 2 proxies in pool, got %d", pm.GetPoolCount())
+	}
+
+	req = httptest.NewRequest("GET", "/api/proxy/pool", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp struct {
+		Count   int                   `json:"count"`
+		Proxies []services.ProxyStats `json:"proxies"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Count != 2 || len(resp.Proxies) != 2 {
+		t.Errorf("Expected 2 proxies listed, got %+v", resp)
+	}
+}
+
+func TestProxyPoolHandler_Test(t *testing.T) {
+	router, pm := setupProxyPoolRouter(t)
+	pm.SetHealthPolicy(1, time.Minute, "")
+	pm.SavePool("1.1.1.1:8080\n2.2.2.2:8080\n")
+
+	// Single proxy
+	body, _ := json.Marshal(TestProxyPoolRequest{ProxyURL: "http://2.2.2.2:8080"})
+	req := httptest.NewRequest("POST", "/api/proxy/pool/test", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var single map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &single)
+	if single["success"] != false || single["error"] == nil {
+		t.Errorf("Expected failed probe, got %v", single)
+	}
+
+	// Whole pool
+	req = httptest.NewRequest("POST", "/api/proxy/pool/test", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var all struct {
+		Healthy int `json:"healthy"`
+		Count   int `json:"count"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &all)
+	if all.Healthy != 1 || all.Count != 2 {
+		t.Errorf("Expected 1/2 healthy, got %d/%d", all.Healthy, all.Count)
+	}
+	if got := pm.GetProxyURL(); got != "http://1.1.1.1:8080" {
+		t.Errorf("Expected unhealthy proxy to be skipped, got %s", got)
+	}
+}
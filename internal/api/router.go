@@ -6,23 +6,157 @@ import (
 	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"soranow/internal/config"
 	"soranow/internal/database"
 	"soranow/internal/services"
 )
 
+// RouterOptions holds services shared between the router and the rest of the process.
+// Nil fields are created by the router and filled in, so the caller can reach them
+// (e.g. GenerationHandler for draining on shutdown).
+type RouterOptions struct {
+	TokenManager      *services.TokenManager
+	Webhooks          *services.WebhookDispatcher
+	TaskHub           *services.TaskHub
+	SessionManager    *services.SessionManager
+	GenerationHandler *services.GenerationHandler
+	Config            *config.Manager
+	ProxyManager      *services.ProxyManager // Optional; without it the system config proxy is used
+	Sora              services.SoraAPI       // Optional; defaults to a SoraClient on the shared sessions and proxy pool
+	Moderator         *services.Moderator    // Optional; without it prompts are not screened
+	CharacterWatcher  *services.CharacterWatcher
+	CharacterArchive  *services.FileCache // Optional; without it character source clips are not kept
+	FileCache         *services.FileCache // Optional; without it story jobs are unavailable
+	StoryRenderer     *services.StoryRenderer
+}
+
 // SetupRouter creates and configures the Gin router
 func SetupRouter(db *database.DB, lb *services.LoadBalancer, cm *services.ConcurrencyManager) *gin.Engine {
+	return SetupRouterWithOptions(db, lb, cm, nil)
+}
+
+// SetupRouterWithOptions creates the Gin router using the shared services in opts
+func SetupRouterWithOptions(db *database.DB, lb *services.LoadBalancer, cm *services.ConcurrencyManager, opts *RouterOptions) *gin.Engine {
+	if opts == nil {
+		opts = &RouterOptions{}
+	}
+	if opts.Webhooks == nil {
+		opts.Webhooks = services.NewWebhookDispatcher(db)
+	}
+	if opts.TaskHub == nil {
+		opts.TaskHub = services.NewTaskHub()
+	}
+	if opts.TokenManager == nil {
+		opts.TokenManager = services.NewTokenManager(db, lb, cm)
+		opts.TokenManager.SetWebhookDispatcher(opts.Webhooks)
+	}
+
+	// One Sora upstream for generation and character operations. Sharing the
+	// session manager keeps a single TLS session per token across them, and
+	// lets shutdown stop its cleanup loop.
+	var sentinels *services.SentinelCache
+	if opts.Sora == nil {
+		sentinels = services.NewSentinelCache(services.DefaultSentinelTTL)
+		soraClient := services.NewSoraClient("", 120, nil)
+		soraClient.SetFingerprintResolver(services.TokenFingerprints(db))
+		soraClient.SetSentinelCache(sentinels)
+		if opts.ProxyManager != nil {
+			soraClient.SetProxyManager(opts.ProxyManager)
+		}
+		if opts.SessionManager != nil {
+			soraClient.SetSessionManager(opts.SessionManager)
+		}
+		if opts.Config != nil {
+			// Base URL, timeout and retries follow config reloads
+			soraClient.Configure(services.SoraClientConfigFrom(opts.Config.Get()))
+			opts.Config.Subscribe(func(cfg *config.Config) {
+				soraClient.Configure(services.SoraClientConfigFrom(cfg))
+			})
+		}
+		opts.Sora = soraClient
+	}
+
 	router := gin.New()
 	router.Use(gin.Recovery())
 	router.Use(CORSMiddleware())
 
-	handler := NewHandler(db, lb, cm)
-	adminHandler := NewAdminHandler(db, lb, cm)
+	handler := newHandler(db, lb, cm, opts.TokenManager, opts.Sora)
+	handler.generationHandler.SetWebhookDispatcher(opts.Webhooks)
+	handler.generationHandler.SetTaskHub(opts.TaskHub)
+	opts.GenerationHandler = handler.generationHandler
+	adminHandler := newAdminHandler(db, lb, cm, opts.TokenManager)
+	adminHandler.SetGenerationHandler(handler.generationHandler)
+	adminHandler.SetSentinelCache(sentinels)
+	if opts.Config != nil {
+		// Generation settings follow config reloads
+		gh := handler.generationHandler
+		gh.SetConfig(services.GenerationConfigFrom(opts.Config.Get()))
+		opts.Config.Subscribe(func(cfg *config.Config) {
+			gh.SetConfig(services.GenerationConfigFrom(cfg))
+		})
+		adminHandler.SetConfigManager(opts.Config)
+	}
+	webhookHandler := NewWebhookHandler(db, opts.Webhooks)
+	taskEventsHandler := NewTaskEventsHandler(db, opts.TaskHub, handler.generationHandler)
+
+	characterHandler := NewCharacterHandler(db, opts.Sora)
+	if opts.CharacterWatcher == nil {
+		opts.CharacterWatcher = services.NewCharacterWatcher(db, opts.Sora)
+		opts.CharacterWatcher.SetWebhookDispatcher(opts.Webhooks)
+	}
+	characterHandler.SetCharacterWatcher(opts.CharacterWatcher)
+	characterHandler.SetSourceArchive(opts.CharacterArchive)
+	if opts.Config != nil {
+		// Character upload limits follow config reloads
+		setUploadLimits := func(cfg *config.Config) {
+			characterHandler.SetUploadLimits(int64(cfg.Character.MaxUploadSize)<<20, cfg.Character.UploadDir)
+		}
+		setUploadLimits(opts.Config.Get())
+		opts.Config.Subscribe(setUploadLimits)
+	}
+	generateHandler := NewGenerateHandler(db, opts.Sora)
+	presetHandler := NewPresetHandler(db)
+
+	// Story jobs join their clips into the file cache
+	if opts.StoryRenderer == nil && opts.FileCache != nil {
+		opts.StoryRenderer = services.NewStoryRenderer(db, handler.generationHandler, opts.FileCache)
+	}
+	if opts.StoryRenderer != nil {
+		handler.SetStoryRenderer(opts.StoryRenderer)
+		if opts.Config != nil {
+			renderer := opts.StoryRenderer
+			configureStories := func(cfg *config.Config) {
+				renderer.Configure(cfg.Story.MaxParallel, cfg.Story.FFmpegPath)
+			}
+			configureStories(opts.Config.Get())
+			opts.Config.Subscribe(configureStories)
+		}
+	}
+
+	// Prompts are screened before a token is picked
+	if opts.Moderator != nil {
+		handler.generationHandler.SetModerator(opts.Moderator)
+		generateHandler.SetModerator(opts.Moderator)
+	}
 
-	// Create SoraClient for character operations
-	soraClient := services.NewSoraClient("", 120, nil)
-	characterHandler := NewCharacterHandler(db, soraClient)
-	generateHandler := NewGenerateHandler(db)
+	// Generations polled on the same token share their status requests
+	poller := services.NewTaskPoller(opts.Sora)
+	handler.generationHandler.SetTaskPoller(poller)
+	generateHandler.SetTaskPoller(poller)
+
+	// Route tokens without their own proxy through the shared proxy pool
+	if opts.ProxyManager != nil {
+		handler.generationHandler.SetProxyManager(opts.ProxyManager)
+		adminHandler.SetProxyManager(opts.ProxyManager)
+		characterHandler.SetProxyManager(opts.ProxyManager)
+		opts.CharacterWatcher.SetProxyManager(opts.ProxyManager)
+		generateHandler.SetProxyManager(opts.ProxyManager)
+	}
+
+	// Token conversion and testing use the same TLS sessions as the Sora upstream
+	if opts.SessionManager != nil {
+		opts.TokenManager.SetSessionManager(opts.SessionManager)
+	}
 
 	// Health check (no auth required)
 	router.GET("/health", func(c *gin.Context) {
@@ -52,6 +186,15 @@ func SetupRouter(db *database.DB, lb *services.LoadBalancer, cm *services.Concur
 	{
 		v1.GET("/models", handler.HandleModels)
 		v1.POST("/chat/completions", handler.HandleChatCompletions)
+		v1.POST("/storyboards", handler.HandleStoryboard)
+		v1.POST("/stories", handler.HandleCreateStory)
+		v1.GET("/stories/:job_id", handler.HandleGetStory)
+		v1.GET("/tasks/:task_id/events", taskEventsHandler.HandleTaskEvents)
+		v1.POST("/tasks/:task_id/remix", handler.HandleRemixTask)
+		v1.POST("/tasks/:task_id/extend", handler.HandleExtendTask)
+		v1.GET("/tasks/:task_id/lineage", handler.HandleTaskLineage)
+		v1.GET("/templates", presetHandler.HandleGetTemplates)
+		v1.GET("/styles", presetHandler.HandleGetStyles)
 	}
 
 	// Admin API routes
@@ -89,6 +232,9 @@ func SetupRouter(db *database.DB, lb *services.LoadBalancer, cm *services.Concur
 			// System configuration
 			protected.GET("/config", adminHandler.HandleGetConfig)
 			protected.PUT("/config", adminHandler.HandleUpdateConfig)
+			protected.GET("/config/effective", adminHandler.HandleGetEffectiveConfig)
+			protected.POST("/config/reload", adminHandler.HandleReloadConfig)
+			protected.DELETE("/config/overrides/:key", adminHandler.HandleDeleteConfigOverride)
 
 			// Statistics
 			protected.GET("/stats", adminHandler.HandleGetStats)
@@ -103,6 +249,7 @@ func SetupRouter(db *database.DB, lb *services.LoadBalancer, cm *services.Concur
 
 			// Task management
 			protected.POST("/tasks/:task_id/cancel", adminHandler.HandleCancelTask)
+			protected.GET("/tasks/:task_id/events", taskEventsHandler.HandleAdminTaskEvents)
 
 			// Admin password and API key
 			protected.POST("/admin/password", adminHandler.HandleUpdatePassword)
@@ -110,18 +257,57 @@ func SetupRouter(db *database.DB, lb *services.LoadBalancer, cm *services.Concur
 
 			// Proxy test
 			protected.POST("/proxy/test", adminHandler.HandleTestProxy)
+			if opts.ProxyManager != nil {
+				proxyPoolHandler := NewProxyPoolHandler(opts.ProxyManager)
+				protected.GET("/proxy/pool", proxyPoolHandler.HandleGetProxyPool)
+				protected.POST("/proxy/pool", proxyPoolHandler.HandleUploadProxyPool)
+				protected.POST("/proxy/pool/reload", proxyPoolHandler.HandleReloadProxyPool)
+				protected.POST("/proxy/pool/test", proxyPoolHandler.HandleTestProxyPool)
+			}
+
+			// TLS fingerprint profiles
+			protected.GET("/fingerprint/profiles", adminHandler.HandleGetTLSProfiles)
+
+			// Sentinel cache and proof-of-work metrics
+			protected.GET("/sentinel/stats", adminHandler.HandleGetSentinelStats)
 
 			// Character management
 			protected.GET("/characters", characterHandler.HandleGetCharacters)
 			protected.GET("/characters/:id", characterHandler.HandleGetCharacter)
 			protected.POST("/characters/upload", characterHandler.HandleUploadCharacterVideo)
+			protected.POST("/characters/upload/file", characterHandler.HandleUploadCharacterFile)
 			protected.GET("/characters/:id/status", characterHandler.HandleGetCameoStatus)
 			protected.GET("/characters/username/check", characterHandler.HandleCheckUsername)
 			protected.POST("/characters/finalize", characterHandler.HandleFinalizeCharacter)
 			protected.DELETE("/characters/:id", characterHandler.HandleDeleteCharacter)
+			protected.GET("/characters/:id/replicas", characterHandler.HandleGetCharacterReplicas)
+			protected.POST("/characters/:id/replicas", characterHandler.HandleReplicateCharacter)
+			protected.DELETE("/characters/:id/replicas/:replica_id", characterHandler.HandleDeleteCharacterReplica)
+			protected.POST("/characters/:id/recreate", characterHandler.HandleRecreateCharacter)
 			protected.GET("/characters/search", characterHandler.HandleSearchCharacters)
 			protected.POST("/characters/sync", characterHandler.HandleSyncCharacters)
 
+			// Webhooks
+			protected.GET("/webhooks", webhookHandler.HandleGetWebhooks)
+			protected.POST("/webhooks", webhookHandler.HandleCreateWebhook)
+			protected.PUT("/webhooks/:id", webhookHandler.HandleUpdateWebhook)
+			protected.DELETE("/webhooks/:id", webhookHandler.HandleDeleteWebhook)
+			protected.POST("/webhooks/:id/test", webhookHandler.HandleTestWebhook)
+			protected.GET("/webhooks/:id/deliveries", webhookHandler.HandleGetWebhookDeliveries)
+
+			// Prompt templates and style presets
+			protected.GET("/templates", presetHandler.HandleGetTemplates)
+			protected.POST("/templates", presetHandler.HandleCreateTemplate)
+			protected.GET("/templates/:id", presetHandler.HandleGetTemplate)
+			protected.PUT("/templates/:id", presetHandler.HandleUpdateTemplate)
+			protected.DELETE("/templates/:id", presetHandler.HandleDeleteTemplate)
+			protected.GET("/styles", presetHandler.HandleGetStyles)
+			protected.POST("/styles", presetHandler.HandleCreateStyle)
+			protected.PUT("/styles/:id", presetHandler.HandleUpdateStyle)
+			protected.DELETE("/styles/:id", presetHandler.HandleDeleteStyle)
+			protected.GET("/presets/export", presetHandler.HandleExportPresets)
+			protected.POST("/presets/import", presetHandler.HandleImportPresets)
+
 			// Generation
 			protected.POST("/generate/video", generateHandler.HandleGenerateVideo)
 			protected.POST("/generate/image", generateHandler.HandleGenerateImage)
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/services"
+)
+
+// StoryRequest is the request of /v1/stories: a storyboard of any length
+type StoryRequest struct {
+	Model string `json:"model" binding:"required"` // Video model; its duration is replaced per clip
+	services.Storyboard
+	services.PresetOptions
+}
+
+// storyUnavailable responds when story jobs cannot be run
+func (h *Handler) storyUnavailable(c *gin.Context) bool {
+	message := ""
+	switch {
+	case h.storyRenderer == nil:
+		message = "Story rendering is not enabled"
+	case h.generationHandler.IsDraining():
+		message = services.ErrShuttingDown.Error()
+	default:
+		return false
+	}
+	c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+		Error: ErrorDetail{
+			Message: message,
+			Type:    "server_error",
+			Code:    "service_unavailable",
+		},
+	})
+	return true
+}
+
+// HandleCreateStory handles POST /v1/stories. The storyboard is split into clips
+// Sora can generate, rendered in the background and joined into one video; the
+// job is returned at once and followed on GET /v1/stories/:job_id.
+func (h *Handler) HandleCreateStory(c *gin.Context) {
+	if h.storyUnavailable(c) {
+		return
+	}
+
+	var req StoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid request: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	if !IsVideoModel(req.Model) && !IsVideoModel(req.Model+"-10s") {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Stories need a video model, got %s", req.Model),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	// PlanStory fits the clips itself
+	_, storyboard, ok := h.applyPresets(c, &req.PresetOptions, "", &req.Storyboard, "")
+	if !ok {
+		return
+	}
+
+	job, err := h.storyRenderer.Start(storyboard, req.Model)
+	if err != nil {
+		status, resp := generationErrorResponse(err)
+		c.JSON(status, resp)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// HandleGetStory handles GET /v1/stories/:job_id, the job with its clips
+func (h *Handler) HandleGetStory(c *gin.Context) {
+	if h.storyRenderer == nil {
+		h.storyUnavailable(c)
+		return
+	}
+
+	job, err := h.storyRenderer.Job(c.Param("job_id"))
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "Story job not found",
+				Type:    "invalid_request_error",
+				Code:    "not_found",
+			},
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
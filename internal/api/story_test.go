@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+func TestHandleStories(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	handler := NewHandler(db, services.NewLoadBalancer(), nil)
+	router := gin.New()
+	router.POST("/v1/stories", handler.HandleCreateStory)
+	router.GET("/v1/stories/:job_id", handler.HandleGetStory)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	story := `{"model": "sora2-landscape", "title": "Cats", "shots": [
+		{"duration": 10, "prompt": "a cat jumps"}, {"duration": 10, "prompt": "the cat flies"}, {"duration": 10, "prompt": "the cat lands"}]}`
+
+	if w := do("POST", "/v1/stories", story); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a renderer, got %d", w.Code)
+	}
+
+	renderer := services.NewStoryRenderer(db, handler.generationHandler, services.NewFileCache(t.TempDir(), 0, ""))
+	handler.SetStoryRenderer(renderer)
+	defer renderer.Wait()
+
+	for _, body := range []string{
+		`{"model": "sora-image", "shots": [{"duration": 10, "prompt": "a"}]}`,
+		`{"model": "sora2-landscape", "shots": [{"duration": 30, "prompt": "a"}]}`,
+		`{"model": "sora2-landscape", "shots": []}`,
+	} {
+		if w := do("POST", "/v1/stories", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	w := do("POST", "/v1/stories", story)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var job models.StoryJob
+	json.Unmarshal(w.Body.Bytes(), &job)
+	if !strings.HasPrefix(job.JobID, "story_") || len(job.Clips) != 2 || job.Clips[0].Model != "sora2-landscape-25s" {
+		t.Errorf("Unexpected job %+v", job)
+	}
+
+	renderer.Wait()
+	w = do("GET", "/v1/stories/"+job.JobID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	json.Unmarshal(w.Body.Bytes(), &job)
+	if job.Status != models.StoryStatusFailed || len(job.Clips) != 2 {
+		t.Errorf("Expected job failed without tokens, got %+v", job)
+	}
+
+	if w := do("GET", "/v1/stories/story_missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
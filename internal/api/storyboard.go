@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/services"
+)
+
+// StoryboardRequest is the request of /v1/storyboards; a template gives the
+// shots instead of the request
+type StoryboardRequest struct {
+	Model string `json:"model" binding:"required"`
+	services.Storyboard
+	services.PresetOptions
+}
+
+// StoryboardResponse is the result of a storyboard generation
+type StoryboardResponse struct {
+	ID      string   `json:"id"` // Sora task ID
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Status  string   `json:"status"`
+	Prompt  string   `json:"prompt"` // Timeline prompt sent to Sora
+	URLs    []string `json:"urls"`
+}
+
+// HandleStoryboard handles the /v1/storyboards endpoint: a storyboard video from
+// structured shots whose durations fill the video of the model. The request
+// waits for the video.
+func (h *Handler) HandleStoryboard(c *gin.Context) {
+	if h.generationHandler.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: services.ErrShuttingDown.Error(),
+				Type:    "server_error",
+				Code:    "service_unavailable",
+			},
+		})
+		return
+	}
+
+	var req StoryboardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid request: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	if !IsVideoModel(req.Model) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Storyboards need a video model, got %s", req.Model),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	_, storyboard, ok := h.applyPresets(c, &req.PresetOptions, "", &req.Storyboard, req.Model)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Minute)
+	defer cancel()
+
+	result, err := h.generationHandler.GenerateStoryboard(ctx, storyboard, req.Model, false, nil)
+	if errors.Is(err, services.ErrShuttingDown) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "server_error",
+				Code:    "service_unavailable",
+			},
+		})
+		return
+	}
+	if err != nil {
+		status, resp := generationErrorResponse(err)
+		c.JSON(status, resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, StoryboardResponse{
+		ID:      result.TaskID,
+		Object:  "storyboard",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Status:  result.Status,
+		Prompt:  storyboard.Prompt(),
+		URLs:    result.URLs,
+	})
+}
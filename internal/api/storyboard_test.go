@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleStoryboard_Rejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	handler := NewHandler(db, nil, nil)
+	router := gin.New()
+	router.POST("/v1/storyboards", handler.HandleStoryboard)
+	router.POST("/v1/chat/completions", handler.HandleChatCompletions)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want string
+	}{
+		{"image model", "/v1/storyboards", `{"model": "sora-image", "shots": [{"duration": 10, "prompt": "a cat"}]}`, "video model"},
+		{"no shots", "/v1/storyboards", `{"model": "sora2-landscape-10s", "shots": []}`, "分镜"},
+		{"duration mismatch", "/v1/storyboards", `{"model": "sora2-landscape-10s", "shots": [{"duration": 5, "prompt": "a cat"}]}`, "分镜"},
+		{"chat duration mismatch", "/v1/chat/completions",
+			`{"model": "sora2-landscape-15s", "messages": [], "storyboard": {"shots": [{"duration": 5, "prompt": "a"}, {"duration": 5, "prompt": "b"}]}}`, "分镜"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to parse response: %v", tt.name, err)
+		}
+		if resp.Error.Type != "invalid_request_error" || !strings.Contains(resp.Error.Message, tt.want) {
+			t.Errorf("%s: unexpected error %+v", tt.name, resp.Error)
+		}
+	}
+}
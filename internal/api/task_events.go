@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// taskEventsHeartbeat is the interval of SSE keep-alive comments
+var taskEventsHeartbeat = 15 * time.Second
+
+// TaskEventsHandler streams task progress as Server-Sent Events
+type TaskEventsHandler struct {
+	db                *database.DB
+	taskHub           *services.TaskHub
+	generationHandler *services.GenerationHandler
+}
+
+// NewTaskEventsHandler creates a new TaskEventsHandler
+func NewTaskEventsHandler(db *database.DB, hub *services.TaskHub, gh *services.GenerationHandler) *TaskEventsHandler {
+	return &TaskEventsHandler{
+		db:                db,
+		taskHub:           hub,
+		generationHandler: gh,
+	}
+}
+
+// HandleTaskEvents streams events of a task (OpenAI-style errors, for /v1)
+func (h *TaskEventsHandler) HandleTaskEvents(c *gin.Context) {
+	task, err := h.db.GetTaskByTaskID(c.Param("task_id"))
+	if err != nil {
+		status, message := http.StatusInternalServerError, err.Error()
+		if err == database.ErrNotFound {
+			status, message = http.StatusNotFound, "Task not found"
+		}
+		c.JSON(status, ErrorResponse{
+			Error: ErrorDetail{
+				Message: message,
+				Type:    "invalid_request_error",
+				Code:    "task_not_found",
+			},
+		})
+		return
+	}
+	h.streamTaskEvents(c, task)
+}
+
+// HandleAdminTaskEvents streams events of a task (admin API)
+func (h *TaskEventsHandler) HandleAdminTaskEvents(c *gin.Context) {
+	task, err := h.db.GetTaskByTaskID(c.Param("task_id"))
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.streamTaskEvents(c, task)
+}
+
+// taskEventFromRecord builds the event describing the stored state of a task
+func taskEventFromRecord(task *models.Task) services.TaskEvent {
+	event := services.TaskEvent{
+		TaskID:   task.TaskID,
+		Type:     services.TaskEventStatus,
+		Status:   task.Status,
+		Progress: task.Progress,
+		Time:     time.Now(),
+	}
+	switch task.Status {
+	case models.TaskStatusCompleted:
+		event.Type = services.TaskEventDone
+		event.Progress = 100
+		if task.ResultURLs != "" {
+			json.Unmarshal([]byte(task.ResultURLs), &event.URLs)
+		}
+	case models.TaskStatusFailed, models.TaskStatusCancelled:
+		event.Type = services.TaskEventError
+		event.Error = task.ErrorMessage
+	}
+	return event
+}
+
+// streamTaskEvents writes the current state and then live updates until the task finishes
+func (h *TaskEventsHandler) streamTaskEvents(c *gin.Context, task *models.Task) {
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming not supported"})
+		return
+	}
+
+	// Subscribe first, then re-read the record, so a finish in between is not missed
+	events, snapshot, hasSnapshot, unsubscribe := h.taskHub.Subscribe(task.TaskID)
+	defer unsubscribe()
+
+	if latest, err := h.db.GetTaskByTaskID(task.TaskID); err == nil {
+		task = latest
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	current := taskEventFromRecord(task)
+	if !current.IsTerminal() && hasSnapshot {
+		current = snapshot
+	}
+	writeTaskEvent(c.Writer, flusher, current)
+	if current.IsTerminal() {
+		return
+	}
+
+	// Nobody in this process is polling the task (e.g. left over from a restart)
+	if h.generationHandler != nil && !h.generationHandler.IsTaskRunning(task.TaskID) {
+		return
+	}
+
+	heartbeat := time.NewTicker(taskEventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			flusher.Flush()
+		case event := <-events:
+			writeTaskEvent(c.Writer, flusher, event)
+			if event.IsTerminal() {
+				return
+			}
+		}
+	}
+}
+
+// writeTaskEvent writes a single named SSE event
+func writeTaskEvent(w http.ResponseWriter, flusher http.Flusher, event services.TaskEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	flusher.Flush()
+}
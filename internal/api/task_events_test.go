@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+func TestTaskEventsHandler_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	defer db.Close()
+
+	h := NewTaskEventsHandler(db, services.NewTaskHub(), nil)
+	router := gin.New()
+	router.GET("/v1/tasks/:task_id/events", h.HandleTaskEvents)
+
+	req := httptest.NewRequest("GET", "/v1/tasks/missing/events", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestTaskEventsHandler_CompletedTask(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "t1", Email: "a@example.com", IsActive: true})
+	task := &models.Task{TaskID: "task_done", TokenID: tokenID, Model: "sora-image", Prompt: "cat", Status: models.TaskStatusProcessing}
+	task.ID, _ = db.CreateTask(task)
+	task.Status = models.TaskStatusCompleted
+	task.ResultURLs = `["https://example.com/a.png"]`
+	db.UpdateTask(task)
+
+	h := NewTaskEventsHandler(db, services.NewTaskHub(), nil)
+	router := gin.New()
+	router.GET("/v1/tasks/:task_id/events", h.HandleTaskEvents)
+
+	req := httptest.NewRequest("GET", "/v1/tasks/task_done/events", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "event: done") || !strings.Contains(body, "https://example.com/a.png") {
+		t.Errorf("Expected done event with URL, got %q", body)
+	}
+}
+
+func TestTaskEventsHandler_LiveStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "t1", Email: "a@example.com", IsActive: true})
+	db.CreateTask(&models.Task{TaskID: "task_live", TokenID: tokenID, Model: "sora-video", Prompt: "dog", Status: models.TaskStatusProcessing})
+
+	hub := services.NewTaskHub()
+	h := NewTaskEventsHandler(db, hub, nil)
+	router := gin.New()
+	router.GET("/api/tasks/:task_id/events", h.HandleAdminTaskEvents)
+
+	req := httptest.NewRequest("GET", "/api/tasks/task_live/events", nil)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, req)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.SubscriberCount("task_live") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for subscriber")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	hub.Publish(services.TaskEvent{TaskID: "task_live", Type: services.TaskEventProgress, Progress: 42})
+	hub.Publish(services.TaskEvent{TaskID: "task_live", Type: services.TaskEventDone, Progress: 100})
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected stream to end after terminal event")
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{"event: status", "event: progress", `"progress":42`, "event: done"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in stream, got %q", want, body)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "soranow/internal/services"
+
 // ChatCompletionRequest represents the OpenAI-compatible chat completion request
 type ChatCompletionRequest struct {
 	Model       string          `json:"model" binding:"required"`
@@ -11,7 +13,18 @@ type ChatCompletionRequest struct {
 	Size        string          `json:"size,omitempty"`        // e.g., "1920x1080"
 	Duration    int             `json:"duration,omitempty"`    // video duration in seconds
 	AspectRatio string          `json:"aspect_ratio,omitempty"` // e.g., "16:9"
-	Style       string          `json:"style,omitempty"`
+	Style       string          `json:"style,omitempty"`       // Style preset ID, appended to the prompt
+	// Structured storyboard; replaces the prompt of the messages
+	Storyboard *services.Storyboard `json:"storyboard,omitempty"`
+	// Prompt template expanded into the storyboard
+	TemplateID string            `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	Character  string            `json:"character,omitempty"`
+}
+
+// presetOptions returns the template and style parameters of the request
+func (r *ChatCompletionRequest) presetOptions() *services.PresetOptions {
+	return &services.PresetOptions{TemplateID: r.TemplateID, Variables: r.Variables, Character: r.Character, Style: r.Style}
 }
 
 // ChatMessage represents a message in the chat
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/database"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+// WebhookHandler handles webhook management API requests
+type WebhookHandler struct {
+	db         *database.DB
+	dispatcher *services.WebhookDispatcher
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler(db *database.DB, dispatcher *services.WebhookDispatcher) *WebhookHandler {
+	return &WebhookHandler{
+		db:         db,
+		dispatcher: dispatcher,
+	}
+}
+
+// WebhookRequest represents create/update webhook request body
+type WebhookRequest struct {
+	Name     *string   `json:"name"`
+	URL      *string   `json:"url"`
+	Secret   *string   `json:"secret"`
+	Events   *[]string `json:"events"`
+	IsActive *bool     `json:"is_active"`
+}
+
+// validateWebhook checks the webhook URL and event filter
+func validateWebhook(hook *models.Webhook) string {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "无效的 Webhook URL，必须为 http 或 https 地址"
+	}
+	for _, e := range hook.Events {
+		if e != "*" && !models.IsValidWebhookEvent(e) {
+			return "未知的事件类型: " + e
+		}
+	}
+	return ""
+}
+
+// applyWebhookRequest copies the provided fields onto the webhook
+func applyWebhookRequest(hook *models.Webhook, req *WebhookRequest) {
+	if req.Name != nil {
+		hook.Name = *req.Name
+	}
+	if req.URL != nil {
+		hook.URL = *req.URL
+	}
+	// A masked secret sent back unchanged keeps the stored one
+	if req.Secret != nil && (hook.Secret == "" || *req.Secret != maskSecret(hook.Secret)) {
+		hook.Secret = *req.Secret
+	}
+	if req.Events != nil {
+		hook.Events = *req.Events
+	}
+	if req.IsActive != nil {
+		hook.IsActive = *req.IsActive
+	}
+}
+
+// maskWebhook returns a copy of the webhook with its signing secret masked
+func maskWebhook(hook *models.Webhook) *models.Webhook {
+	masked := *hook
+	if masked.Secret != "" {
+		masked.Secret = maskSecret(masked.Secret)
+	}
+	return &masked
+}
+
+// getWebhook loads the webhook from the :id param, writing the error response on failure
+func (h *WebhookHandler) getWebhook(c *gin.Context) *models.Webhook {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook ID"})
+		return nil
+	}
+
+	hook, err := h.db.GetWebhookByID(id)
+	if err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return nil
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return hook
+}
+
+// HandleGetWebhooks returns all webhooks
+func (h *WebhookHandler) HandleGetWebhooks(c *gin.Context) {
+	hooks, err := h.db.GetAllWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if hooks == nil {
+		hooks = []*models.Webhook{}
+	}
+	for i, hook := range hooks {
+		hooks[i] = maskWebhook(hook)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "events": models.WebhookEvents})
+}
+
+// HandleCreateWebhook creates a new webhook
+func (h *WebhookHandler) HandleCreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hook := &models.Webhook{IsActive: true}
+	applyWebhookRequest(hook, &req)
+	if msg := validateWebhook(hook); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	id, err := h.db.CreateWebhook(hook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hook.ID = id
+
+	// The secret is shown in full only once, at creation
+	c.JSON(http.StatusOK, gin.H{"success": true, "webhook": hook})
+}
+
+// HandleUpdateWebhook updates an existing webhook
+func (h *WebhookHandler) HandleUpdateWebhook(c *gin.Context) {
+	hook := h.getWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	applyWebhookRequest(hook, &req)
+	if msg := validateWebhook(hook); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.db.UpdateWebhook(hook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "webhook": maskWebhook(hook)})
+}
+
+// HandleDeleteWebhook deletes a webhook and its delivery log
+func (h *WebhookHandler) HandleDeleteWebhook(c *gin.Context) {
+	hook := h.getWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	if err := h.db.DeleteWebhook(hook.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// HandleTestWebhook sends a test event to the webhook and returns the delivery result
+func (h *WebhookHandler) HandleTestWebhook(c *gin.Context) {
+	hook := h.getWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	delivery := h.dispatcher.SendTest(hook)
+	c.JSON(http.StatusOK, gin.H{"success": delivery.Success, "delivery": delivery})
+}
+
+// HandleGetWebhookDeliveries returns the recent delivery log of a webhook
+func (h *WebhookHandler) HandleGetWebhookDeliveries(c *gin.Context) {
+	hook := h.getWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	deliveries, err := h.db.GetWebhookDeliveries(hook.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*models.WebhookDelivery{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"soranow/internal/models"
+	"soranow/internal/services"
+)
+
+func setupWebhookRouter(t *testing.T) (*gin.Engine, *WebhookHandler) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+
+	h := NewWebhookHandler(db, services.NewWebhookDispatcher(db))
+	router := gin.New()
+	router.GET("/api/webhooks", h.HandleGetWebhooks)
+	router.POST("/api/webhooks", h.HandleCreateWebhook)
+	router.PUT("/api/webhooks/:id", h.HandleUpdateWebhook)
+	router.DELETE("/api/webhooks/:id", h.HandleDeleteWebhook)
+	router.POST("/api/webhooks/:id/test", h.HandleTestWebhook)
+	router.GET("/api/webhooks/:id/deliveries", h.HandleGetWebhookDeliveries)
+	return router, h
+}
+
+func TestWebhookHandler_CreateValidation(t *testing.T) {
+	router, _ := setupWebhookRouter(t)
+
+	cases := []struct {
+		body string
+		code int
+	}{
+		{`{"url": "ftp://example.com"}`, http.StatusBadRequest},
+		{`{"url": "https://example.com/hook", "events": ["task.unknown"]}`, http.StatusBadRequest},
+		{`{"url": "https://example.com/hook", "events": ["task.completed"]}`, http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("POST", "/api/webhooks", bytes.NewBufferString(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.body, tc.code, w.Code)
+		}
+	}
+}
+
+func TestWebhookHandler_TestDelivery(t *testing.T) {
+	router, h := setupWebhookRouter(t)
+
+	var event string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event = r.Header.Get(services.WebhookHeaderEvent)
+	}))
+	defer receiver.Close()
+
+	id, _ := h.db.CreateWebhook(&models.Webhook{URL: receiver.URL, IsActive: true})
+
+	req := httptest.NewRequest("POST", "/api/webhooks/"+strconv.FormatInt(id, 10)+"/test", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response["success"] != true {
+		t.Errorf("Expected success, got %v", response)
+	}
+	if event != models.WebhookEventTest {
+		t.Errorf("Expected event '%s', got '%s'", models.WebhookEventTest, event)
+	}
+
+	// Delivery log contains the test attempt
+	req = httptest.NewRequest("GET", "/api/webhooks/"+strconv.FormatInt(id, 10)+"/deliveries", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if deliveries, _ := response["deliveries"].([]interface{}); len(deliveries) != 1 {
+		t.Errorf("Expected 1 delivery, got %v", response["deliveries"])
+	}
+}
+
+func TestWebhookHandler_DeleteNotFound(t *testing.T) {
+	router, _ := setupWebhookRouter(t)
+
+	req := httptest.NewRequest("DELETE", "/api/webhooks/999", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestWebhookHandler_MasksSecret(t *testing.T) {
+	router, h := setupWebhookRouter(t)
+
+	do := func(method, path, body string) map[string]interface{} {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected 200, got %d: %s", method, path, w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	created := do("POST", "/api/webhooks", `{"url": "https://example.com/hook", "secret": "whsec_0123456789"}`)
+	hook := created["webhook"].(map[string]interface{})
+	if hook["secret"] != "whsec_0123456789" {
+		t.Errorf("Expected the secret shown at creation, got %v", hook["secret"])
+	}
+	path := "/api/webhooks/" + strconv.FormatInt(int64(hook["id"].(float64)), 10)
+
+	listed := do("GET", "/api/webhooks", "")
+	if got := listed["webhooks"].([]interface{})[0].(map[string]interface{})["secret"]; got != "whse****6789" {
+		t.Errorf("Expected the listed secret masked, got %v", got)
+	}
+
+	// Saving the form with the masked secret keeps the stored one
+	updated := do("PUT", path, `{"name": "renamed", "secret": "whse****6789"}`)
+	if got := updated["webhook"].(map[string]interface{})["secret"]; got != "whse****6789" {
+		t.Errorf("Expected the updated secret masked, got %v", got)
+	}
+	stored, _ := h.db.GetWebhookByID(int64(hook["id"].(float64)))
+	if stored.Secret != "whsec_0123456789" || stored.Name != "renamed" {
+		t.Errorf("Expected the secret kept and the name updated, got %+v", stored)
+	}
+}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/bogdanfinn/tls-client/profiles"
 )
 
 // Config represents the complete application configuration
@@ -20,6 +24,11 @@ type Config struct {
 	TokenRefresh TokenRefreshConfig `toml:"token_refresh"`
 	CallLogic    CallLogicConfig    `toml:"call_logic"`
 	Timezone     TimezoneConfig     `toml:"timezone"`
+	Webhook      WebhookConfig      `toml:"webhook"`
+	Fingerprint  FingerprintConfig  `toml:"fingerprint"`
+	Moderation   ModerationConfig   `toml:"moderation"`
+	Character    CharacterConfig    `toml:"character"`
+	Story        StoryConfig        `toml:"story"`
 }
 
 type GlobalConfig struct {
@@ -30,17 +39,25 @@ type GlobalConfig struct {
 
 type SoraConfig struct {
 	BaseURL         string  `toml:"base_url"`
+	SentinelURL     string  `toml:"sentinel_url"`
 	Timeout         int     `toml:"timeout"`
 	MaxRetries      int     `toml:"max_retries"`
-	PollInterval    float64 `toml:"poll_interval"`
+	PollInterval    float64 `toml:"poll_interval"`     // Seconds between polls while progress advances
+	MaxPollInterval float64 `toml:"max_poll_interval"` // Upper bound of the interval as progress stalls
 	MaxPollAttempts int     `toml:"max_poll_attempts"`
+	SentinelTTL     int     `toml:"sentinel_ttl"` // Seconds a sentinel token is reused (0: one per request)
+	PowWorkers      int     `toml:"pow_workers"`  // Concurrent proof-of-work solves (0: one per CPU)
 }
 
 type ServerConfig struct {
-	Host string `toml:"host"`
-	Port int    `toml:"port"`
+	Host            string `toml:"host"`
+	Port            int    `toml:"port"`
+	ShutdownTimeout int    `toml:"shutdown_timeout"` // Seconds to wait for running tasks on shutdown
 }
 
+// DefaultShutdownTimeout is used when shutdown_timeout is not set (seconds)
+const DefaultShutdownTimeout = 30
+
 type DebugConfig struct {
 	Enabled      bool `toml:"enabled"`
 	LogRequests  bool `toml:"log_requests"`
@@ -67,8 +84,14 @@ type AdminConfig struct {
 }
 
 type ProxyConfig struct {
-	ProxyEnabled bool   `toml:"proxy_enabled"`
-	ProxyURL     string `toml:"proxy_url"`
+	ProxyEnabled        bool   `toml:"proxy_enabled"`
+	ProxyURL            string `toml:"proxy_url"`
+	PoolEnabled         bool   `toml:"pool_enabled"`          // Rotate through the proxies in pool_file
+	PoolFile            string `toml:"pool_file"`             // One proxy per line
+	HealthCheckInterval int    `toml:"health_check_interval"` // Seconds between pool health probes
+	HealthCheckURL      string `toml:"health_check_url"`
+	MaxFailures         int    `toml:"max_failures"`   // Consecutive failures before a proxy is ejected
+	EjectDuration       int    `toml:"eject_duration"` // Seconds an ejected proxy is skipped
 }
 
 type WatermarkFreeConfig struct {
@@ -91,17 +114,220 @@ type TimezoneConfig struct {
 	TimezoneOffset int `toml:"timezone_offset"`
 }
 
-// LoadConfig loads configuration from a TOML file
+type WebhookConfig struct {
+	MaxAttempts  int `toml:"max_attempts"`  // Delivery attempts per event (default 5)
+	RetryBackoff int `toml:"retry_backoff"` // Base backoff in seconds, doubled after each failure (default 2)
+	Timeout      int `toml:"timeout"`       // Request timeout in seconds (default 10)
+}
+
+type FingerprintConfig struct {
+	TLSProfile    string   `toml:"tls_profile"`     // tls-client profile, e.g. firefox_132, chrome_131, safari_ios_18_0
+	UserAgents    []string `toml:"user_agents"`     // Browser UAs matching tls_profile; empty uses the built-in ones
+	AppUserAgents []string `toml:"app_user_agents"` // Sora app UAs for backend API requests; empty uses the built-in ones
+	HeaderOrder   []string `toml:"header_order"`    // Lower-case header names; empty uses the built-in order
+}
+
+type ModerationConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	RulesFile  string `toml:"rules_file"`  // One keyword or /regex/ per line
+	Endpoint   string `toml:"endpoint"`    // OpenAI-compatible moderation URL; empty checks the rules only
+	APIKey     string `toml:"api_key"`     // Bearer token for the endpoint
+	Timeout    int    `toml:"timeout"`     // Seconds to wait for the endpoint
+	FailClosed bool   `toml:"fail_closed"` // Reject prompts when the endpoint cannot be reached
+}
+
+type CharacterConfig struct {
+	MaxUploadSize int    `toml:"max_upload_size"` // Largest character video upload in MB
+	UploadDir     string `toml:"upload_dir"`      // Where multipart uploads are spooled before going to Sora
+	SyncInterval  int    `toml:"sync_interval"`   // Minutes between syncs with Sora, 0 disables the scheduled sync
+	ArchiveDir    string `toml:"archive_dir"`     // Where source clips are kept for re-creation, empty disables archiving (startup only)
+}
+
+type StoryConfig struct {
+	MaxParallel int    `toml:"max_parallel"` // Clips of one story job generated at the same time
+	FFmpegPath  string `toml:"ffmpeg_path"`  // Local ffmpeg joining the clips, empty uses the built-in MP4 muxer
+}
+
+// DefaultTimezoneOffset is used when timezone_offset is not set (UTC+8)
+const DefaultTimezoneOffset = 8
+
+// Defaults returns the built-in configuration (the lowest precedence layer)
+func Defaults() *Config {
+	return &Config{
+		Global: GlobalConfig{
+			APIKey:        "han1234",
+			AdminUsername: "admin",
+			AdminPassword: "admin",
+		},
+		Sora: SoraConfig{
+			BaseURL:         "https://sora.chatgpt.com/backend",
+			SentinelURL:     "https://chatgpt.com/backend-api/sentinel/req",
+			Timeout:         120,
+			MaxRetries:      3,
+			PollInterval:    2.5,
+			MaxPollInterval: 10,
+			MaxPollAttempts: 600,
+			SentinelTTL:     300,
+		},
+		Server: ServerConfig{
+			Host:            "0.0.0.0",
+			Port:            8000,
+			ShutdownTimeout: DefaultShutdownTimeout,
+		},
+		Cache: CacheConfig{
+			Timeout: 600,
+		},
+		Generation: GenerationConfig{
+			ImageTimeout: 300,
+			VideoTimeout: 3000,
+		},
+		Admin: AdminConfig{
+			ErrorBanThreshold: 3,
+			TaskRetryEnabled:  true,
+			TaskMaxRetries:    3,
+			AutoDisableOn401:  true,
+		},
+		Proxy: ProxyConfig{
+			PoolFile:            "data/proxy.txt",
+			HealthCheckInterval: 300,
+			HealthCheckURL:      "https://sora.chatgpt.com",
+			MaxFailures:         3,
+			EjectDuration:       600,
+		},
+		WatermarkFree: WatermarkFreeConfig{
+			ParseMethod:       "third_party",
+			FallbackOnFailure: true,
+		},
+		CallLogic: CallLogicConfig{
+			CallMode: "default",
+		},
+		Timezone: TimezoneConfig{
+			TimezoneOffset: DefaultTimezoneOffset,
+		},
+		Webhook: WebhookConfig{
+			MaxAttempts:  5,
+			RetryBackoff: 2,
+			Timeout:      10,
+		},
+		Fingerprint: FingerprintConfig{
+			TLSProfile: "firefox_132",
+		},
+		Moderation: ModerationConfig{
+			RulesFile: "data/moderation_rules.txt",
+			Timeout:   10,
+		},
+		Character: CharacterConfig{
+			MaxUploadSize: 100,
+			UploadDir:     "data/uploads",
+			ArchiveDir:    "data/characters",
+		},
+		Story: StoryConfig{
+			MaxParallel: 2,
+		},
+	}
+}
+
+// ValidationError lists every invalid setting
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Errors, "; ")
+}
+
+// Validate checks the configuration and returns a *ValidationError listing all problems
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Global.APIKey != "", "global.api_key must not be empty")
+	check(c.Global.AdminUsername != "", "global.admin_username must not be empty")
+
+	u, err := url.Parse(c.Sora.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "sora.base_url must be an http(s) URL, got %q", c.Sora.BaseURL)
+	u, err = url.Parse(c.Sora.SentinelURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "sora.sentinel_url must be an http(s) URL, got %q", c.Sora.SentinelURL)
+	check(c.Sora.Timeout > 0, "sora.timeout must be positive, got %d", c.Sora.Timeout)
+	check(c.Sora.MaxRetries >= 0, "sora.max_retries must not be negative, got %d", c.Sora.MaxRetries)
+	check(c.Sora.PollInterval > 0, "sora.poll_interval must be positive, got %v", c.Sora.PollInterval)
+	check(c.Sora.MaxPollInterval >= c.Sora.PollInterval, "sora.max_poll_interval must not be less than poll_interval, got %v", c.Sora.MaxPollInterval)
+	check(c.Sora.MaxPollAttempts > 0, "sora.max_poll_attempts must be positive, got %d", c.Sora.MaxPollAttempts)
+	check(c.Sora.SentinelTTL >= 0, "sora.sentinel_ttl must not be negative, got %d", c.Sora.SentinelTTL)
+	check(c.Sora.PowWorkers >= 0, "sora.pow_workers must not be negative, got %d", c.Sora.PowWorkers)
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive, got %d", c.Server.ShutdownTimeout)
+
+	check(c.Cache.Timeout >= 0, "cache.timeout must not be negative, got %d", c.Cache.Timeout)
+	check(c.Generation.ImageTimeout > 0, "generation.image_timeout must be positive, got %d", c.Generation.ImageTimeout)
+	check(c.Generation.VideoTimeout > 0, "generation.video_timeout must be positive, got %d", c.Generation.VideoTimeout)
+
+	check(c.Admin.ErrorBanThreshold >= 0, "admin.error_ban_threshold must not be negative, got %d", c.Admin.ErrorBanThreshold)
+	check(c.Admin.TaskMaxRetries >= 0, "admin.task_max_retries must not be negative, got %d", c.Admin.TaskMaxRetries)
+
+	if c.Proxy.ProxyEnabled && !c.Proxy.PoolEnabled {
+		check(c.Proxy.ProxyURL != "", "proxy.proxy_url is required when proxy_enabled is true")
+	}
+	if c.Proxy.PoolEnabled {
+		check(c.Proxy.PoolFile != "", "proxy.pool_file is required when pool_enabled is true")
+	}
+	check(c.Proxy.HealthCheckInterval > 0, "proxy.health_check_interval must be positive, got %d", c.Proxy.HealthCheckInterval)
+	check(c.Proxy.MaxFailures > 0, "proxy.max_failures must be positive, got %d", c.Proxy.MaxFailures)
+	check(c.Proxy.EjectDuration > 0, "proxy.eject_duration must be positive, got %d", c.Proxy.EjectDuration)
+
+	check(c.WatermarkFree.ParseMethod == "third_party" || c.WatermarkFree.ParseMethod == "custom",
+		"watermark_free.parse_method must be \"third_party\" or \"custom\", got %q", c.WatermarkFree.ParseMethod)
+	if c.WatermarkFree.ParseMethod == "custom" && c.WatermarkFree.WatermarkFreeEnabled {
+		check(c.WatermarkFree.CustomParseURL != "", "watermark_free.custom_parse_url is required for the custom parse method")
+	}
+
+	check(c.Timezone.TimezoneOffset >= -12 && c.Timezone.TimezoneOffset <= 14,
+		"timezone_offset must be between -12 and 14, got %d", c.Timezone.TimezoneOffset)
+
+	check(c.Webhook.MaxAttempts > 0, "webhook.max_attempts must be positive, got %d", c.Webhook.MaxAttempts)
+	check(c.Webhook.RetryBackoff >= 0, "webhook.retry_backoff must not be negative, got %d", c.Webhook.RetryBackoff)
+	check(c.Webhook.Timeout > 0, "webhook.timeout must be positive, got %d", c.Webhook.Timeout)
+
+	_, knownProfile := profiles.MappedTLSClients[c.Fingerprint.TLSProfile]
+	check(knownProfile, "fingerprint.tls_profile %q is not a known TLS profile", c.Fingerprint.TLSProfile)
+
+	if c.Moderation.Endpoint != "" {
+		u, err = url.Parse(c.Moderation.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "moderation.endpoint must be an http(s) URL, got %q", c.Moderation.Endpoint)
+	}
+	check(c.Moderation.Timeout > 0, "moderation.timeout must be positive, got %d", c.Moderation.Timeout)
+
+	check(c.Character.MaxUploadSize > 0, "character.max_upload_size must be positive, got %d", c.Character.MaxUploadSize)
+	check(c.Character.UploadDir != "", "character.upload_dir must not be empty")
+	check(c.Character.SyncInterval >= 0, "character.sync_interval must not be negative, got %d", c.Character.SyncInterval)
+
+	check(c.Story.MaxParallel > 0, "story.max_parallel must be positive, got %d", c.Story.MaxParallel)
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// LoadConfig loads configuration from a TOML file on top of the defaults
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var cfg Config
-	if _, err := toml.Decode(string(data), &cfg); err != nil {
+	cfg := Defaults()
+	if _, err := toml.Decode(string(data), cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
-	return &cfg, nil
+	return cfg, nil
 }
@@ -168,3 +168,100 @@ timezone_offset = 8
 		t.Errorf("Expected TimezoneOffset 8, got %d", cfg.Timezone.TimezoneOffset)
 	}
 }
+
+func TestLoadConfig_TimezoneDefault(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "setting.toml")
+
+	content := `
+[global]
+api_key = "test_key"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.Timezone.TimezoneOffset != DefaultTimezoneOffset {
+		t.Errorf("Expected default TimezoneOffset %d, got %d", DefaultTimezoneOffset, cfg.Timezone.TimezoneOffset)
+	}
+}
+
+func TestLoadConfig_TimezoneExplicitZero(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "setting.toml")
+
+	content := `
+[timezone]
+timezone_offset = 0
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.Timezone.TimezoneOffset != 0 {
+		t.Errorf("Expected TimezoneOffset 0, got %d", cfg.Timezone.TimezoneOffset)
+	}
+}
+
+func TestLoadConfig_TimezoneOutOfRange(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "setting.toml")
+
+	content := `
+[timezone]
+timezone_offset = 20
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected error for out-of-range timezone_offset, got nil")
+	}
+}
+
+func TestLoadConfig_MaxPollIntervalBelowPollInterval(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "setting.toml")
+
+	content := `
+[sora]
+poll_interval = 5
+max_poll_interval = 2
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected error for max_poll_interval below poll_interval, got nil")
+	}
+}
+
+func TestLoadConfig_ModerationEndpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "setting.toml")
+
+	content := `
+[moderation]
+enabled = true
+endpoint = "not a url"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected error for invalid moderation.endpoint, got nil")
+	}
+}
@@ -0,0 +1,330 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Configuration sources, in increasing precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceDB      = "db"
+)
+
+// EnvPrefix is the prefix of environment overrides, e.g. SORANOW_SERVER_PORT
+const EnvPrefix = "SORANOW_"
+
+// OverrideStore provides admin overrides persisted outside the TOML file.
+// Keys are "section.key" (e.g. "generation.image_timeout"), values are JSON.
+type OverrideStore interface {
+	GetConfigOverrides() (map[string]string, error)
+}
+
+// Manager builds the effective configuration from layered sources
+// (defaults < TOML file < environment < database overrides) and notifies
+// subscribers when it is reloaded
+type Manager struct {
+	path      string
+	store     OverrideStore
+	lookupEnv func(string) (string, bool)
+
+	mu      sync.RWMutex
+	current *Config
+	sources map[string]string
+
+	subsMu      sync.Mutex
+	subscribers []func(*Config)
+}
+
+// NewManager creates a config manager for the TOML file and override store (both optional)
+func NewManager(path string, store OverrideStore) *Manager {
+	return &Manager{
+		path:      path,
+		store:     store,
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+// SetLookupEnv replaces the environment lookup (used by tests)
+func (m *Manager) SetLookupEnv(fn func(string) (string, bool)) {
+	m.lookupEnv = fn
+}
+
+// Path returns the TOML file path
+func (m *Manager) Path() string {
+	return m.path
+}
+
+// Reload rebuilds the configuration from all layers. On error the previous
+// configuration is kept; on success subscribers are notified.
+func (m *Manager) Reload() error {
+	cfg, sources, err := m.build()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.current = cfg
+	m.sources = sources
+	m.mu.Unlock()
+
+	m.subsMu.Lock()
+	subscribers := append([]func(*Config){}, m.subscribers...)
+	m.subsMu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(m.Get())
+	}
+	return nil
+}
+
+// Get returns a copy of the effective configuration (defaults before the first load)
+func (m *Manager) Get() *Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.current == nil {
+		return Defaults()
+	}
+	cfg := *m.current
+	return &cfg
+}
+
+// Sources returns the source of every configuration key
+func (m *Manager) Sources() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sources := make(map[string]string, len(m.sources))
+	for k, v := range m.sources {
+		sources[k] = v
+	}
+	return sources
+}
+
+// Subscribe registers a function called with the new configuration after each reload
+func (m *Manager) Subscribe(fn func(*Config)) {
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// build applies all layers and validates the result
+func (m *Manager) build() (*Config, map[string]string, error) {
+	cfg := Defaults()
+	fields := configFields(cfg)
+	sources := make(map[string]string, len(fields))
+	for _, f := range fields {
+		sources[f.key] = SourceDefault
+	}
+
+	// TOML file
+	if m.path != "" {
+		data, err := os.ReadFile(m.path)
+		switch {
+		case os.IsNotExist(err):
+			log.Printf("Config file %s not found, using defaults", m.path)
+		case err != nil:
+			return nil, nil, err
+		default:
+			meta, err := toml.Decode(string(data), cfg)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", m.path, err)
+			}
+			for _, key := range meta.Keys() {
+				if _, ok := sources[key.String()]; ok {
+					sources[key.String()] = SourceFile
+				}
+			}
+		}
+	}
+
+	// Environment variables
+	var errs []string
+	for _, f := range fields {
+		raw, ok := m.lookupEnv(EnvName(f.key))
+		if !ok {
+			continue
+		}
+		if err := setFromString(f.value, raw); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", EnvName(f.key), err))
+			continue
+		}
+		sources[f.key] = SourceEnv
+	}
+
+	// Database overrides
+	if m.store != nil {
+		overrides, err := m.store.GetConfigOverrides()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load config overrides: %v", err)
+		}
+		byKey := make(map[string]reflect.Value, len(fields))
+		for _, f := range fields {
+			byKey[f.key] = f.value
+		}
+		for key, raw := range overrides {
+			v, ok := byKey[key]
+			if !ok {
+				continue // internal markers and keys removed in newer versions
+			}
+			if err := json.Unmarshal([]byte(raw), v.Addr().Interface()); err != nil {
+				errs = append(errs, fmt.Sprintf("override %s: %v", key, err))
+				continue
+			}
+			sources[key] = SourceDB
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, &ValidationError{Errors: errs}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, sources, nil
+}
+
+// secretKeys are masked when the configuration is displayed
+var secretKeys = map[string]bool{
+	"global.api_key":                    true,
+	"global.admin_password":             true,
+	"watermark_free.custom_parse_token": true,
+	"moderation.api_key":                true,
+}
+
+// IsSecret reports whether the key holds a credential
+func IsSecret(key string) bool {
+	return secretKeys[key]
+}
+
+// EnvName returns the environment variable for a config key, e.g. "server.port" -> SORANOW_SERVER_PORT
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Keys returns all configuration keys in "section.key" form
+func Keys() []string {
+	fields := configFields(Defaults())
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.key
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// IsKey reports whether key is a known configuration key
+func IsKey(key string) bool {
+	for _, f := range configFields(Defaults()) {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeOverride checks that value fits the key's type and returns its JSON encoding
+func EncodeOverride(key string, value interface{}) (string, error) {
+	for _, f := range configFields(Defaults()) {
+		if f.key != key {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(raw, f.value.Addr().Interface()); err != nil {
+			return "", fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+		return string(raw), nil
+	}
+	return "", fmt.Errorf("unknown config key: %s", key)
+}
+
+// Flatten returns the configuration as a "section.key" -> value map
+func (c *Config) Flatten() map[string]interface{} {
+	fields := configFields(c)
+	values := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		values[f.key] = f.value.Interface()
+	}
+	return values
+}
+
+type configField struct {
+	key   string
+	value reflect.Value
+}
+
+// configFields lists the settable leaf fields of cfg keyed by their TOML path
+func configFields(cfg *Config) []configField {
+	var fields []configField
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i).Tag.Get("toml")
+		sv := root.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			name := sv.Type().Field(j).Tag.Get("toml")
+			if name == "" {
+				continue
+			}
+			fields = append(fields, configField{key: section + "." + name, value: sv.Field(j)})
+		}
+	}
+	return fields
+}
+
+// setFromString parses an environment value into the field
+func setFromString(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		// A JSON array, or values separated by "|" (user agents contain commas)
+		var values []string
+		raw = strings.TrimSpace(raw)
+		if strings.HasPrefix(raw, "[") {
+			if err := json.Unmarshal([]byte(raw), &values); err != nil {
+				return fmt.Errorf("invalid list %q", raw)
+			}
+		} else if raw != "" {
+			for _, item := range strings.Split(raw, "|") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, item)
+				}
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Kind())
+	}
+	return nil
+}
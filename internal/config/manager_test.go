@@ -0,0 +1,192 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memoryStore is an in-memory OverrideStore
+type memoryStore map[string]string
+
+func (s memoryStore) GetConfigOverrides() (map[string]string, error) {
+	return s, nil
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "setting.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestManager_Precedence(t *testing.T) {
+	path := writeConfig(t, `
+[server]
+port = 9000
+
+[generation]
+image_timeout = 100
+video_timeout = 200
+`)
+	store := memoryStore{
+		"generation.video_timeout": "400",
+		"_migrated.system_config":  "true",
+	}
+
+	m := NewManager(path, store)
+	m.SetLookupEnv(envMap(map[string]string{
+		"SORANOW_GENERATION_IMAGE_TIMEOUT": "150",
+		"SORANOW_GENERATION_VIDEO_TIMEOUT": "300",
+	}))
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	cfg := m.Get()
+	if cfg.Server.Port != 9000 {
+		t.Errorf("Expected port from file 9000, got %d", cfg.Server.Port)
+	}
+	if cfg.Generation.ImageTimeout != 150 {
+		t.Errorf("Expected image_timeout from env 150, got %d", cfg.Generation.ImageTimeout)
+	}
+	if cfg.Generation.VideoTimeout != 400 {
+		t.Errorf("Expected video_timeout from db 400, got %d", cfg.Generation.VideoTimeout)
+	}
+	if cfg.Sora.MaxPollAttempts != 600 {
+		t.Errorf("Expected default max_poll_attempts 600, got %d", cfg.Sora.MaxPollAttempts)
+	}
+
+	sources := m.Sources()
+	expected := map[string]string{
+		"server.port":              SourceFile,
+		"generation.image_timeout": SourceEnv,
+		"generation.video_timeout": SourceDB,
+		"sora.max_poll_attempts":   SourceDefault,
+	}
+	for key, want := range expected {
+		if sources[key] != want {
+			t.Errorf("Expected source of %s to be %s, got %s", key, want, sources[key])
+		}
+	}
+}
+
+func TestManager_MissingFileUsesDefaults(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "missing.toml"), nil)
+	m.SetLookupEnv(envMap(nil))
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if m.Get().Global.APIKey != "han1234" {
+		t.Errorf("Expected default API key, got %s", m.Get().Global.APIKey)
+	}
+}
+
+func TestManager_InvalidEnv(t *testing.T) {
+	m := NewManager("", nil)
+	m.SetLookupEnv(envMap(map[string]string{"SORANOW_SERVER_PORT": "abc"}))
+
+	err := m.Reload()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+}
+
+func TestManager_ValidationErrorKeepsPrevious(t *testing.T) {
+	store := memoryStore{}
+	m := NewManager("", store)
+	m.SetLookupEnv(envMap(nil))
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	reloads := 0
+	m.Subscribe(func(*Config) { reloads++ })
+
+	store["generation.image_timeout"] = "-1"
+	err := m.Reload()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+	if m.Get().Generation.ImageTimeout != 300 {
+		t.Errorf("Expected previous image_timeout 300, got %d", m.Get().Generation.ImageTimeout)
+	}
+	if reloads != 0 {
+		t.Errorf("Subscribers should not be notified of a failed reload")
+	}
+
+	store["generation.image_timeout"] = "120"
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if m.Get().Generation.ImageTimeout != 120 {
+		t.Errorf("Expected image_timeout 120, got %d", m.Get().Generation.ImageTimeout)
+	}
+	if reloads != 1 {
+		t.Errorf("Expected 1 notification, got %d", reloads)
+	}
+}
+
+func TestEncodeOverride(t *testing.T) {
+	raw, err := EncodeOverride("cache.enabled", true)
+	if err != nil || raw != "true" {
+		t.Errorf("Expected \"true\", got %q (%v)", raw, err)
+	}
+	if _, err := EncodeOverride("cache.enabled", "yes"); err == nil {
+		t.Error("Expected type error for string value of bool key")
+	}
+	if _, err := EncodeOverride("cache.unknown", 1); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("watermark_free.parse_method"); got != "SORANOW_WATERMARK_FREE_PARSE_METHOD" {
+		t.Errorf("Unexpected env name %s", got)
+	}
+}
+
+func TestManager_FingerprintLists(t *testing.T) {
+	m := NewManager("", nil)
+	m.SetLookupEnv(envMap(map[string]string{
+		"SORANOW_FINGERPRINT_TLS_PROFILE":     "chrome_131",
+		"SORANOW_FINGERPRINT_USER_AGENTS":     "Mozilla/5.0 (X11; Linux x86_64) Chrome/131.0.0.0 | Mozilla/5.0 (Windows NT 10.0) Chrome/131.0.0.0",
+		"SORANOW_FINGERPRINT_APP_USER_AGENTS": `["Sora/1.0 (Android 15)"]`,
+	}))
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	fp := m.Get().Fingerprint
+	if fp.TLSProfile != "chrome_131" {
+		t.Errorf("Expected chrome_131, got %s", fp.TLSProfile)
+	}
+	if len(fp.UserAgents) != 2 || fp.UserAgents[1] != "Mozilla/5.0 (Windows NT 10.0) Chrome/131.0.0.0" {
+		t.Errorf("Unexpected user agents %q", fp.UserAgents)
+	}
+	if len(fp.AppUserAgents) != 1 || fp.AppUserAgents[0] != "Sora/1.0 (Android 15)" {
+		t.Errorf("Unexpected app user agents %q", fp.AppUserAgents)
+	}
+}
+
+func TestManager_UnknownTLSProfile(t *testing.T) {
+	m := NewManager("", nil)
+	m.SetLookupEnv(envMap(map[string]string{"SORANOW_FINGERPRINT_TLS_PROFILE": "netscape_4"}))
+
+	var verr *ValidationError
+	if err := m.Reload(); !errors.As(err, &verr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+}
@@ -2,7 +2,10 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -23,6 +26,10 @@ func NewDB(path string) (*DB, error) {
 	if err := conn.Ping(); err != nil {
 		return nil, err
 	}
+	if path == ":memory:" {
+		// Each connection to :memory: opens a separate database; keep a single one
+		conn.SetMaxOpenConns(1)
+	}
 	return &DB{conn: conn}, nil
 }
 
@@ -41,6 +48,8 @@ func (db *DB) InitSchema() error {
 		refresh_token TEXT,
 		client_id TEXT,
 		proxy_url TEXT,
+		tls_profile TEXT,
+		user_agent TEXT,
 		remark TEXT,
 		is_active BOOLEAN DEFAULT 1,
 		is_expired BOOLEAN DEFAULT 0,
@@ -110,6 +119,9 @@ func (db *DB) InitSchema() error {
 		retry_count INTEGER DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		completed_at DATETIME,
+		generation_id TEXT,
+		parent_task_id TEXT,
+		operation TEXT,
 		FOREIGN KEY (token_id) REFERENCES tokens(id) ON DELETE CASCADE
 	);
 
@@ -140,22 +152,188 @@ func (db *DB) InitSchema() error {
 		status TEXT DEFAULT 'processing',
 		token_id INTEGER NOT NULL,
 		error_message TEXT,
+		auto_finalize INTEGER DEFAULT 0,
+		timestamps TEXT,
+		source_file TEXT,
+		source_type TEXT,
+		source_size INTEGER DEFAULT 0,
+		replaced_cameo_id TEXT,
+		replaced_token_id INTEGER DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME,
 		FOREIGN KEY (token_id) REFERENCES tokens(id) ON DELETE CASCADE
 	);
 
+	CREATE TABLE IF NOT EXISTS character_replicas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		character_id INTEGER NOT NULL,
+		token_id INTEGER NOT NULL,
+		cameo_id TEXT NOT NULL UNIQUE,
+		sora_character_id TEXT,
+		username TEXT NOT NULL,
+		status TEXT DEFAULT 'processing',
+		error_message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME,
+		UNIQUE (character_id, token_id),
+		FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE,
+		FOREIGN KEY (token_id) REFERENCES tokens(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS story_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL UNIQUE,
+		model TEXT NOT NULL,
+		title TEXT,
+		storyboard TEXT NOT NULL,
+		status TEXT DEFAULT 'pending',
+		progress REAL DEFAULT 0.0,
+		result_url TEXT,
+		result_file TEXT,
+		error_message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		completed_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS story_clips (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id INTEGER NOT NULL,
+		clip_index INTEGER NOT NULL,
+		model TEXT NOT NULL,
+		storyboard TEXT NOT NULL,
+		task_id TEXT,
+		status TEXT DEFAULT 'pending',
+		result_url TEXT,
+		error_message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME,
+		UNIQUE (job_id, clip_index),
+		FOREIGN KEY (job_id) REFERENCES story_jobs(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL,
+		secret TEXT,
+		events TEXT,
+		is_active BOOLEAN DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		delivery_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT,
+		attempt INTEGER DEFAULT 1,
+		status_code INTEGER DEFAULT 0,
+		response_body TEXT,
+		error TEXT,
+		success BOOLEAN DEFAULT 0,
+		duration_ms INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS prompt_templates (
+		id TEXT PRIMARY KEY,
+		category TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL,
+		description TEXT,
+		shots TEXT NOT NULL,
+		variables TEXT,
+		style TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS style_presets (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT,
+		icon TEXT,
+		prompt_suffix TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS config_overrides (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS proxy_bindings (
+		token_id INTEGER PRIMARY KEY,
+		proxy_url TEXT NOT NULL,
+		bound_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (token_id) REFERENCES tokens(id) ON DELETE CASCADE
+	);
+
 	INSERT OR IGNORE INTO system_config (id) VALUES (1);
 	`
-	_, err := db.conn.Exec(schema)
+	if _, err := db.conn.Exec(schema); err != nil {
+		return err
+	}
+	if err := db.migrateColumns(); err != nil {
+		return err
+	}
+
+	// Indexes on added columns exist only once the columns do
+	_, err := db.conn.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_tasks_parent ON tasks(parent_task_id);
+	CREATE INDEX IF NOT EXISTS idx_tasks_generation ON tasks(generation_id);
+	`)
 	return err
 }
 
+// addedColumns lists columns added after the initial release; CREATE TABLE IF NOT EXISTS
+// does not add them to existing databases
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"tokens", "tls_profile", "TEXT"},
+	{"tokens", "user_agent", "TEXT"},
+	{"characters", "auto_finalize", "INTEGER DEFAULT 0"},
+	{"characters", "timestamps", "TEXT"},
+	{"characters", "source_file", "TEXT"},
+	{"characters", "source_type", "TEXT"},
+	{"characters", "source_size", "INTEGER DEFAULT 0"},
+	{"characters", "replaced_cameo_id", "TEXT"},
+	{"characters", "replaced_token_id", "INTEGER DEFAULT 0"},
+	{"tasks", "generation_id", "TEXT"},
+	{"tasks", "parent_task_id", "TEXT"},
+	{"tasks", "operation", "TEXT"},
+}
+
+// migrateColumns adds missing columns to tables created by older versions
+func (db *DB) migrateColumns() error {
+	for _, c := range addedColumns {
+		var count int
+		err := db.conn.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.conn.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
 func (db *DB) CreateToken(token *models.Token) (int64, error) {
 	result, err := db.conn.Exec(`
-		INSERT INTO tokens (token, email, name, session_token, refresh_token, client_id, proxy_url, remark, is_active, is_expired, image_enabled, video_enabled, image_concurrency, video_concurrency, sora2_supported)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		token.Token, token.Email, token.Name, token.SessionToken, token.RefreshToken, token.ClientID, token.ProxyURL, token.Remark,
+		INSERT INTO tokens (token, email, name, session_token, refresh_token, client_id, proxy_url, tls_profile, user_agent, remark, is_active, is_expired, image_enabled, video_enabled, image_concurrency, video_concurrency, sora2_supported)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.Token, token.Email, token.Name, token.SessionToken, token.RefreshToken, token.ClientID, token.ProxyURL, token.TLSProfile, token.UserAgent, token.Remark,
 		token.IsActive, token.IsExpired, token.ImageEnabled, token.VideoEnabled, token.ImageConcurrency, token.VideoConcurrency, token.Sora2Supported)
 	if err != nil {
 		return 0, err
@@ -163,22 +341,40 @@ func (db *DB) CreateToken(token *models.Token) (int64, error) {
 	return result.LastInsertId()
 }
 
-func (db *DB) GetTokenByID(id int64) (*models.Token, error) {
-	token := &models.Token{}
-	err := db.conn.QueryRow(`
-		SELECT id, token, email, COALESCE(name, ''), COALESCE(session_token, ''), COALESCE(refresh_token, ''), COALESCE(client_id, ''), COALESCE(proxy_url, ''), COALESCE(remark, ''),
+// tokenColumns is the column list shared by all token queries (matches scanToken)
+const tokenColumns = `id, token, email, COALESCE(name, ''), COALESCE(session_token, ''), COALESCE(refresh_token, ''), COALESCE(client_id, ''), COALESCE(proxy_url, ''),
+		COALESCE(tls_profile, ''), COALESCE(user_agent, ''), COALESCE(remark, ''),
 		is_active, is_expired, image_enabled, video_enabled,
 		image_concurrency, video_concurrency, sora2_supported, cooled_until,
 		COALESCE(total_image_count, 0), COALESCE(total_video_count, 0), COALESCE(total_error_count, 0),
 		COALESCE(today_image_count, 0), COALESCE(today_video_count, 0), COALESCE(today_error_count, 0), COALESCE(today_date, ''),
-		COALESCE(consecutive_errors, 0), last_error_at, last_used_at, created_at
-		FROM tokens WHERE id = ?`, id).Scan(
-		&token.ID, &token.Token, &token.Email, &token.Name, &token.SessionToken, &token.RefreshToken, &token.ClientID, &token.ProxyURL, &token.Remark,
+		COALESCE(consecutive_errors, 0), last_error_at, last_used_at, created_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func scanToken(row rowScanner) (*models.Token, error) {
+	token := &models.Token{}
+	err := row.Scan(
+		&token.ID, &token.Token, &token.Email, &token.Name, &token.SessionToken, &token.RefreshToken, &token.ClientID, &token.ProxyURL,
+		&token.TLSProfile, &token.UserAgent, &token.Remark,
 		&token.IsActive, &token.IsExpired, &token.ImageEnabled, &token.VideoEnabled, &token.ImageConcurrency, &token.VideoConcurrency,
 		&token.Sora2Supported, &token.CooledUntil,
 		&token.TotalImageCount, &token.TotalVideoCount, &token.TotalErrorCount,
 		&token.TodayImageCount, &token.TodayVideoCount, &token.TodayErrorCount, &token.TodayDate,
 		&token.ConsecutiveErrors, &token.LastErrorAt, &token.LastUsedAt, &token.CreatedAt)
+	return token, err
+}
+
+func (db *DB) GetTokenByID(id int64) (*models.Token, error) {
+	token, err := scanToken(db.conn.QueryRow(`SELECT `+tokenColumns+` FROM tokens WHERE id = ?`, id))
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -186,10 +382,7 @@ func (db *DB) GetTokenByID(id int64) (*models.Token, error) {
 }
 
 func (db *DB) GetTokenByToken(tokenStr string) (*models.Token, error) {
-	token := &models.Token{}
-	err := db.conn.QueryRow(`SELECT id, token, email, COALESCE(name, ''), COALESCE(session_token, ''), COALESCE(refresh_token, ''), COALESCE(client_id, ''), COALESCE(proxy_url, ''), COALESCE(remark, ''), is_active, is_expired, image_enabled, video_enabled, image_concurrency, video_concurrency, sora2_supported, created_at FROM tokens WHERE token = ?`, tokenStr).Scan(
-		&token.ID, &token.Token, &token.Email, &token.Name, &token.SessionToken, &token.RefreshToken, &token.ClientID, &token.ProxyURL, &token.Remark,
-		&token.IsActive, &token.IsExpired, &token.ImageEnabled, &token.VideoEnabled, &token.ImageConcurrency, &token.VideoConcurrency, &token.Sora2Supported, &token.CreatedAt)
+	token, err := scanToken(db.conn.QueryRow(`SELECT `+tokenColumns+` FROM tokens WHERE token = ?`, tokenStr))
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -198,14 +391,14 @@ func (db *DB) GetTokenByToken(tokenStr string) (*models.Token, error) {
 
 func (db *DB) UpdateToken(token *models.Token) error {
 	_, err := db.conn.Exec(`
-		UPDATE tokens SET token=?, email=?, name=?, session_token=?, refresh_token=?, client_id=?, proxy_url=?, remark=?,
+		UPDATE tokens SET token=?, email=?, name=?, session_token=?, refresh_token=?, client_id=?, proxy_url=?, tls_profile=?, user_agent=?, remark=?,
 		is_active=?, is_expired=?, image_enabled=?, video_enabled=?,
 		image_concurrency=?, video_concurrency=?, sora2_supported=?, cooled_until=?,
 		total_image_count=?, total_video_count=?, total_error_count=?,
 		today_image_count=?, today_video_count=?, today_error_count=?, today_date=?,
 		consecutive_errors=?, last_error_at=?, last_used_at=?
 		WHERE id=?`,
-		token.Token, token.Email, token.Name, token.SessionToken, token.RefreshToken, token.ClientID, token.ProxyURL, token.Remark,
+		token.Token, token.Email, token.Name, token.SessionToken, token.RefreshToken, token.ClientID, token.ProxyURL, token.TLSProfile, token.UserAgent, token.Remark,
 		token.IsActive, token.IsExpired, token.ImageEnabled, token.VideoEnabled, token.ImageConcurrency, token.VideoConcurrency,
 		token.Sora2Supported, token.CooledUntil,
 		token.TotalImageCount, token.TotalVideoCount, token.TotalErrorCount,
@@ -216,12 +409,29 @@ func (db *DB) UpdateToken(token *models.Token) error {
 }
 
 func (db *DB) DeleteToken(id int64) error {
+	if _, err := db.conn.Exec(`DELETE FROM proxy_bindings WHERE token_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.conn.Exec(`DELETE FROM character_replicas WHERE token_id = ?`, id); err != nil {
+		return err
+	}
 	_, err := db.conn.Exec(`DELETE FROM tokens WHERE id = ?`, id)
 	return err
 }
 
+// ResetDailyCounters zeroes today's counters for tokens whose today_date is not the given date
+func (db *DB) ResetDailyCounters(today string) (int64, error) {
+	result, err := db.conn.Exec(`
+		UPDATE tokens SET today_image_count = 0, today_video_count = 0, today_error_count = 0, today_date = ?
+		WHERE COALESCE(today_date, '') != ?`, today, today)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (db *DB) GetActiveTokens() ([]*models.Token, error) {
-	rows, err := db.conn.Query(`SELECT id, token, email, COALESCE(name, ''), COALESCE(session_token, ''), COALESCE(refresh_token, ''), COALESCE(client_id, ''), COALESCE(proxy_url, ''), COALESCE(remark, ''), is_active, is_expired, image_enabled, video_enabled, image_concurrency, video_concurrency, sora2_supported, created_at FROM tokens WHERE is_active = 1 AND is_expired = 0`)
+	rows, err := db.conn.Query(`SELECT ` + tokenColumns + ` FROM tokens WHERE is_active = 1 AND is_expired = 0`)
 	if err != nil {
 		return nil, err
 	}
@@ -230,7 +440,7 @@ func (db *DB) GetActiveTokens() ([]*models.Token, error) {
 }
 
 func (db *DB) GetAllTokens() ([]*models.Token, error) {
-	rows, err := db.conn.Query(`SELECT id, token, email, COALESCE(name, ''), COALESCE(session_token, ''), COALESCE(refresh_token, ''), COALESCE(client_id, ''), COALESCE(proxy_url, ''), COALESCE(remark, ''), is_active, is_expired, image_enabled, video_enabled, image_concurrency, video_concurrency, sora2_supported, created_at FROM tokens`)
+	rows, err := db.conn.Query(`SELECT ` + tokenColumns + ` FROM tokens`)
 	if err != nil {
 		return nil, err
 	}
@@ -241,9 +451,8 @@ func (db *DB) GetAllTokens() ([]*models.Token, error) {
 func scanTokens(rows *sql.Rows) ([]*models.Token, error) {
 	var tokens []*models.Token
 	for rows.Next() {
-		token := &models.Token{}
-		if err := rows.Scan(&token.ID, &token.Token, &token.Email, &token.Name, &token.SessionToken, &token.RefreshToken, &token.ClientID, &token.ProxyURL, &token.Remark,
-			&token.IsActive, &token.IsExpired, &token.ImageEnabled, &token.VideoEnabled, &token.ImageConcurrency, &token.VideoConcurrency, &token.Sora2Supported, &token.CreatedAt); err != nil {
+		token, err := scanToken(rows)
+		if err != nil {
 			return nil, err
 		}
 		tokens = append(tokens, token)
@@ -275,33 +484,139 @@ func (db *DB) UpdateSystemConfig(cfg *models.SystemConfig) error {
 	return err
 }
 
+// Config Overrides (admin changes layered on top of TOML and environment)
+
+func (db *DB) GetConfigOverrides() (map[string]string, error) {
+	rows, err := db.conn.Query(`SELECT key, value FROM config_overrides`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		overrides[key] = value
+	}
+	return overrides, rows.Err()
+}
+
+func (db *DB) SetConfigOverride(key, value string) error {
+	_, err := db.conn.Exec(`INSERT INTO config_overrides (key, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`, key, value, time.Now())
+	return err
+}
+
+func (db *DB) DeleteConfigOverride(key string) error {
+	_, err := db.conn.Exec(`DELETE FROM config_overrides WHERE key = ?`, key)
+	return err
+}
+
+// Proxy Bindings (sticky pool proxy per token)
+
+func (db *DB) GetProxyBindings() (map[int64]string, error) {
+	rows, err := db.conn.Query(`SELECT token_id, proxy_url FROM proxy_bindings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bindings := make(map[int64]string)
+	for rows.Next() {
+		var tokenID int64
+		var proxyURL string
+		if err := rows.Scan(&tokenID, &proxyURL); err != nil {
+			return nil, err
+		}
+		bindings[tokenID] = proxyURL
+	}
+	return bindings, rows.Err()
+}
+
+func (db *DB) SetProxyBinding(tokenID int64, proxyURL string) error {
+	_, err := db.conn.Exec(`INSERT INTO proxy_bindings (token_id, proxy_url, bound_at) VALUES (?, ?, ?)
+		ON CONFLICT(token_id) DO UPDATE SET proxy_url = excluded.proxy_url, bound_at = excluded.bound_at`, tokenID, proxyURL, time.Now())
+	return err
+}
+
+func (db *DB) DeleteProxyBinding(tokenID int64) error {
+	_, err := db.conn.Exec(`DELETE FROM proxy_bindings WHERE token_id = ?`, tokenID)
+	return err
+}
+
 func (db *DB) CreateTask(task *models.Task) (int64, error) {
 	result, err := db.conn.Exec(`
-		INSERT INTO tasks (task_id, token_id, model, prompt, status, progress)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		task.TaskID, task.TokenID, task.Model, task.Prompt, task.Status, task.Progress)
+		INSERT INTO tasks (task_id, token_id, model, prompt, status, progress, parent_task_id, operation)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		task.TaskID, task.TokenID, task.Model, task.Prompt, task.Status, task.Progress, task.ParentTaskID, task.Operation)
 	if err != nil {
 		return 0, err
 	}
 	return result.LastInsertId()
 }
 
-func (db *DB) GetTaskByTaskID(taskID string) (*models.Task, error) {
+// taskColumns is the column list shared by all task queries (matches scanTask)
+const taskColumns = `id, task_id, token_id, model, prompt, status, progress, COALESCE(result_urls, ''), COALESCE(error_message, ''),
+		retry_count, created_at, completed_at, COALESCE(generation_id, ''), COALESCE(parent_task_id, ''), COALESCE(operation, '')`
+
+func scanTask(row rowScanner) (*models.Task, error) {
 	task := &models.Task{}
-	err := db.conn.QueryRow(`SELECT id, task_id, token_id, model, prompt, status, progress, retry_count, created_at FROM tasks WHERE task_id = ?`, taskID).Scan(
-		&task.ID, &task.TaskID, &task.TokenID, &task.Model, &task.Prompt, &task.Status, &task.Progress, &task.RetryCount, &task.CreatedAt)
+	err := row.Scan(&task.ID, &task.TaskID, &task.TokenID, &task.Model, &task.Prompt, &task.Status, &task.Progress, &task.ResultURLs, &task.ErrorMessage,
+		&task.RetryCount, &task.CreatedAt, &task.CompletedAt, &task.GenerationID, &task.ParentTaskID, &task.Operation)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
-	return task, err
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (db *DB) GetTaskByTaskID(taskID string) (*models.Task, error) {
+	return scanTask(db.conn.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE task_id = ?`, taskID))
+}
+
+func (db *DB) GetTasksByStatus(status string) ([]*models.Task, error) {
+	return db.queryTasks(`SELECT `+taskColumns+` FROM tasks WHERE status = ? ORDER BY id`, status)
+}
+
+// GetTaskByGenerationID returns the task that produced a Sora generation
+func (db *DB) GetTaskByGenerationID(generationID string) (*models.Task, error) {
+	return scanTask(db.conn.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE generation_id = ? ORDER BY id DESC LIMIT 1`, generationID))
+}
+
+// GetChildTasks returns the tasks remixing or extending the given task, oldest first
+func (db *DB) GetChildTasks(parentTaskID string) ([]*models.Task, error) {
+	return db.queryTasks(`SELECT `+taskColumns+` FROM tasks WHERE parent_task_id = ? ORDER BY id`, parentTaskID)
 }
 
 func (db *DB) UpdateTask(task *models.Task) error {
-	_, err := db.conn.Exec(`UPDATE tasks SET status=?, progress=?, result_urls=?, error_message=?, retry_count=?, completed_at=? WHERE id=?`,
-		task.Status, task.Progress, task.ResultURLs, task.ErrorMessage, task.RetryCount, task.CompletedAt, task.ID)
+	_, err := db.conn.Exec(`UPDATE tasks SET status=?, progress=?, result_urls=?, error_message=?, retry_count=?, completed_at=?, generation_id=? WHERE id=?`,
+		task.Status, task.Progress, task.ResultURLs, task.ErrorMessage, task.RetryCount, task.CompletedAt, task.GenerationID, task.ID)
 	return err
 }
 
+func (db *DB) queryTasks(query string, args ...interface{}) ([]*models.Task, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*models.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
 // Request Logs
 
 func (db *DB) CreateRequestLog(log *models.RequestLog) (int64, error) {
@@ -466,7 +781,7 @@ func (db *DB) GetTokensByIDs(ids []int64) ([]*models.Token, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
-	query := `SELECT id, token, email, name, is_active, is_expired, image_enabled, video_enabled, image_concurrency, video_concurrency, sora2_supported, created_at FROM tokens WHERE id IN (`
+	query := `SELECT ` + tokenColumns + ` FROM tokens WHERE id IN (`
 	args := make([]interface{}, len(ids))
 	for i, id := range ids {
 		if i > 0 {
@@ -488,62 +803,50 @@ func (db *DB) GetTokensByIDs(ids []int64) ([]*models.Token, error) {
 
 func (db *DB) CreateCharacter(char *models.Character) (int64, error) {
 	result, err := db.conn.Exec(`
-		INSERT INTO characters (cameo_id, character_id, username, display_name, profile_url, instruction_set, safety_instruction_set, visibility, status, token_id, error_message)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		INSERT INTO characters (cameo_id, character_id, username, display_name, profile_url, instruction_set, safety_instruction_set, visibility, status, token_id, error_message, auto_finalize,
+			timestamps, source_file, source_type, source_size, replaced_cameo_id, replaced_token_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		char.CameoID, char.CharacterID, char.Username, char.DisplayName, char.ProfileURL,
-		char.InstructionSet, char.SafetyInstructionSet, char.Visibility, char.Status, char.TokenID, char.ErrorMessage)
+		char.InstructionSet, char.SafetyInstructionSet, char.Visibility, char.Status, char.TokenID, char.ErrorMessage, char.AutoFinalize,
+		char.Timestamps, char.SourceFile, char.SourceType, char.SourceSize, char.ReplacedCameoID, char.ReplacedTokenID)
 	if err != nil {
 		return 0, err
 	}
 	return result.LastInsertId()
 }
 
-func (db *DB) GetCharacterByID(id int64) (*models.Character, error) {
-	char := &models.Character{}
-	err := db.conn.QueryRow(`
-		SELECT id, cameo_id, COALESCE(character_id, ''), username, display_name, COALESCE(profile_url, ''),
+// characterColumns is the column list shared by all character queries (matches scanCharacter)
+const characterColumns = `id, cameo_id, COALESCE(character_id, ''), username, display_name, COALESCE(profile_url, ''),
 		COALESCE(instruction_set, ''), COALESCE(safety_instruction_set, ''), visibility, status, token_id,
-		COALESCE(error_message, ''), created_at, updated_at
-		FROM characters WHERE id = ?`, id).Scan(
-		&char.ID, &char.CameoID, &char.CharacterID, &char.Username, &char.DisplayName, &char.ProfileURL,
-		&char.InstructionSet, &char.SafetyInstructionSet, &char.Visibility, &char.Status, &char.TokenID,
-		&char.ErrorMessage, &char.CreatedAt, &char.UpdatedAt)
+		COALESCE(error_message, ''), COALESCE(auto_finalize, 0), COALESCE(timestamps, ''), COALESCE(source_file, ''),
+		COALESCE(source_type, ''), COALESCE(source_size, 0), COALESCE(replaced_cameo_id, ''), COALESCE(replaced_token_id, 0),
+		created_at, updated_at`
+
+func scanCharacter(row rowScanner) (*models.Character, error) {
+	char := &models.Character{}
+	err := row.Scan(&char.ID, &char.CameoID, &char.CharacterID, &char.Username, &char.DisplayName,
+		&char.ProfileURL, &char.InstructionSet, &char.SafetyInstructionSet, &char.Visibility,
+		&char.Status, &char.TokenID, &char.ErrorMessage, &char.AutoFinalize, &char.Timestamps, &char.SourceFile,
+		&char.SourceType, &char.SourceSize, &char.ReplacedCameoID, &char.ReplacedTokenID, &char.CreatedAt, &char.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
-	return char, err
+	if err != nil {
+		return nil, err
+	}
+	return char, nil
+}
+
+func (db *DB) GetCharacterByID(id int64) (*models.Character, error) {
+	return scanCharacter(db.conn.QueryRow(`SELECT `+characterColumns+` FROM characters WHERE id = ?`, id))
 }
 
 func (db *DB) GetCharacterByCameoID(cameoID string) (*models.Character, error) {
-	char := &models.Character{}
-	err := db.conn.QueryRow(`
-		SELECT id, cameo_id, COALESCE(character_id, ''), username, display_name, COALESCE(profile_url, ''),
-		COALESCE(instruction_set, ''), COALESCE(safety_instruction_set, ''), visibility, status, token_id,
-		COALESCE(error_message, ''), created_at, updated_at
-		FROM characters WHERE cameo_id = ?`, cameoID).Scan(
-		&char.ID, &char.CameoID, &char.CharacterID, &char.Username, &char.DisplayName, &char.ProfileURL,
-		&char.InstructionSet, &char.SafetyInstructionSet, &char.Visibility, &char.Status, &char.TokenID,
-		&char.ErrorMessage, &char.CreatedAt, &char.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, ErrNotFound
-	}
-	return char, err
+	return scanCharacter(db.conn.QueryRow(`SELECT `+characterColumns+` FROM characters WHERE cameo_id = ?`, cameoID))
 }
 
 func (db *DB) GetCharacterByUsername(username string) (*models.Character, error) {
-	char := &models.Character{}
-	err := db.conn.QueryRow(`
-		SELECT id, cameo_id, COALESCE(character_id, ''), username, display_name, COALESCE(profile_url, ''),
-		COALESCE(instruction_set, ''), COALESCE(safety_instruction_set, ''), visibility, status, token_id,
-		COALESCE(error_message, ''), created_at, updated_at
-		FROM characters WHERE username = ?`, username).Scan(
-		&char.ID, &char.CameoID, &char.CharacterID, &char.Username, &char.DisplayName, &char.ProfileURL,
-		&char.InstructionSet, &char.SafetyInstructionSet, &char.Visibility, &char.Status, &char.TokenID,
-		&char.ErrorMessage, &char.CreatedAt, &char.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, ErrNotFound
-	}
-	return char, err
+	return scanCharacter(db.conn.QueryRow(`SELECT `+characterColumns+` FROM characters WHERE username = ?`, username))
 }
 
 func (db *DB) UpdateCharacter(char *models.Character) error {
@@ -551,25 +854,26 @@ func (db *DB) UpdateCharacter(char *models.Character) error {
 	char.UpdatedAt = &now
 	_, err := db.conn.Exec(`
 		UPDATE characters SET cameo_id=?, character_id=?, username=?, display_name=?, profile_url=?,
-		instruction_set=?, safety_instruction_set=?, visibility=?, status=?, token_id=?, error_message=?, updated_at=?
+		instruction_set=?, safety_instruction_set=?, visibility=?, status=?, token_id=?, error_message=?, auto_finalize=?,
+		timestamps=?, source_file=?, source_type=?, source_size=?, replaced_cameo_id=?, replaced_token_id=?, updated_at=?
 		WHERE id=?`,
 		char.CameoID, char.CharacterID, char.Username, char.DisplayName, char.ProfileURL,
 		char.InstructionSet, char.SafetyInstructionSet, char.Visibility, char.Status, char.TokenID,
-		char.ErrorMessage, char.UpdatedAt, char.ID)
+		char.ErrorMessage, char.AutoFinalize, char.Timestamps, char.SourceFile, char.SourceType, char.SourceSize,
+		char.ReplacedCameoID, char.ReplacedTokenID, char.UpdatedAt, char.ID)
 	return err
 }
 
 func (db *DB) DeleteCharacter(id int64) error {
+	if _, err := db.conn.Exec(`DELETE FROM character_replicas WHERE character_id = ?`, id); err != nil {
+		return err
+	}
 	_, err := db.conn.Exec(`DELETE FROM characters WHERE id = ?`, id)
 	return err
 }
 
 func (db *DB) GetCharactersByTokenID(tokenID int64) ([]*models.Character, error) {
-	rows, err := db.conn.Query(`
-		SELECT id, cameo_id, COALESCE(character_id, ''), username, display_name, COALESCE(profile_url, ''),
-		COALESCE(instruction_set, ''), COALESCE(safety_instruction_set, ''), visibility, status, token_id,
-		COALESCE(error_message, ''), created_at, updated_at
-		FROM characters WHERE token_id = ? ORDER BY created_at DESC`, tokenID)
+	rows, err := db.conn.Query(`SELECT `+characterColumns+` FROM characters WHERE token_id = ? ORDER BY created_at DESC`, tokenID)
 	if err != nil {
 		return nil, err
 	}
@@ -578,11 +882,7 @@ func (db *DB) GetCharactersByTokenID(tokenID int64) ([]*models.Character, error)
 }
 
 func (db *DB) GetAllCharacters() ([]*models.Character, error) {
-	rows, err := db.conn.Query(`
-		SELECT id, cameo_id, COALESCE(character_id, ''), username, display_name, COALESCE(profile_url, ''),
-		COALESCE(instruction_set, ''), COALESCE(safety_instruction_set, ''), visibility, status, token_id,
-		COALESCE(error_message, ''), created_at, updated_at
-		FROM characters ORDER BY created_at DESC`)
+	rows, err := db.conn.Query(`SELECT ` + characterColumns + ` FROM characters ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -591,11 +891,19 @@ func (db *DB) GetAllCharacters() ([]*models.Character, error) {
 }
 
 func (db *DB) GetFinalizedCharacters() ([]*models.Character, error) {
-	rows, err := db.conn.Query(`
-		SELECT id, cameo_id, COALESCE(character_id, ''), username, display_name, COALESCE(profile_url, ''),
-		COALESCE(instruction_set, ''), COALESCE(safety_instruction_set, ''), visibility, status, token_id,
-		COALESCE(error_message, ''), created_at, updated_at
-		FROM characters WHERE status = 'finalized' ORDER BY created_at DESC`)
+	rows, err := db.conn.Query(`SELECT ` + characterColumns + ` FROM characters WHERE status = 'finalized' ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCharacters(rows)
+}
+
+// GetPendingCharacters returns characters whose cameo is still processing, and
+// ready ones waiting to be finalized automatically
+func (db *DB) GetPendingCharacters() ([]*models.Character, error) {
+	rows, err := db.conn.Query(`SELECT ` + characterColumns + ` FROM characters
+		WHERE status = 'processing' OR (status = 'ready' AND auto_finalize = 1) ORDER BY created_at`)
 	if err != nil {
 		return nil, err
 	}
@@ -606,10 +914,8 @@ func (db *DB) GetFinalizedCharacters() ([]*models.Character, error) {
 func scanCharacters(rows *sql.Rows) ([]*models.Character, error) {
 	var characters []*models.Character
 	for rows.Next() {
-		char := &models.Character{}
-		if err := rows.Scan(&char.ID, &char.CameoID, &char.CharacterID, &char.Username, &char.DisplayName,
-			&char.ProfileURL, &char.InstructionSet, &char.SafetyInstructionSet, &char.Visibility,
-			&char.Status, &char.TokenID, &char.ErrorMessage, &char.CreatedAt, &char.UpdatedAt); err != nil {
+		char, err := scanCharacter(rows)
+		if err != nil {
 			return nil, err
 		}
 		characters = append(characters, char)
@@ -617,3 +923,499 @@ func scanCharacters(rows *sql.Rows) ([]*models.Character, error) {
 	return characters, rows.Err()
 }
 
+// Character Replica Operations
+
+func (db *DB) CreateCharacterReplica(rep *models.CharacterReplica) (int64, error) {
+	result, err := db.conn.Exec(`
+		INSERT INTO character_replicas (character_id, token_id, cameo_id, sora_character_id, username, status, error_message)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rep.CharacterID, rep.TokenID, rep.CameoID, rep.SoraID, rep.Username, rep.Status, rep.ErrorMessage)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// replicaColumns is the column list shared by all replica queries (matches scanReplica)
+const replicaColumns = `id, character_id, token_id, cameo_id, COALESCE(sora_character_id, ''), username, status,
+		COALESCE(error_message, ''), created_at, updated_at`
+
+func scanReplica(row rowScanner) (*models.CharacterReplica, error) {
+	rep := &models.CharacterReplica{}
+	err := row.Scan(&rep.ID, &rep.CharacterID, &rep.TokenID, &rep.CameoID, &rep.SoraID, &rep.Username,
+		&rep.Status, &rep.ErrorMessage, &rep.CreatedAt, &rep.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+func (db *DB) GetCharacterReplicaByID(id int64) (*models.CharacterReplica, error) {
+	return scanReplica(db.conn.QueryRow(`SELECT `+replicaColumns+` FROM character_replicas WHERE id = ?`, id))
+}
+
+// GetCharacterReplicas returns the replicas of a character
+func (db *DB) GetCharacterReplicas(characterID int64) ([]*models.CharacterReplica, error) {
+	return db.queryReplicas(`SELECT `+replicaColumns+` FROM character_replicas WHERE character_id = ? ORDER BY token_id`, characterID)
+}
+
+// GetAllCharacterReplicas returns the replicas of every character
+func (db *DB) GetAllCharacterReplicas() ([]*models.CharacterReplica, error) {
+	return db.queryReplicas(`SELECT ` + replicaColumns + ` FROM character_replicas ORDER BY character_id, token_id`)
+}
+
+// GetPendingCharacterReplicas returns replicas not yet finalized or failed
+func (db *DB) GetPendingCharacterReplicas() ([]*models.CharacterReplica, error) {
+	return db.queryReplicas(`SELECT ` + replicaColumns + ` FROM character_replicas WHERE status IN ('processing', 'ready') ORDER BY created_at`)
+}
+
+func (db *DB) UpdateCharacterReplica(rep *models.CharacterReplica) error {
+	now := time.Now()
+	rep.UpdatedAt = &now
+	_, err := db.conn.Exec(`
+		UPDATE character_replicas SET cameo_id=?, sora_character_id=?, username=?, status=?, error_message=?, updated_at=?
+		WHERE id=?`,
+		rep.CameoID, rep.SoraID, rep.Username, rep.Status, rep.ErrorMessage, rep.UpdatedAt, rep.ID)
+	return err
+}
+
+func (db *DB) DeleteCharacterReplica(id int64) error {
+	_, err := db.conn.Exec(`DELETE FROM character_replicas WHERE id = ?`, id)
+	return err
+}
+
+func (db *DB) queryReplicas(query string, args ...interface{}) ([]*models.CharacterReplica, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replicas []*models.CharacterReplica
+	for rows.Next() {
+		rep, err := scanReplica(rows)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, rep)
+	}
+	return replicas, rows.Err()
+}
+
+// Story Job Operations
+
+func (db *DB) CreateStoryJob(job *models.StoryJob) (int64, error) {
+	result, err := db.conn.Exec(`
+		INSERT INTO story_jobs (job_id, model, title, storyboard, status, progress)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		job.JobID, job.Model, job.Title, job.Storyboard, job.Status, job.Progress)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// storyJobColumns is the column list shared by all story job queries (matches scanStoryJob)
+const storyJobColumns = `id, job_id, model, COALESCE(title, ''), storyboard, status, progress, COALESCE(result_url, ''),
+		COALESCE(result_file, ''), COALESCE(error_message, ''), created_at, completed_at`
+
+func scanStoryJob(row rowScanner) (*models.StoryJob, error) {
+	job := &models.StoryJob{}
+	err := row.Scan(&job.ID, &job.JobID, &job.Model, &job.Title, &job.Storyboard, &job.Status, &job.Progress,
+		&job.ResultURL, &job.ResultFile, &job.ErrorMessage, &job.CreatedAt, &job.CompletedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (db *DB) GetStoryJobByJobID(jobID string) (*models.StoryJob, error) {
+	return scanStoryJob(db.conn.QueryRow(`SELECT `+storyJobColumns+` FROM story_jobs WHERE job_id = ?`, jobID))
+}
+
+// GetStoryJobs returns the most recent story jobs
+func (db *DB) GetStoryJobs(limit int) ([]*models.StoryJob, error) {
+	return db.queryStoryJobs(`SELECT `+storyJobColumns+` FROM story_jobs ORDER BY id DESC LIMIT ?`, limit)
+}
+
+// GetUnfinishedStoryJobs returns story jobs neither completed nor failed
+func (db *DB) GetUnfinishedStoryJobs() ([]*models.StoryJob, error) {
+	return db.queryStoryJobs(`SELECT ` + storyJobColumns + ` FROM story_jobs WHERE status NOT IN ('completed', 'failed') ORDER BY id`)
+}
+
+func (db *DB) UpdateStoryJob(job *models.StoryJob) error {
+	_, err := db.conn.Exec(`
+		UPDATE story_jobs SET status=?, progress=?, result_url=?, result_file=?, error_message=?, completed_at=?
+		WHERE id=?`,
+		job.Status, job.Progress, job.ResultURL, job.ResultFile, job.ErrorMessage, job.CompletedAt, job.ID)
+	return err
+}
+
+// DeleteStoryJob deletes a story job and its clips
+func (db *DB) DeleteStoryJob(id int64) error {
+	if _, err := db.conn.Exec(`DELETE FROM story_clips WHERE job_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(`DELETE FROM story_jobs WHERE id = ?`, id)
+	return err
+}
+
+func (db *DB) queryStoryJobs(query string, args ...interface{}) ([]*models.StoryJob, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*models.StoryJob
+	for rows.Next() {
+		job, err := scanStoryJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (db *DB) CreateStoryClip(clip *models.StoryClip) (int64, error) {
+	result, err := db.conn.Exec(`
+		INSERT INTO story_clips (job_id, clip_index, model, storyboard, task_id, status, result_url, error_message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		clip.JobID, clip.Index, clip.Model, clip.Storyboard, clip.TaskID, clip.Status, clip.ResultURL, clip.ErrorMessage)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetStoryClips returns the clips of a story job in order
+func (db *DB) GetStoryClips(jobID int64) ([]*models.StoryClip, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, job_id, clip_index, model, storyboard, COALESCE(task_id, ''), status, COALESCE(result_url, ''),
+		COALESCE(error_message, ''), created_at, updated_at
+		FROM story_clips WHERE job_id = ? ORDER BY clip_index`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clips []*models.StoryClip
+	for rows.Next() {
+		clip := &models.StoryClip{}
+		if err := rows.Scan(&clip.ID, &clip.JobID, &clip.Index, &clip.Model, &clip.Storyboard, &clip.TaskID, &clip.Status,
+			&clip.ResultURL, &clip.ErrorMessage, &clip.CreatedAt, &clip.UpdatedAt); err != nil {
+			return nil, err
+		}
+		clips = append(clips, clip)
+	}
+	return clips, rows.Err()
+}
+
+func (db *DB) UpdateStoryClip(clip *models.StoryClip) error {
+	now := time.Now()
+	clip.UpdatedAt = &now
+	_, err := db.conn.Exec(`
+		UPDATE story_clips SET task_id=?, status=?, result_url=?, error_message=?, updated_at=?
+		WHERE id=?`,
+		clip.TaskID, clip.Status, clip.ResultURL, clip.ErrorMessage, clip.UpdatedAt, clip.ID)
+	return err
+}
+
+// Webhook Operations
+
+func joinWebhookEvents(events []string) string {
+	return strings.Join(events, ",")
+}
+
+func splitWebhookEvents(events string) []string {
+	result := []string{}
+	for _, e := range strings.Split(events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func (db *DB) CreateWebhook(hook *models.Webhook) (int64, error) {
+	result, err := db.conn.Exec(`
+		INSERT INTO webhooks (name, url, secret, events, is_active, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		hook.Name, hook.URL, hook.Secret, joinWebhookEvents(hook.Events), hook.IsActive, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const webhookColumns = `id, name, url, COALESCE(secret, ''), COALESCE(events, ''), is_active, created_at, updated_at`
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	hook := &models.Webhook{}
+	var events string
+	if err := row.Scan(&hook.ID, &hook.Name, &hook.URL, &hook.Secret, &events, &hook.IsActive, &hook.CreatedAt, &hook.UpdatedAt); err != nil {
+		return nil, err
+	}
+	hook.Events = splitWebhookEvents(events)
+	return hook, nil
+}
+
+func (db *DB) GetWebhookByID(id int64) (*models.Webhook, error) {
+	hook, err := scanWebhook(db.conn.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return hook, err
+}
+
+func (db *DB) GetAllWebhooks() ([]*models.Webhook, error) {
+	return db.queryWebhooks(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+}
+
+func (db *DB) GetActiveWebhooks() ([]*models.Webhook, error) {
+	return db.queryWebhooks(`SELECT ` + webhookColumns + ` FROM webhooks WHERE is_active = 1 ORDER BY id`)
+}
+
+func (db *DB) queryWebhooks(query string, args ...interface{}) ([]*models.Webhook, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []*models.Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (db *DB) UpdateWebhook(hook *models.Webhook) error {
+	now := time.Now()
+	hook.UpdatedAt = &now
+	_, err := db.conn.Exec(`UPDATE webhooks SET name=?, url=?, secret=?, events=?, is_active=?, updated_at=? WHERE id=?`,
+		hook.Name, hook.URL, hook.Secret, joinWebhookEvents(hook.Events), hook.IsActive, hook.UpdatedAt, hook.ID)
+	return err
+}
+
+func (db *DB) DeleteWebhook(id int64) error {
+	if _, err := db.conn.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	return err
+}
+
+func (db *DB) CreateWebhookDelivery(d *models.WebhookDelivery) (int64, error) {
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+	result, err := db.conn.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, delivery_id, event, payload, attempt, status_code, response_body, error, success, duration_ms, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.WebhookID, d.DeliveryID, d.Event, d.Payload, d.Attempt, d.StatusCode, d.ResponseBody, d.Error, d.Success, d.DurationMs, d.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *DB) GetWebhookDeliveries(webhookID int64, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, webhook_id, delivery_id, event, COALESCE(payload, ''), attempt, status_code, COALESCE(response_body, ''),
+		COALESCE(error, ''), success, duration_ms, created_at
+		FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		d := &models.WebhookDelivery{}
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.DeliveryID, &d.Event, &d.Payload, &d.Attempt, &d.StatusCode, &d.ResponseBody,
+			&d.Error, &d.Success, &d.DurationMs, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Prompt templates and style presets
+
+const promptTemplateColumns = `id, category, name, COALESCE(description, ''), shots, COALESCE(variables, ''), COALESCE(style, ''), created_at, updated_at`
+
+func scanPromptTemplate(row rowScanner) (*models.PromptTemplate, error) {
+	tpl := &models.PromptTemplate{}
+	var shots, variables string
+	err := row.Scan(&tpl.ID, &tpl.Category, &tpl.Name, &tpl.Description, &shots, &variables, &tpl.Style, &tpl.CreatedAt, &tpl.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(shots), &tpl.Shots); err != nil {
+		return nil, fmt.Errorf("invalid shots of template %s: %w", tpl.ID, err)
+	}
+	if variables != "" {
+		if err := json.Unmarshal([]byte(variables), &tpl.Variables); err != nil {
+			return nil, fmt.Errorf("invalid variables of template %s: %w", tpl.ID, err)
+		}
+	}
+	return tpl, nil
+}
+
+func (db *DB) GetPromptTemplate(id string) (*models.PromptTemplate, error) {
+	return scanPromptTemplate(db.conn.QueryRow(`SELECT `+promptTemplateColumns+` FROM prompt_templates WHERE id = ?`, id))
+}
+
+// GetPromptTemplates returns all templates by category and ID
+func (db *DB) GetPromptTemplates() ([]*models.PromptTemplate, error) {
+	rows, err := db.conn.Query(`SELECT ` + promptTemplateColumns + ` FROM prompt_templates ORDER BY category, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []*models.PromptTemplate
+	for rows.Next() {
+		tpl, err := scanPromptTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, tpl)
+	}
+	return templates, rows.Err()
+}
+
+// SavePromptTemplate creates the template or replaces the one with its ID
+func (db *DB) SavePromptTemplate(tpl *models.PromptTemplate) error {
+	return savePromptTemplate(db.conn, tpl)
+}
+
+func savePromptTemplate(ex execer, tpl *models.PromptTemplate) error {
+	shots, err := json.Marshal(tpl.Shots)
+	if err != nil {
+		return err
+	}
+	var variables []byte
+	if len(tpl.Variables) > 0 {
+		if variables, err = json.Marshal(tpl.Variables); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	_, err = ex.Exec(`
+		INSERT INTO prompt_templates (id, category, name, description, shots, variables, style, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET category = excluded.category, name = excluded.name, description = excluded.description,
+			shots = excluded.shots, variables = excluded.variables, style = excluded.style, updated_at = ?`,
+		tpl.ID, tpl.Category, tpl.Name, tpl.Description, string(shots), string(variables), tpl.Style, now, now)
+	return err
+}
+
+func (db *DB) DeletePromptTemplate(id string) error {
+	_, err := db.conn.Exec(`DELETE FROM prompt_templates WHERE id = ?`, id)
+	return err
+}
+
+const stylePresetColumns = `id, name, COALESCE(description, ''), COALESCE(icon, ''), prompt_suffix, created_at, updated_at`
+
+func scanStylePreset(row rowScanner) (*models.StylePreset, error) {
+	style := &models.StylePreset{}
+	err := row.Scan(&style.ID, &style.Name, &style.Description, &style.Icon, &style.PromptSuffix, &style.CreatedAt, &style.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return style, nil
+}
+
+func (db *DB) GetStylePreset(id string) (*models.StylePreset, error) {
+	return scanStylePreset(db.conn.QueryRow(`SELECT `+stylePresetColumns+` FROM style_presets WHERE id = ?`, id))
+}
+
+// GetStylePresets returns all style presets in creation order
+func (db *DB) GetStylePresets() ([]*models.StylePreset, error) {
+	rows, err := db.conn.Query(`SELECT ` + stylePresetColumns + ` FROM style_presets ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var styles []*models.StylePreset
+	for rows.Next() {
+		style, err := scanStylePreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		styles = append(styles, style)
+	}
+	return styles, rows.Err()
+}
+
+// SaveStylePreset creates the style or replaces the one with its ID
+func (db *DB) SaveStylePreset(style *models.StylePreset) error {
+	return saveStylePreset(db.conn, style)
+}
+
+func saveStylePreset(ex execer, style *models.StylePreset) error {
+	now := time.Now()
+	_, err := ex.Exec(`
+		INSERT INTO style_presets (id, name, description, icon, prompt_suffix, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET name = excluded.name, description = excluded.description, icon = excluded.icon,
+			prompt_suffix = excluded.prompt_suffix, updated_at = ?`,
+		style.ID, style.Name, style.Description, style.Icon, style.PromptSuffix, now, now)
+	return err
+}
+
+func (db *DB) DeleteStylePreset(id string) error {
+	_, err := db.conn.Exec(`DELETE FROM style_presets WHERE id = ?`, id)
+	return err
+}
+
+// SavePresets stores templates and styles in one transaction, replacing those
+// with the same IDs. With replace, all other templates and styles are deleted.
+func (db *DB) SavePresets(templates []*models.PromptTemplate, styles []*models.StylePreset, replace bool) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM prompt_templates`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM style_presets`); err != nil {
+			return err
+		}
+	}
+	for _, style := range styles {
+		if err := saveStylePreset(tx, style); err != nil {
+			return err
+		}
+	}
+	for _, tpl := range templates {
+		if err := savePromptTemplate(tx, tpl); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
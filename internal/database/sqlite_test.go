@@ -273,3 +273,294 @@ func TestDB_TaskCRUD(t *testing.T) {
 		t.Errorf("Expected status '%s', got '%s'", models.TaskStatusCompleted, updated.Status)
 	}
 }
+
+func TestDB_ProxyBindings(t *testing.T) {
+	db, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Failed to initialize schema: %v", err)
+	}
+
+	id, _ := db.CreateToken(&models.Token{Token: "bound_token", Email: "bound@example.com"})
+	if err := db.SetProxyBinding(id, "http://1.1.1.1:8080"); err != nil {
+		t.Fatalf("Failed to set binding: %v", err)
+	}
+	if err := db.SetProxyBinding(id, "http://2.2.2.2:8080"); err != nil {
+		t.Fatalf("Failed to update binding: %v", err)
+	}
+
+	bindings, err := db.GetProxyBindings()
+	if err != nil {
+		t.Fatalf("Failed to get bindings: %v", err)
+	}
+	if len(bindings) != 1 || bindings[id] != "http://2.2.2.2:8080" {
+		t.Errorf("Unexpected bindings: %v", bindings)
+	}
+
+	// Deleting the token removes its binding
+	if err := db.DeleteToken(id); err != nil {
+		t.Fatalf("Failed to delete token: %v", err)
+	}
+	bindings, _ = db.GetProxyBindings()
+	if len(bindings) != 0 {
+		t.Errorf("Expected binding to be removed with the token, got %v", bindings)
+	}
+}
+
+func TestDB_MigrateColumns(t *testing.T) {
+	db, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Tokens table as created by older versions
+	if _, err := db.conn.Exec(`CREATE TABLE tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, token TEXT NOT NULL UNIQUE, email TEXT NOT NULL, name TEXT DEFAULT '',
+		session_token TEXT, refresh_token TEXT, client_id TEXT, proxy_url TEXT, remark TEXT,
+		is_active BOOLEAN DEFAULT 1, is_expired BOOLEAN DEFAULT 0, cooled_until DATETIME,
+		image_enabled BOOLEAN DEFAULT 1, video_enabled BOOLEAN DEFAULT 1,
+		image_concurrency INTEGER DEFAULT -1, video_concurrency INTEGER DEFAULT -1, sora2_supported BOOLEAN DEFAULT 0,
+		total_image_count INTEGER DEFAULT 0, total_video_count INTEGER DEFAULT 0, total_error_count INTEGER DEFAULT 0,
+		today_image_count INTEGER DEFAULT 0, today_video_count INTEGER DEFAULT 0, today_error_count INTEGER DEFAULT 0,
+		today_date TEXT, consecutive_errors INTEGER DEFAULT 0, last_error_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP, last_used_at DATETIME)`); err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+	if _, err := db.conn.Exec(`INSERT INTO tokens (token, email) VALUES ('legacy', 'legacy@example.com')`); err != nil {
+		t.Fatalf("Failed to insert legacy token: %v", err)
+	}
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Failed to migrate schema: %v", err)
+	}
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Migration should be idempotent: %v", err)
+	}
+
+	token, err := db.GetTokenByToken("legacy")
+	if err != nil {
+		t.Fatalf("Failed to read legacy token: %v", err)
+	}
+	token.TLSProfile = "chrome_131"
+	token.UserAgent = "Mozilla/5.0 Chrome/131.0.0.0"
+	if err := db.UpdateToken(token); err != nil {
+		t.Fatalf("Failed to update token: %v", err)
+	}
+	token, _ = db.GetTokenByID(token.ID)
+	if token.TLSProfile != "chrome_131" || token.UserAgent != "Mozilla/5.0 Chrome/131.0.0.0" {
+		t.Errorf("Expected fingerprint fields to persist, got %q / %q", token.TLSProfile, token.UserAgent)
+	}
+}
+
+func TestDB_CharacterReplicas(t *testing.T) {
+	db, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Failed to initialize schema: %v", err)
+	}
+
+	charID, _ := db.CreateCharacter(&models.Character{CameoID: "cameo_1", Username: "kitty", TokenID: 1})
+	tokenID, _ := db.CreateToken(&models.Token{Token: "replica_token", Email: "replica@example.com"})
+	rep := &models.CharacterReplica{CharacterID: charID, TokenID: tokenID, CameoID: "cameo_2", Username: "kitty_2", Status: "processing"}
+	rep.ID, err = db.CreateCharacterReplica(rep)
+	if err != nil {
+		t.Fatalf("Failed to create replica: %v", err)
+	}
+	if _, err := db.CreateCharacterReplica(&models.CharacterReplica{CharacterID: charID, TokenID: tokenID, CameoID: "cameo_3"}); err == nil {
+		t.Error("Expected a second replica on the same token to be rejected")
+	}
+
+	if pending, _ := db.GetPendingCharacterReplicas(); len(pending) != 1 {
+		t.Errorf("Expected 1 pending replica, got %d", len(pending))
+	}
+	rep.Status = "finalized"
+	rep.SoraID = "ch_2"
+	if err := db.UpdateCharacterReplica(rep); err != nil {
+		t.Fatalf("Failed to update replica: %v", err)
+	}
+	if pending, _ := db.GetPendingCharacterReplicas(); len(pending) != 0 {
+		t.Errorf("Expected no pending replicas, got %d", len(pending))
+	}
+	stored, err := db.GetCharacterReplicaByID(rep.ID)
+	if err != nil || stored.SoraID != "ch_2" || stored.Status != "finalized" {
+		t.Errorf("Expected updated replica, got %+v (%v)", stored, err)
+	}
+
+	// Replicas are removed with their token or character
+	db.DeleteToken(tokenID)
+	if replicas, _ := db.GetCharacterReplicas(charID); len(replicas) != 0 {
+		t.Errorf("Expected replicas of deleted token removed, got %d", len(replicas))
+	}
+}
+
+func TestDB_StoryJobs(t *testing.T) {
+	db, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Failed to initialize schema: %v", err)
+	}
+
+	job := &models.StoryJob{JobID: "story_1", Model: "sora2-landscape", Storyboard: `{"shots":[]}`, Status: models.StoryStatusPending}
+	job.ID, err = db.CreateStoryJob(job)
+	if err != nil {
+		t.Fatalf("Failed to create story job: %v", err)
+	}
+	for i := 1; i >= 0; i-- {
+		if _, err := db.CreateStoryClip(&models.StoryClip{JobID: job.ID, Index: i, Model: "sora2-landscape-10s", Storyboard: "{}", Status: "pending"}); err != nil {
+			t.Fatalf("Failed to create clip: %v", err)
+		}
+	}
+	if _, err := db.CreateStoryClip(&models.StoryClip{JobID: job.ID, Index: 1, Model: "sora2-landscape-10s", Storyboard: "{}"}); err == nil {
+		t.Error("Expected a duplicate clip index to be rejected")
+	}
+
+	clips, err := db.GetStoryClips(job.ID)
+	if err != nil || len(clips) != 2 || clips[0].Index != 0 {
+		t.Fatalf("Expected 2 clips in order, got %+v (%v)", clips, err)
+	}
+	clips[0].TaskID = "task_1"
+	clips[0].Status = "completed"
+	if err := db.UpdateStoryClip(clips[0]); err != nil {
+		t.Fatalf("Failed to update clip: %v", err)
+	}
+
+	if unfinished, _ := db.GetUnfinishedStoryJobs(); len(unfinished) != 1 {
+		t.Errorf("Expected 1 unfinished job, got %d", len(unfinished))
+	}
+	job.Status = models.StoryStatusCompleted
+	job.ResultURL = "http://localhost/cache/stories/story_1.mp4"
+	if err := db.UpdateStoryJob(job); err != nil {
+		t.Fatalf("Failed to update story job: %v", err)
+	}
+	if unfinished, _ := db.GetUnfinishedStoryJobs(); len(unfinished) != 0 {
+		t.Errorf("Expected no unfinished jobs, got %d", len(unfinished))
+	}
+	stored, err := db.GetStoryJobByJobID("story_1")
+	if err != nil || stored.Status != models.StoryStatusCompleted || stored.ResultURL != job.ResultURL {
+		t.Errorf("Expected updated job, got %+v (%v)", stored, err)
+	}
+	if clips, _ := db.GetStoryClips(job.ID); clips[0].TaskID != "task_1" {
+		t.Errorf("Expected clip task ID, got %+v", clips[0])
+	}
+
+	if err := db.DeleteStoryJob(job.ID); err != nil {
+		t.Fatalf("Failed to delete story job: %v", err)
+	}
+	if clips, _ := db.GetStoryClips(job.ID); len(clips) != 0 {
+		t.Errorf("Expected clips deleted with the job, got %d", len(clips))
+	}
+	if _, err := db.GetStoryJobByJobID("story_1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDB_Presets(t *testing.T) {
+	db, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Failed to initialize schema: %v", err)
+	}
+
+	tpl := &models.PromptTemplate{
+		ID: "intro", Category: "character", Name: "Intro",
+		Shots:     []models.TemplateShot{{Duration: 5, Prompt: "@{character} waves"}},
+		Variables: []models.TemplateVariable{{Name: "character"}},
+		Style:     "anime",
+	}
+	if err := db.SavePromptTemplate(tpl); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+	tpl.Name = "Intro 2"
+	if err := db.SavePromptTemplate(tpl); err != nil {
+		t.Fatalf("Failed to replace template: %v", err)
+	}
+	got, err := db.GetPromptTemplate("intro")
+	if err != nil {
+		t.Fatalf("Failed to get template: %v", err)
+	}
+	if got.Name != "Intro 2" || len(got.Shots) != 1 || got.Shots[0].Prompt != "@{character} waves" ||
+		len(got.Variables) != 1 || got.Style != "anime" || got.UpdatedAt == nil {
+		t.Errorf("Unexpected template %+v", got)
+	}
+	if all, _ := db.GetPromptTemplates(); len(all) != 1 {
+		t.Errorf("Expected 1 template, got %d", len(all))
+	}
+
+	style := &models.StylePreset{ID: "anime", Name: "Anime", PromptSuffix: "anime style"}
+	if err := db.SaveStylePreset(style); err != nil {
+		t.Fatalf("Failed to save style: %v", err)
+	}
+	if got, err := db.GetStylePreset("anime"); err != nil || got.PromptSuffix != "anime style" {
+		t.Errorf("Unexpected style %+v: %v", got, err)
+	}
+
+	db.DeletePromptTemplate("intro")
+	db.DeleteStylePreset("anime")
+	if _, err := db.GetPromptTemplate("intro"); err != ErrNotFound {
+		t.Errorf("Expected deleted template, got %v", err)
+	}
+	if _, err := db.GetStylePreset("anime"); err != ErrNotFound {
+		t.Errorf("Expected deleted style, got %v", err)
+	}
+}
+
+func TestDB_SavePresetsIsAtomic(t *testing.T) {
+	db, err := NewDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("Failed to initialize schema: %v", err)
+	}
+
+	kept := &models.PromptTemplate{ID: "intro", Category: "character", Name: "Intro",
+		Shots: []models.TemplateShot{{Duration: 5, Prompt: "waves"}}}
+	db.SavePromptTemplate(kept)
+	db.SaveStylePreset(&models.StylePreset{ID: "anime", Name: "Anime", PromptSuffix: "anime style"})
+	if _, err := db.conn.Exec(`CREATE TRIGGER reject_bad BEFORE INSERT ON style_presets WHEN NEW.id = 'bad'
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+
+	// A failed replace keeps the existing presets
+	styles := []*models.StylePreset{{ID: "bad", Name: "Bad", PromptSuffix: "bad"}}
+	if err := db.SavePresets(nil, styles, true); err == nil {
+		t.Fatal("Expected the rejected style to fail the import")
+	}
+	if all, _ := db.GetPromptTemplates(); len(all) != 1 {
+		t.Errorf("Expected templates kept after the failed import, got %d", len(all))
+	}
+	if _, err := db.GetStylePreset("anime"); err != nil {
+		t.Errorf("Expected style kept after the failed import, got %v", err)
+	}
+
+	styles = []*models.StylePreset{{ID: "noir", Name: "Noir", PromptSuffix: "film noir"}}
+	if err := db.SavePresets(nil, styles, true); err != nil {
+		t.Fatalf("SavePresets failed: %v", err)
+	}
+	if all, _ := db.GetStylePresets(); len(all) != 1 || all[0].ID != "noir" {
+		t.Errorf("Expected only the imported style, got %+v", all)
+	}
+	if all, _ := db.GetPromptTemplates(); len(all) != 0 {
+		t.Errorf("Expected templates replaced, got %d", len(all))
+	}
+}
@@ -0,0 +1,89 @@
+package mocksora
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MP4FPS is the frame rate of the videos built by MP4
+const MP4FPS = 30
+
+// MP4 builds a small but structurally valid MP4 with one video track of the
+// given number of frames. The frames hold the name, so the samples of
+// different videos can be told apart after remuxing.
+func MP4(name string, frames int) []byte {
+	samples := make([][]byte, frames)
+	for i := range samples {
+		samples[i] = []byte(fmt.Sprintf("%s#%d;", name, i))
+	}
+
+	var sizes []byte
+	var payload []byte
+	for _, s := range samples {
+		sizes = binary.BigEndian.AppendUint32(sizes, uint32(len(s)))
+		payload = append(payload, s...)
+	}
+
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2avc1mp41"))
+	stbl := box("stbl",
+		fullBox("stsd", u32(1), box("avc1", make([]byte, 78))),
+		fullBox("stts", u32(1), u32(uint32(frames)), u32(1)),
+		fullBox("stsc", u32(1), u32(1), u32(uint32(frames)), u32(1)),
+		fullBox("stsz", u32(0), u32(uint32(frames)), sizes),
+		fullBox("stco", u32(1), u32(0)), // Patched below once the layout is known
+		fullBox("stss", u32(1), u32(1)),
+	)
+	trak := box("trak",
+		fullBox("tkhd", u32(0), u32(0), u32(1), u32(0), u32(uint32(frames*1000/MP4FPS)), make([]byte, 60)),
+		box("mdia",
+			fullBox("mdhd", u32(0), u32(0), u32(MP4FPS), u32(uint32(frames)), make([]byte, 4)),
+			fullBox("hdlr", u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00")),
+			box("minf", stbl),
+		),
+	)
+	moov := box("moov",
+		fullBox("mvhd", u32(0), u32(0), u32(1000), u32(uint32(frames*1000/MP4FPS)), make([]byte, 76), u32(2)),
+		trak,
+	)
+
+	// The only chunk starts right after the mdat header
+	offset := len(ftyp) + len(moov) + 8
+	stco := indexOf(moov, "stco")
+	binary.BigEndian.PutUint32(moov[stco+16:], uint32(offset))
+
+	out := append(ftyp, moov...)
+	return append(out, box("mdat", payload)...)
+}
+
+// box encodes an ISO BMFF box
+func box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	out := binary.BigEndian.AppendUint32(make([]byte, 0, size), uint32(size))
+	out = append(out, typ...)
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// fullBox encodes a box with version 0 and no flags
+func fullBox(typ string, payload ...[]byte) []byte {
+	return box(typ, append([][]byte{u32(0)}, payload...)...)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// indexOf returns the offset of the box of the given type in data
+func indexOf(data []byte, typ string) int {
+	for i := 4; i+4 <= len(data); i++ {
+		if string(data[i:i+4]) == typ {
+			return i - 4
+		}
+	}
+	return -1
+}
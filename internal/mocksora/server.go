@@ -0,0 +1,739 @@
+// Package mocksora is a fake Sora backend for integration tests and local development.
+// It implements the endpoints used by services.SoraClient with scripted progress and
+// failures: tasks advance one step each time a status endpoint reports them.
+package mocksora
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// BasePath is the prefix of the Sora backend endpoints (the client's base URL is <server>/backend)
+	BasePath = "/backend"
+	// SentinelPath is the sentinel endpoint (the client's sentinel URL is <server>/backend-api/sentinel/req)
+	SentinelPath = "/backend-api/sentinel/req"
+)
+
+// Scenario scripts how tasks whose prompt contains Match behave
+type Scenario struct {
+	Match        string `json:"match"`         // Prompt substring; empty matches every prompt
+	Polls        int    `json:"polls"`         // Status polls before the task finishes
+	Fail         string `json:"fail"`          // Finish as failed with this message instead of succeeding
+	RejectStatus int    `json:"reject_status"` // Reject task creation with this HTTP status
+	RejectBody   string `json:"reject_body"`   // Response body of a rejected creation
+}
+
+// Options configures a Server
+type Options struct {
+	Polls      int        `json:"polls"`       // Status polls before a task finishes when no scenario matches (default 3)
+	CameoPolls int        `json:"cameo_polls"` // Status polls before an uploaded cameo is ready (default 2)
+	CameoFail  string     `json:"cameo_fail"`  // Fail cameo processing with this message
+	Scenarios  []Scenario `json:"scenarios"`   // Checked in order, the first match wins
+
+	RejectSentinels int `json:"reject_sentinels"` // Reject this many sentinel tokens at task creation
+	SentinelExpiry  int `json:"sentinel_expiry"`  // expire_after of sentinel responses in seconds (default 540)
+}
+
+// LoadOptions reads Options from a JSON file
+func LoadOptions(path string) (*Options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var opts Options
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return nil, fmt.Errorf("invalid script %s: %w", path, err)
+	}
+	return &opts, nil
+}
+
+type task struct {
+	ID       string
+	Token    string
+	Kind     string // "image" or "video"
+	Prompt   string
+	Polls    int
+	Total    int
+	Fail     string
+	PostID   string
+	CameoIDs []string
+	Source   string // Generation remixed or extended by the task
+	Finished bool
+}
+
+// Upload is a cameo video upload announced by upload/init
+type Upload struct {
+	FileSize int64  // Declared size
+	FileType string // Declared content type
+	Received int64  // Bytes received by the PUT
+}
+
+type cameo struct {
+	ID          string
+	Token       string
+	UploadID    string
+	Polls       int
+	Username    string
+	DisplayName string
+	CharacterID string
+	Visibility  string
+}
+
+// Server is the fake Sora backend. It is safe for concurrent use.
+type Server struct {
+	mu      sync.Mutex
+	opts    Options
+	nextID  int
+	tasks   []*task // Newest first, like recent_tasks
+	cameos  map[string]*cameo
+	uploads map[string]*Upload
+	calls   map[string]int
+	mux     *http.ServeMux
+}
+
+// NewServer creates a fake Sora backend. A nil opts uses the defaults.
+func NewServer(opts *Options) *Server {
+	s := &Server{
+		cameos:  make(map[string]*cameo),
+		uploads: make(map[string]*Upload),
+		calls:   make(map[string]int),
+		mux:     http.NewServeMux(),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Polls <= 0 {
+		s.opts.Polls = 3
+	}
+	if s.opts.CameoPolls <= 0 {
+		s.opts.CameoPolls = 2
+	}
+	if s.opts.SentinelExpiry <= 0 {
+		s.opts.SentinelExpiry = 540
+	}
+
+	s.mux.HandleFunc("POST "+SentinelPath, s.handleSentinel)
+
+	s.mux.HandleFunc("POST "+BasePath+"/video_gen", s.handleCreate("image"))
+	s.mux.HandleFunc("POST "+BasePath+"/nf/create", s.handleCreate("video"))
+	s.mux.HandleFunc("POST "+BasePath+"/nf/create/storyboard", s.handleCreate("video"))
+	s.mux.HandleFunc("GET "+BasePath+"/v2/recent_tasks", s.handleRecentTasks)
+	s.mux.HandleFunc("GET "+BasePath+"/nf/pending/v2", s.handlePending)
+	s.mux.HandleFunc("GET "+BasePath+"/project_y/profile/drafts", s.handleDrafts)
+	s.mux.HandleFunc("POST "+BasePath+"/project_y/post", s.handlePublish)
+	s.mux.HandleFunc("DELETE "+BasePath+"/project_y/post/{id}", s.handleDeletePost)
+
+	s.mux.HandleFunc("POST "+BasePath+"/cameo/upload/init", s.handleUploadInit)
+	s.mux.HandleFunc("PUT /upload/{id}", s.handleUpload)
+	s.mux.HandleFunc("POST "+BasePath+"/cameo/create", s.handleCameoCreate)
+	s.mux.HandleFunc("GET "+BasePath+"/cameo/username/check", s.handleUsernameCheck)
+	s.mux.HandleFunc("POST "+BasePath+"/cameo/finalize", s.handleFinalize)
+	s.mux.HandleFunc("GET "+BasePath+"/cameo/search", s.handleSearch)
+	s.mux.HandleFunc("GET "+BasePath+"/cameo/mine", s.handleMine)
+	s.mux.HandleFunc("GET "+BasePath+"/cameo/{id}", s.handleCameoStatus)
+	s.mux.HandleFunc("DELETE "+BasePath+"/cameo/{id}", s.handleDeleteCameo)
+
+	// Generated media, so returned URLs resolve
+	s.mux.HandleFunc("GET /media/{file}", s.handleMedia)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.calls[r.Method+" "+r.URL.Path]++
+	s.mu.Unlock()
+	s.mux.ServeHTTP(w, r)
+}
+
+// Calls returns how many requests were made to an endpoint, e.g. "GET /backend/v2/recent_tasks"
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// RejectSentinels makes the next n task creations fail with a rejected sentinel token
+func (s *Server) RejectSentinels(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.RejectSentinels = n
+}
+
+// TaskCameos returns the cameo IDs a task was created with
+func (s *Server) TaskCameos(taskID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.ID == taskID {
+			return t.CameoIDs
+		}
+	}
+	return nil
+}
+
+// TaskPrompt returns the prompt a task was created with
+func (s *Server) TaskPrompt(taskID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.ID == taskID {
+			return t.Prompt
+		}
+	}
+	return ""
+}
+
+// TaskSource returns the generation a task remixes or extends: its remix
+// target, or the generation inpaint item of an extension
+func (s *Server) TaskSource(taskID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.ID == taskID {
+			return t.Source
+		}
+	}
+	return ""
+}
+
+// AddScenario appends a scenario, checked after the existing ones
+func (s *Server) AddScenario(sc Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.Scenarios = append(s.opts.Scenarios, sc)
+}
+
+// scenario returns the scenario for a prompt; the caller holds s.mu
+func (s *Server) scenario(prompt string) Scenario {
+	for _, sc := range s.opts.Scenarios {
+		if sc.Match == "" || strings.Contains(prompt, sc.Match) {
+			if sc.Polls <= 0 {
+				sc.Polls = s.opts.Polls
+			}
+			return sc
+		}
+	}
+	return Scenario{Polls: s.opts.Polls}
+}
+
+// newID returns a new ID with the prefix; the caller holds s.mu
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s_mock%d", prefix, s.nextID)
+}
+
+// bearer returns the access token of the request, or writes a 401
+func bearer(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"error": map[string]string{"message": "Missing bearer token", "code": "token_invalid"},
+		})
+		return "", false
+	}
+	return token, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(r *http.Request) map[string]interface{} {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+	return body
+}
+
+// serverURL returns the URL of this server as seen by the client
+func serverURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// mediaURL returns the URL of a generated file on this server
+func mediaURL(r *http.Request, file string) string {
+	return serverURL(r) + "/media/" + file
+}
+
+func (s *Server) handleSentinel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	expiry := s.opts.SentinelExpiry
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token":        "mock_sentinel",
+		"expire_after": expiry,
+		"proofofwork":  map[string]interface{}{"required": false},
+		"turnstile":    map[string]interface{}{"dx": ""},
+	})
+}
+
+func (s *Server) handleCreate(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearer(w, r)
+		if !ok {
+			return
+		}
+		if r.Header.Get("openai-sentinel-token") == "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"detail": "Missing sentinel token"})
+			return
+		}
+		body := readJSON(r)
+		prompt, _ := body["prompt"].(string)
+		var cameoIDs []string
+		if ids, ok := body["cameo_ids"].([]interface{}); ok {
+			for _, id := range ids {
+				if id, ok := id.(string); ok {
+					cameoIDs = append(cameoIDs, id)
+				}
+			}
+		}
+		source, _ := body["remix_target_id"].(string)
+		if items, ok := body["inpaint_items"].([]interface{}); ok {
+			for _, item := range items {
+				if item, ok := item.(map[string]interface{}); ok && item["kind"] == "generation" {
+					source, _ = item["generation_id"].(string)
+				}
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.opts.RejectSentinels > 0 {
+			s.opts.RejectSentinels--
+			writeJSON(w, http.StatusForbidden, map[string]string{"detail": "Invalid openai-sentinel-token"})
+			return
+		}
+		sc := s.scenario(prompt)
+		if sc.RejectStatus != 0 {
+			body := sc.RejectBody
+			if body == "" {
+				body = `{"error":{"message":"rejected by mock scenario"}}`
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(sc.RejectStatus)
+			w.Write([]byte(body))
+			return
+		}
+
+		t := &task{ID: s.newID("task"), Token: token, Kind: kind, Prompt: prompt, Total: sc.Polls, Fail: sc.Fail, CameoIDs: cameoIDs, Source: source}
+		s.tasks = append([]*task{t}, s.tasks...)
+		writeJSON(w, http.StatusOK, map[string]string{"id": t.ID})
+	}
+}
+
+// advance moves the token's unfinished tasks one poll forward; the caller holds s.mu
+func (s *Server) advance(token string) {
+	for _, t := range s.tasks {
+		if t.Token == token && !t.Finished {
+			t.Polls++
+			if t.Polls >= t.Total {
+				t.Finished = true
+			}
+		}
+	}
+}
+
+// taskJSON renders a task in the recent_tasks format
+func taskJSON(r *http.Request, t *task) map[string]interface{} {
+	out := map[string]interface{}{
+		"id":           t.ID,
+		"prompt":       t.Prompt,
+		"status":       "running",
+		"progress_pct": float64(t.Polls) / float64(t.Total),
+		"generations":  []interface{}{},
+	}
+	switch {
+	case !t.Finished:
+	case t.Fail != "":
+		out["status"] = "failed"
+		out["error_message"] = t.Fail
+	default:
+		out["status"] = "succeeded"
+		out["progress_pct"] = 1.0
+		ext := "png"
+		if t.Kind == "video" {
+			ext = "mp4"
+		}
+		out["generations"] = []interface{}{
+			map[string]interface{}{"id": "gen_" + t.ID, "url": mediaURL(r, t.ID+"."+ext)},
+		}
+	}
+	return out
+}
+
+func (s *Server) handleRecentTasks(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance(token)
+	tasks := []interface{}{}
+	for _, t := range s.tasks {
+		if t.Token == token {
+			tasks = append(tasks, taskJSON(r, t))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"task_responses": tasks})
+}
+
+func (s *Server) handlePending(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance(token)
+	tasks := []interface{}{}
+	for _, t := range s.tasks {
+		if t.Token == token && !t.Finished {
+			tasks = append(tasks, map[string]interface{}{
+				"id":           t.ID,
+				"progress_pct": float64(t.Polls) / float64(t.Total),
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": tasks})
+}
+
+func (s *Server) handleDrafts(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drafts := []interface{}{}
+	for _, t := range s.tasks {
+		if t.Token != token || t.Kind != "video" || !t.Finished {
+			continue
+		}
+		draft := map[string]interface{}{"id": t.ID, "status": "succeeded"}
+		if t.Fail != "" {
+			draft["status"] = "failed"
+		} else {
+			draft["media"] = map[string]interface{}{
+				"url":           mediaURL(r, t.ID+".mp4"),
+				"thumbnail_url": mediaURL(r, t.ID+".jpg"),
+			}
+		}
+		drafts = append(drafts, draft)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"drafts": drafts})
+}
+
+func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	draftID, _ := readJSON(r)["draft_id"].(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.ID == draftID && t.Token == token && t.Finished && t.Fail == "" {
+			if t.PostID == "" {
+				t.PostID = s.newID("s")
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"id":    t.PostID,
+				"media": map[string]interface{}{"url": mediaURL(r, t.PostID+".mp4")},
+			})
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Draft not found"})
+}
+
+func (s *Server) handleDeletePost(w http.ResponseWriter, r *http.Request) {
+	if _, ok := bearer(w, r); !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.PostID != "" && t.PostID == r.PathValue("id") {
+			t.PostID = ""
+			writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Post not found"})
+}
+
+func (s *Server) handleUploadInit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := bearer(w, r); !ok {
+		return
+	}
+	body := readJSON(r)
+	size, _ := body["file_size"].(float64)
+	fileType, _ := body["file_type"].(string)
+
+	s.mu.Lock()
+	id := s.newID("upload")
+	s.uploads[id] = &Upload{FileSize: int64(size), FileType: fileType}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"upload_id":  id,
+		"upload_url": serverURL(r) + "/upload/" + id,
+	})
+}
+
+// handleUpload receives the video; its size and type must match the ones announced
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	size, _ := io.Copy(io.Discard, r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if u.FileSize != size || (u.FileType != "" && r.Header.Get("Content-Type") != u.FileType) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": fmt.Sprintf(
+			"Upload does not match init: %d bytes of %s, expected %d bytes of %s",
+			size, r.Header.Get("Content-Type"), u.FileSize, u.FileType)})
+		return
+	}
+	u.Received = size
+	w.WriteHeader(http.StatusOK)
+}
+
+// CameoUpload returns the upload a cameo was created from
+func (s *Server) CameoUpload(cameoID string) (Upload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.findCameo(cameoID)
+	if c == nil || s.uploads[c.UploadID] == nil {
+		return Upload{}, false
+	}
+	return *s.uploads[c.UploadID], true
+}
+
+// EditCameo changes a finalized cameo as if its owner edited it on Sora
+func (s *Server) EditCameo(id, displayName, visibility string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.findCameo(id)
+	if c == nil {
+		return false
+	}
+	c.DisplayName = displayName
+	c.Visibility = visibility
+	return true
+}
+
+func (s *Server) handleCameoCreate(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	uploadID, _ := readJSON(r)["upload_id"].(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.uploads[uploadID]; !ok || u.Received == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "Upload not found or empty"})
+		return
+	}
+	c := &cameo{ID: s.newID("cameo"), Token: token, UploadID: uploadID}
+	s.cameos[c.ID] = c
+	writeJSON(w, http.StatusOK, map[string]string{"cameo_id": c.ID})
+}
+
+// cameoStatus returns the processing status of a cameo; the caller holds s.mu
+func (s *Server) cameoStatus(c *cameo) string {
+	switch {
+	case c.CharacterID != "":
+		return "finalized"
+	case c.Polls < s.opts.CameoPolls:
+		return "processing"
+	case s.opts.CameoFail != "":
+		return "failed"
+	}
+	return "ready"
+}
+
+// cameoJSON renders a cameo; the caller holds s.mu
+func (s *Server) cameoJSON(r *http.Request, c *cameo) map[string]interface{} {
+	out := map[string]interface{}{
+		"id":           c.ID,
+		"cameo_id":     c.ID,
+		"character_id": c.CharacterID,
+		"username":     c.Username,
+		"display_name": c.DisplayName,
+		"visibility":   c.Visibility,
+		"status":       s.cameoStatus(c),
+	}
+	if out["status"] == "failed" {
+		out["error_message"] = s.opts.CameoFail
+	}
+	if c.Polls >= s.opts.CameoPolls {
+		out["profile_url"] = mediaURL(r, c.ID+".jpg")
+	}
+	return out
+}
+
+func (s *Server) findCameo(id string) *cameo {
+	if c, ok := s.cameos[id]; ok {
+		return c
+	}
+	for _, c := range s.cameos {
+		if c.CharacterID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleCameoStatus(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.findCameo(r.PathValue("id"))
+	if c == nil || c.Token != token {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Cameo not found"})
+		return
+	}
+	c.Polls++
+	writeJSON(w, http.StatusOK, s.cameoJSON(r, c))
+}
+
+func (s *Server) handleUsernameCheck(w http.ResponseWriter, r *http.Request) {
+	if _, ok := bearer(w, r); !ok {
+		return
+	}
+	username := r.URL.Query().Get("username")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	available := username != ""
+	for _, c := range s.cameos {
+		if strings.EqualFold(c.Username, username) {
+			available = false
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"available": available})
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	body := readJSON(r)
+	cameoID, _ := body["cameo_id"].(string)
+	username, _ := body["username"].(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.cameos[cameoID]
+	if c == nil || c.Token != token {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Cameo not found"})
+		return
+	}
+	if status := s.cameoStatus(c); status != "ready" {
+		writeJSON(w, http.StatusConflict, map[string]string{"detail": "Cameo is " + status})
+		return
+	}
+	for _, other := range s.cameos {
+		if other != c && strings.EqualFold(other.Username, username) {
+			writeJSON(w, http.StatusConflict, map[string]string{"detail": "Username is taken"})
+			return
+		}
+	}
+	c.Username = username
+	c.DisplayName, _ = body["display_name"].(string)
+	c.Visibility, _ = body["visibility"].(string)
+	c.CharacterID = s.newID("ch")
+	writeJSON(w, http.StatusOK, map[string]string{
+		"character_id": c.CharacterID,
+		"profile_url":  mediaURL(r, c.ID+".jpg"),
+	})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if _, ok := bearer(w, r); !ok {
+		return
+	}
+	q := strings.ToLower(r.URL.Query().Get("q"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := []interface{}{}
+	for _, c := range s.cameos {
+		if c.CharacterID != "" && c.Visibility == "public" && strings.Contains(strings.ToLower(c.Username), q) {
+			results = append(results, s.cameoJSON(r, c))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"characters": results})
+}
+
+func (s *Server) handleMine(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mine := []interface{}{}
+	for _, c := range s.cameos {
+		if c.Token == token {
+			mine = append(mine, s.cameoJSON(r, c))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"characters": mine})
+}
+
+func (s *Server) handleDeleteCameo(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearer(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.findCameo(r.PathValue("id"))
+	if c == nil || c.Token != token {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Cameo not found"})
+		return
+	}
+	delete(s.cameos, c.ID)
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	switch {
+	case strings.HasSuffix(file, ".mp4"):
+		// Real MP4s, so downloaded videos can be remuxed
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(MP4(file, MP4FPS))
+		return
+	case strings.HasSuffix(file, ".png"):
+		w.Header().Set("Content-Type", "image/png")
+	default:
+		w.Header().Set("Content-Type", "image/jpeg")
+	}
+	w.Write([]byte("mock media " + file))
+}
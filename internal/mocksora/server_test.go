@@ -0,0 +1,91 @@
+package mocksora
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func do(t *testing.T, method, url string, body interface{}) map[string]interface{} {
+	t.Helper()
+	var reader *bytes.Reader
+	if b, ok := body.([]byte); ok {
+		reader = bytes.NewReader(b)
+	} else {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, _ := http.NewRequest(method, url, reader)
+	req.Header.Set("Authorization", "Bearer at_test")
+	req.Header.Set("openai-sentinel-token", "sentinel")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+	out["_status"] = resp.StatusCode
+	return out
+}
+
+func TestServer_TaskProgress(t *testing.T) {
+	server := httptest.NewServer(NewServer(&Options{Polls: 2}))
+	defer server.Close()
+	base := server.URL + BasePath
+
+	created := do(t, "POST", base+"/nf/create", map[string]string{"prompt": "a cat"})
+	id, _ := created["id"].(string)
+	if id == "" {
+		t.Fatalf("Expected task ID, got %v", created)
+	}
+
+	first := do(t, "GET", base+"/v2/recent_tasks?limit=20", nil)["task_responses"].([]interface{})[0].(map[string]interface{})
+	if first["status"] != "running" || first["progress_pct"] != 0.5 {
+		t.Errorf("Expected task half done after one poll, got %v", first)
+	}
+	second := do(t, "GET", base+"/v2/recent_tasks?limit=20", nil)["task_responses"].([]interface{})[0].(map[string]interface{})
+	if second["status"] != "succeeded" || len(second["generations"].([]interface{})) != 1 {
+		t.Errorf("Expected task to succeed after two polls, got %v", second)
+	}
+
+	drafts := do(t, "GET", base+"/project_y/profile/drafts?limit=20", nil)["drafts"].([]interface{})
+	if len(drafts) != 1 {
+		t.Errorf("Expected finished video in drafts, got %v", drafts)
+	}
+}
+
+func TestServer_CameoLifecycle(t *testing.T) {
+	server := httptest.NewServer(NewServer(&Options{CameoPolls: 1}))
+	defer server.Close()
+	base := server.URL + BasePath
+
+	upload := do(t, "POST", base+"/cameo/upload/init", map[string]int{"file_size": 4})
+	do(t, "PUT", upload["upload_url"].(string), []byte("mp4!"))
+	cameoID, _ := do(t, "POST", base+"/cameo/create", map[string]string{"upload_id": upload["upload_id"].(string)})["cameo_id"].(string)
+	if cameoID == "" {
+		t.Fatal("Expected cameo ID")
+	}
+
+	if status := do(t, "GET", base+"/cameo/"+cameoID, nil)["status"]; status != "ready" {
+		t.Errorf("Expected cameo ready after one poll, got %v", status)
+	}
+	finalized := do(t, "POST", base+"/cameo/finalize", map[string]string{"cameo_id": cameoID, "username": "kitty", "visibility": "public"})
+	if finalized["character_id"] == "" || finalized["_status"] != 200 {
+		t.Fatalf("Finalize failed: %v", finalized)
+	}
+	if available := do(t, "GET", base+"/cameo/username/check?username=kitty", nil)["available"]; available != false {
+		t.Error("Expected username to be taken")
+	}
+	if results := do(t, "GET", base+"/cameo/search?q=kit", nil)["characters"].([]interface{}); len(results) != 1 {
+		t.Errorf("Expected 1 search result, got %d", len(results))
+	}
+	if deleted := do(t, "DELETE", base+"/cameo/"+cameoID, nil); deleted["_status"] != 200 {
+		t.Errorf("Delete failed: %v", deleted)
+	}
+}
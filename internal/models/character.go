@@ -13,8 +13,10 @@ const (
 // Character status constants
 const (
 	CharacterStatusProcessing = "processing"
+	CharacterStatusReady      = "ready" // Cameo processed, waiting to be finalized
 	CharacterStatusFinalized  = "finalized"
 	CharacterStatusFailed     = "failed"
+	CharacterStatusOrphaned   = "orphaned" // Cameo no longer exists on Sora
 )
 
 // Character represents a Sora character (cameo) for consistent character generation
@@ -28,13 +30,35 @@ type Character struct {
 	InstructionSet       string     `db:"instruction_set" json:"instruction_set"`              // Character description/instructions
 	SafetyInstructionSet string     `db:"safety_instruction_set" json:"safety_instruction_set"` // Safety instructions
 	Visibility           string     `db:"visibility" json:"visibility"`                        // private or public
-	Status               string     `db:"status" json:"status"`                                // processing, finalized, failed
+	Status               string     `db:"status" json:"status"`                                // processing, ready, finalized, failed
 	TokenID              int64      `db:"token_id" json:"token_id"`                            // Associated token ID
 	ErrorMessage         string     `db:"error_message" json:"error_message,omitempty"`        // Error message if failed
+	AutoFinalize         bool       `db:"auto_finalize" json:"auto_finalize"`                  // Finalize with the stored settings once the cameo is ready
+	Timestamps           string     `db:"timestamps" json:"timestamps,omitempty"`              // Clip range used for the cameo, e.g. "0-5"
+	SourceFile           string     `db:"source_file" json:"source_file,omitempty"`            // Archived source clip, relative to the archive
+	SourceType           string     `db:"source_type" json:"source_type,omitempty"`            // Content type of the source clip
+	SourceSize           int64      `db:"source_size" json:"source_size,omitempty"`            // Size of the source clip in bytes
+	ReplacedCameoID      string     `db:"replaced_cameo_id" json:"replaced_cameo_id,omitempty"` // Previous cameo of a recreated character, deleted before finalizing
+	ReplacedTokenID      int64      `db:"replaced_token_id" json:"replaced_token_id,omitempty"` // Token holding the previous cameo
 	CreatedAt            time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt            *time.Time `db:"updated_at" json:"updated_at,omitempty"`
 }
 
+// CharacterReplica is a copy of a character on another token, so generations
+// using the character can run on any token holding a copy
+type CharacterReplica struct {
+	ID           int64      `db:"id" json:"id"`
+	CharacterID  int64      `db:"character_id" json:"character_id"`                // Local character ID
+	TokenID      int64      `db:"token_id" json:"token_id"`                        // Token holding the copy
+	CameoID      string     `db:"cameo_id" json:"cameo_id"`                        // Cameo ID on that token
+	SoraID       string     `db:"sora_character_id" json:"sora_character_id"`      // Sora's character ID after finalization
+	Username     string     `db:"username" json:"username"`                        // Username of the copy (Sora usernames are unique)
+	Status       string     `db:"status" json:"status"`                            // processing, ready, finalized, failed
+	ErrorMessage string     `db:"error_message" json:"error_message,omitempty"`    // Error message if failed
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
+
 // CameoUploadResponse represents the response from uploading a character video
 type CameoUploadResponse struct {
 	CameoID    string `json:"cameo_id"`
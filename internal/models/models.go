@@ -12,6 +12,13 @@ const (
 	TaskStatusCancelled  = "cancelled"
 )
 
+// Task operation constants: how a task relates to its parent task
+const (
+	TaskOperationGenerate = "generate" // New generation, no parent
+	TaskOperationRemix    = "remix"    // Remix of the parent video
+	TaskOperationExtend   = "extend"   // Continuation of the parent video
+)
+
 // Token represents a Sora API token
 type Token struct {
 	ID               int64      `db:"id" json:"id"`
@@ -22,6 +29,8 @@ type Token struct {
 	RefreshToken     string     `db:"refresh_token" json:"refresh_token,omitempty"`
 	ClientID         string     `db:"client_id" json:"client_id,omitempty"`
 	ProxyURL         string     `db:"proxy_url" json:"proxy_url,omitempty"`
+	TLSProfile       string     `db:"tls_profile" json:"tls_profile,omitempty"` // Overrides the configured TLS client profile
+	UserAgent        string     `db:"user_agent" json:"user_agent,omitempty"`   // Browser UA matching TLSProfile
 	Remark           string     `db:"remark" json:"remark,omitempty"`
 
 	// Status
@@ -120,6 +129,9 @@ type Task struct {
 	RetryCount   int        `db:"retry_count" json:"retry_count"`
 	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
 	CompletedAt  *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+	GenerationID string     `db:"generation_id" json:"generation_id,omitempty"`   // Sora generation of the result, used as remix target
+	ParentTaskID string     `db:"parent_task_id" json:"parent_task_id,omitempty"` // Task this one remixes or extends
+	Operation    string     `db:"operation" json:"operation,omitempty"`           // generate, remix or extend
 }
 
 // RequestLog represents a request log entry
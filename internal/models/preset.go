@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// TemplateShot is one shot of a prompt template; the prompt may hold
+// {variable} placeholders, e.g. "@{character} 开心大笑"
+type TemplateShot struct {
+	Duration   float64  `json:"duration"` // Seconds
+	Prompt     string   `json:"prompt"`
+	Characters []string `json:"characters,omitempty"` // Usernames, placeholders allowed
+}
+
+// TemplateVariable is a placeholder of a prompt template
+type TemplateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"` // Used when the request gives no value; empty means required
+}
+
+// PromptTemplate is a reusable storyboard expanded by the server
+type PromptTemplate struct {
+	ID          string             `db:"id" json:"id"` // Slug, e.g. cinematic-opening
+	Category    string             `db:"category" json:"category"`
+	Name        string             `db:"name" json:"name"`
+	Description string             `db:"description" json:"description,omitempty"`
+	Shots       []TemplateShot     `db:"shots" json:"shots"`                   // Stored as JSON
+	Variables   []TemplateVariable `db:"variables" json:"variables,omitempty"` // Stored as JSON
+	Style       string             `db:"style" json:"style,omitempty"`         // Default style preset
+	CreatedAt   time.Time          `db:"created_at" json:"created_at"`
+	UpdatedAt   *time.Time         `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+// StylePreset is a visual style appended to prompts
+type StylePreset struct {
+	ID           string     `db:"id" json:"id"` // Slug, e.g. anime
+	Name         string     `db:"name" json:"name"`
+	Description  string     `db:"description" json:"description,omitempty"`
+	Icon         string     `db:"icon" json:"icon,omitempty"`
+	PromptSuffix string     `db:"prompt_suffix" json:"prompt_suffix"` // Appended as ", <suffix>"
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// Story job status constants
+const (
+	StoryStatusPending    = "pending"    // Clips not started yet
+	StoryStatusRendering  = "rendering"  // Clips being generated
+	StoryStatusConcatting = "concatting" // Clips being downloaded and joined
+	StoryStatusCompleted  = "completed"
+	StoryStatusFailed     = "failed"
+)
+
+// Story clip status constants
+const (
+	StoryClipPending    = "pending"
+	StoryClipProcessing = "processing" // Task created or being created
+	StoryClipCompleted  = "completed"
+	StoryClipFailed     = "failed"
+)
+
+// StoryJob is a long storyboard rendered as several Sora clips joined into one video
+type StoryJob struct {
+	ID           int64      `db:"id" json:"id"`
+	JobID        string     `db:"job_id" json:"job_id"` // Public ID, story_...
+	Model        string     `db:"model" json:"model"`   // Model the clip models are derived from
+	Title        string     `db:"title" json:"title,omitempty"`
+	Storyboard   string     `db:"storyboard" json:"-"` // JSON of the requested storyboard
+	Status       string     `db:"status" json:"status"`
+	Progress     float64    `db:"progress" json:"progress"`
+	ResultURL    string     `db:"result_url" json:"result_url,omitempty"`   // Joined video in the cache
+	ResultFile   string     `db:"result_file" json:"result_file,omitempty"` // Cache file name
+	ErrorMessage string     `db:"error_message" json:"error_message,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	CompletedAt  *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+
+	Clips []*StoryClip `json:"clips,omitempty"` // Not in the story_jobs table
+}
+
+// StoryClip is one Sora generation of a story job
+type StoryClip struct {
+	ID           int64      `db:"id" json:"id"`
+	JobID        int64      `db:"job_id" json:"-"`                        // Local story job ID
+	Index        int        `db:"clip_index" json:"index"`                // Position in the joined video, from 0
+	Model        string     `db:"model" json:"model"`                     // Model matching the clip duration
+	Storyboard   string     `db:"storyboard" json:"-"`                    // JSON of the shots of the clip
+	TaskID       string     `db:"task_id" json:"task_id,omitempty"`       // Child task, set once generated
+	Status       string     `db:"status" json:"status"`                   // pending, processing, completed, failed
+	ResultURL    string     `db:"result_url" json:"result_url,omitempty"` // Sora video URL
+	ErrorMessage string     `db:"error_message" json:"error_message,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+)
+
+// Webhook event constants
+const (
+	WebhookEventTaskCompleted   = "task.completed"
+	WebhookEventTaskFailed      = "task.failed"
+	WebhookEventTaskCancelled   = "task.cancelled"
+	WebhookEventTokenDisabled   = "token.disabled"
+	WebhookEventTokenExpired    = "token.expired"
+	WebhookEventTokenCooled     = "token.cooled"
+	WebhookEventQuotaExhausted  = "token.quota_exhausted"
+	WebhookEventCharacterReady  = "character.ready"
+	WebhookEventCharacterFailed = "character.failed"
+	WebhookEventTest            = "webhook.test"
+)
+
+// WebhookEvents lists all events a webhook can subscribe to
+var WebhookEvents = []string{
+	WebhookEventTaskCompleted,
+	WebhookEventTaskFailed,
+	WebhookEventTaskCancelled,
+	WebhookEventTokenDisabled,
+	WebhookEventTokenExpired,
+	WebhookEventTokenCooled,
+	WebhookEventQuotaExhausted,
+	WebhookEventCharacterReady,
+	WebhookEventCharacterFailed,
+}
+
+// IsValidWebhookEvent checks if the event name is a known webhook event
+func IsValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook represents an outbound webhook subscription
+type Webhook struct {
+	ID        int64      `db:"id" json:"id"`
+	Name      string     `db:"name" json:"name"`
+	URL       string     `db:"url" json:"url"`
+	Secret    string     `db:"secret" json:"secret,omitempty"` // HMAC-SHA256 signing secret
+	Events    []string   `db:"events" json:"events"`           // Empty means all events
+	IsActive  bool       `db:"is_active" json:"is_active"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+// Accepts reports whether the webhook subscribes to the given event
+func (w *Webhook) Accepts(event string) bool {
+	if event == WebhookEventTest {
+		return true
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery represents a single delivery attempt of a webhook event
+type WebhookDelivery struct {
+	ID           int64     `db:"id" json:"id"`
+	WebhookID    int64     `db:"webhook_id" json:"webhook_id"`
+	DeliveryID   string    `db:"delivery_id" json:"delivery_id"` // Shared by all attempts of one event
+	Event        string    `db:"event" json:"event"`
+	Payload      string    `db:"payload" json:"payload"`
+	Attempt      int       `db:"attempt" json:"attempt"`
+	StatusCode   int       `db:"status_code" json:"status_code"`
+	ResponseBody string    `db:"response_body" json:"response_body,omitempty"`
+	Error        string    `db:"error" json:"error,omitempty"`
+	Success      bool      `db:"success" json:"success"`
+	DurationMs   int64     `db:"duration_ms" json:"duration_ms"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -67,7 +68,52 @@ func (c *FileCache) Save(filename string, content []byte) (string, error) {
 
 	c.files[filename] = time.Now()
 
-	return c.GetURL(filename), nil
+	return c.urlFor(filename), nil
+}
+
+// SaveFrom streams content to the cache and returns the number of bytes written.
+// The content is copied without holding the cache lock, so slow readers such as
+// joined stories do not block the cache; only the final rename is locked.
+func (c *FileCache) SaveFrom(filename string, r io.Reader) (int64, error) {
+	filePath := filepath.Join(c.baseDir, filename)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write to a temporary file first so a failed copy leaves no partial file
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".partial-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to write file: %w", err)
+	}
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("failed to write file: %w", err)
+	}
+	c.files[filename] = time.Now()
+	return written, nil
+}
+
+// Open opens a cached file for reading
+func (c *FileCache) Open(filename string) (*os.File, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	file, err := os.Open(filepath.Join(c.baseDir, filename))
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %w", err)
+	}
+	return file, nil
 }
 
 // Get retrieves content from cache
@@ -100,9 +146,24 @@ func (c *FileCache) Delete(filename string) error {
 
 // GetURL returns the URL for a cached file
 func (c *FileCache) GetURL(filename string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.urlFor(filename)
+}
+
+// urlFor builds the URL of a cached file; the caller holds the lock
+func (c *FileCache) urlFor(filename string) string {
 	return fmt.Sprintf("%s/cache/%s", c.baseURL, filename)
 }
 
+// Configure updates the expiry timeout and public base URL
+func (c *FileCache) Configure(timeout int, baseURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = timeout
+	c.baseURL = baseURL
+}
+
 // Exists checks if a file exists in cache
 func (c *FileCache) Exists(filename string) bool {
 	c.mu.RLock()
@@ -1,8 +1,10 @@
 package services
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -152,3 +154,68 @@ func TestFileCache_List(t *testing.T) {
 		t.Errorf("Expected 3 files, got %d", len(files))
 	}
 }
+
+func TestFileCache_SaveFromAndOpen(t *testing.T) {
+	tmpDir := t.TempDir()
+	cache := NewFileCache(tmpDir, 600, "http://localhost:8000")
+
+	written, err := cache.SaveFrom("sources/clip.mp4", strings.NewReader("streamed content"))
+	if err != nil {
+		t.Fatalf("Failed to save file: %v", err)
+	}
+	if written != int64(len("streamed content")) || !cache.Exists("sources/clip.mp4") {
+		t.Errorf("Expected %d bytes cached, got %d", len("streamed content"), written)
+	}
+
+	file, err := cache.Open("sources/clip.mp4")
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer file.Close()
+	if content, _ := io.ReadAll(file); string(content) != "streamed content" {
+		t.Errorf("Expected streamed content, got %q", content)
+	}
+
+	// No partial files are left behind
+	entries, _ := os.ReadDir(filepath.Join(tmpDir, "sources"))
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 file in the directory, got %d", len(entries))
+	}
+}
+
+func TestFileCache_SaveFromDoesNotBlockCache(t *testing.T) {
+	cache := NewFileCache(t.TempDir(), 600, "http://localhost:8000")
+
+	pr, pw := io.Pipe()
+	saved := make(chan error, 1)
+	go func() {
+		_, err := cache.SaveFrom("stories/slow.mp4", pr)
+		saved <- err
+	}()
+	pw.Write([]byte("first part"))
+
+	// The cache serves other files while the stream is still being copied
+	done := make(chan struct{})
+	go func() {
+		cache.Save("other.png", []byte("image"))
+		cache.Get("other.png")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the cache usable during SaveFrom")
+	}
+	if cache.Exists("stories/slow.mp4") {
+		t.Error("Expected the streamed file hidden until it is complete")
+	}
+
+	pw.Write([]byte(", second part"))
+	pw.Close()
+	if err := <-saved; err != nil {
+		t.Fatalf("SaveFrom failed: %v", err)
+	}
+	if content, _ := cache.Get("stories/slow.mp4"); string(content) != "first part, second part" {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
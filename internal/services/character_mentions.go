@@ -0,0 +1,224 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// mentionPattern matches @username not preceded by a word character, so
+// e-mail addresses are not taken for mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.@])@([A-Za-z0-9_]+(?:\.[A-Za-z0-9_]+)*)`)
+
+// ParseMentions returns the @mentioned usernames of a prompt in order, without duplicates
+func ParseMentions(prompt string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(prompt, -1) {
+		key := strings.ToLower(m[1])
+		if !seen[key] {
+			seen[key] = true
+			usernames = append(usernames, m[1])
+		}
+	}
+	return usernames
+}
+
+// CharacterMentions are the characters @mentioned in a prompt
+type CharacterMentions struct {
+	CameoIDs   []string
+	TokenIDs   []int64  // Tokens holding every private character, empty if there are none
+	Unresolved []string // Mentions not found locally, to look up as public characters
+
+	// Per private character, its cameo on each token holding it
+	private []privateMention
+	// Mentioned usernames (lower case) to rewrite, as replicas have their own usernames
+	renames map[string]string
+}
+
+// privateMention is a mentioned private character and its cameos by token
+type privateMention struct {
+	username string
+	cameos   map[int64]characterCameo
+}
+
+// characterCameo is a character on one token: its own cameo or a replica
+type characterCameo struct {
+	cameoID  string
+	username string
+}
+
+// HasPrivate reports whether the job must run on one of TokenIDs
+func (m *CharacterMentions) HasPrivate() bool {
+	return len(m.private) > 0
+}
+
+// useToken adds the cameo IDs of the private characters on the chosen token;
+// mentions of characters held as a replica are renamed to the replica username
+func (m *CharacterMentions) useToken(tokenID int64) {
+	for _, p := range m.private {
+		cameo := p.cameos[tokenID]
+		m.CameoIDs = append(m.CameoIDs, cameo.cameoID)
+		if !strings.EqualFold(cameo.username, p.username) {
+			if m.renames == nil {
+				m.renames = make(map[string]string)
+			}
+			m.renames[strings.ToLower(p.username)] = cameo.username
+		}
+	}
+}
+
+// rewrite renames the mentions of replicated characters in a prompt
+func (m *CharacterMentions) rewrite(text string) string {
+	if m == nil || len(m.renames) == 0 {
+		return text
+	}
+	return mentionPattern.ReplaceAllStringFunc(text, func(match string) string {
+		// The character before the mention is never an @
+		i := strings.LastIndex(match, "@")
+		if username, ok := m.renames[strings.ToLower(match[i+1:])]; ok {
+			return match[:i+1] + username
+		}
+		return match
+	})
+}
+
+// rewriteStoryboard returns a copy of the storyboard with the mentions and
+// characters of its shots renamed
+func (m *CharacterMentions) rewriteStoryboard(sb *Storyboard) *Storyboard {
+	if m == nil || len(m.renames) == 0 {
+		return sb
+	}
+	renamed := *sb
+	renamed.Instructions = m.rewrite(sb.Instructions)
+	renamed.Shots = make([]StoryboardShot, len(sb.Shots))
+	for i, shot := range sb.Shots {
+		shot.Prompt = m.rewrite(shot.Prompt)
+		characters := make([]string, len(shot.Characters))
+		for j, username := range shot.Characters {
+			characters[j] = username
+			if replica, ok := m.renames[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))]; ok {
+				characters[j] = replica
+			}
+		}
+		shot.Characters = characters
+		renamed.Shots[i] = shot
+	}
+	return &renamed
+}
+
+// resolveLocalMentions looks the mentions up in the characters table. A private
+// character can only be used on its token or a token holding a replica, so
+// the job runs on a token holding all of them.
+func (h *GenerationHandler) resolveLocalMentions(usernames []string) (*CharacterMentions, error) {
+	mentions := &CharacterMentions{}
+	var common map[int64]bool
+	first := ""
+	for _, username := range usernames {
+		char, err := h.db.GetCharacterByUsername(username)
+		if errors.Is(err, database.ErrNotFound) {
+			mentions.Unresolved = append(mentions.Unresolved, username)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("查询角色 @%s 失败: %v", username, err)
+		}
+		if char.Status != models.CharacterStatusFinalized {
+			return nil, fmt.Errorf("角色 @%s 尚未完成处理，当前状态: %s", username, char.Status)
+		}
+
+		if char.Visibility != models.CharacterVisibilityPrivate {
+			mentions.CameoIDs = append(mentions.CameoIDs, char.CameoID)
+			continue
+		}
+
+		cameos, err := h.characterCameos(char)
+		if err != nil {
+			return nil, fmt.Errorf("查询角色 @%s 的副本失败: %v", username, err)
+		}
+		if common == nil {
+			common = make(map[int64]bool, len(cameos))
+			for tokenID := range cameos {
+				common[tokenID] = true
+			}
+			first = username
+		} else {
+			for tokenID := range common {
+				if _, ok := cameos[tokenID]; !ok {
+					delete(common, tokenID)
+				}
+			}
+			if len(common) == 0 {
+				return nil, fmt.Errorf("私有角色 @%s 与 @%s 属于不同的 Token，且没有共同的副本，无法在同一个视频中使用", first, username)
+			}
+		}
+		mentions.private = append(mentions.private, privateMention{username: username, cameos: cameos})
+	}
+
+	for tokenID := range common {
+		mentions.TokenIDs = append(mentions.TokenIDs, tokenID)
+	}
+	sort.Slice(mentions.TokenIDs, func(i, j int) bool { return mentions.TokenIDs[i] < mentions.TokenIDs[j] })
+	return mentions, nil
+}
+
+// characterCameos returns the cameo of a private character on its token and
+// on every token holding a finalized replica
+func (h *GenerationHandler) characterCameos(char *models.Character) (map[int64]characterCameo, error) {
+	cameos := map[int64]characterCameo{char.TokenID: {cameoID: char.CameoID, username: char.Username}}
+	replicas, err := h.db.GetCharacterReplicas(char.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rep := range replicas {
+		if rep.Status == models.CharacterStatusFinalized {
+			cameos[rep.TokenID] = characterCameo{cameoID: rep.CameoID, username: rep.Username}
+		}
+	}
+	return cameos, nil
+}
+
+// mentionToken picks one of the tokens holding the mentioned private characters
+// and adds their cameo IDs on it
+func (h *GenerationHandler) mentionToken(mentions *CharacterMentions) (*models.Token, error) {
+	token := h.loadBalancer.GetNextTokenAmong(mentions.TokenIDs, false, true)
+	if token == nil {
+		return nil, fmt.Errorf("私有角色所在的 Token %v 均不可用，请确认已启用视频生成", mentions.TokenIDs)
+	}
+	mentions.useToken(token.ID)
+	return token, nil
+}
+
+// resolvePublicMentions searches Sora for mentions unknown locally. Mentions
+// without an exact public match stay plain text.
+func (h *GenerationHandler) resolvePublicMentions(mentions *CharacterMentions, accessToken, proxyURL string) error {
+	for _, username := range mentions.Unresolved {
+		results, err := h.soraClient.SearchCharacter(username, accessToken, proxyURL)
+		if err != nil {
+			return fmt.Errorf("搜索角色 @%s 失败: %w", username, err)
+		}
+		cameoID := ""
+		for _, result := range results {
+			if name, _ := result["username"].(string); strings.EqualFold(name, username) {
+				cameoID, _ = result["cameo_id"].(string)
+				if cameoID == "" {
+					cameoID, _ = result["id"].(string)
+				}
+				break
+			}
+		}
+		if cameoID == "" {
+			log.Printf("[Generation] Mention @%s matches no character, kept as text", username)
+			continue
+		}
+		mentions.CameoIDs = append(mentions.CameoIDs, cameoID)
+	}
+	mentions.Unresolved = nil
+	return nil
+}
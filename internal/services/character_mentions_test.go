@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   []string
+	}{
+		{"a cat", nil},
+		{"@kitty dances", []string{"kitty"}},
+		{"@kitty and @doggo.v2 meet @Kitty.", []string{"kitty", "doggo.v2"}},
+		{"mail me at me@example.com", nil},
+		{"(@cat_1) waves", []string{"cat_1"}},
+	}
+	for _, tt := range tests {
+		if got := ParseMentions(tt.prompt); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMentions(%q) = %v, want %v", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestGenerationHandler_MentionsResolveCharacters(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	owner := &models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true, VideoEnabled: true}
+	owner.ID, _ = db.CreateToken(owner)
+	other := &models.Token{Token: "at_other", Email: "other@example.com", IsActive: true, VideoEnabled: true}
+	other.ID, _ = db.CreateToken(other)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{other, owner})
+
+	client, mock := newMockSora(t, &mocksora.Options{Polls: 1, CameoPolls: 1})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+
+	// A public character known only to Sora
+	cameoID, err := client.UploadCharacterVideo([]byte("mp4!"), "at_other", "", "")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	client.GetCameoStatus(cameoID, "at_other", "")
+	if _, _, err := client.FinalizeCharacter(cameoID, "doggo", "Doggo", "", "", models.CharacterVisibilityPublic, "at_other", ""); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+
+	db.CreateCharacter(&models.Character{CameoID: "cameo_kitty", Username: "kitty", Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusFinalized, TokenID: owner.ID})
+	db.CreateCharacter(&models.Character{CameoID: "cameo_rival", Username: "rival", Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusFinalized, TokenID: other.ID})
+	db.CreateCharacter(&models.Character{CameoID: "cameo_draft", Username: "draft", Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusProcessing, TokenID: owner.ID})
+
+	// Private characters pin the owner; public ones are found by search
+	for i := 0; i < 2; i++ {
+		result, err := h.Generate(context.Background(), "@kitty chases @doggo, email a@b.com", "sora-video-10s", false, nil)
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if got := mock.TaskCameos(result.TaskID); !reflect.DeepEqual(got, []string{"cameo_kitty", cameoID}) {
+			t.Errorf("Expected cameo IDs [cameo_kitty %s], got %v", cameoID, got)
+		}
+		if stored, _ := db.GetTaskByTaskID(result.TaskID); stored == nil || stored.TokenID != owner.ID {
+			t.Errorf("Expected task on owner token %d, got %+v", owner.ID, stored)
+		}
+	}
+
+	// Unknown mentions stay plain text
+	result, err := h.Generate(context.Background(), "@nobody waves", "sora-video-10s", false, nil)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if got := mock.TaskCameos(result.TaskID); len(got) != 0 {
+		t.Errorf("Expected no cameo IDs, got %v", got)
+	}
+
+	creates := mock.Calls("POST /backend/nf/create")
+	if _, err := h.Generate(context.Background(), "@kitty meets @rival", "sora-video-10s", false, nil); err == nil || !strings.Contains(err.Error(), "不同的 Token") {
+		t.Errorf("Expected owner conflict, got %v", err)
+	}
+	if _, err := h.Generate(context.Background(), "@draft waves", "sora-video-10s", false, nil); err == nil || !strings.Contains(err.Error(), "尚未完成处理") {
+		t.Errorf("Expected unfinished character error, got %v", err)
+	}
+	owner.VideoEnabled = false
+	if _, err := h.Generate(context.Background(), "@kitty waves", "sora-video-10s", false, nil); err == nil || !strings.Contains(err.Error(), "不可用") {
+		t.Errorf("Expected unavailable owner error, got %v", err)
+	}
+	if got := mock.Calls("POST /backend/nf/create"); got != creates {
+		t.Errorf("Expected rejected mentions to create no tasks, got %d more", got-creates)
+	}
+}
+
+func TestGenerationHandler_MentionsUseReplicas(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	owner := &models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true, VideoEnabled: true}
+	owner.ID, _ = db.CreateToken(owner)
+	other := &models.Token{Token: "at_other", Email: "other@example.com", IsActive: true, VideoEnabled: true}
+	other.ID, _ = db.CreateToken(other)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{owner, other})
+
+	client, mock := newMockSora(t, &mocksora.Options{Polls: 1})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+
+	kitty := &models.Character{CameoID: "cameo_kitty", Username: "kitty", Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusFinalized, TokenID: owner.ID}
+	kitty.ID, _ = db.CreateCharacter(kitty)
+	rival := &models.Character{CameoID: "cameo_rival", Username: "rival", Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusFinalized, TokenID: other.ID}
+	rival.ID, _ = db.CreateCharacter(rival)
+	db.CreateCharacterReplica(&models.CharacterReplica{CharacterID: kitty.ID, TokenID: other.ID, CameoID: "cameo_kitty_2", Username: "kitty_2", Status: models.CharacterStatusFinalized})
+
+	// Only the other token holds both characters
+	result, err := h.Generate(context.Background(), "@kitty meets @rival", "sora-video-10s", false, nil)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if got := mock.TaskCameos(result.TaskID); !reflect.DeepEqual(got, []string{"cameo_kitty_2", "cameo_rival"}) {
+		t.Errorf("Expected replica cameo IDs, got %v", got)
+	}
+	if stored, _ := db.GetTaskByTaskID(result.TaskID); stored == nil || stored.TokenID != other.ID {
+		t.Errorf("Expected task on token %d, got %+v", other.ID, stored)
+	}
+	// The other token knows kitty by the replica username
+	if got := mock.TaskPrompt(result.TaskID); got != "@kitty_2 meets @rival" {
+		t.Errorf("Expected the replica mentioned, got %q", got)
+	}
+
+	// With the owner unavailable the replica still serves the character
+	owner.VideoEnabled = false
+	result, err = h.Generate(context.Background(), "@kitty waves", "sora-video-10s", false, nil)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if got := mock.TaskCameos(result.TaskID); !reflect.DeepEqual(got, []string{"cameo_kitty_2"}) {
+		t.Errorf("Expected replica cameo ID, got %v", got)
+	}
+
+	// Storyboard shots are renamed too
+	sb := &Storyboard{Shots: []StoryboardShot{{Duration: 5, Prompt: "@kitty jumps"}, {Duration: 5, Prompt: "landing", Characters: []string{"kitty"}}}}
+	result, err = h.GenerateStoryboard(context.Background(), sb, "sora2-landscape-10s", false, nil)
+	if err != nil {
+		t.Fatalf("GenerateStoryboard failed: %v", err)
+	}
+	if got := mock.TaskPrompt(result.TaskID); !strings.Contains(got, "@kitty_2 jumps") || strings.Contains(got, "@kitty ") {
+		t.Errorf("Expected the replica mentioned in the storyboard, got %q", got)
+	}
+	if sb.Shots[0].Prompt != "@kitty jumps" || sb.Shots[1].Characters[0] != "kitty" {
+		t.Errorf("Expected the request storyboard unchanged, got %+v", sb.Shots)
+	}
+}
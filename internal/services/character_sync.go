@@ -0,0 +1,432 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"soranow/internal/models"
+)
+
+// orphanedMessage is stored on characters whose cameo disappeared from Sora
+const orphanedMessage = "cameo no longer exists on Sora"
+
+// Character sync actions
+const (
+	CharacterSyncCreated  = "created"
+	CharacterSyncUpdated  = "updated"
+	CharacterSyncOrphaned = "orphaned"
+	CharacterSyncConflict = "conflict"
+)
+
+// CharacterFieldChange is a field changed by a sync
+type CharacterFieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// CharacterSyncChange is one local row created, updated or orphaned by a sync,
+// or a remote character it could not take over
+type CharacterSyncChange struct {
+	Action      string                 `json:"action"`
+	CharacterID int64                  `json:"character_id,omitempty"`
+	ReplicaID   int64                  `json:"replica_id,omitempty"`
+	CameoID     string                 `json:"cameo_id"`
+	Username    string                 `json:"username"`
+	TokenID     int64                  `json:"token_id"`
+	Fields      []CharacterFieldChange `json:"fields,omitempty"`
+	Reason      string                 `json:"reason,omitempty"`
+}
+
+// CharacterSyncResult reports what a sync changed
+type CharacterSyncResult struct {
+	DryRun    bool                  `json:"dry_run"`
+	Tokens    int                   `json:"tokens"` // Tokens whose characters were listed
+	Remote    int                   `json:"remote"` // Characters listed by Sora
+	Created   int                   `json:"created"`
+	Updated   int                   `json:"updated"`
+	Orphaned  int                   `json:"orphaned"`
+	Conflicts int                   `json:"conflicts"`
+	Unchanged int                   `json:"unchanged"`
+	Changes   []CharacterSyncChange `json:"changes"`
+	Errors    map[string]string     `json:"errors,omitempty"` // By token ID
+}
+
+// Sync reconciles the local characters and replicas with the cameos Sora lists
+// for each active token, or only for tokenID when it is positive:
+//   - remote cameos unknown locally are created
+//   - display name, profile URL, visibility and status are updated from Sora
+//   - a cameo listed by another token than the stored one moves to that token
+//   - local rows of a listed token whose cameo is gone are marked orphaned
+//
+// Sora wins every difference. Tokens that cannot be listed are left untouched.
+// With dryRun the changes are only reported.
+func (w *CharacterWatcher) Sync(tokenID int64, dryRun bool) (*CharacterSyncResult, error) {
+	w.checkMu.Lock()
+	defer w.checkMu.Unlock()
+
+	var tokens, all []*models.Token
+	if tokenID > 0 {
+		token, err := w.db.GetTokenByID(tokenID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token: %v", err)
+		}
+		tokens = append(tokens, token)
+	} else {
+		var err error
+		if all, err = w.db.GetAllTokens(); err != nil {
+			return nil, err
+		}
+		for _, token := range all {
+			if token.IsActive {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	chars, err := w.db.GetAllCharacters()
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := w.db.GetAllCharacterReplicas()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &characterSync{
+		watcher:     w,
+		result:      &CharacterSyncResult{DryRun: dryRun, Changes: []CharacterSyncChange{}},
+		byCameo:     make(map[string]*models.Character),
+		byName:      make(map[string]*models.Character),
+		replicas:    make(map[string]*models.CharacterReplica),
+		listed:      make(map[int64]bool),
+		seen:        make(map[int64]bool),
+		seenReplica: make(map[int64]bool),
+		tokensSet:   make(map[int64]bool),
+	}
+	for _, char := range chars {
+		s.byCameo[char.CameoID] = char
+		if char.CharacterID != "" {
+			s.byCameo[char.CharacterID] = char
+		}
+		s.byName[char.Username] = char
+	}
+	for _, rep := range replicas {
+		s.replicas[rep.CameoID] = rep
+		if rep.SoraID != "" {
+			s.replicas[rep.SoraID] = rep
+		}
+	}
+
+	for _, token := range tokens {
+		remote, err := w.client.GetMyCharacters(token.Token, TokenProxy(w.db, w.proxyManager, token))
+		if err != nil {
+			if s.result.Errors == nil {
+				s.result.Errors = make(map[string]string)
+			}
+			s.result.Errors[strconv.FormatInt(token.ID, 10)] = err.Error()
+			log.Printf("[Characters] Sync of token %d failed: %v", token.ID, err)
+			continue
+		}
+		s.listed[token.ID] = true
+		s.result.Tokens++
+		s.result.Remote += len(remote)
+		for _, rc := range remote {
+			s.apply(token.ID, rc)
+		}
+	}
+
+	// A full sync also orphans characters of deleted tokens
+	for _, token := range all {
+		s.tokensSet[token.ID] = true
+	}
+	// Cameos still being processed may not be listed yet; the watcher owns them
+	for _, char := range chars {
+		if s.seen[char.ID] || char.Status == models.CharacterStatusOrphaned {
+			continue
+		}
+		if !s.tokensSet[char.TokenID] && tokenID <= 0 {
+			s.orphanCharacter(char)
+		} else if s.listed[char.TokenID] && !watchedCharacter(char) {
+			s.orphanCharacter(char)
+		}
+	}
+	for _, rep := range replicas {
+		if s.seenReplica[rep.ID] || rep.Status == models.CharacterStatusOrphaned || !s.listed[rep.TokenID] {
+			continue
+		}
+		if rep.Status != models.CharacterStatusProcessing && rep.Status != models.CharacterStatusReady {
+			s.orphanReplica(rep)
+		}
+	}
+
+	sort.SliceStable(s.result.Changes, func(i, j int) bool {
+		return s.result.Changes[i].TokenID < s.result.Changes[j].TokenID
+	})
+	r := s.result
+	if r.Created+r.Updated+r.Orphaned+r.Conflicts > 0 {
+		log.Printf("[Characters] Sync of %d tokens: %d created, %d updated, %d orphaned, %d conflicts (dry run: %v)",
+			r.Tokens, r.Created, r.Updated, r.Orphaned, r.Conflicts, dryRun)
+	}
+	return r, nil
+}
+
+// watchedCharacter reports whether the character watcher still handles a
+// character: it is processing, or ready and finalized automatically
+func watchedCharacter(char *models.Character) bool {
+	return char.Status == models.CharacterStatusProcessing ||
+		(char.Status == models.CharacterStatusReady && char.AutoFinalize)
+}
+
+// characterSync holds the state of one Sync run
+type characterSync struct {
+	watcher *CharacterWatcher
+	result  *CharacterSyncResult
+
+	byCameo  map[string]*models.Character // By cameo ID and Sora character ID
+	byName   map[string]*models.Character
+	replicas map[string]*models.CharacterReplica // By cameo ID and Sora character ID
+
+	listed      map[int64]bool // Tokens listed successfully
+	seen        map[int64]bool // Characters listed by Sora
+	seenReplica map[int64]bool // Replicas listed by Sora
+	tokensSet   map[int64]bool // Existing tokens, for a full sync
+}
+
+// remoteString returns a string field of a remote character
+func remoteString(rc map[string]interface{}, key string) string {
+	v, _ := rc[key].(string)
+	return v
+}
+
+// remoteStatus maps the status Sora reports to a character status, "" if unknown
+func remoteStatus(status string) string {
+	switch status {
+	case models.CharacterStatusProcessing, models.CharacterStatusReady,
+		models.CharacterStatusFinalized, models.CharacterStatusFailed:
+		return status
+	}
+	return ""
+}
+
+// apply reconciles one character listed for the token
+func (s *characterSync) apply(tokenID int64, rc map[string]interface{}) {
+	cameoID := remoteString(rc, "cameo_id")
+	if cameoID == "" {
+		cameoID = remoteString(rc, "id")
+	}
+	if cameoID == "" {
+		return
+	}
+	characterID := remoteString(rc, "character_id")
+
+	rep := s.replicas[cameoID]
+	if rep == nil && characterID != "" {
+		rep = s.replicas[characterID]
+	}
+	if rep != nil {
+		s.seenReplica[rep.ID] = true
+		s.applyReplica(rep, rc)
+		return
+	}
+
+	char := s.byCameo[cameoID]
+	if char == nil && characterID != "" {
+		char = s.byCameo[characterID]
+	}
+	if char == nil {
+		s.create(tokenID, cameoID, characterID, rc)
+		return
+	}
+	s.seen[char.ID] = true
+
+	updated := *char
+	var fields []CharacterFieldChange
+	set := func(field string, dst *string, value string) {
+		if value != "" && value != *dst {
+			fields = append(fields, CharacterFieldChange{Field: field, From: *dst, To: value})
+			*dst = value
+		}
+	}
+	set("character_id", &updated.CharacterID, characterID)
+	set("display_name", &updated.DisplayName, remoteString(rc, "display_name"))
+	set("profile_url", &updated.ProfileURL, remoteString(rc, "profile_url"))
+	set("visibility", &updated.Visibility, remoteString(rc, "visibility"))
+	set("status", &updated.Status, remoteStatus(remoteString(rc, "status")))
+	if updated.Status != models.CharacterStatusFailed {
+		updated.ErrorMessage = ""
+	}
+
+	action := CharacterSyncUpdated
+	reason := ""
+	if updated.TokenID != tokenID {
+		fields = append(fields, CharacterFieldChange{
+			Field: "token_id", From: strconv.FormatInt(updated.TokenID, 10), To: strconv.FormatInt(tokenID, 10),
+		})
+		updated.TokenID = tokenID
+		action = CharacterSyncConflict
+		reason = "cameo is listed by another token, moved to it"
+	}
+	if len(fields) == 0 {
+		s.result.Unchanged++
+		return
+	}
+
+	if !s.result.DryRun {
+		if err := s.watcher.db.UpdateCharacter(&updated); err != nil {
+			s.fail(tokenID, fmt.Errorf("failed to update @%s: %v", char.Username, err))
+			return
+		}
+		*char = updated
+	}
+	if action == CharacterSyncConflict {
+		s.result.Conflicts++
+	} else {
+		s.result.Updated++
+	}
+	s.result.Changes = append(s.result.Changes, CharacterSyncChange{
+		Action: action, CharacterID: char.ID, CameoID: cameoID, Username: char.Username,
+		TokenID: tokenID, Fields: fields, Reason: reason,
+	})
+}
+
+// create stores a character that exists only on Sora
+func (s *characterSync) create(tokenID int64, cameoID, characterID string, rc map[string]interface{}) {
+	username := remoteString(rc, "username")
+	change := CharacterSyncChange{Action: CharacterSyncCreated, CameoID: cameoID, Username: username, TokenID: tokenID}
+	if username == "" {
+		// Not finalized yet; Sora has no username to store it under
+		return
+	}
+	if other := s.byName[username]; other != nil {
+		change.Action = CharacterSyncConflict
+		change.CharacterID = other.ID
+		change.Reason = fmt.Sprintf("username is already used by local cameo %s", other.CameoID)
+		s.result.Conflicts++
+		s.result.Changes = append(s.result.Changes, change)
+		return
+	}
+
+	char := &models.Character{
+		CameoID:     cameoID,
+		CharacterID: characterID,
+		Username:    username,
+		DisplayName: remoteString(rc, "display_name"),
+		ProfileURL:  remoteString(rc, "profile_url"),
+		Visibility:  remoteString(rc, "visibility"),
+		Status:      remoteStatus(remoteString(rc, "status")),
+		TokenID:     tokenID,
+	}
+	if char.DisplayName == "" {
+		char.DisplayName = username
+	}
+	if char.Visibility == "" {
+		char.Visibility = models.CharacterVisibilityPrivate
+	}
+	if char.Status == "" {
+		char.Status = models.CharacterStatusFinalized
+	}
+	if !s.result.DryRun {
+		id, err := s.watcher.db.CreateCharacter(char)
+		if err != nil {
+			s.fail(tokenID, fmt.Errorf("failed to create @%s: %v", username, err))
+			return
+		}
+		char.ID = id
+		change.CharacterID = id
+	}
+	s.byCameo[cameoID] = char
+	s.byName[username] = char
+	s.seen[char.ID] = true
+	s.result.Created++
+	s.result.Changes = append(s.result.Changes, change)
+}
+
+// applyReplica updates the status of a replica listed by Sora
+func (s *characterSync) applyReplica(rep *models.CharacterReplica, rc map[string]interface{}) {
+	updated := *rep
+	var fields []CharacterFieldChange
+	if status := remoteStatus(remoteString(rc, "status")); status != "" && status != updated.Status {
+		fields = append(fields, CharacterFieldChange{Field: "status", From: updated.Status, To: status})
+		updated.Status = status
+	}
+	if id := remoteString(rc, "character_id"); id != "" && id != updated.SoraID {
+		fields = append(fields, CharacterFieldChange{Field: "sora_character_id", From: updated.SoraID, To: id})
+		updated.SoraID = id
+	}
+	if len(fields) == 0 {
+		s.result.Unchanged++
+		return
+	}
+	if updated.Status != models.CharacterStatusFailed {
+		updated.ErrorMessage = ""
+	}
+	if !s.result.DryRun {
+		if err := s.watcher.db.UpdateCharacterReplica(&updated); err != nil {
+			s.fail(rep.TokenID, fmt.Errorf("failed to update replica @%s: %v", rep.Username, err))
+			return
+		}
+		*rep = updated
+	}
+	s.result.Updated++
+	s.result.Changes = append(s.result.Changes, CharacterSyncChange{
+		Action: CharacterSyncUpdated, CharacterID: rep.CharacterID, ReplicaID: rep.ID,
+		CameoID: rep.CameoID, Username: rep.Username, TokenID: rep.TokenID, Fields: fields,
+	})
+}
+
+// orphanCharacter marks a character whose cameo Sora no longer lists
+func (s *characterSync) orphanCharacter(char *models.Character) {
+	fields := []CharacterFieldChange{{Field: "status", From: char.Status, To: models.CharacterStatusOrphaned}}
+	if !s.result.DryRun {
+		updated := *char
+		updated.Status = models.CharacterStatusOrphaned
+		updated.ErrorMessage = orphanedMessage
+		updated.AutoFinalize = false
+		if err := s.watcher.db.UpdateCharacter(&updated); err != nil {
+			s.fail(char.TokenID, fmt.Errorf("failed to orphan @%s: %v", char.Username, err))
+			return
+		}
+		*char = updated
+	}
+	s.result.Orphaned++
+	s.result.Changes = append(s.result.Changes, CharacterSyncChange{
+		Action: CharacterSyncOrphaned, CharacterID: char.ID, CameoID: char.CameoID,
+		Username: char.Username, TokenID: char.TokenID, Fields: fields,
+	})
+}
+
+// orphanReplica marks a replica whose cameo Sora no longer lists
+func (s *characterSync) orphanReplica(rep *models.CharacterReplica) {
+	fields := []CharacterFieldChange{{Field: "status", From: rep.Status, To: models.CharacterStatusOrphaned}}
+	if !s.result.DryRun {
+		updated := *rep
+		updated.Status = models.CharacterStatusOrphaned
+		updated.ErrorMessage = orphanedMessage
+		if err := s.watcher.db.UpdateCharacterReplica(&updated); err != nil {
+			s.fail(rep.TokenID, fmt.Errorf("failed to orphan replica @%s: %v", rep.Username, err))
+			return
+		}
+		*rep = updated
+	}
+	s.result.Orphaned++
+	s.result.Changes = append(s.result.Changes, CharacterSyncChange{
+		Action: CharacterSyncOrphaned, CharacterID: rep.CharacterID, ReplicaID: rep.ID,
+		CameoID: rep.CameoID, Username: rep.Username, TokenID: rep.TokenID, Fields: fields,
+	})
+}
+
+// fail records a database error against the token
+func (s *characterSync) fail(tokenID int64, err error) {
+	if s.result.Errors == nil {
+		s.result.Errors = make(map[string]string)
+	}
+	key := strconv.FormatInt(tokenID, 10)
+	if prev, ok := s.result.Errors[key]; ok {
+		err = fmt.Errorf("%s; %v", prev, err)
+	}
+	s.result.Errors[key] = err.Error()
+	log.Printf("[Characters] Sync: %v", err)
+}
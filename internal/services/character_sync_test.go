@@ -0,0 +1,151 @@
+package services
+
+import (
+	"testing"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+// finalizeTestCameo uploads and finalizes a cameo on the mock for the token
+func finalizeTestCameo(t *testing.T, client *SoraClient, token, username, visibility string) (string, string) {
+	t.Helper()
+	cameoID, err := client.UploadCharacterVideo([]byte("mp4!"), token, "0-5", "")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	client.GetCameoStatus(cameoID, token, "")
+	characterID, _, err := client.FinalizeCharacter(cameoID, username, username, "", "", visibility, token, "")
+	if err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+	return cameoID, characterID
+}
+
+func TestCharacterWatcher_Sync(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ownerID, _ := db.CreateToken(&models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true})
+	otherID, _ := db.CreateToken(&models.Token{Token: "at_other", Email: "other@example.com", IsActive: true})
+	client, mock := newMockSora(t, &mocksora.Options{CameoPolls: 1})
+	w := NewCharacterWatcher(db, client)
+
+	// kitty is edited on Sora, doggo is stored under the wrong token, ghost is
+	// deleted upstream and remote exists only on Sora
+	kittyCameo, kittyID := finalizeTestCameo(t, client, "at_owner", "kitty", models.CharacterVisibilityPrivate)
+	doggoCameo, doggoID := finalizeTestCameo(t, client, "at_other", "doggo", models.CharacterVisibilityPublic)
+	ghostCameo, ghostID := finalizeTestCameo(t, client, "at_owner", "ghost", models.CharacterVisibilityPrivate)
+	remoteCameo, _ := finalizeTestCameo(t, client, "at_other", "remote", models.CharacterVisibilityPublic)
+
+	kitty := &models.Character{CameoID: kittyCameo, CharacterID: kittyID, Username: "kitty", DisplayName: "kitty",
+		Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusFinalized, TokenID: ownerID}
+	kitty.ID, _ = db.CreateCharacter(kitty)
+	doggo := &models.Character{CameoID: doggoCameo, CharacterID: doggoID, Username: "doggo", DisplayName: "doggo",
+		Visibility: models.CharacterVisibilityPublic, Status: models.CharacterStatusFinalized, TokenID: ownerID}
+	doggo.ID, _ = db.CreateCharacter(doggo)
+	ghost := &models.Character{CameoID: ghostCameo, CharacterID: ghostID, Username: "ghost", DisplayName: "ghost",
+		Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusFinalized, TokenID: ownerID}
+	ghost.ID, _ = db.CreateCharacter(ghost)
+
+	mock.EditCameo(kittyCameo, "Kitty Cat", models.CharacterVisibilityPublic)
+	if err := client.DeleteCharacter(ghostID, "at_owner", ""); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// A dry run reports the diff without writing it
+	dry, err := w.Sync(0, true)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if dry.Tokens != 2 || dry.Created != 1 || dry.Updated != 1 || dry.Orphaned != 1 || dry.Conflicts != 1 {
+		t.Errorf("Expected 1 created, updated, orphaned and conflict, got %+v", dry)
+	}
+	if stored, _ := db.GetCharacterByID(kitty.ID); stored.DisplayName != "kitty" {
+		t.Errorf("Expected dry run to leave kitty unchanged, got %+v", stored)
+	}
+
+	result, err := w.Sync(0, false)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(result.Changes) != len(dry.Changes) {
+		t.Errorf("Expected the dry run diff to match, got %d and %d changes", len(dry.Changes), len(result.Changes))
+	}
+	if stored, _ := db.GetCharacterByID(kitty.ID); stored.DisplayName != "Kitty Cat" || stored.Visibility != models.CharacterVisibilityPublic {
+		t.Errorf("Expected kitty updated from Sora, got %+v", stored)
+	}
+	if stored, _ := db.GetCharacterByID(doggo.ID); stored.TokenID != otherID {
+		t.Errorf("Expected doggo moved to token %d, got %+v", otherID, stored)
+	}
+	if stored, _ := db.GetCharacterByID(ghost.ID); stored.Status != models.CharacterStatusOrphaned || stored.ErrorMessage == "" {
+		t.Errorf("Expected ghost orphaned, got %+v", stored)
+	}
+	if stored, err := db.GetCharacterByCameoID(remoteCameo); err != nil || stored.TokenID != otherID || stored.Visibility != models.CharacterVisibilityPublic {
+		t.Errorf("Expected remote character created, got %+v (%v)", stored, err)
+	}
+
+	// Nothing is left to change
+	if again, _ := w.Sync(0, false); len(again.Changes) != 0 || again.Unchanged != 3 {
+		t.Errorf("Expected a second sync to change nothing, got %+v", again)
+	}
+}
+
+func TestCharacterWatcher_SyncSkipsFailedTokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true})
+	client, _ := newMockSora(t, &mocksora.Options{CameoPolls: 1})
+	w := NewCharacterWatcher(db, client)
+
+	char := &models.Character{CameoID: "cameo_kept", Username: "kept", DisplayName: "kept",
+		Status: models.CharacterStatusFinalized, TokenID: tokenID}
+	char.ID, _ = db.CreateCharacter(char)
+
+	// Listing fails when the access token is rejected
+	db.UpdateToken(&models.Token{ID: tokenID, Token: "", Email: "owner@example.com", IsActive: true})
+	result, err := w.Sync(0, false)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Tokens != 0 || len(result.Errors) != 1 || result.Orphaned != 0 {
+		t.Errorf("Expected the token to be skipped, got %+v", result)
+	}
+	if stored, _ := db.GetCharacterByID(char.ID); stored.Status != models.CharacterStatusFinalized {
+		t.Errorf("Expected character untouched, got %+v", stored)
+	}
+}
+
+func TestCharacterWatcher_SyncLeavesWatchedCharacters(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ownerID, _ := db.CreateToken(&models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true})
+	client, _ := newMockSora(t, &mocksora.Options{CameoPolls: 1})
+	w := NewCharacterWatcher(db, client)
+
+	// None of these cameos is listed by Sora
+	processing := &models.Character{CameoID: "cameo_processing", Username: "processing", Status: models.CharacterStatusProcessing, TokenID: ownerID}
+	processing.ID, _ = db.CreateCharacter(processing)
+	auto := &models.Character{CameoID: "cameo_auto", Username: "auto", Status: models.CharacterStatusReady, AutoFinalize: true, TokenID: ownerID}
+	auto.ID, _ = db.CreateCharacter(auto)
+	manual := &models.Character{CameoID: "cameo_manual", Username: "manual", Status: models.CharacterStatusReady, TokenID: ownerID}
+	manual.ID, _ = db.CreateCharacter(manual)
+
+	result, err := w.Sync(0, false)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Orphaned != 1 {
+		t.Errorf("Expected only the manually finalized character orphaned, got %+v", result)
+	}
+	for _, char := range []*models.Character{processing, auto} {
+		if stored, _ := db.GetCharacterByID(char.ID); stored.Status != char.Status {
+			t.Errorf("Expected %s left to the watcher, got %s", char.Username, stored.Status)
+		}
+	}
+	if stored, _ := db.GetCharacterByID(manual.ID); stored.Status != models.CharacterStatusOrphaned {
+		t.Errorf("Expected manual orphaned, got %s", stored.Status)
+	}
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// CharacterWatchInterval is how often processing cameos are polled
+const CharacterWatchInterval = 30 * time.Second
+
+// CharacterCheckResult summarizes one pass of the character watcher
+type CharacterCheckResult struct {
+	Checked   int `json:"checked"`
+	Ready     int `json:"ready"`
+	Finalized int `json:"finalized"`
+	Failed    int `json:"failed"`
+	Errors    int `json:"errors"`
+}
+
+// CharacterWatcher advances characters whose cameo is still processing on
+// Sora, finalizing the ones queued for it at upload
+type CharacterWatcher struct {
+	db           *database.DB
+	client       SoraAPI
+	proxyManager *ProxyManager
+	webhooks     *WebhookDispatcher
+
+	checkMu sync.Mutex // one pass at a time
+}
+
+// NewCharacterWatcher creates a watcher polling the client
+func NewCharacterWatcher(db *database.DB, client SoraAPI) *CharacterWatcher {
+	return &CharacterWatcher{db: db, client: client}
+}
+
+// SetProxyManager sets the proxy pool used for tokens without their own proxy
+func (w *CharacterWatcher) SetProxyManager(pm *ProxyManager) {
+	w.proxyManager = pm
+}
+
+// SetWebhookDispatcher sets the dispatcher used for character notifications
+func (w *CharacterWatcher) SetWebhookDispatcher(d *WebhookDispatcher) {
+	w.webhooks = d
+}
+
+// Check polls every pending character and replica once
+func (w *CharacterWatcher) Check() CharacterCheckResult {
+	w.checkMu.Lock()
+	defer w.checkMu.Unlock()
+
+	var result CharacterCheckResult
+	count := func(status string) {
+		switch status {
+		case models.CharacterStatusReady:
+			result.Ready++
+		case models.CharacterStatusFinalized:
+			result.Finalized++
+		case models.CharacterStatusFailed:
+			result.Failed++
+		}
+	}
+
+	chars, err := w.db.GetPendingCharacters()
+	if err != nil {
+		log.Printf("[Characters] Failed to load pending characters: %v", err)
+		return result
+	}
+	for _, char := range chars {
+		result.Checked++
+		if err := w.Refresh(char); err != nil {
+			result.Errors++
+			log.Printf("[Characters] Status of @%s not updated: %v", char.Username, err)
+			continue
+		}
+		count(char.Status)
+	}
+
+	replicas, err := w.db.GetPendingCharacterReplicas()
+	if err != nil {
+		log.Printf("[Characters] Failed to load pending replicas: %v", err)
+		return result
+	}
+	for _, rep := range replicas {
+		result.Checked++
+		if err := w.RefreshReplica(rep); err != nil {
+			result.Errors++
+			log.Printf("[Characters] Status of replica @%s not updated: %v", rep.Username, err)
+			continue
+		}
+		count(rep.Status)
+	}
+
+	if result.Ready+result.Finalized+result.Failed > 0 {
+		log.Printf("[Characters] Checked %d: %d ready, %d finalized, %d failed",
+			result.Checked, result.Ready, result.Finalized, result.Failed)
+	}
+	return result
+}
+
+// Refresh fetches the cameo status of the character and stores the change.
+// A ready cameo queued for auto-finalize is finalized with the stored settings.
+func (w *CharacterWatcher) Refresh(char *models.Character) error {
+	token, err := w.db.GetTokenByID(char.TokenID)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %v", err)
+	}
+	proxyURL := TokenProxy(w.db, w.proxyManager, token)
+
+	previous := *char
+	status, err := w.cameoStatus(char.CameoID, token, proxyURL)
+	if err != nil {
+		return err
+	}
+	if status.ProfileURL != "" {
+		char.ProfileURL = status.ProfileURL
+	}
+	char.Status = status.Status
+	switch status.Status {
+	case models.CharacterStatusFailed:
+		char.ErrorMessage = status.Error
+	case models.CharacterStatusFinalized:
+		char.AutoFinalize = false
+	case models.CharacterStatusReady:
+		if char.AutoFinalize && char.ReplacedCameoID != "" {
+			if err := w.deleteReplaced(char); err != nil {
+				char.ErrorMessage = "failed to delete previous cameo: " + err.Error()
+				break
+			}
+			char.ReplacedCameoID = ""
+			char.ReplacedTokenID = 0
+			char.ErrorMessage = ""
+		}
+		if char.AutoFinalize {
+			visibility := char.Visibility
+			if visibility == "" {
+				visibility = models.CharacterVisibilityPrivate
+			}
+			finalized := w.finalize(char.CameoID, char.Username, char, visibility, token.Token, proxyURL)
+			if finalized != nil {
+				char.Status = finalized.Status
+				char.ErrorMessage = finalized.Error
+				char.CharacterID = finalized.CameoID
+				if finalized.ProfileURL != "" {
+					char.ProfileURL = finalized.ProfileURL
+				}
+				char.Visibility = visibility
+				char.AutoFinalize = false
+			}
+		}
+	}
+
+	if *char == previous {
+		return nil
+	}
+	if err := w.db.UpdateCharacter(char); err != nil {
+		return err
+	}
+	if char.Status != previous.Status {
+		w.notify(char)
+	}
+	return nil
+}
+
+// RefreshReplica fetches the cameo status of a replica and stores the change.
+// Replicas are finalized as soon as their cameo is ready, with the settings of
+// the character and private visibility.
+func (w *CharacterWatcher) RefreshReplica(rep *models.CharacterReplica) error {
+	char, err := w.db.GetCharacterByID(rep.CharacterID)
+	if err != nil {
+		return fmt.Errorf("failed to get character: %v", err)
+	}
+	token, err := w.db.GetTokenByID(rep.TokenID)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %v", err)
+	}
+	proxyURL := TokenProxy(w.db, w.proxyManager, token)
+
+	previous := *rep
+	status, err := w.cameoStatus(rep.CameoID, token, proxyURL)
+	if err != nil {
+		return err
+	}
+	rep.Status = status.Status
+	rep.ErrorMessage = status.Error
+	if status.Status == models.CharacterStatusReady {
+		if finalized := w.finalize(rep.CameoID, rep.Username, char, models.CharacterVisibilityPrivate, token.Token, proxyURL); finalized != nil {
+			rep.Status = finalized.Status
+			rep.ErrorMessage = finalized.Error
+			rep.SoraID = finalized.CameoID
+		}
+	}
+
+	if *rep == previous {
+		return nil
+	}
+	if rep.Status != previous.Status {
+		log.Printf("[Characters] Replica @%s on token %d is %s", rep.Username, rep.TokenID, rep.Status)
+	}
+	return w.db.UpdateCharacterReplica(rep)
+}
+
+// deleteReplaced deletes the cameo a recreated character replaces, freeing its
+// username for the new cameo. A cameo or token that no longer exists counts as
+// deleted.
+func (w *CharacterWatcher) deleteReplaced(char *models.Character) error {
+	token, err := w.db.GetTokenByID(char.ReplacedTokenID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = w.client.DeleteCharacter(char.ReplacedCameoID, token.Token, TokenProxy(w.db, w.proxyManager, token))
+	if err != nil && !IsSoraError(err, SoraErrorNotFound) {
+		return err
+	}
+	log.Printf("[Characters] Deleted previous cameo %s of @%s", char.ReplacedCameoID, char.Username)
+	return nil
+}
+
+// cameoStatus fetches the status of a cameo as a character status. A cameo
+// Sora no longer knows is failed.
+func (w *CharacterWatcher) cameoStatus(cameoID string, token *models.Token, proxyURL string) (*models.CameoStatus, error) {
+	status, err := w.client.GetCameo(cameoID, token.Token, proxyURL)
+	if IsSoraError(err, SoraErrorNotFound) {
+		return &models.CameoStatus{CameoID: cameoID, Status: models.CharacterStatusFailed, Error: "cameo not found"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch status.Status {
+	case "", models.CharacterStatusProcessing:
+		status.Status = models.CharacterStatusProcessing
+	case models.CharacterStatusFailed:
+		if status.Error == "" {
+			status.Error = "cameo processing failed"
+		}
+	case models.CharacterStatusFinalized:
+	default:
+		status.Status = models.CharacterStatusReady
+	}
+	if status.Status != models.CharacterStatusFailed {
+		status.Error = ""
+	}
+	return status, nil
+}
+
+// finalize finalizes a ready cameo under the username with the character's
+// settings. The result holds the new status and the Sora character ID (as
+// CameoID); nil means a retryable failure left it ready for the next pass.
+func (w *CharacterWatcher) finalize(cameoID, username string, char *models.Character, visibility, token, proxyURL string) *models.CameoStatus {
+	characterID, profileURL, err := w.client.FinalizeCharacter(
+		cameoID, username, char.DisplayName,
+		char.InstructionSet, char.SafetyInstructionSet, visibility, token, proxyURL,
+	)
+	if err != nil {
+		if soraErr, ok := AsSoraError(err); ok && soraErr.Retryable() {
+			log.Printf("[Characters] Finalize of @%s deferred: %v", username, err)
+			return nil
+		}
+		return &models.CameoStatus{Status: models.CharacterStatusFailed, Error: err.Error()}
+	}
+	return &models.CameoStatus{CameoID: characterID, Status: models.CharacterStatusFinalized, ProfileURL: profileURL}
+}
+
+// notify sends the webhook of a character that became usable or failed. A
+// ready cameo still waiting to be finalized automatically is not announced.
+func (w *CharacterWatcher) notify(char *models.Character) {
+	switch {
+	case char.Status == models.CharacterStatusFailed:
+		w.webhooks.Dispatch(models.WebhookEventCharacterFailed, CharacterWebhookData(char))
+	case char.Status == models.CharacterStatusFinalized,
+		char.Status == models.CharacterStatusReady && !char.AutoFinalize:
+		w.webhooks.Dispatch(models.WebhookEventCharacterReady, CharacterWebhookData(char))
+	}
+}
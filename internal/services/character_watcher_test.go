@@ -0,0 +1,185 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+// uploadTestCharacter uploads a cameo to the mock and stores its character
+func uploadTestCharacter(t *testing.T, w *CharacterWatcher, client *SoraClient, char *models.Character) *models.Character {
+	t.Helper()
+	token, _ := w.db.GetTokenByID(char.TokenID)
+	cameoID, err := client.UploadCharacterVideo([]byte("mp4!"), token.Token, "0-5", "")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	char.CameoID = cameoID
+	char.Status = models.CharacterStatusProcessing
+	char.ID, err = w.db.CreateCharacter(char)
+	if err != nil {
+		t.Fatalf("CreateCharacter failed: %v", err)
+	}
+	return char
+}
+
+func TestCharacterWatcher_AdvancesAndAutoFinalizes(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var mu sync.Mutex
+	var events []string
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.Header.Get(WebhookHeaderEvent))
+		mu.Unlock()
+	}))
+	defer hooks.Close()
+	db.CreateWebhook(&models.Webhook{URL: hooks.URL, IsActive: true})
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "at_chars", Email: "a@example.com", IsActive: true})
+	client, mock := newMockSora(t, &mocksora.Options{CameoPolls: 2})
+	webhooks := NewWebhookDispatcher(db)
+	w := NewCharacterWatcher(db, client)
+	w.SetWebhookDispatcher(webhooks)
+
+	auto := uploadTestCharacter(t, w, client, &models.Character{Username: "kitty", DisplayName: "Kitty",
+		Visibility: models.CharacterVisibilityPublic, TokenID: tokenID, AutoFinalize: true})
+	manual := uploadTestCharacter(t, w, client, &models.Character{Username: "doggo", DisplayName: "doggo",
+		Visibility: models.CharacterVisibilityPrivate, TokenID: tokenID})
+
+	if result := w.Check(); result.Checked != 2 || result.Ready+result.Finalized+result.Failed != 0 {
+		t.Errorf("Expected 2 characters still processing, got %+v", result)
+	}
+	if result := w.Check(); result.Finalized != 1 || result.Ready != 1 {
+		t.Errorf("Expected 1 finalized and 1 ready, got %+v", result)
+	}
+
+	stored, _ := db.GetCharacterByID(auto.ID)
+	if stored.Status != models.CharacterStatusFinalized || stored.CharacterID == "" || stored.AutoFinalize || stored.ProfileURL == "" {
+		t.Errorf("Expected auto-finalized character, got %+v", stored)
+	}
+	if stored, _ := db.GetCharacterByID(manual.ID); stored.Status != models.CharacterStatusReady || stored.CharacterID != "" {
+		t.Errorf("Expected ready character awaiting finalize, got %+v", stored)
+	}
+	if n := mock.Calls("POST /backend/cameo/finalize"); n != 1 {
+		t.Errorf("Expected 1 finalize call, got %d", n)
+	}
+
+	// Ready characters without auto-finalize are left to the user
+	if result := w.Check(); result.Checked != 0 {
+		t.Errorf("Expected nothing pending, got %+v", result)
+	}
+
+	webhooks.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(events)
+	if len(events) != 2 || events[0] != models.WebhookEventCharacterReady || events[1] != models.WebhookEventCharacterReady {
+		t.Errorf("Expected 2 character.ready events, got %v", events)
+	}
+}
+
+func TestCharacterWatcher_Failures(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "at_chars", Email: "a@example.com", IsActive: true})
+	client, _ := newMockSora(t, &mocksora.Options{CameoPolls: 1, CameoFail: "No face detected"})
+	w := NewCharacterWatcher(db, client)
+
+	failed := uploadTestCharacter(t, w, client, &models.Character{Username: "blank", DisplayName: "blank", TokenID: tokenID})
+	gone := &models.Character{CameoID: "cameo_missing", Username: "gone", DisplayName: "gone",
+		Status: models.CharacterStatusProcessing, TokenID: tokenID}
+	gone.ID, _ = db.CreateCharacter(gone)
+
+	if result := w.Check(); result.Failed != 2 || result.Errors != 0 {
+		t.Errorf("Expected 2 failed characters, got %+v", result)
+	}
+	if stored, _ := db.GetCharacterByID(failed.ID); stored.Status != models.CharacterStatusFailed || stored.ErrorMessage != "No face detected" {
+		t.Errorf("Expected processing failure recorded, got %+v", stored)
+	}
+	if stored, _ := db.GetCharacterByID(gone.ID); stored.Status != models.CharacterStatusFailed || stored.ErrorMessage != "cameo not found" {
+		t.Errorf("Expected missing cameo recorded, got %+v", stored)
+	}
+}
+
+func TestCharacterWatcher_DeletesReplacedCameo(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "at_chars", Email: "a@example.com", IsActive: true})
+	expiredID, _ := db.CreateToken(&models.Token{Token: "", Email: "b@example.com", IsActive: true})
+	client, mock := newMockSora(t, &mocksora.Options{CameoPolls: 1})
+	w := NewCharacterWatcher(db, client)
+
+	old := uploadTestCharacter(t, w, client, &models.Character{Username: "kitty_old", DisplayName: "Kitty", TokenID: tokenID})
+	char := uploadTestCharacter(t, w, client, &models.Character{Username: "kitty", DisplayName: "Kitty", TokenID: tokenID,
+		AutoFinalize: true, ReplacedCameoID: old.CameoID, ReplacedTokenID: expiredID})
+	db.DeleteCharacter(old.ID)
+
+	// A failed delete keeps the new cameo ready and says why
+	w.Check()
+	w.Check()
+	stored, _ := db.GetCharacterByID(char.ID)
+	if stored.Status != models.CharacterStatusReady || !strings.HasPrefix(stored.ErrorMessage, "failed to delete previous cameo") ||
+		stored.ReplacedCameoID != old.CameoID {
+		t.Fatalf("Expected finalize held back by the failed delete, got %+v", stored)
+	}
+	if n := mock.Calls("POST /backend/cameo/finalize"); n != 0 {
+		t.Errorf("Expected no finalize before the previous cameo is deleted, got %d", n)
+	}
+
+	deletes := mock.Calls("DELETE /backend/cameo/" + old.CameoID)
+	stored.ReplacedTokenID = tokenID
+	db.UpdateCharacter(stored)
+	w.Check()
+	stored, _ = db.GetCharacterByID(char.ID)
+	if stored.Status != models.CharacterStatusFinalized || stored.ErrorMessage != "" || stored.ReplacedCameoID != "" {
+		t.Errorf("Expected character finalized after the previous cameo was deleted, got %+v", stored)
+	}
+	if n := mock.Calls("DELETE /backend/cameo/" + old.CameoID); n != deletes+1 {
+		t.Errorf("Expected the previous cameo deleted once, got %d calls", n-deletes)
+	}
+}
+
+func TestCharacterWatcher_FinalizesReplicas(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ownerID, _ := db.CreateToken(&models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true})
+	otherID, _ := db.CreateToken(&models.Token{Token: "at_other", Email: "other@example.com", IsActive: true})
+	client, mock := newMockSora(t, &mocksora.Options{CameoPolls: 1})
+	w := NewCharacterWatcher(db, client)
+
+	char := &models.Character{CameoID: "cameo_kitty", Username: "kitty", DisplayName: "Kitty",
+		Visibility: models.CharacterVisibilityPublic, Status: models.CharacterStatusFinalized, TokenID: ownerID}
+	char.ID, _ = db.CreateCharacter(char)
+	cameoID, err := client.UploadCharacterVideo([]byte("mp4!"), "at_other", "0-5", "")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	rep := &models.CharacterReplica{CharacterID: char.ID, TokenID: otherID, CameoID: cameoID,
+		Username: "kitty_2", Status: models.CharacterStatusProcessing}
+	rep.ID, _ = db.CreateCharacterReplica(rep)
+
+	if result := w.Check(); result.Checked != 1 || result.Finalized != 1 {
+		t.Errorf("Expected replica finalized, got %+v", result)
+	}
+	stored, _ := db.GetCharacterReplicaByID(rep.ID)
+	if stored.Status != models.CharacterStatusFinalized || stored.SoraID == "" {
+		t.Errorf("Expected finalized replica, got %+v", stored)
+	}
+	if n := mock.Calls("POST /backend/cameo/finalize"); n != 1 {
+		t.Errorf("Expected 1 finalize call, got %d", n)
+	}
+	if result := w.Check(); result.Checked != 0 {
+		t.Errorf("Expected nothing pending, got %+v", result)
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"soranow/internal/config"
+)
+
+// Clock provides the current time in the configured timezone so that daily
+// counters, statistics and scheduled jobs share the same notion of "today"
+type Clock struct {
+	offset int
+	loc    *time.Location
+	now    func() time.Time
+}
+
+// NewClock creates a clock for the given UTC offset in hours
+func NewClock(offsetHours int) *Clock {
+	return &Clock{
+		offset: offsetHours,
+		loc:    time.FixedZone(timezoneName(offsetHours), offsetHours*60*60),
+		now:    time.Now,
+	}
+}
+
+// timezoneName returns a display name like "UTC+8" for the offset
+func timezoneName(offsetHours int) string {
+	if offsetHours >= 0 {
+		return fmt.Sprintf("UTC+%d", offsetHours)
+	}
+	return fmt.Sprintf("UTC%d", offsetHours)
+}
+
+// Now returns the current time in the clock's timezone
+func (c *Clock) Now() time.Time {
+	return c.now().In(c.loc)
+}
+
+// In converts a time to the clock's timezone
+func (c *Clock) In(t time.Time) time.Time {
+	return t.In(c.loc)
+}
+
+// Today returns the current date (YYYY-MM-DD) in the clock's timezone
+func (c *Clock) Today() string {
+	return c.Now().Format("2006-01-02")
+}
+
+// StartOfDay returns midnight of the day containing t in the clock's timezone
+func (c *Clock) StartOfDay(t time.Time) time.Time {
+	local := t.In(c.loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, c.loc)
+}
+
+// NextMidnight returns the next midnight after t in the clock's timezone
+func (c *Clock) NextMidnight(t time.Time) time.Time {
+	return c.StartOfDay(t).AddDate(0, 0, 1)
+}
+
+// Location returns the clock's timezone
+func (c *Clock) Location() *time.Location {
+	return c.loc
+}
+
+// OffsetHours returns the UTC offset in hours
+func (c *Clock) OffsetHours() int {
+	return c.offset
+}
+
+var (
+	defaultClockMu sync.RWMutex
+	defaultClock   = NewClock(config.DefaultTimezoneOffset)
+)
+
+// DefaultClock returns the process-wide clock
+func DefaultClock() *Clock {
+	defaultClockMu.RLock()
+	defer defaultClockMu.RUnlock()
+	return defaultClock
+}
+
+// SetDefaultClock replaces the process-wide clock
+func SetDefaultClock(c *Clock) {
+	if c == nil {
+		return
+	}
+	defaultClockMu.Lock()
+	defer defaultClockMu.Unlock()
+	defaultClock = c
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"soranow/internal/config"
+)
+
+func TestClock_Today(t *testing.T) {
+	clock := NewClock(8)
+	// 2026-01-01 20:00 UTC is already 2026-01-02 in UTC+8
+	clock.now = func() time.Time { return time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC) }
+
+	if got := clock.Today(); got != "2026-01-02" {
+		t.Errorf("Expected today '2026-01-02', got '%s'", got)
+	}
+
+	utc := NewClock(0)
+	utc.now = clock.now
+	if got := utc.Today(); got != "2026-01-01" {
+		t.Errorf("Expected today '2026-01-01', got '%s'", got)
+	}
+}
+
+func TestClock_NextMidnight(t *testing.T) {
+	clock := NewClock(8)
+	now := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC) // 04:00 on Jan 2 in UTC+8
+
+	next := clock.NextMidnight(now)
+	expected := time.Date(2026, 1, 2, 16, 0, 0, 0, time.UTC) // 00:00 on Jan 3 in UTC+8
+	if !next.Equal(expected) {
+		t.Errorf("Expected next midnight %v, got %v", expected, next)
+	}
+}
+
+func TestClock_Location(t *testing.T) {
+	clock := NewClock(-5)
+
+	_, offset := clock.Now().Zone()
+	if offset != -5*60*60 {
+		t.Errorf("Expected offset -18000, got %d", offset)
+	}
+	if clock.Location().String() != "UTC-5" {
+		t.Errorf("Expected location 'UTC-5', got '%s'", clock.Location().String())
+	}
+}
+
+func TestClock_DefaultClock(t *testing.T) {
+	original := DefaultClock()
+	defer SetDefaultClock(original)
+
+	if original.OffsetHours() != config.DefaultTimezoneOffset {
+		t.Errorf("Expected default offset %d, got %d", config.DefaultTimezoneOffset, original.OffsetHours())
+	}
+
+	SetDefaultClock(NewClock(9))
+	if DefaultClock().OffsetHours() != 9 {
+		t.Errorf("Expected offset 9, got %d", DefaultClock().OffsetHours())
+	}
+
+	// nil is ignored
+	SetDefaultClock(nil)
+	if DefaultClock() == nil {
+		t.Error("Expected non-nil default clock")
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"soranow/internal/config"
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// overridesMigratedKey marks that legacy system_config values were imported as overrides
+const overridesMigratedKey = "_migrated.system_config"
+
+// GenerationConfigFrom builds the generation settings from the effective configuration
+func GenerationConfigFrom(cfg *config.Config) *GenerationConfig {
+	return &GenerationConfig{
+		ImageTimeout:    cfg.Generation.ImageTimeout,
+		VideoTimeout:    cfg.Generation.VideoTimeout,
+		PollInterval:    time.Duration(cfg.Sora.PollInterval * float64(time.Second)),
+		MaxPollInterval: time.Duration(cfg.Sora.MaxPollInterval * float64(time.Second)),
+		MaxPollAttempts: cfg.Sora.MaxPollAttempts,
+		WatermarkFree:   cfg.WatermarkFree.WatermarkFreeEnabled,
+		CacheEnabled:    cfg.Cache.Enabled,
+		CacheBaseURL:    cfg.Cache.BaseURL,
+	}
+}
+
+// applyConfigToSystemConfig copies the layered settings into the system_config row,
+// which request-time code (auth, proxy selection, admin UI) reads
+func applyConfigToSystemConfig(sc *models.SystemConfig, cfg *config.Config) {
+	sc.APIKey = cfg.Global.APIKey
+	sc.AdminUsername = cfg.Global.AdminUsername
+	sc.ProxyEnabled = cfg.Proxy.ProxyEnabled
+	sc.ProxyURL = cfg.Proxy.ProxyURL
+	sc.CacheEnabled = cfg.Cache.Enabled
+	sc.CacheTimeout = cfg.Cache.Timeout
+	sc.CacheBaseURL = cfg.Cache.BaseURL
+	sc.ImageTimeout = cfg.Generation.ImageTimeout
+	sc.VideoTimeout = cfg.Generation.VideoTimeout
+	sc.ErrorBanThreshold = cfg.Admin.ErrorBanThreshold
+	sc.TaskRetryEnabled = cfg.Admin.TaskRetryEnabled
+	sc.TaskMaxRetries = cfg.Admin.TaskMaxRetries
+	sc.AutoDisable401 = cfg.Admin.AutoDisableOn401
+	sc.TokenAutoRefresh = cfg.TokenRefresh.ATAutoRefreshEnabled
+	sc.WatermarkFreeEnabled = cfg.WatermarkFree.WatermarkFreeEnabled
+	sc.WatermarkParseMethod = cfg.WatermarkFree.ParseMethod
+	sc.WatermarkParseURL = cfg.WatermarkFree.CustomParseURL
+	sc.WatermarkParseToken = cfg.WatermarkFree.CustomParseToken
+	sc.WatermarkFallback = cfg.WatermarkFree.FallbackOnFailure
+	sc.CallMode = cfg.CallLogic.CallMode
+}
+
+// SyncSystemConfig materializes the effective configuration into the system_config row.
+// The admin password is only bootstrapped from the config when none is set yet.
+func SyncSystemConfig(db *database.DB, cfg *config.Config) error {
+	sc, err := db.GetSystemConfig()
+	if err != nil {
+		return err
+	}
+
+	applyConfigToSystemConfig(sc, cfg)
+
+	if sc.AdminPasswordHash == "" && cfg.Global.AdminPassword != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(cfg.Global.AdminPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		sc.AdminPasswordHash = string(hash)
+	}
+
+	return db.UpdateSystemConfig(sc)
+}
+
+// MigrateSystemConfigOverrides imports admin changes made before config layering existed.
+// Any system_config value that differs from the schema default was set through the admin
+// UI, so it is kept as a database override. Runs once.
+func MigrateSystemConfigOverrides(db *database.DB) error {
+	overrides, err := db.GetConfigOverrides()
+	if err != nil {
+		return err
+	}
+	if _, done := overrides[overridesMigratedKey]; done {
+		return nil
+	}
+
+	sc, err := db.GetSystemConfig()
+	if err != nil {
+		return err
+	}
+
+	// Schema defaults of system_config
+	legacy := &models.SystemConfig{
+		APIKey:               "han1234",
+		AdminUsername:        "admin",
+		CacheTimeout:         600,
+		ImageTimeout:         300,
+		VideoTimeout:         3000,
+		ErrorBanThreshold:    3,
+		TaskRetryEnabled:     true,
+		TaskMaxRetries:       3,
+		AutoDisable401:       true,
+		WatermarkParseMethod: "third_party",
+		WatermarkFallback:    true,
+		CallMode:             "default",
+	}
+
+	current, defaults := config.Defaults(), config.Defaults()
+	systemConfigToConfig(current, sc)
+	systemConfigToConfig(defaults, legacy)
+
+	defaultValues := defaults.Flatten()
+	imported := 0
+	for key, value := range current.Flatten() {
+		if reflect.DeepEqual(value, defaultValues[key]) {
+			continue
+		}
+		encoded, err := config.EncodeOverride(key, value)
+		if err != nil {
+			return err
+		}
+		if err := db.SetConfigOverride(key, encoded); err != nil {
+			return err
+		}
+		imported++
+	}
+
+	if imported > 0 {
+		log.Printf("Imported %d admin settings as config overrides", imported)
+	}
+	return db.SetConfigOverride(overridesMigratedKey, "true")
+}
+
+// systemConfigToConfig is the inverse of applyConfigToSystemConfig
+func systemConfigToConfig(cfg *config.Config, sc *models.SystemConfig) {
+	cfg.Global.APIKey = sc.APIKey
+	cfg.Global.AdminUsername = sc.AdminUsername
+	cfg.Proxy.ProxyEnabled = sc.ProxyEnabled
+	cfg.Proxy.ProxyURL = sc.ProxyURL
+	cfg.Cache.Enabled = sc.CacheEnabled
+	cfg.Cache.Timeout = sc.CacheTimeout
+	cfg.Cache.BaseURL = sc.CacheBaseURL
+	cfg.Generation.ImageTimeout = sc.ImageTimeout
+	cfg.Generation.VideoTimeout = sc.VideoTimeout
+	cfg.Admin.ErrorBanThreshold = sc.ErrorBanThreshold
+	cfg.Admin.TaskRetryEnabled = sc.TaskRetryEnabled
+	cfg.Admin.TaskMaxRetries = sc.TaskMaxRetries
+	cfg.Admin.AutoDisableOn401 = sc.AutoDisable401
+	cfg.TokenRefresh.ATAutoRefreshEnabled = sc.TokenAutoRefresh
+	cfg.WatermarkFree.WatermarkFreeEnabled = sc.WatermarkFreeEnabled
+	cfg.WatermarkFree.ParseMethod = sc.WatermarkParseMethod
+	cfg.WatermarkFree.CustomParseURL = sc.WatermarkParseURL
+	cfg.WatermarkFree.CustomParseToken = sc.WatermarkParseToken
+	cfg.WatermarkFree.FallbackOnFailure = sc.WatermarkFallback
+	cfg.CallLogic.CallMode = sc.CallMode
+}
+
+// ApplyConfigOverrides stores admin overrides and hot-reloads the configuration.
+// If the result does not validate, the previous overrides are restored.
+func ApplyConfigOverrides(db *database.DB, manager *config.Manager, overrides map[string]interface{}) error {
+	encoded := make(map[string]string, len(overrides))
+	for key, value := range overrides {
+		raw, err := config.EncodeOverride(key, value)
+		if err != nil {
+			return &config.ValidationError{Errors: []string{err.Error()}}
+		}
+		encoded[key] = raw
+	}
+
+	previous, err := db.GetConfigOverrides()
+	if err != nil {
+		return err
+	}
+
+	for key, raw := range encoded {
+		if err := db.SetConfigOverride(key, raw); err != nil {
+			return err
+		}
+	}
+
+	reloadErr := manager.Reload()
+	if reloadErr == nil {
+		return nil
+	}
+
+	// Roll back to the previous overrides
+	for key := range encoded {
+		if old, ok := previous[key]; ok {
+			db.SetConfigOverride(key, old)
+		} else {
+			db.DeleteConfigOverride(key)
+		}
+	}
+	if err := manager.Reload(); err != nil {
+		return fmt.Errorf("%v (rollback failed: %v)", reloadErr, err)
+	}
+	return reloadErr
+}
+
+// ResetConfigOverride removes an admin override so the key falls back to env/TOML/default
+func ResetConfigOverride(db *database.DB, manager *config.Manager, key string) error {
+	if err := db.DeleteConfigOverride(key); err != nil {
+		return err
+	}
+	return manager.Reload()
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"soranow/internal/config"
+)
+
+func TestMigrateSystemConfigOverrides(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Values changed through the admin UI before config layering
+	sc, _ := db.GetSystemConfig()
+	sc.ImageTimeout = 120
+	sc.CallMode = "polling"
+	db.UpdateSystemConfig(sc)
+
+	if err := MigrateSystemConfigOverrides(db); err != nil {
+		t.Fatalf("MigrateSystemConfigOverrides failed: %v", err)
+	}
+
+	overrides, _ := db.GetConfigOverrides()
+	if overrides["generation.image_timeout"] != "120" {
+		t.Errorf("Expected image_timeout override 120, got %q", overrides["generation.image_timeout"])
+	}
+	if overrides["call_logic.call_mode"] != `"polling"` {
+		t.Errorf("Expected call_mode override, got %q", overrides["call_logic.call_mode"])
+	}
+	if _, ok := overrides["global.api_key"]; ok {
+		t.Error("Unchanged values should not become overrides")
+	}
+
+	// Runs only once
+	sc.VideoTimeout = 100
+	db.UpdateSystemConfig(sc)
+	MigrateSystemConfigOverrides(db)
+	overrides, _ = db.GetConfigOverrides()
+	if _, ok := overrides["generation.video_timeout"]; ok {
+		t.Error("Migration should not run twice")
+	}
+}
+
+func TestSyncSystemConfig(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	cfg := config.Defaults()
+	cfg.Global.APIKey = "from-config"
+	cfg.Generation.VideoTimeout = 1200
+
+	if err := SyncSystemConfig(db, cfg); err != nil {
+		t.Fatalf("SyncSystemConfig failed: %v", err)
+	}
+
+	sc, _ := db.GetSystemConfig()
+	if sc.APIKey != "from-config" {
+		t.Errorf("Expected API key from config, got %s", sc.APIKey)
+	}
+	if sc.VideoTimeout != 1200 {
+		t.Errorf("Expected video timeout 1200, got %d", sc.VideoTimeout)
+	}
+}
+
+func TestApplyConfigOverrides_RollsBackInvalid(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	manager := config.NewManager("", db)
+	manager.SetLookupEnv(func(string) (string, bool) { return "", false })
+	if err := manager.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if err := ApplyConfigOverrides(db, manager, map[string]interface{}{"generation.image_timeout": 60}); err != nil {
+		t.Fatalf("ApplyConfigOverrides failed: %v", err)
+	}
+	if manager.Get().Generation.ImageTimeout != 60 {
+		t.Errorf("Expected image_timeout 60, got %d", manager.Get().Generation.ImageTimeout)
+	}
+
+	err := ApplyConfigOverrides(db, manager, map[string]interface{}{"generation.image_timeout": 0})
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	overrides, _ := db.GetConfigOverrides()
+	if overrides["generation.image_timeout"] != "60" {
+		t.Errorf("Expected previous override to be restored, got %q", overrides["generation.image_timeout"])
+	}
+	if manager.Get().Generation.ImageTimeout != 60 {
+		t.Errorf("Expected image_timeout to stay 60, got %d", manager.Get().Generation.ImageTimeout)
+	}
+}
+
+func TestGenerationConfigFrom(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Sora.PollInterval = 1.5
+
+	gc := GenerationConfigFrom(cfg)
+	if gc.PollInterval != 1500*time.Millisecond {
+		t.Errorf("Expected poll interval 1.5s, got %v", gc.PollInterval)
+	}
+	if gc.ImageTimeout != 300 || gc.VideoTimeout != 3000 {
+		t.Errorf("Unexpected timeouts %d/%d", gc.ImageTimeout, gc.VideoTimeout)
+	}
+}
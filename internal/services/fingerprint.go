@@ -0,0 +1,243 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bogdanfinn/tls-client/profiles"
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// DefaultTLSProfile is the TLS client profile used when none is configured
+const DefaultTLSProfile = "firefox_132"
+
+// DefaultHeaderOrder is the order in which request headers are sent
+var DefaultHeaderOrder = []string{
+	"accept",
+	"accept-language",
+	"authorization",
+	"content-type",
+	"origin",
+	"referer",
+	"sec-fetch-dest",
+	"sec-fetch-mode",
+	"sec-fetch-site",
+	"user-agent",
+	"openai-sentinel-token",
+}
+
+// Fingerprint pairs a TLS client profile with user agents of the same browser,
+// so the TLS handshake and the User-Agent header never contradict each other
+type Fingerprint struct {
+	Profile       string   `json:"tls_profile"`
+	UserAgents    []string `json:"user_agents"`     // Browser UAs matching Profile (sentinel/PoW and web requests)
+	AppUserAgents []string `json:"app_user_agents"` // Sora app UAs for backend API requests
+	HeaderOrder   []string `json:"header_order"`
+
+	clientProfile profiles.ClientProfile
+}
+
+// NewFingerprint creates a fingerprint. Empty values fall back to the built-in
+// user agents of the profile, MobileUserAgents and DefaultHeaderOrder.
+func NewFingerprint(profile string, userAgents, appUserAgents, headerOrder []string) (*Fingerprint, error) {
+	if profile == "" {
+		profile = DefaultTLSProfile
+	}
+	clientProfile, ok := profiles.MappedTLSClients[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS profile: %s", profile)
+	}
+
+	if len(userAgents) == 0 {
+		userAgents = BrowserUserAgents(profile)
+		if len(userAgents) == 0 {
+			return nil, fmt.Errorf("no built-in user agents for TLS profile %s, user_agents must be set", profile)
+		}
+	}
+	if len(appUserAgents) == 0 {
+		appUserAgents = MobileUserAgents
+	}
+	if len(headerOrder) == 0 {
+		headerOrder = DefaultHeaderOrder
+	} else {
+		lower := make([]string, len(headerOrder))
+		for i, h := range headerOrder {
+			lower[i] = strings.ToLower(strings.TrimSpace(h))
+		}
+		headerOrder = lower
+	}
+
+	return &Fingerprint{
+		Profile:       profile,
+		UserAgents:    userAgents,
+		AppUserAgents: appUserAgents,
+		HeaderOrder:   headerOrder,
+		clientProfile: clientProfile,
+	}, nil
+}
+
+// ClientProfile returns the tls-client profile
+func (f *Fingerprint) ClientProfile() profiles.ClientProfile {
+	return f.clientProfile
+}
+
+// UserAgent returns a browser user agent, always the same one for the same key (e.g. a token)
+func (f *Fingerprint) UserAgent(key string) string {
+	return pickStable(f.UserAgents, key)
+}
+
+// AppUserAgent returns a Sora app user agent, always the same one for the same key
+func (f *Fingerprint) AppUserAgent(key string) string {
+	return pickStable(f.AppUserAgents, key)
+}
+
+// pickStable picks an element by hash of key, or at random for an empty key
+func pickStable(values []string, key string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if key == "" {
+		return values[rand.Intn(len(values))]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return values[h.Sum32()%uint32(len(values))]
+}
+
+// BrowserUserAgents returns user agents of the browser emulated by a TLS profile,
+// or nil if the profile does not belong to a known browser
+func BrowserUserAgents(profile string) []string {
+	parts := strings.Split(profile, "_")
+	switch {
+	case parts[0] == "firefox" && len(parts) == 2:
+		v := parts[1]
+		return []string{
+			fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:%s.0) Gecko/20100101 Firefox/%s.0", v, v),
+			fmt.Sprintf("Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:%s.0) Gecko/20100101 Firefox/%s.0", v, v),
+			fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64; rv:%s.0) Gecko/20100101 Firefox/%s.0", v, v),
+		}
+	case parts[0] == "chrome" && len(parts) >= 2:
+		v := parts[1]
+		return []string{
+			fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", v),
+			fmt.Sprintf("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", v),
+			fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", v),
+		}
+	case parts[0] == "safari" && len(parts) >= 3 && (parts[1] == "ios" || parts[1] == "ipad"):
+		v := parts[2:]
+		device := "iPhone; CPU iPhone OS"
+		if parts[1] == "ipad" {
+			device = "iPad; CPU OS"
+		}
+		return []string{
+			fmt.Sprintf("Mozilla/5.0 (%s %s like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/%s Mobile/15E148 Safari/604.1",
+				device, strings.Join(v, "_"), strings.Join(v, ".")),
+		}
+	case parts[0] == "safari" && len(parts) >= 2:
+		return []string{
+			fmt.Sprintf("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/%s Safari/605.1.15",
+				strings.Join(parts[1:], ".")),
+		}
+	}
+	return nil
+}
+
+// TLSProfiles returns the names of all available TLS profiles, sorted
+func TLSProfiles() []string {
+	names := make([]string, 0, len(profiles.MappedTLSClients))
+	for name := range profiles.MappedTLSClients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	defaultFingerprintMu sync.RWMutex
+	defaultFingerprint   = mustFingerprint(NewFingerprint(DefaultTLSProfile, nil, nil, nil))
+)
+
+func mustFingerprint(fp *Fingerprint, err error) *Fingerprint {
+	if err != nil {
+		panic(err)
+	}
+	return fp
+}
+
+// DefaultFingerprint returns the process-wide fingerprint
+func DefaultFingerprint() *Fingerprint {
+	defaultFingerprintMu.RLock()
+	defer defaultFingerprintMu.RUnlock()
+	return defaultFingerprint
+}
+
+// SetDefaultFingerprint replaces the process-wide fingerprint
+func SetDefaultFingerprint(fp *Fingerprint) {
+	if fp == nil {
+		return
+	}
+	defaultFingerprintMu.Lock()
+	defer defaultFingerprintMu.Unlock()
+	defaultFingerprint = fp
+}
+
+// FingerprintForToken returns the token's own fingerprint if it sets a TLS profile
+// or user agent, otherwise the default one
+func FingerprintForToken(token *models.Token) *Fingerprint {
+	if token == nil || (token.TLSProfile == "" && token.UserAgent == "") {
+		return DefaultFingerprint()
+	}
+	fp, err := tokenFingerprint(token)
+	if err != nil {
+		log.Printf("[Fingerprint] Token %d: %v, using default", token.ID, err)
+		return DefaultFingerprint()
+	}
+	return fp
+}
+
+// ValidateTokenFingerprint checks that the token's TLS profile and user agent form a usable fingerprint
+func ValidateTokenFingerprint(token *models.Token) error {
+	if token.TLSProfile == "" && token.UserAgent == "" {
+		return nil
+	}
+	_, err := tokenFingerprint(token)
+	return err
+}
+
+// tokenFingerprint builds the fingerprint of a token with its own profile or user agent.
+// Without its own user agent, the token uses the default UAs only if they match its profile.
+func tokenFingerprint(token *models.Token) (*Fingerprint, error) {
+	def := DefaultFingerprint()
+	profile := token.TLSProfile
+	if profile == "" {
+		profile = def.Profile
+	}
+	var userAgents []string
+	if token.UserAgent != "" {
+		userAgents = []string{token.UserAgent}
+	} else if profile == def.Profile {
+		userAgents = def.UserAgents
+	}
+	return NewFingerprint(profile, userAgents, def.AppUserAgents, def.HeaderOrder)
+}
+
+// FingerprintResolver returns the fingerprint to use for an access token
+type FingerprintResolver func(accessToken string) *Fingerprint
+
+// TokenFingerprints resolves access tokens to the fingerprint of their token
+func TokenFingerprints(db *database.DB) FingerprintResolver {
+	return func(accessToken string) *Fingerprint {
+		if accessToken != "" {
+			if token, err := db.GetTokenByToken(accessToken); err == nil {
+				return FingerprintForToken(token)
+			}
+		}
+		return DefaultFingerprint()
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"soranow/internal/models"
+)
+
+func TestNewFingerprint_PairsUserAgentsWithProfile(t *testing.T) {
+	fp, err := NewFingerprint("", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewFingerprint failed: %v", err)
+	}
+	if fp.Profile != DefaultTLSProfile {
+		t.Errorf("Expected default profile %s, got %s", DefaultTLSProfile, fp.Profile)
+	}
+	for _, ua := range fp.UserAgents {
+		if !strings.Contains(ua, "Firefox/132.0") {
+			t.Errorf("Expected Firefox 132 user agent, got %s", ua)
+		}
+	}
+	if len(fp.AppUserAgents) != len(MobileUserAgents) || len(fp.HeaderOrder) != len(DefaultHeaderOrder) {
+		t.Error("Expected built-in app user agents and header order")
+	}
+
+	chrome, err := NewFingerprint("chrome_131_PSK", nil, nil, []string{"User-Agent", " Accept"})
+	if err != nil {
+		t.Fatalf("NewFingerprint failed: %v", err)
+	}
+	if !strings.Contains(chrome.UserAgents[0], "Chrome/131.0.0.0") {
+		t.Errorf("Expected Chrome 131 user agent, got %s", chrome.UserAgents[0])
+	}
+	if chrome.HeaderOrder[0] != "user-agent" || chrome.HeaderOrder[1] != "accept" {
+		t.Errorf("Expected normalized header order, got %v", chrome.HeaderOrder)
+	}
+
+	ios, _ := NewFingerprint("safari_ios_18_0", nil, nil, nil)
+	if !strings.Contains(ios.UserAgents[0], "iPhone OS 18_0") || !strings.Contains(ios.UserAgents[0], "Version/18.0") {
+		t.Errorf("Unexpected iOS user agent %s", ios.UserAgents[0])
+	}
+}
+
+func TestNewFingerprint_Errors(t *testing.T) {
+	if _, err := NewFingerprint("netscape_4", nil, nil, nil); err == nil {
+		t.Error("Expected error for unknown profile")
+	}
+	if _, err := NewFingerprint("okhttp4_android_13", nil, nil, nil); err == nil {
+		t.Error("Expected error for profile without built-in user agents")
+	}
+	if _, err := NewFingerprint("okhttp4_android_13", []string{"okhttp/4.12.0"}, nil, nil); err != nil {
+		t.Errorf("Expected configured user agents to be accepted, got %v", err)
+	}
+}
+
+func TestFingerprint_StableUserAgentPerKey(t *testing.T) {
+	fp, _ := NewFingerprint("firefox_132", nil, nil, nil)
+	first := fp.UserAgent("token-a")
+	for i := 0; i < 10; i++ {
+		if fp.UserAgent("token-a") != first {
+			t.Fatal("Expected the same user agent for the same token")
+		}
+	}
+	if fp.AppUserAgent("token-a") != fp.AppUserAgent("token-a") {
+		t.Error("Expected the same app user agent for the same token")
+	}
+}
+
+func TestFingerprintForToken(t *testing.T) {
+	if FingerprintForToken(&models.Token{ID: 1}) != DefaultFingerprint() {
+		t.Error("Expected default fingerprint for token without overrides")
+	}
+
+	fp := FingerprintForToken(&models.Token{ID: 2, TLSProfile: "chrome_131"})
+	if fp.Profile != "chrome_131" || !strings.Contains(fp.UserAgent("x"), "Chrome/131") {
+		t.Errorf("Expected Chrome fingerprint, got %s / %s", fp.Profile, fp.UserAgent("x"))
+	}
+
+	fp = FingerprintForToken(&models.Token{ID: 3, UserAgent: "Custom/1.0"})
+	if fp.Profile != DefaultFingerprint().Profile || fp.UserAgent("x") != "Custom/1.0" {
+		t.Errorf("Expected default profile with custom UA, got %s / %s", fp.Profile, fp.UserAgent("x"))
+	}
+
+	invalid := &models.Token{ID: 4, TLSProfile: "okhttp4_android_13"}
+	if ValidateTokenFingerprint(invalid) == nil {
+		t.Error("Expected validation error for profile without user agents")
+	}
+	if FingerprintForToken(invalid) != DefaultFingerprint() {
+		t.Error("Expected invalid token fingerprint to fall back to default")
+	}
+}
+
+func TestSessionManager_RecreatesSessionOnProfileChange(t *testing.T) {
+	sm := NewSessionManager(30)
+	defer sm.Stop()
+
+	chrome, _ := NewFingerprint("chrome_131", nil, nil, nil)
+	first, _ := sm.GetSession("token", "", nil)
+	again, _ := sm.GetSession("token", "", DefaultFingerprint())
+	if first != again {
+		t.Error("Expected nil fingerprint to mean the default one")
+	}
+	if switched, _ := sm.GetSession("token", "", chrome); switched == first {
+		t.Error("Expected a new session when the TLS profile changes")
+	}
+}
@@ -3,8 +3,12 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"soranow/internal/database"
@@ -15,7 +19,9 @@ import (
 type GenerationConfig struct {
 	ImageTimeout     int
 	VideoTimeout     int
-	PollInterval     time.Duration
+	PollInterval     time.Duration // Interval while progress advances
+	MaxPollInterval  time.Duration // Ceiling the interval backs off to while progress stalls
+	MaxPollAttempts  int           // Polls before giving up (0: until the timeout)
 	WatermarkFree    bool
 	CacheEnabled     bool
 	CacheBaseURL     string
@@ -24,28 +30,269 @@ type GenerationConfig struct {
 // GenerationHandler handles image and video generation
 type GenerationHandler struct {
 	db           *database.DB
-	soraClient   *SoraClient
+	soraClient   SoraAPI
+	poller       *TaskPoller
 	loadBalancer *LoadBalancer
 	tokenManager *TokenManager
-	config       *GenerationConfig
+	config       atomic.Pointer[GenerationConfig]
+	webhooks     *WebhookDispatcher
+	taskHub      *TaskHub
+	proxyManager *ProxyManager
+	moderator    PromptChecker
+
+	runningMu sync.Mutex
+	running   map[string]context.CancelCauseFunc
+	draining  atomic.Bool
 }
 
-// NewGenerationHandler creates a new generation handler
-func NewGenerationHandler(db *database.DB, lb *LoadBalancer, tm *TokenManager, cfg *GenerationConfig) *GenerationHandler {
+// ErrTaskCancelled is the cancellation cause for tasks cancelled by an admin
+var ErrTaskCancelled = errors.New("任务已取消")
+
+// ErrShuttingDown is returned for new generations during shutdown, and is the
+// cancellation cause of tasks checkpointed for resume on the next start
+var ErrShuttingDown = errors.New("服务正在关闭，请稍后重试")
+
+// checkpointGrace is how long Drain waits for checkpointed polls to exit
+const checkpointGrace = 5 * time.Second
+
+// Polling defaults for a GenerationConfig without poll settings
+const (
+	defaultPollInterval    = 2500 * time.Millisecond
+	defaultMaxPollInterval = 10 * time.Second
+)
+
+// NewGenerationHandler creates a new generation handler. A nil sora uses a
+// SoraClient of its own with direct connections.
+func NewGenerationHandler(db *database.DB, lb *LoadBalancer, tm *TokenManager, cfg *GenerationConfig, sora SoraAPI) *GenerationHandler {
 	if cfg == nil {
 		cfg = &GenerationConfig{
-			ImageTimeout: 300,
-			VideoTimeout: 3000,
-			PollInterval: 2500 * time.Millisecond,
+			ImageTimeout:    300,
+			VideoTimeout:    3000,
+			PollInterval:    defaultPollInterval,
+			MaxPollInterval: defaultMaxPollInterval,
+			MaxPollAttempts: 600,
 		}
 	}
-	return &GenerationHandler{
+	if sora == nil {
+		client := NewSoraClient("", 120, nil)
+		client.SetFingerprintResolver(TokenFingerprints(db))
+		sora = client
+	}
+	h := &GenerationHandler{
 		db:           db,
-		soraClient:   NewSoraClient("", 120, nil),
+		soraClient:   sora,
+		poller:       NewTaskPoller(sora),
 		loadBalancer: lb,
 		tokenManager: tm,
-		config:       cfg,
+		running:      make(map[string]context.CancelCauseFunc),
+	}
+	h.config.Store(cfg)
+	return h
+}
+
+// SetConfig replaces the generation settings; running tasks pick them up on their next poll
+func (h *GenerationHandler) SetConfig(cfg *GenerationConfig) {
+	if cfg != nil {
+		h.config.Store(cfg)
+	}
+}
+
+// SetWebhookDispatcher sets the dispatcher used for task notifications
+func (h *GenerationHandler) SetWebhookDispatcher(d *WebhookDispatcher) {
+	h.webhooks = d
+}
+
+// SetProxyManager sets the proxy pool used for tasks of tokens without their own proxy
+func (h *GenerationHandler) SetProxyManager(pm *ProxyManager) {
+	h.proxyManager = pm
+}
+
+// SetTaskPoller sets the poller shared with other handlers polling the same Sora client
+func (h *GenerationHandler) SetTaskPoller(p *TaskPoller) {
+	if p != nil {
+		h.poller = p
+	}
+}
+
+// SetModerator sets the check prompts must pass before a token is used
+func (h *GenerationHandler) SetModerator(m PromptChecker) {
+	h.moderator = m
+}
+
+// SetTaskHub sets the hub that task progress and status changes are published to
+func (h *GenerationHandler) SetTaskHub(hub *TaskHub) {
+	h.taskHub = hub
+}
+
+// publish sends a task event to the task hub, if configured
+func (h *GenerationHandler) publish(event TaskEvent) {
+	if h.taskHub == nil {
+		return
+	}
+	h.taskHub.Publish(event)
+}
+
+// notifyTask sends a task event to the webhook dispatcher, if configured
+func (h *GenerationHandler) notifyTask(event string, task *models.Task) {
+	if h.webhooks == nil {
+		return
+	}
+	h.webhooks.Dispatch(event, TaskWebhookData(task))
+}
+
+// CancelTask cancels a running task. Returns false if the task is not running in this process.
+func (h *GenerationHandler) CancelTask(taskID string) bool {
+	h.runningMu.Lock()
+	cancel, ok := h.running[taskID]
+	h.runningMu.Unlock()
+	if ok {
+		cancel(ErrTaskCancelled)
+	}
+	return ok
+}
+
+// IsTaskRunning reports whether the task is being polled by this process
+func (h *GenerationHandler) IsTaskRunning(taskID string) bool {
+	h.runningMu.Lock()
+	defer h.runningMu.Unlock()
+	_, ok := h.running[taskID]
+	return ok
+}
+
+// MarkTaskCancelled marks a task that is not running in this process as cancelled
+func (h *GenerationHandler) MarkTaskCancelled(task *models.Task) error {
+	task.Status = models.TaskStatusCancelled
+	task.ErrorMessage = ErrTaskCancelled.Error()
+	now := time.Now()
+	task.CompletedAt = &now
+	if err := h.db.UpdateTask(task); err != nil {
+		return err
+	}
+	h.publish(TaskEvent{TaskID: task.TaskID, Type: TaskEventError, Status: task.Status, Progress: task.Progress, Error: task.ErrorMessage})
+	h.notifyTask(models.WebhookEventTaskCancelled, task)
+	return nil
+}
+
+func (h *GenerationHandler) trackTask(ctx context.Context, taskID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	h.runningMu.Lock()
+	h.running[taskID] = cancel
+	h.runningMu.Unlock()
+	return ctx, func() {
+		h.runningMu.Lock()
+		delete(h.running, taskID)
+		h.runningMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// RunningCount returns the number of tasks being polled by this process
+func (h *GenerationHandler) RunningCount() int {
+	h.runningMu.Lock()
+	defer h.runningMu.Unlock()
+	return len(h.running)
+}
+
+// BeginDrain stops accepting new generations
+func (h *GenerationHandler) BeginDrain() {
+	h.draining.Store(true)
+}
+
+// IsDraining reports whether the handler is shutting down
+func (h *GenerationHandler) IsDraining() bool {
+	return h.draining.Load()
+}
+
+// Drain stops accepting new generations and waits for running tasks to finish.
+// When ctx expires first, the remaining tasks are checkpointed: their polls are
+// stopped and they stay "processing" in the tasks table so ResumeTasks can pick
+// them up on the next start. Returns the number of checkpointed tasks.
+func (h *GenerationHandler) Drain(ctx context.Context) int {
+	h.BeginDrain()
+
+	if h.waitIdle(ctx) {
+		return 0
+	}
+
+	h.runningMu.Lock()
+	checkpointed := len(h.running)
+	for _, cancel := range h.running {
+		cancel(ErrShuttingDown)
+	}
+	h.runningMu.Unlock()
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), checkpointGrace)
+	defer cancel()
+	h.waitIdle(graceCtx)
+
+	return checkpointed
+}
+
+// waitIdle waits until no task is running, returning false if ctx expires first
+func (h *GenerationHandler) waitIdle(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for h.RunningCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// ResumeTasks resumes polling of tasks left "processing" by a previous run in the
+// background. Returns the number of resumed tasks.
+func (h *GenerationHandler) ResumeTasks() (int, error) {
+	tasks, err := h.db.GetTasksByStatus(models.TaskStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+
+	resumed := 0
+	for _, task := range tasks {
+		if h.IsTaskRunning(task.TaskID) {
+			continue
+		}
+
+		token, err := h.db.GetTokenByID(task.TokenID)
+		if err != nil {
+			task.Status = models.TaskStatusFailed
+			task.ErrorMessage = "恢复任务失败: Token 不存在"
+			h.db.UpdateTask(task)
+			continue
+		}
+
+		isVideo := ParseModel(task.Model).IsVideo
+		timeout := h.taskTimeout(isVideo) - time.Since(task.CreatedAt)
+		if timeout <= 0 {
+			h.finishTask(context.Background(), task, isVideo, nil, fmt.Errorf("generation timeout after %v", h.taskTimeout(isVideo)))
+			continue
+		}
+
+		pollCtx, untrack := h.trackTask(context.Background(), task.TaskID)
+		resumed++
+		go func(task *models.Task, token *models.Token) {
+			defer untrack()
+			result, err := h.pollTaskResult(pollCtx, task, token.Token, isVideo, TokenProxy(h.db, h.proxyManager, token), timeout, false, nil)
+			h.finishTask(pollCtx, task, isVideo, result, err)
+		}(task, token)
+	}
+
+	if resumed > 0 {
+		log.Printf("Resumed %d checkpointed tasks", resumed)
+	}
+	return resumed, nil
+}
+
+// taskTimeout returns the generation timeout for the media type
+func (h *GenerationHandler) taskTimeout(isVideo bool) time.Duration {
+	if isVideo {
+		return time.Duration(h.config.Load().VideoTimeout) * time.Second
 	}
+	return time.Duration(h.config.Load().ImageTimeout) * time.Second
 }
 
 // GenerationResult represents the result of a generation
@@ -55,6 +302,8 @@ type GenerationResult struct {
 	Progress float64  `json:"progress"`
 	URLs     []string `json:"urls,omitempty"`
 	Error    string   `json:"error,omitempty"`
+
+	GenerationID string `json:"generation_id,omitempty"` // Sora generation of a video, the target of remixes
 }
 
 // StreamEvent represents a streaming event
@@ -244,11 +493,91 @@ func (h *GenerationHandler) Generate(ctx context.Context, prompt, model string,
 
 // GenerateWithMedia starts a generation task with optional media data
 func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model, imageData, videoData, remixTargetID string, stream bool, eventChan chan<- StreamEvent) (*GenerationResult, error) {
+	var source *generationSource
+	if remixTargetID != "" {
+		source = &generationSource{
+			operation:    models.TaskOperationRemix,
+			generationID: remixTargetID,
+			parentTaskID: h.taskOfGeneration(remixTargetID),
+		}
+	}
+	return h.generate(ctx, prompt, model, source, nil, stream, eventChan)
+}
+
+// taskCreatedKey is the context key of the WithTaskCreated callback
+type taskCreatedKey struct{}
+
+// WithTaskCreated returns a context whose generations call fn with the task ID
+// once the task exists, before it is polled
+func WithTaskCreated(ctx context.Context, fn func(taskID string)) context.Context {
+	return context.WithValue(ctx, taskCreatedKey{}, fn)
+}
+
+// GenerateStoryboard starts a storyboard video from structured shots. The shot
+// durations must fill the video of the model.
+func (h *GenerationHandler) GenerateStoryboard(ctx context.Context, storyboard *Storyboard, model string, stream bool, eventChan chan<- StreamEvent) (*GenerationResult, error) {
+	return h.generate(ctx, storyboard.Prompt(), model, nil, storyboard, stream, eventChan)
+}
+
+// generate starts a generation task; a structured storyboard replaces the prompt,
+// a source makes it a remix or extension of an earlier video
+func (h *GenerationHandler) generate(ctx context.Context, prompt, model string, source *generationSource, storyboard *Storyboard, stream bool, eventChan chan<- StreamEvent) (*GenerationResult, error) {
+	if h.IsDraining() {
+		return nil, ErrShuttingDown
+	}
+
+	// Rejected prompts never reach Sora, so they cost no quota and no token health
+	if h.moderator != nil {
+		if err := h.moderator.CheckPrompt(ctx, prompt); err != nil {
+			return nil, err
+		}
+	}
+
 	// Parse model configuration
 	modelCfg := ParseModel(model)
+	if storyboard != nil {
+		if !modelCfg.IsVideo {
+			return nil, storyboardError("分镜需要使用视频模型，当前模型: %s", model)
+		}
+		if err := storyboard.Validate(modelCfg.NFrames); err != nil {
+			return nil, err
+		}
+	}
+	if source != nil && !modelCfg.IsVideo {
+		return nil, &SoraError{Kind: SoraErrorInvalidRequest, Code: "invalid_model", Message: fmt.Sprintf("Remix 和续写需要使用视频模型，当前模型: %s", model)}
+	}
+
+	// @mentioned characters become cameo references of a plain video generation,
+	// the characters of a structured storyboard those of the storyboard
+	var mentions *CharacterMentions
+	var usernames []string
+	if storyboard != nil {
+		usernames = storyboard.Characters()
+	} else if modelCfg.IsVideo && source == nil && !IsStoryboardPrompt(prompt) {
+		usernames = ParseMentions(prompt)
+	}
+	if len(usernames) > 0 {
+		var err error
+		if mentions, err = h.resolveLocalMentions(usernames); err != nil {
+			return nil, err
+		}
+	}
 
-	// Get a token from load balancer
-	token := h.loadBalancer.GetNextToken(!modelCfg.IsVideo, modelCfg.IsVideo)
+	// Get a token from load balancer, or one holding the private characters, or
+	// preferably the one that generated the source video
+	var token *models.Token
+	if mentions != nil && mentions.HasPrivate() {
+		var err error
+		if token, err = h.mentionToken(mentions); err != nil {
+			return nil, err
+		}
+	} else if source != nil && source.tokenID != 0 {
+		if token = h.loadBalancer.GetNextTokenAmong([]int64{source.tokenID}, false, true); token == nil {
+			token = h.loadBalancer.GetNextToken(false, true)
+		}
+	} else {
+		token = h.loadBalancer.GetNextToken(!modelCfg.IsVideo, modelCfg.IsVideo)
+	}
 	if token == nil {
 		tokenType := "图片"
 		if modelCfg.IsVideo {
@@ -258,13 +587,7 @@ func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model
 	}
 
 	// Get proxy URL from config
-	proxyURL := ""
-	if cfg, err := h.db.GetSystemConfig(); err == nil && cfg.ProxyEnabled {
-		proxyURL = cfg.ProxyURL
-	}
-	if token.ProxyURL != "" {
-		proxyURL = token.ProxyURL
-	}
+	proxyURL := TokenProxy(h.db, h.proxyManager, token)
 
 	// Get the access token to use
 	accessToken := token.Token
@@ -307,15 +630,38 @@ func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model
 	var err error
 
 	if modelCfg.IsVideo {
-		// Check for remix mode first
-		if remixTargetID != "" {
+		// Check for remix and extension first
+		if source != nil && source.operation == models.TaskOperationExtend {
+			if stream && eventChan != nil {
+				eventChan <- StreamEvent{Type: "progress", Progress: 0, Content: "检测到续写模式..."}
+			}
+			taskID, err = h.soraClient.ExtendVideo(
+				prompt, accessToken, modelCfg.Orientation, source.generationID,
+				modelCfg.NFrames, proxyURL,
+			)
+		} else if source != nil {
 			if stream && eventChan != nil {
 				eventChan <- StreamEvent{Type: "progress", Progress: 0, Content: "检测到 Remix 模式..."}
 			}
 			taskID, err = h.soraClient.RemixVideo(
-				prompt, accessToken, modelCfg.Orientation, remixTargetID,
+				prompt, accessToken, modelCfg.Orientation, source.generationID,
 				modelCfg.NFrames, modelCfg.Model, proxyURL,
 			)
+		} else if storyboard != nil {
+			var cameoIDs []string
+			if mentions != nil {
+				err = h.resolvePublicMentions(mentions, accessToken, proxyURL)
+				cameoIDs = mentions.CameoIDs
+			}
+			if err == nil {
+				if stream && eventChan != nil {
+					eventChan <- StreamEvent{Type: "progress", Progress: 0, Content: fmt.Sprintf("使用 Storyboard API 生成 %d 个镜头...", len(storyboard.Shots))}
+				}
+				taskID, err = h.soraClient.GenerateStructuredStoryboard(
+					mentions.rewriteStoryboard(storyboard), accessToken, modelCfg.Orientation,
+					modelCfg.NFrames, cameoIDs, proxyURL,
+				)
+			}
 		} else if IsStoryboardPrompt(prompt) {
 			// Check if prompt is in storyboard format
 			formattedPrompt := FormatStoryboardPrompt(prompt)
@@ -326,6 +672,16 @@ func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model
 				formattedPrompt, accessToken, modelCfg.Orientation, "",
 				modelCfg.NFrames, proxyURL,
 			)
+		} else if mentions != nil {
+			if err = h.resolvePublicMentions(mentions, accessToken, proxyURL); err == nil {
+				if stream && eventChan != nil && len(mentions.CameoIDs) > 0 {
+					eventChan <- StreamEvent{Type: "progress", Progress: 0, Content: fmt.Sprintf("检测到 %d 个角色引用...", len(mentions.CameoIDs))}
+				}
+				taskID, err = h.soraClient.GenerateVideoWithCameo(
+					mentions.rewrite(prompt), accessToken, modelCfg.Orientation, "",
+					modelCfg.NFrames, "", modelCfg.Model, modelCfg.Size, mentions.CameoIDs, proxyURL,
+				)
+			}
 		} else {
 			taskID, err = h.soraClient.GenerateVideo(
 				prompt, accessToken, modelCfg.Orientation, "",
@@ -339,10 +695,14 @@ func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model
 	}
 
 	if err != nil {
-		h.tokenManager.RecordError(token.ID)
-		return nil, fmt.Errorf("failed to start generation: %v", err)
+		h.tokenManager.RecordSoraError(token.ID, err)
+		return nil, fmt.Errorf("failed to start generation: %w", err)
 	}
 
+	// Track before the record exists so viewers never see an untracked processing task
+	pollCtx, untrack := h.trackTask(ctx, taskID)
+	defer untrack()
+
 	// Create task record
 	task := &models.Task{
 		TaskID:  taskID,
@@ -350,8 +710,20 @@ func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model
 		Model:   model,
 		Prompt:  prompt,
 		Status:  models.TaskStatusProcessing,
+
+		Operation: models.TaskOperationGenerate,
+	}
+	if source != nil {
+		task.Operation = source.operation
+		task.ParentTaskID = source.parentTaskID
 	}
-	h.db.CreateTask(task)
+	if id, err := h.db.CreateTask(task); err == nil {
+		task.ID = id
+	}
+	if fn, ok := ctx.Value(taskCreatedKey{}).(func(string)); ok {
+		fn(taskID)
+	}
+	h.publish(TaskEvent{TaskID: taskID, Type: TaskEventStatus, Status: task.Status, Message: "任务已创建，开始生成..."})
 
 	// Send initial progress
 	if stream && eventChan != nil {
@@ -359,23 +731,33 @@ func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model
 	}
 
 	// Poll for result
-	timeout := time.Duration(h.config.ImageTimeout) * time.Second
-	if modelCfg.IsVideo {
-		timeout = time.Duration(h.config.VideoTimeout) * time.Second
-	}
+	result, err := h.pollTaskResult(pollCtx, task, accessToken, modelCfg.IsVideo, proxyURL, h.taskTimeout(modelCfg.IsVideo), stream, eventChan)
+	return h.finishTask(pollCtx, task, modelCfg.IsVideo, result, err)
+}
 
-	result, err := h.pollTaskResult(ctx, taskID, accessToken, modelCfg.IsVideo, proxyURL, timeout, stream, eventChan)
+// finishTask records the outcome of a polled task, publishes it and notifies webhooks
+func (h *GenerationHandler) finishTask(pollCtx context.Context, task *models.Task, isVideo bool, result *GenerationResult, err error) (*GenerationResult, error) {
 	if err != nil {
-		h.tokenManager.RecordError(token.ID)
+		switch cause := context.Cause(pollCtx); {
+		case errors.Is(cause, ErrTaskCancelled):
+			h.MarkTaskCancelled(task)
+			return nil, ErrTaskCancelled
+		case errors.Is(cause, ErrShuttingDown):
+			// Checkpointed: leave the task processing so it is resumed on the next start
+			return nil, ErrShuttingDown
+		}
+		h.tokenManager.RecordSoraError(task.TokenID, err)
 		task.Status = models.TaskStatusFailed
 		task.ErrorMessage = err.Error()
 		h.db.UpdateTask(task)
+		h.publish(TaskEvent{TaskID: task.TaskID, Type: TaskEventError, Status: task.Status, Progress: task.Progress, Error: task.ErrorMessage})
+		h.notifyTask(models.WebhookEventTaskFailed, task)
 		return nil, err
 	}
 
 	// Record success
-	h.tokenManager.RecordUsage(token.ID, modelCfg.IsVideo)
-	h.tokenManager.RecordSuccess(token.ID, modelCfg.IsVideo)
+	h.tokenManager.RecordUsage(task.TokenID, isVideo)
+	h.tokenManager.RecordSuccess(task.TokenID, isVideo)
 
 	// Update task
 	task.Status = models.TaskStatusCompleted
@@ -385,38 +767,94 @@ func (h *GenerationHandler) GenerateWithMedia(ctx context.Context, prompt, model
 	}
 	now := time.Now()
 	task.CompletedAt = &now
+	task.Progress = 100
+	task.GenerationID = result.GenerationID
 	h.db.UpdateTask(task)
+	h.publish(TaskEvent{TaskID: task.TaskID, Type: TaskEventDone, Status: task.Status, Progress: 100, URLs: result.URLs})
+	h.notifyTask(models.WebhookEventTaskCompleted, task)
 
 	return result, nil
 }
 
-// pollTaskResult polls for task completion
-func (h *GenerationHandler) pollTaskResult(ctx context.Context, taskID, token string, isVideo bool, proxyURL string, timeout time.Duration, stream bool, eventChan chan<- StreamEvent) (*GenerationResult, error) {
-	startTime := time.Now()
-	pollInterval := h.config.PollInterval
-	if pollInterval == 0 {
-		pollInterval = 2500 * time.Millisecond
+// pollIntervals returns the base and maximum poll interval of the config
+func pollIntervals(cfg *GenerationConfig) (base, max time.Duration) {
+	base, max = cfg.PollInterval, cfg.MaxPollInterval
+	if base <= 0 {
+		base = defaultPollInterval
 	}
+	if max < base {
+		max = base
+	}
+	return base, max
+}
+
+// nextPollInterval polls at the base interval while progress advances and backs
+// off by half the interval per stalled poll, up to max
+func nextPollInterval(current, base, max time.Duration, progressed bool) time.Duration {
+	if progressed || current < base {
+		return base
+	}
+	if next := current + current/2; next < max {
+		return next
+	}
+	return max
+}
+
+// Polled progress is stored once it rose by progressSaveStep points or
+// progressSaveInterval passed, so task lists and restarts see it without a
+// database write on every poll
+const (
+	progressSaveStep     = 10
+	progressSaveInterval = 10 * time.Second
+)
+
+// pollTaskResult polls for task completion, storing the progress of the task record
+func (h *GenerationHandler) pollTaskResult(ctx context.Context, record *models.Task, token string, isVideo bool, proxyURL string, timeout time.Duration, stream bool, eventChan chan<- StreamEvent) (*GenerationResult, error) {
+	taskID := record.TaskID
+	startTime := time.Now()
+	pollInterval, _ := pollIntervals(h.config.Load())
 
 	lastProgress := float64(0)
+	progressed := true // Start at the base interval
+	savedAt := time.Now()
+
+	// Tasks on the same token share their recent_tasks requests
+	release := h.poller.Watch(token)
+	defer release()
 
-	for {
+	for attempt := 1; ; attempt++ {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
 		}
 
-		// Check timeout
+		// Check timeout and poll limit; settings changed by a reload apply from the next poll
+		cfg := h.config.Load()
 		if time.Since(startTime) > timeout {
 			return nil, fmt.Errorf("generation timeout after %v", timeout)
 		}
+		if cfg.MaxPollAttempts > 0 && attempt > cfg.MaxPollAttempts {
+			return nil, fmt.Errorf("generation not finished after %d polls", cfg.MaxPollAttempts)
+		}
+		base, max := pollIntervals(cfg)
+		pollInterval = nextPollInterval(pollInterval, base, max, progressed)
+		progressed = false
 
 		// Wait before polling
-		time.Sleep(pollInterval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
 
-		// Use recent_tasks for both image and video (more reliable, less Cloudflare issues)
-		task, err := h.soraClient.FindTaskInImageTasks(taskID, token, proxyURL)
+		// Use recent_tasks for both image and video (more reliable, less Cloudflare issues).
+		// A snapshot fetched for another task within the base interval is as fresh as our own.
+		task, err := h.poller.FindRecentTask(taskID, token, proxyURL, base)
+		if IsSoraError(err, SoraErrorAuth) {
+			// The token was revoked; further polls fail the same way
+			return nil, err
+		}
 		if err != nil {
 			continue
 		}
@@ -432,6 +870,14 @@ func (h *GenerationHandler) pollTaskResult(ctx context.Context, taskID, token st
 		progress := progressPct * 100
 		if progress > lastProgress {
 			lastProgress = progress
+			progressed = true
+			h.publish(TaskEvent{
+				TaskID:   taskID,
+				Type:     TaskEventProgress,
+				Status:   models.TaskStatusProcessing,
+				Progress: progress,
+				Message:  fmt.Sprintf("生成进度: %.0f%%", progress),
+			})
 			if stream && eventChan != nil {
 				eventChan <- StreamEvent{
 					Type:     "progress",
@@ -439,19 +885,31 @@ func (h *GenerationHandler) pollTaskResult(ctx context.Context, taskID, token st
 					Content:  fmt.Sprintf("生成进度: %.0f%%", progress),
 				}
 			}
+			if progress-record.Progress >= progressSaveStep || time.Since(savedAt) >= progressSaveInterval {
+				saved := record.Progress
+				record.Progress = progress
+				if err := h.db.UpdateTask(record); err != nil {
+					log.Printf("[Generation] Failed to save progress of task %s: %v", record.TaskID, err)
+					record.Progress = saved
+				} else {
+					savedAt = time.Now()
+				}
+			}
 		}
 
 		// Check if completed
 		if status == "succeeded" {
 			var urls []string
 
+			var generationID string
 			if isVideo {
-				// Extract video URL from generations
+				// Extract video URL and generation ID from generations
 				if gens, ok := task["generations"].([]interface{}); ok && len(gens) > 0 {
 					if gen, ok := gens[0].(map[string]interface{}); ok {
 						if videoURL, ok := gen["url"].(string); ok && videoURL != "" {
 							urls = append(urls, videoURL)
 						}
+						generationID, _ = gen["id"].(string)
 					}
 				}
 			} else {
@@ -468,6 +926,8 @@ func (h *GenerationHandler) pollTaskResult(ctx context.Context, taskID, token st
 					Status:   "completed",
 					Progress: 100,
 					URLs:     urls,
+
+					GenerationID: generationID,
 				}, nil
 			}
 		} else if status == "failed" {
@@ -475,7 +935,7 @@ func (h *GenerationHandler) pollTaskResult(ctx context.Context, taskID, token st
 			if msg, ok := task["error_message"].(string); ok && msg != "" {
 				errMsg = msg
 			}
-			return nil, fmt.Errorf("%s", errMsg)
+			return nil, newTaskError(errMsg)
 		}
 		// Still processing, continue polling
 	}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+func TestGenerationHandler_DrainRejectsNewGenerations(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	h := NewGenerationHandler(db, NewLoadBalancer(), NewTokenManager(db, nil, nil), nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if n := h.Drain(ctx); n != 0 {
+		t.Errorf("Expected 0 checkpointed tasks, got %d", n)
+	}
+	if !h.IsDraining() {
+		t.Error("Expected handler to be draining")
+	}
+
+	if _, err := h.Generate(context.Background(), "a cat", "sora-image", false, nil); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown, got %v", err)
+	}
+}
+
+func TestGenerationHandler_DrainCheckpointsRunningTasks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "t1", Email: "a@example.com", IsActive: true})
+	task := &models.Task{TaskID: "task_ckpt", TokenID: tokenID, Model: "sora-video", Prompt: "dog", Status: models.TaskStatusProcessing}
+	task.ID, _ = db.CreateTask(task)
+
+	h := NewGenerationHandler(db, NewLoadBalancer(), NewTokenManager(db, nil, nil), nil, nil)
+
+	// Simulate a poll that only stops when cancelled
+	pollCtx, untrack := h.trackTask(context.Background(), task.TaskID)
+	finished := make(chan error, 1)
+	go func() {
+		defer untrack()
+		<-pollCtx.Done()
+		_, err := h.finishTask(pollCtx, task, true, nil, pollCtx.Err())
+		finished <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if n := h.Drain(ctx); n != 1 {
+		t.Errorf("Expected 1 checkpointed task, got %d", n)
+	}
+
+	if err := <-finished; !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown, got %v", err)
+	}
+	if h.RunningCount() != 0 {
+		t.Errorf("Expected no running tasks, got %d", h.RunningCount())
+	}
+
+	stored, _ := db.GetTaskByTaskID(task.TaskID)
+	if stored.Status != models.TaskStatusProcessing {
+		t.Errorf("Expected checkpointed task to stay processing, got '%s'", stored.Status)
+	}
+	token, _ := db.GetTokenByID(tokenID)
+	if token.TotalErrorCount != 0 {
+		t.Errorf("Expected no error recorded for checkpointed task, got %d", token.TotalErrorCount)
+	}
+}
+
+func TestGenerationHandler_ResumeTasks_Expired(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tokenID, _ := db.CreateToken(&models.Token{Token: "t1", Email: "a@example.com", IsActive: true})
+	db.CreateTask(&models.Task{TaskID: "task_old", TokenID: tokenID, Model: "sora-image", Prompt: "cat", Status: models.TaskStatusProcessing})
+
+	// A zero image timeout means every checkpointed image task has expired
+	h := NewGenerationHandler(db, NewLoadBalancer(), NewTokenManager(db, nil, nil), &GenerationConfig{ImageTimeout: 0, VideoTimeout: 3000}, nil)
+
+	resumed, err := h.ResumeTasks()
+	if err != nil {
+		t.Fatalf("ResumeTasks failed: %v", err)
+	}
+	if resumed != 0 {
+		t.Errorf("Expected 0 resumed tasks, got %d", resumed)
+	}
+
+	stored, _ := db.GetTaskByTaskID("task_old")
+	if stored.Status != models.TaskStatusFailed {
+		t.Errorf("Expected expired task to be failed, got '%s'", stored.Status)
+	}
+}
+
+func TestSessionManager_Stop(t *testing.T) {
+	sm := NewSessionManager(30)
+	if _, err := sm.GetSession("token", "", nil); err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+
+	sm.Stop()
+	sm.Stop() // idempotent
+
+	if sm.GetSessionCount() != 0 {
+		t.Errorf("Expected sessions to be dropped, got %d", sm.GetSessionCount())
+	}
+}
+
+// newMockSora starts a mock Sora backend and returns a client pointed at it
+func newMockSora(t *testing.T, opts *mocksora.Options) (*SoraClient, *mocksora.Server) {
+	t.Helper()
+	mock := mocksora.NewServer(opts)
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+
+	client := NewSoraClient(server.URL+mocksora.BasePath, 30, nil)
+	client.SetSentinelURL(server.URL + mocksora.SentinelPath)
+	t.Cleanup(client.Close)
+	return client, mock
+}
+
+func TestGenerationHandler_GenerateWithMockSora(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	token := &models.Token{Token: "at_mock", Email: "a@example.com", IsActive: true, ImageEnabled: true, VideoEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, mock := newMockSora(t, &mocksora.Options{
+		Polls: 2,
+		Scenarios: []mocksora.Scenario{
+			{Match: "forbidden", Fail: "This content may violate our content policies."},
+			{Match: "busy", RejectStatus: 429, RejectBody: `{"error":{"message":"Too many requests"}}`},
+		},
+	})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+
+	events := make(chan StreamEvent, 16)
+	result, err := h.Generate(context.Background(), "a cat", "sora-image", true, events)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(result.URLs) != 1 || !strings.HasSuffix(result.URLs[0], ".png") {
+		t.Errorf("Expected one image URL, got %v", result.URLs)
+	}
+	stored, _ := db.GetTaskByTaskID(result.TaskID)
+	if stored == nil || stored.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected completed task record, got %+v", stored)
+	}
+	if polls := mock.Calls("GET /backend/v2/recent_tasks"); polls != 2 {
+		t.Errorf("Expected 2 polls, got %d", polls)
+	}
+
+	// Scripted failure while polling
+	if _, err := h.Generate(context.Background(), "forbidden dance", "sora-video-10s", false, nil); err == nil || !strings.Contains(err.Error(), "content policies") {
+		t.Errorf("Expected scripted task failure, got %v", err)
+	}
+
+	// Scripted rejection at creation
+	if _, err := h.Generate(context.Background(), "busy hour", "sora-video-10s", false, nil); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("Expected scripted rejection, got %v", err)
+	}
+}
+
+func TestGenerationHandler_StoresProgress(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	token := &models.Token{Token: "at_mock", Email: "a@example.com", IsActive: true, VideoEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, _ := newMockSora(t, &mocksora.Options{Scenarios: []mocksora.Scenario{{Polls: 5, Fail: "render error"}}})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+
+	var taskID string
+	ctx := WithTaskCreated(context.Background(), func(id string) { taskID = id })
+	if _, err := h.Generate(ctx, "a cat", "sora2-landscape-10s", false, nil); err == nil {
+		t.Fatal("Expected the scripted failure")
+	}
+	// The progress polled before the failure was stored
+	stored, _ := db.GetTaskByTaskID(taskID)
+	if stored == nil || stored.Status != models.TaskStatusFailed || stored.Progress < 60 {
+		t.Errorf("Expected failed task with its polled progress, got %+v", stored)
+	}
+}
+
+func TestNextPollInterval(t *testing.T) {
+	base, max := time.Second, 3*time.Second
+
+	interval := nextPollInterval(0, base, max, false)
+	if interval != base {
+		t.Fatalf("Expected first poll at the base interval, got %v", interval)
+	}
+	var stalled []time.Duration
+	for i := 0; i < 4; i++ {
+		interval = nextPollInterval(interval, base, max, false)
+		stalled = append(stalled, interval)
+	}
+	want := []time.Duration{1500 * time.Millisecond, 2250 * time.Millisecond, max, max}
+	for i := range want {
+		if stalled[i] != want[i] {
+			t.Errorf("Stalled poll %d: expected %v, got %v", i+1, want[i], stalled[i])
+		}
+	}
+	if got := nextPollInterval(interval, base, max, true); got != base {
+		t.Errorf("Expected progress to reset the interval, got %v", got)
+	}
+}
+
+func TestGenerationHandler_MaxPollAttempts(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	token := &models.Token{Token: "at_mock", Email: "a@example.com", IsActive: true, ImageEnabled: true, VideoEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, mock := newMockSora(t, &mocksora.Options{Polls: 5})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond, MaxPollAttempts: 2,
+	}, client)
+
+	if _, err := h.Generate(context.Background(), "a slow cat", "sora-image", false, nil); err == nil || !strings.Contains(err.Error(), "2 polls") {
+		t.Errorf("Expected poll limit error, got %v", err)
+	}
+	if polls := mock.Calls("GET /backend/v2/recent_tasks"); polls != 2 {
+		t.Errorf("Expected 2 polls, got %d", polls)
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// maxLineageDepth bounds the walks over parent links, which are never cyclic
+// unless the tasks table was edited by hand
+const maxLineageDepth = 100
+
+// generationSource is the earlier video a remix or extension starts from
+type generationSource struct {
+	operation    string // models.TaskOperationRemix or models.TaskOperationExtend
+	generationID string // Sora generation of the video
+	parentTaskID string // Local task of the video, empty when unknown
+	tokenID      int64  // Token that generated the video, 0 when unknown
+	model        string // Model of the video, the default of the new task
+}
+
+// Remix starts a remix of the video of an earlier task; the new task is
+// recorded as its child
+func (h *GenerationHandler) Remix(ctx context.Context, parentTaskID, prompt, model string, stream bool, eventChan chan<- StreamEvent) (*GenerationResult, error) {
+	source, err := h.sourceOfTask(parentTaskID, models.TaskOperationRemix)
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		model = source.model
+	}
+	return h.generate(ctx, prompt, model, source, nil, stream, eventChan)
+}
+
+// Extend starts a video continuing the video of an earlier task; the new task
+// is recorded as its child
+func (h *GenerationHandler) Extend(ctx context.Context, parentTaskID, prompt, model string, stream bool, eventChan chan<- StreamEvent) (*GenerationResult, error) {
+	source, err := h.sourceOfTask(parentTaskID, models.TaskOperationExtend)
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		model = source.model
+	}
+	return h.generate(ctx, prompt, model, source, nil, stream, eventChan)
+}
+
+// sourceOfTask resolves a task to the Sora generation of its video
+func (h *GenerationHandler) sourceOfTask(taskID, operation string) (*generationSource, error) {
+	task, err := h.db.GetTaskByTaskID(taskID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, &SoraError{Kind: SoraErrorNotFound, Code: "task_not_found", Message: fmt.Sprintf("任务 %s 不存在", taskID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if task.Status != models.TaskStatusCompleted {
+		return nil, &SoraError{Kind: SoraErrorInvalidRequest, Code: "task_not_completed", Message: fmt.Sprintf("任务 %s 尚未完成（%s），无法 Remix 或续写", taskID, task.Status)}
+	}
+	if !ParseModel(task.Model).IsVideo {
+		return nil, &SoraError{Kind: SoraErrorInvalidRequest, Code: "not_a_video", Message: fmt.Sprintf("任务 %s 不是视频任务", taskID)}
+	}
+	if task.GenerationID == "" {
+		return nil, &SoraError{Kind: SoraErrorInvalidRequest, Code: "generation_unknown", Message: fmt.Sprintf("任务 %s 没有记录 Sora 生成 ID，请使用 remix:<generation_id> 提示词", taskID)}
+	}
+	return &generationSource{
+		operation:    operation,
+		generationID: task.GenerationID,
+		parentTaskID: task.TaskID,
+		tokenID:      task.TokenID,
+		model:        task.Model,
+	}, nil
+}
+
+// taskOfGeneration returns the local task of a Sora generation, or "" when the
+// generation was not made here
+func (h *GenerationHandler) taskOfGeneration(generationID string) string {
+	task, err := h.db.GetTaskByGenerationID(generationID)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			log.Printf("[Generation] Failed to look up the task of generation %s: %v", generationID, err)
+		}
+		return ""
+	}
+	return task.TaskID
+}
+
+// LineageNode is a task with the remixes and extensions made from it
+type LineageNode struct {
+	*models.Task
+	Children []*LineageNode `json:"children,omitempty"`
+}
+
+// TaskLineage is the family of a task: the chain of tasks it derives from and
+// the tree of all iterations of its original video
+type TaskLineage struct {
+	TaskID    string         `json:"task_id"`
+	Ancestors []*models.Task `json:"ancestors"` // From the original video down to the parent
+	Tree      *LineageNode   `json:"tree"`      // Rooted at the original video
+}
+
+// Lineage returns the lineage of a task
+func (h *GenerationHandler) Lineage(taskID string) (*TaskLineage, error) {
+	task, err := h.db.GetTaskByTaskID(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk up to the original video; parents missing from the table end the walk
+	chain := []*models.Task{task}
+	seen := map[string]bool{task.TaskID: true}
+	for root := task; root.ParentTaskID != "" && len(chain) < maxLineageDepth; {
+		parent, err := h.db.GetTaskByTaskID(root.ParentTaskID)
+		if errors.Is(err, database.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seen[parent.TaskID] {
+			break
+		}
+		seen[parent.TaskID] = true
+		chain = append(chain, parent)
+		root = parent
+	}
+
+	lineage := &TaskLineage{TaskID: taskID, Ancestors: []*models.Task{}}
+	for i := len(chain) - 1; i > 0; i-- {
+		lineage.Ancestors = append(lineage.Ancestors, chain[i])
+	}
+	if lineage.Tree, err = h.lineageTree(chain[len(chain)-1], map[string]bool{}, 0); err != nil {
+		return nil, err
+	}
+	return lineage, nil
+}
+
+// lineageTree builds the tree of the iterations of a task
+func (h *GenerationHandler) lineageTree(task *models.Task, seen map[string]bool, depth int) (*LineageNode, error) {
+	node := &LineageNode{Task: task}
+	seen[task.TaskID] = true
+	if depth >= maxLineageDepth {
+		return node, nil
+	}
+	children, err := h.db.GetChildTasks(task.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if seen[child.TaskID] {
+			continue
+		}
+		childNode, err := h.lineageTree(child, seen, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, childNode)
+	}
+	return node, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+func TestGenerationHandler_RemixExtendLineage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	token := &models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true, VideoEnabled: true, ImageEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, mock := newMockSora(t, &mocksora.Options{Polls: 1})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+	ctx := context.Background()
+
+	root, err := h.Generate(ctx, "a cat", "sora2-landscape-10s", false, nil)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if root.GenerationID != "gen_"+root.TaskID {
+		t.Fatalf("Expected generation ID of the task, got %q", root.GenerationID)
+	}
+	rootTask, _ := db.GetTaskByTaskID(root.TaskID)
+	if rootTask.GenerationID != root.GenerationID || rootTask.Operation != models.TaskOperationGenerate {
+		t.Errorf("Expected generation ID and operation recorded, got %+v", rootTask)
+	}
+
+	remix, err := h.Remix(ctx, root.TaskID, "a dog instead", "", false, nil)
+	if err != nil {
+		t.Fatalf("Remix failed: %v", err)
+	}
+	if got := mock.TaskSource(remix.TaskID); got != root.GenerationID {
+		t.Errorf("Expected remix of %s, got %q", root.GenerationID, got)
+	}
+	extension, err := h.Extend(ctx, remix.TaskID, "the dog runs away", "", false, nil)
+	if err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+	if got := mock.TaskSource(extension.TaskID); got != remix.GenerationID {
+		t.Errorf("Expected extension of %s, got %q", remix.GenerationID, got)
+	}
+	if got := mock.Calls("POST /backend/nf/create/storyboard"); got != 1 {
+		t.Errorf("Expected the extension to be a storyboard, got %d storyboard requests", got)
+	}
+
+	// A remix: prompt of a generation made here is linked to its task too
+	chat, err := h.GenerateWithMedia(ctx, "remix: a bird", "sora2-landscape-10s", "", "", root.GenerationID, false, nil)
+	if err != nil {
+		t.Fatalf("GenerateWithMedia failed: %v", err)
+	}
+
+	extensionTask, _ := db.GetTaskByTaskID(extension.TaskID)
+	if extensionTask.ParentTaskID != remix.TaskID || extensionTask.Operation != models.TaskOperationExtend || extensionTask.Model != "sora2-landscape-10s" {
+		t.Errorf("Expected extension of the remix with the parent model, got %+v", extensionTask)
+	}
+
+	lineage, err := h.Lineage(extension.TaskID)
+	if err != nil {
+		t.Fatalf("Lineage failed: %v", err)
+	}
+	if len(lineage.Ancestors) != 2 || lineage.Ancestors[0].TaskID != root.TaskID || lineage.Ancestors[1].TaskID != remix.TaskID {
+		t.Errorf("Expected ancestors [root remix], got %+v", lineage.Ancestors)
+	}
+	tree := lineage.Tree
+	if tree.TaskID != root.TaskID || len(tree.Children) != 2 {
+		t.Fatalf("Expected root with 2 children, got %+v", tree)
+	}
+	if tree.Children[0].TaskID != remix.TaskID || tree.Children[1].TaskID != chat.TaskID {
+		t.Errorf("Expected children [remix chat], got %s %s", tree.Children[0].TaskID, tree.Children[1].TaskID)
+	}
+	if len(tree.Children[0].Children) != 1 || tree.Children[0].Children[0].TaskID != extension.TaskID {
+		t.Errorf("Expected the extension under the remix, got %+v", tree.Children[0].Children)
+	}
+
+	// Sources must be completed videos made here
+	var soraErr *SoraError
+	if _, err := h.Remix(ctx, "task_missing", "x", "", false, nil); !errors.As(err, &soraErr) || soraErr.Kind != SoraErrorNotFound {
+		t.Errorf("Expected not found, got %v", err)
+	}
+	image, err := h.Generate(ctx, "a picture", "sora-image", false, nil)
+	if err != nil {
+		t.Fatalf("Generate image failed: %v", err)
+	}
+	if _, err := h.Extend(ctx, image.TaskID, "x", "", false, nil); !errors.As(err, &soraErr) || soraErr.Kind != SoraErrorInvalidRequest {
+		t.Errorf("Expected image sources to be rejected, got %v", err)
+	}
+	db.CreateTask(&models.Task{TaskID: "task_running", TokenID: token.ID, Model: "sora2-landscape-10s", Prompt: "x", Status: models.TaskStatusProcessing})
+	if _, err := h.Remix(ctx, "task_running", "x", "", false, nil); !errors.As(err, &soraErr) || soraErr.Code != "task_not_completed" {
+		t.Errorf("Expected unfinished sources to be rejected, got %v", err)
+	}
+}
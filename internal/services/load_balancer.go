@@ -42,18 +42,47 @@ func (lb *LoadBalancer) GetNextToken(forImage, forVideo bool) *models.Token {
 	// Filter tokens based on capability
 	var eligible []*models.Token
 	for _, t := range lb.tokens {
-		if !t.IsActive || t.IsExpired {
-			continue
+		if tokenEligible(t, forImage, forVideo) {
+			eligible = append(eligible, t)
 		}
-		if forImage && !t.ImageEnabled {
-			continue
-		}
-		if forVideo && !t.VideoEnabled {
-			continue
+	}
+	return lb.pick(eligible)
+}
+
+// GetNextTokenAmong is GetNextToken limited to the tokens with the given IDs
+func (lb *LoadBalancer) GetNextTokenAmong(ids []int64, forImage, forVideo bool) *models.Token {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	allowed := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		allowed[id] = true
+	}
+	var eligible []*models.Token
+	for _, t := range lb.tokens {
+		if allowed[t.ID] && tokenEligible(t, forImage, forVideo) {
+			eligible = append(eligible, t)
 		}
-		eligible = append(eligible, t)
 	}
+	return lb.pick(eligible)
+}
+
+// tokenEligible reports whether the token is usable for the requested generation
+func tokenEligible(t *models.Token, forImage, forVideo bool) bool {
+	if !t.IsActive || t.IsExpired {
+		return false
+	}
+	if forImage && !t.ImageEnabled {
+		return false
+	}
+	if forVideo && !t.VideoEnabled {
+		return false
+	}
+	return true
+}
 
+// pick returns the next of the eligible tokens in round-robin order
+func (lb *LoadBalancer) pick(eligible []*models.Token) *models.Token {
 	if len(eligible) == 0 {
 		return nil
 	}
@@ -155,3 +155,22 @@ func TestLoadBalancer_GetTokenCount(t *testing.T) {
 		t.Errorf("Expected 2 tokens, got %d", lb.GetTokenCount())
 	}
 }
+
+func TestLoadBalancer_GetNextTokenAmong(t *testing.T) {
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{
+		{ID: 1, Token: "token1", IsActive: true, VideoEnabled: true},
+		{ID: 2, Token: "token2", IsActive: true, VideoEnabled: false},
+		{ID: 3, Token: "token3", IsActive: true, VideoEnabled: true},
+	})
+
+	for i := 0; i < 4; i++ {
+		token := lb.GetNextTokenAmong([]int64{2, 3}, false, true)
+		if token == nil || token.ID != 3 {
+			t.Fatalf("Expected token 3, got %+v", token)
+		}
+	}
+	if token := lb.GetNextTokenAmong([]int64{2, 9}, false, true); token != nil {
+		t.Errorf("Expected no eligible token, got %+v", token)
+	}
+}
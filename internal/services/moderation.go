@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"soranow/internal/config"
+)
+
+// PromptChecker screens a prompt before it is sent to Sora. A rejected prompt
+// returns a *SoraError of kind SoraErrorContentPolicy.
+type PromptChecker interface {
+	CheckPrompt(ctx context.Context, prompt string) error
+}
+
+// ContentPolicyCode is the OpenAI error code of prompts rejected by moderation
+const ContentPolicyCode = "content_policy_violation"
+
+// newPolicyError returns the error of a prompt rejected before reaching Sora
+func newPolicyError(reason string) *SoraError {
+	return &SoraError{
+		Kind:    SoraErrorContentPolicy,
+		Code:    ContentPolicyCode,
+		Message: "提示词未通过内容审核: " + reason,
+	}
+}
+
+// ModerationRule is a keyword (case-insensitive substring) or a regular expression
+type ModerationRule struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// ParseModerationRules parses one rule per line: a keyword, or a regular
+// expression between slashes. Blank lines and lines starting with # are skipped.
+func ParseModerationRules(r io.Reader) ([]ModerationRule, error) {
+	var rules []ModerationRule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := ModerationRule{Pattern: text}
+		if len(text) > 2 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") {
+			re, err := regexp.Compile("(?i)" + text[1:len(text)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			rule.re = re
+		} else {
+			rule.Pattern = strings.ToLower(text)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Match reports whether the prompt hits the rule
+func (r ModerationRule) Match(prompt string) bool {
+	if r.re != nil {
+		return r.re.MatchString(prompt)
+	}
+	return strings.Contains(strings.ToLower(prompt), r.Pattern)
+}
+
+// RuleChecker rejects prompts matching a local rule
+type RuleChecker struct {
+	rules []ModerationRule
+}
+
+// NewRuleChecker creates a checker of the rules
+func NewRuleChecker(rules []ModerationRule) *RuleChecker {
+	return &RuleChecker{rules: rules}
+}
+
+// LoadRuleChecker reads the rules file; a missing file has no rules
+func LoadRuleChecker(path string) (*RuleChecker, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewRuleChecker(nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := ParseModerationRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewRuleChecker(rules), nil
+}
+
+// CheckPrompt rejects the prompt if it matches any rule
+func (c *RuleChecker) CheckPrompt(ctx context.Context, prompt string) error {
+	for _, rule := range c.rules {
+		if rule.Match(prompt) {
+			return newPolicyError("命中本地审核规则")
+		}
+	}
+	return nil
+}
+
+// RemoteChecker asks an OpenAI-compatible moderation endpoint
+// (POST {"input": prompt} -> {"results": [{"flagged", "categories"}]})
+type RemoteChecker struct {
+	endpoint   string
+	apiKey     string
+	failClosed bool
+	client     *http.Client
+}
+
+// NewRemoteChecker creates a checker of the endpoint. With failClosed, prompts
+// are rejected while the endpoint cannot be reached; otherwise they pass.
+func NewRemoteChecker(endpoint, apiKey string, timeout time.Duration, failClosed bool) *RemoteChecker {
+	return &RemoteChecker{
+		endpoint:   endpoint,
+		apiKey:     apiKey,
+		failClosed: failClosed,
+		client:     &http.Client{Timeout: timeout},
+	}
+}
+
+// CheckPrompt rejects the prompt if the endpoint flags it
+func (c *RemoteChecker) CheckPrompt(ctx context.Context, prompt string) error {
+	categories, flagged, err := c.moderate(ctx, prompt)
+	if err != nil {
+		if c.failClosed {
+			return newPolicyError("审核服务不可用")
+		}
+		log.Printf("[Moderation] Endpoint check skipped: %v", err)
+		return nil
+	}
+	if !flagged {
+		return nil
+	}
+	if len(categories) == 0 {
+		return newPolicyError("flagged")
+	}
+	return newPolicyError(strings.Join(categories, ", "))
+}
+
+// moderate returns the flagged categories of the prompt
+func (c *RemoteChecker) moderate(ctx context.Context, prompt string) ([]string, bool, error) {
+	body, _ := json.Marshal(map[string]string{"input": prompt})
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, false, fmt.Errorf("moderation endpoint returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, false, fmt.Errorf("invalid moderation response: %v", err)
+	}
+
+	var categories []string
+	flagged := false
+	for _, r := range result.Results {
+		flagged = flagged || r.Flagged
+		for category, hit := range r.Categories {
+			if hit {
+				categories = append(categories, category)
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories, flagged, nil
+}
+
+// Moderator runs the configured prompt checks in order. While disabled every
+// prompt passes.
+type Moderator struct {
+	mu       sync.RWMutex
+	checkers []PromptChecker
+}
+
+// NewModerator creates a moderator without checks
+func NewModerator() *Moderator {
+	return &Moderator{}
+}
+
+// SetCheckers replaces the checks, e.g. with a custom PromptChecker
+func (m *Moderator) SetCheckers(checkers ...PromptChecker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkers = checkers
+}
+
+// Configure builds the checks from the moderation settings: the rules file,
+// then the endpoint if set. If the rules file cannot be read the previous
+// checks are kept.
+func (m *Moderator) Configure(cfg config.ModerationConfig) error {
+	if !cfg.Enabled {
+		m.SetCheckers()
+		return nil
+	}
+
+	rules, err := LoadRuleChecker(cfg.RulesFile)
+	if err != nil {
+		return err
+	}
+	checkers := []PromptChecker{rules}
+	if cfg.Endpoint != "" {
+		checkers = append(checkers, NewRemoteChecker(cfg.Endpoint, cfg.APIKey, time.Duration(cfg.Timeout)*time.Second, cfg.FailClosed))
+	}
+	m.SetCheckers(checkers...)
+	return nil
+}
+
+// CheckPrompt runs the checks, returning the first rejection
+func (m *Moderator) CheckPrompt(ctx context.Context, prompt string) error {
+	m.mu.RLock()
+	checkers := m.checkers
+	m.mu.RUnlock()
+
+	for _, checker := range checkers {
+		if err := checker.CheckPrompt(ctx, prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
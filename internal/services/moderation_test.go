@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"soranow/internal/config"
+	"soranow/internal/models"
+)
+
+func TestParseModerationRules(t *testing.T) {
+	rules, err := ParseModerationRules(strings.NewReader("# comment\n\nGore\n/\\bblood\\s*bath\\b/\n"))
+	if err != nil {
+		t.Fatalf("ParseModerationRules failed: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+
+	checker := NewRuleChecker(rules)
+	for prompt, rejected := range map[string]bool{
+		"a GORE scene":         true,
+		"a Blood Bath":         true,
+		"bloodbathing puppies": false,
+		"a cat on a sofa":      false,
+	} {
+		err := checker.CheckPrompt(context.Background(), prompt)
+		if (err != nil) != rejected {
+			t.Errorf("%q: expected rejected=%v, got %v", prompt, rejected, err)
+		}
+		if err != nil && !IsSoraError(err, SoraErrorContentPolicy) {
+			t.Errorf("%q: expected content policy error, got %v", prompt, err)
+		}
+	}
+
+	if _, err := ParseModerationRules(strings.NewReader("/([/\n")); err == nil {
+		t.Error("Expected invalid regex error")
+	}
+}
+
+func TestRemoteChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Input string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		flagged := strings.Contains(req.Input, "violent")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []interface{}{map[string]interface{}{
+				"flagged":    flagged,
+				"categories": map[string]bool{"violence": flagged, "sexual": false},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	checker := NewRemoteChecker(server.URL, "sk-test", time.Second, false)
+	if err := checker.CheckPrompt(context.Background(), "a cat"); err != nil {
+		t.Errorf("Expected clean prompt to pass, got %v", err)
+	}
+	err := checker.CheckPrompt(context.Background(), "a violent fight")
+	if !IsSoraError(err, SoraErrorContentPolicy) || !strings.Contains(err.Error(), "violence") {
+		t.Errorf("Expected violence rejection, got %v", err)
+	}
+
+	// An unreachable endpoint lets prompts pass unless failing closed
+	if err := NewRemoteChecker(server.URL, "wrong", time.Second, false).CheckPrompt(context.Background(), "a cat"); err != nil {
+		t.Errorf("Expected fail-open, got %v", err)
+	}
+	if err := NewRemoteChecker(server.URL, "wrong", time.Second, true).CheckPrompt(context.Background(), "a cat"); err == nil {
+		t.Error("Expected fail-closed rejection")
+	}
+}
+
+func TestModerator_Configure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	os.WriteFile(path, []byte("forbidden\n"), 0644)
+
+	m := NewModerator()
+	if err := m.Configure(config.ModerationConfig{Enabled: true, RulesFile: path, Timeout: 1}); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	if err := m.CheckPrompt(context.Background(), "something forbidden"); err == nil {
+		t.Error("Expected rule rejection")
+	}
+
+	// A broken rules file keeps the previous rules
+	os.WriteFile(path, []byte("/([/\n"), 0644)
+	if err := m.Configure(config.ModerationConfig{Enabled: true, RulesFile: path, Timeout: 1}); err == nil {
+		t.Error("Expected rules error")
+	}
+	if err := m.CheckPrompt(context.Background(), "something forbidden"); err == nil {
+		t.Error("Expected previous rules to be kept")
+	}
+
+	m.Configure(config.ModerationConfig{Enabled: false})
+	if err := m.CheckPrompt(context.Background(), "something forbidden"); err != nil {
+		t.Errorf("Expected disabled moderation to pass, got %v", err)
+	}
+}
+
+func TestGenerationHandler_ModerationRejectsBeforeSora(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	token := &models.Token{Token: "at_mock", Email: "a@example.com", IsActive: true, ImageEnabled: true, VideoEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, mock := newMockSora(t, nil)
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+	rules, _ := ParseModerationRules(strings.NewReader("forbidden"))
+	h.SetModerator(NewRuleChecker(rules))
+
+	_, err := h.Generate(context.Background(), "a forbidden dance", "sora-image", false, nil)
+	soraErr, ok := AsSoraError(err)
+	if !ok || soraErr.Kind != SoraErrorContentPolicy || soraErr.Code != ContentPolicyCode {
+		t.Fatalf("Expected content policy violation, got %v", err)
+	}
+	if calls := mock.Calls("POST /backend/video_gen"); calls != 0 {
+		t.Errorf("Expected no Sora request, got %d", calls)
+	}
+	if stored, _ := db.GetTokenByID(token.ID); stored.ConsecutiveErrors != 0 {
+		t.Errorf("Expected no token error, got %d", stored.ConsecutiveErrors)
+	}
+
+	if _, err := h.Generate(context.Background(), "a cat", "sora-image", false, nil); err != nil {
+		t.Errorf("Expected clean prompt to generate, got %v", err)
+	}
+}
@@ -0,0 +1,639 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrMP4Unsupported is returned for MP4 files ConcatMP4 cannot join, e.g.
+// fragmented files or clips encoded differently
+var ErrMP4Unsupported = errors.New("unsupported mp4")
+
+// mp4Containers are the boxes on the path to the sample tables whose children are parsed
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "edts": true, "mvex": true,
+}
+
+// mp4Box is a parsed box of the movie header; container boxes keep their children
+type mp4Box struct {
+	typ      string
+	data     []byte // Payload of leaf boxes
+	children []*mp4Box
+}
+
+// child returns the first child box of the given type
+func (b *mp4Box) child(typ string) *mp4Box {
+	for _, c := range b.children {
+		if c.typ == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+// path returns the descendant box at the given path of types, nil if missing
+func (b *mp4Box) path(types ...string) *mp4Box {
+	for _, typ := range types {
+		if b = b.child(typ); b == nil {
+			return nil
+		}
+	}
+	return b
+}
+
+// remove drops the child boxes of the given types
+func (b *mp4Box) remove(types ...string) {
+	kept := b.children[:0]
+	for _, c := range b.children {
+		drop := false
+		for _, typ := range types {
+			drop = drop || c.typ == typ
+		}
+		if !drop {
+			kept = append(kept, c)
+		}
+	}
+	b.children = kept
+}
+
+// set replaces the payload of the child box of the given type, adding the box if missing
+func (b *mp4Box) set(typ string, data []byte) {
+	if c := b.child(typ); c != nil {
+		c.data = data
+		return
+	}
+	b.children = append(b.children, &mp4Box{typ: typ, data: data})
+}
+
+// bytes encodes the box
+func (b *mp4Box) bytes() []byte {
+	payload := b.data
+	if mp4Containers[b.typ] {
+		var buf bytes.Buffer
+		for _, c := range b.children {
+			buf.Write(c.bytes())
+		}
+		payload = buf.Bytes()
+	}
+	out := binary.BigEndian.AppendUint32(make([]byte, 0, 8+len(payload)), uint32(8+len(payload)))
+	out = append(out, b.typ...)
+	return append(out, payload...)
+}
+
+// parseMP4Boxes parses a sequence of boxes held in memory
+func parseMP4Boxes(data []byte) ([]*mp4Box, error) {
+	var boxes []*mp4Box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("%w: truncated box", ErrMP4Unsupported)
+		}
+		size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		typ := string(data[4:8])
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("%w: truncated %s box", ErrMP4Unsupported, typ)
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: invalid size of %s box", ErrMP4Unsupported, typ)
+		}
+
+		b := &mp4Box{typ: typ, data: data[header:size]}
+		if mp4Containers[typ] {
+			children, err := parseMP4Boxes(b.data)
+			if err != nil {
+				return nil, err
+			}
+			b.data, b.children = nil, children
+		}
+		boxes = append(boxes, b)
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// mp4Range is a byte range of a file
+type mp4Range struct {
+	start, end int64
+}
+
+// mp4Track holds the sample tables of a track
+type mp4Track struct {
+	handler     string
+	timescale   uint32
+	duration    uint64 // In the media timescale
+	stsd        []byte
+	stts        [][2]uint32 // Sample count, delta
+	ctts        [][2]uint32 // Sample count, composition offset
+	cttsVersion byte
+	stsc        [][3]uint32 // First chunk, samples per chunk, sample description index
+	sizes       []uint32
+	chunks      []uint64 // File offsets
+	sync        []uint32 // nil when every sample is a sync sample
+}
+
+// samples returns the number of samples of the track
+func (t *mp4Track) samples() uint32 {
+	return uint32(len(t.sizes))
+}
+
+// mp4Clip is one parsed input of ConcatMP4
+type mp4Clip struct {
+	r         *io.SectionReader
+	ftyp      []byte
+	moov      *mp4Box
+	timescale uint32 // Movie timescale
+	duration  uint64 // In the movie timescale
+	tracks    []*mp4Track
+	mdats     []mp4Range // Payloads of the media data boxes
+}
+
+// parseMP4Clip reads the movie header of a file and the location of its media data
+func parseMP4Clip(r *io.SectionReader) (*mp4Clip, error) {
+	clip := &mp4Clip{r: r}
+	var header [16]byte
+	for offset := int64(0); offset < r.Size(); {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("%w: truncated box at %d", ErrMP4Unsupported, offset)
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header[:])), int64(8)
+		typ := string(header[4:8])
+		switch size {
+		case 0:
+			size = r.Size() - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("%w: truncated %s box", ErrMP4Unsupported, typ)
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize || offset+size > r.Size() {
+			return nil, fmt.Errorf("%w: invalid size of %s box", ErrMP4Unsupported, typ)
+		}
+
+		switch typ {
+		case "ftyp", "moov":
+			data := make([]byte, size)
+			if _, err := r.ReadAt(data, offset); err != nil {
+				return nil, err
+			}
+			if typ == "ftyp" {
+				clip.ftyp = data
+				break
+			}
+			boxes, err := parseMP4Boxes(data)
+			if err != nil {
+				return nil, err
+			}
+			clip.moov = boxes[0]
+		case "mdat":
+			clip.mdats = append(clip.mdats, mp4Range{offset + headerSize, offset + size})
+		case "moof":
+			return nil, fmt.Errorf("%w: fragmented files are not supported", ErrMP4Unsupported)
+		}
+		offset += size
+	}
+	if clip.moov == nil {
+		return nil, fmt.Errorf("%w: no moov box", ErrMP4Unsupported)
+	}
+	if clip.moov.child("mvex") != nil {
+		return nil, fmt.Errorf("%w: fragmented files are not supported", ErrMP4Unsupported)
+	}
+
+	mvhd := clip.moov.child("mvhd")
+	if mvhd == nil {
+		return nil, fmt.Errorf("%w: no mvhd box", ErrMP4Unsupported)
+	}
+	var err error
+	if clip.timescale, clip.duration, err = mp4HeaderTiming(mvhd.data); err != nil {
+		return nil, err
+	}
+	if clip.timescale == 0 {
+		return nil, fmt.Errorf("%w: zero movie timescale", ErrMP4Unsupported)
+	}
+	for _, b := range clip.moov.children {
+		if b.typ != "trak" {
+			continue
+		}
+		track, err := parseMP4Track(b)
+		if err != nil {
+			return nil, err
+		}
+		clip.tracks = append(clip.tracks, track)
+	}
+	if len(clip.tracks) == 0 {
+		return nil, fmt.Errorf("%w: no tracks", ErrMP4Unsupported)
+	}
+	return clip, nil
+}
+
+// mp4HeaderTiming reads the timescale and duration of an mvhd or mdhd box
+func mp4HeaderTiming(data []byte) (uint32, uint64, error) {
+	if len(data) >= 32 && data[0] == 1 {
+		return binary.BigEndian.Uint32(data[20:]), binary.BigEndian.Uint64(data[24:]), nil
+	}
+	if len(data) >= 20 && data[0] == 0 {
+		return binary.BigEndian.Uint32(data[12:]), uint64(binary.BigEndian.Uint32(data[16:])), nil
+	}
+	return 0, 0, fmt.Errorf("%w: invalid header box", ErrMP4Unsupported)
+}
+
+// setMP4Duration writes the duration of an mvhd, mdhd or tkhd box in place
+func setMP4Duration(typ string, data []byte, duration uint64) error {
+	offset := 16 // mvhd and mdhd: creation and modification time, timescale
+	if typ == "tkhd" {
+		offset = 20 // creation and modification time, track ID, reserved
+	}
+	if len(data) > 0 && data[0] == 1 {
+		offset += 8
+		if len(data) < offset+8 {
+			return fmt.Errorf("%w: truncated %s box", ErrMP4Unsupported, typ)
+		}
+		binary.BigEndian.PutUint64(data[offset:], duration)
+		return nil
+	}
+	if len(data) < offset+4 {
+		return fmt.Errorf("%w: truncated %s box", ErrMP4Unsupported, typ)
+	}
+	if duration > math.MaxUint32 {
+		return fmt.Errorf("%w: %s duration overflows", ErrMP4Unsupported, typ)
+	}
+	binary.BigEndian.PutUint32(data[offset:], uint32(duration))
+	return nil
+}
+
+// mp4Table returns the entries of a sample table box: the payload after the
+// version, flags and the fields before the entry count, checked to hold count
+// entries of entrySize bytes
+func mp4Table(b *mp4Box, skip, entrySize int) ([]byte, uint32, error) {
+	data := b.data
+	if len(data) < 8+skip {
+		return nil, 0, fmt.Errorf("%w: truncated %s box", ErrMP4Unsupported, b.typ)
+	}
+	count := binary.BigEndian.Uint32(data[4+skip:])
+	entries := data[8+skip:]
+	if uint64(len(entries)) < uint64(count)*uint64(entrySize) {
+		return nil, 0, fmt.Errorf("%w: truncated %s box", ErrMP4Unsupported, b.typ)
+	}
+	return entries, count, nil
+}
+
+// parseMP4Track reads the sample tables of a trak box
+func parseMP4Track(trak *mp4Box) (*mp4Track, error) {
+	mdhd := trak.path("mdia", "mdhd")
+	hdlr := trak.path("mdia", "hdlr")
+	stbl := trak.path("mdia", "minf", "stbl")
+	if mdhd == nil || hdlr == nil || stbl == nil || len(hdlr.data) < 12 {
+		return nil, fmt.Errorf("%w: incomplete track", ErrMP4Unsupported)
+	}
+	if stbl.child("stz2") != nil {
+		return nil, fmt.Errorf("%w: compact sample sizes are not supported", ErrMP4Unsupported)
+	}
+
+	track := &mp4Track{handler: string(hdlr.data[8:12])}
+	var err error
+	if track.timescale, track.duration, err = mp4HeaderTiming(mdhd.data); err != nil {
+		return nil, err
+	}
+	if track.timescale == 0 {
+		return nil, fmt.Errorf("%w: zero media timescale", ErrMP4Unsupported)
+	}
+
+	stsd, stts, stsc, stsz := stbl.child("stsd"), stbl.child("stts"), stbl.child("stsc"), stbl.child("stsz")
+	if stsd == nil || stts == nil || stsc == nil || stsz == nil {
+		return nil, fmt.Errorf("%w: incomplete sample table", ErrMP4Unsupported)
+	}
+	track.stsd = stsd.data
+
+	entries, count, err := mp4Table(stts, 0, 8)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < count; i++ {
+		track.stts = append(track.stts, [2]uint32{binary.BigEndian.Uint32(entries[i*8:]), binary.BigEndian.Uint32(entries[i*8+4:])})
+	}
+
+	if ctts := stbl.child("ctts"); ctts != nil {
+		if entries, count, err = mp4Table(ctts, 0, 8); err != nil {
+			return nil, err
+		}
+		track.cttsVersion = ctts.data[0]
+		for i := uint32(0); i < count; i++ {
+			track.ctts = append(track.ctts, [2]uint32{binary.BigEndian.Uint32(entries[i*8:]), binary.BigEndian.Uint32(entries[i*8+4:])})
+		}
+	}
+
+	if entries, count, err = mp4Table(stsc, 0, 12); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < count; i++ {
+		track.stsc = append(track.stsc, [3]uint32{
+			binary.BigEndian.Uint32(entries[i*12:]), binary.BigEndian.Uint32(entries[i*12+4:]), binary.BigEndian.Uint32(entries[i*12+8:]),
+		})
+	}
+
+	// A non-zero sample size applies to every sample
+	if len(stsz.data) < 12 {
+		return nil, fmt.Errorf("%w: truncated stsz box", ErrMP4Unsupported)
+	}
+	if uniform := binary.BigEndian.Uint32(stsz.data[4:]); uniform != 0 {
+		count := binary.BigEndian.Uint32(stsz.data[8:])
+		track.sizes = make([]uint32, count)
+		for i := range track.sizes {
+			track.sizes[i] = uniform
+		}
+	} else {
+		if entries, count, err = mp4Table(stsz, 4, 4); err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < count; i++ {
+			track.sizes = append(track.sizes, binary.BigEndian.Uint32(entries[i*4:]))
+		}
+	}
+
+	if stco := stbl.child("stco"); stco != nil {
+		if entries, count, err = mp4Table(stco, 0, 4); err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < count; i++ {
+			track.chunks = append(track.chunks, uint64(binary.BigEndian.Uint32(entries[i*4:])))
+		}
+	} else if co64 := stbl.child("co64"); co64 != nil {
+		if entries, count, err = mp4Table(co64, 0, 8); err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < count; i++ {
+			track.chunks = append(track.chunks, binary.BigEndian.Uint64(entries[i*8:]))
+		}
+	} else {
+		return nil, fmt.Errorf("%w: no chunk offsets", ErrMP4Unsupported)
+	}
+
+	if stss := stbl.child("stss"); stss != nil {
+		if entries, count, err = mp4Table(stss, 0, 4); err != nil {
+			return nil, err
+		}
+		track.sync = []uint32{}
+		for i := uint32(0); i < count; i++ {
+			track.sync = append(track.sync, binary.BigEndian.Uint32(entries[i*4:]))
+		}
+	}
+	return track, nil
+}
+
+// mapOffset moves a file offset of the clip into the joined media data, whose
+// payload holds the media data of the previous clips (base bytes) followed by
+// the media data boxes of this clip
+func (c *mp4Clip) mapOffset(offset uint64, base int64) (uint64, bool) {
+	for _, m := range c.mdats {
+		if int64(offset) >= m.start && int64(offset) < m.end {
+			return uint64(base + int64(offset) - m.start), true
+		}
+		base += m.end - m.start
+	}
+	return 0, false
+}
+
+// mediaSize returns the size of the media data of the clip
+func (c *mp4Clip) mediaSize() int64 {
+	var size int64
+	for _, m := range c.mdats {
+		size += m.end - m.start
+	}
+	return size
+}
+
+// ConcatMP4 joins MP4 clips into one MP4 without re-encoding. The clips need the
+// same tracks with the same codec configuration, as produced by one encoder; the
+// media data is copied as is and the sample tables are joined. Edit lists and
+// sample groups of the clips are dropped. Errors about the input wrap ErrMP4Unsupported.
+func ConcatMP4(w io.Writer, inputs []*io.SectionReader) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("%w: no clips", ErrMP4Unsupported)
+	}
+	clips := make([]*mp4Clip, len(inputs))
+	for i, r := range inputs {
+		clip, err := parseMP4Clip(r)
+		if err != nil {
+			return fmt.Errorf("clip %d: %w", i+1, err)
+		}
+		clips[i] = clip
+	}
+
+	first := clips[0]
+	for i, clip := range clips[1:] {
+		if len(clip.tracks) != len(first.tracks) {
+			return fmt.Errorf("%w: clip %d has %d tracks, clip 1 has %d", ErrMP4Unsupported, i+2, len(clip.tracks), len(first.tracks))
+		}
+		for j, track := range clip.tracks {
+			want := first.tracks[j]
+			if track.handler != want.handler || track.timescale != want.timescale || !bytes.Equal(track.stsd, want.stsd) {
+				return fmt.Errorf("%w: track %d of clip %d is encoded differently from clip 1", ErrMP4Unsupported, j+1, i+2)
+			}
+		}
+	}
+
+	var mediaSize int64
+	for _, clip := range clips {
+		mediaSize += clip.mediaSize()
+	}
+	mdatHeader := int64(8)
+	if mediaSize+8 > math.MaxUint32 {
+		mdatHeader = 16
+	}
+	ftyp := first.ftyp
+	if ftyp == nil {
+		ftyp = (&mp4Box{typ: "ftyp", data: []byte("isom\x00\x00\x02\x00isomiso2mp41")}).bytes()
+	}
+
+	// Chunk offsets are written as 64 bits, so the size of the movie header does
+	// not depend on them: build it once to learn where the media data starts
+	moov, err := joinMP4Movie(clips, 0)
+	if err != nil {
+		return err
+	}
+	if moov, err = joinMP4Movie(clips, int64(len(ftyp)+len(moov))+mdatHeader); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(ftyp); err != nil {
+		return err
+	}
+	if _, err := w.Write(moov); err != nil {
+		return err
+	}
+	var header []byte
+	if mdatHeader == 16 {
+		header = binary.BigEndian.AppendUint32(header, 1)
+		header = append(header, "mdat"...)
+		header = binary.BigEndian.AppendUint64(header, uint64(mediaSize+16))
+	} else {
+		header = binary.BigEndian.AppendUint32(header, uint32(mediaSize+8))
+		header = append(header, "mdat"...)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, clip := range clips {
+		for _, m := range clip.mdats {
+			if _, err := io.Copy(w, io.NewSectionReader(clip.r, m.start, m.end-m.start)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// joinMP4Movie builds the movie header of the joined clips, whose media data
+// payload starts at mediaStart
+func joinMP4Movie(clips []*mp4Clip, mediaStart int64) ([]byte, error) {
+	boxes, err := parseMP4Boxes(clips[0].moov.bytes())
+	if err != nil {
+		return nil, err
+	}
+	moov := boxes[0]
+
+	var movieDuration uint64
+	for _, clip := range clips {
+		movieDuration += clip.duration * uint64(clips[0].timescale) / uint64(clip.timescale)
+	}
+	if err := setMP4Duration("mvhd", moov.child("mvhd").data, movieDuration); err != nil {
+		return nil, err
+	}
+
+	trackIndex := 0
+	for _, trak := range moov.children {
+		if trak.typ != "trak" {
+			continue
+		}
+		joined, err := joinMP4Track(clips, trackIndex, mediaStart)
+		if err != nil {
+			return nil, err
+		}
+		trackIndex++
+
+		// Edit lists describe the timeline of one clip only
+		trak.remove("edts")
+		var mediaDuration uint64
+		for _, s := range joined.stts {
+			mediaDuration += uint64(s[0]) * uint64(s[1])
+		}
+		if err := setMP4Duration("mdhd", trak.path("mdia", "mdhd").data, mediaDuration); err != nil {
+			return nil, err
+		}
+		if tkhd := trak.child("tkhd"); tkhd != nil {
+			trackDuration := mediaDuration * uint64(clips[0].timescale) / uint64(joined.timescale)
+			if err := setMP4Duration("tkhd", tkhd.data, trackDuration); err != nil {
+				return nil, err
+			}
+		}
+
+		stbl := trak.path("mdia", "minf", "stbl")
+		stbl.remove("stco", "co64", "ctts", "stss", "sdtp", "sbgp", "sgpd", "subs", "saiz", "saio")
+		stbl.set("stts", mp4Entries(0, len(joined.stts), func(b []byte, i int) []byte {
+			b = binary.BigEndian.AppendUint32(b, joined.stts[i][0])
+			return binary.BigEndian.AppendUint32(b, joined.stts[i][1])
+		}))
+		if joined.ctts != nil {
+			stbl.set("ctts", mp4Entries(joined.cttsVersion, len(joined.ctts), func(b []byte, i int) []byte {
+				b = binary.BigEndian.AppendUint32(b, joined.ctts[i][0])
+				return binary.BigEndian.AppendUint32(b, joined.ctts[i][1])
+			}))
+		}
+		stbl.set("stsc", mp4Entries(0, len(joined.stsc), func(b []byte, i int) []byte {
+			b = binary.BigEndian.AppendUint32(b, joined.stsc[i][0])
+			b = binary.BigEndian.AppendUint32(b, joined.stsc[i][1])
+			return binary.BigEndian.AppendUint32(b, joined.stsc[i][2])
+		}))
+		stsz := binary.BigEndian.AppendUint32(make([]byte, 4, 12+4*len(joined.sizes)), 0)
+		stsz = binary.BigEndian.AppendUint32(stsz, uint32(len(joined.sizes)))
+		for _, size := range joined.sizes {
+			stsz = binary.BigEndian.AppendUint32(stsz, size)
+		}
+		stbl.set("stsz", stsz)
+		stbl.set("co64", mp4Entries(0, len(joined.chunks), func(b []byte, i int) []byte {
+			return binary.BigEndian.AppendUint64(b, joined.chunks[i])
+		}))
+		if joined.sync != nil {
+			stbl.set("stss", mp4Entries(0, len(joined.sync), func(b []byte, i int) []byte {
+				return binary.BigEndian.AppendUint32(b, joined.sync[i])
+			}))
+		}
+	}
+	return moov.bytes(), nil
+}
+
+// mp4Entries encodes the payload of a sample table box
+func mp4Entries(version byte, count int, entry func([]byte, int) []byte) []byte {
+	b := []byte{version, 0, 0, 0}
+	b = binary.BigEndian.AppendUint32(b, uint32(count))
+	for i := 0; i < count; i++ {
+		b = entry(b, i)
+	}
+	return b
+}
+
+// joinMP4Track joins the sample tables of one track of every clip
+func joinMP4Track(clips []*mp4Clip, index int, mediaStart int64) (*mp4Track, error) {
+	joined := &mp4Track{timescale: clips[0].tracks[index].timescale}
+	withCtts, withSync := false, false
+	for _, clip := range clips {
+		withCtts = withCtts || clip.tracks[index].ctts != nil
+		withSync = withSync || clip.tracks[index].sync != nil
+	}
+	if withSync {
+		joined.sync = []uint32{}
+	}
+
+	base := mediaStart
+	var samples, chunks uint32
+	for i, clip := range clips {
+		track := clip.tracks[index]
+		joined.stts = append(joined.stts, track.stts...)
+		joined.sizes = append(joined.sizes, track.sizes...)
+
+		if withCtts {
+			ctts := track.ctts
+			if ctts == nil {
+				ctts = [][2]uint32{{track.samples(), 0}}
+			}
+			if track.cttsVersion > joined.cttsVersion {
+				joined.cttsVersion = track.cttsVersion
+			}
+			joined.ctts = append(joined.ctts, ctts...)
+		}
+		for _, entry := range track.stsc {
+			joined.stsc = append(joined.stsc, [3]uint32{entry[0] + chunks, entry[1], entry[2]})
+		}
+		for _, chunk := range track.chunks {
+			offset, ok := clip.mapOffset(chunk, base)
+			if !ok {
+				return nil, fmt.Errorf("%w: clip %d has a chunk outside its media data", ErrMP4Unsupported, i+1)
+			}
+			joined.chunks = append(joined.chunks, offset)
+		}
+		if withSync {
+			if track.sync == nil {
+				for s := uint32(1); s <= track.samples(); s++ {
+					joined.sync = append(joined.sync, s+samples)
+				}
+			}
+			for _, s := range track.sync {
+				joined.sync = append(joined.sync, s+samples)
+			}
+		}
+
+		samples += track.samples()
+		chunks += uint32(len(track.chunks))
+		base += clip.mediaSize()
+	}
+	return joined, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"soranow/internal/mocksora"
+)
+
+func TestConcatMP4(t *testing.T) {
+	a, b := mocksora.MP4("a", 30), mocksora.MP4("b", 15)
+	var out bytes.Buffer
+	if err := ConcatMP4(&out, []*io.SectionReader{
+		io.NewSectionReader(bytes.NewReader(a), 0, int64(len(a))),
+		io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))),
+	}); err != nil {
+		t.Fatalf("ConcatMP4 failed: %v", err)
+	}
+	if string(out.Bytes()[4:8]) != "ftyp" {
+		t.Errorf("Expected output to start with ftyp, got %q", out.Bytes()[4:8])
+	}
+
+	clip, err := parseMP4Clip(io.NewSectionReader(bytes.NewReader(out.Bytes()), 0, int64(out.Len())))
+	if err != nil {
+		t.Fatalf("Failed to parse output: %v", err)
+	}
+	if clip.duration != 1500 {
+		t.Errorf("Expected movie duration 1500, got %d", clip.duration)
+	}
+	track := clip.tracks[0]
+	if track.samples() != 45 || track.duration != 45 {
+		t.Errorf("Expected 45 samples lasting 45, got %d lasting %d", track.samples(), track.duration)
+	}
+	if len(track.sync) != 2 || track.sync[0] != 1 || track.sync[1] != 31 {
+		t.Errorf("Expected sync samples [1 31], got %v", track.sync)
+	}
+
+	// Every sample is found where the joined tables point
+	sample := 0
+	for chunk, offset := range track.chunks {
+		perChunk := track.stsc[len(track.stsc)-1][1]
+		for _, entry := range track.stsc {
+			if uint32(chunk+1) >= entry[0] {
+				perChunk = entry[1]
+			}
+		}
+		for i := uint32(0); i < perChunk; i++ {
+			want := fmt.Sprintf("a#%d;", sample)
+			if sample >= 30 {
+				want = fmt.Sprintf("b#%d;", sample-30)
+			}
+			size := uint64(track.sizes[sample])
+			if got := string(out.Bytes()[offset : offset+size]); got != want {
+				t.Fatalf("Sample %d: expected %q, got %q", sample+1, want, got)
+			}
+			offset += size
+			sample++
+		}
+	}
+	if sample != 45 {
+		t.Errorf("Expected 45 samples in the chunks, got %d", sample)
+	}
+}
+
+func TestConcatMP4_Unsupported(t *testing.T) {
+	a, junk := mocksora.MP4("a", 30), []byte("not an mp4 at all")
+	err := ConcatMP4(io.Discard, []*io.SectionReader{
+		io.NewSectionReader(bytes.NewReader(a), 0, int64(len(a))),
+		io.NewSectionReader(bytes.NewReader(junk), 0, int64(len(junk))),
+	})
+	if !errors.Is(err, ErrMP4Unsupported) {
+		t.Errorf("Expected ErrMP4Unsupported, got %v", err)
+	}
+}
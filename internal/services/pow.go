@@ -152,7 +152,7 @@ func GetPowToken(userAgent string) string {
 	configList := GetPowConfig(userAgent)
 	seed := fmt.Sprintf("%f", rand.Float64())
 	difficulty := "0fffff"
-	solution, _ := SolvePow(seed, difficulty, configList)
+	solution, _ := defaultPowPool.Solve(seed, difficulty, configList)
 	return "gAAAAAC" + solution
 }
 
@@ -167,7 +167,7 @@ func BuildSentinelToken(flow, reqID, powToken string, resp map[string]interface{
 			difficulty, _ := proofofwork["difficulty"].(string)
 			if seed != "" && difficulty != "" {
 				configList := GetPowConfig(userAgent)
-				solution, _ := SolvePow(seed, difficulty, configList)
+				solution, _ := defaultPowPool.Solve(seed, difficulty, configList)
 				finalPowToken = "gAAAAAB" + solution
 			}
 		}
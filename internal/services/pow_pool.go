@@ -0,0 +1,124 @@
+package services
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// PowStats reports proof-of-work solving since startup
+type PowStats struct {
+	Workers   int     `json:"workers"`
+	Running   int     `json:"running"`
+	Waiting   int     `json:"waiting"`
+	Solves    int64   `json:"solves"`
+	Failures  int64   `json:"failures"` // No solution within POWMaxIteration
+	AvgMs     float64 `json:"avg_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	LastMs    float64 `json:"last_ms"`
+	AvgWaitMs float64 `json:"avg_wait_ms"` // Time spent queued for a worker
+}
+
+// PowPool bounds how many proofs of work are solved at once, so a burst of
+// sentinel requests cannot starve the process of CPU, and records solve times
+type PowPool struct {
+	mu      sync.Mutex
+	slots   chan struct{}
+	running int
+	waiting int
+
+	solves    int64
+	failures  int64
+	totalTime time.Duration
+	totalWait time.Duration
+	maxTime   time.Duration
+	lastTime  time.Duration
+}
+
+// NewPowPool creates a pool of workers (0 or less: one per CPU)
+func NewPowPool(workers int) *PowPool {
+	p := &PowPool{}
+	p.SetWorkers(workers)
+	return p
+}
+
+// SetWorkers resizes the pool (0 or less: one per CPU). Solves already running
+// finish on the previous slots.
+func (p *PowPool) SetWorkers(workers int) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.slots == nil || cap(p.slots) != workers {
+		p.slots = make(chan struct{}, workers)
+	}
+}
+
+// Solve runs SolvePow on a pool worker, waiting for a free one
+func (p *PowPool) Solve(seed, difficulty string, configList []interface{}) (string, bool) {
+	p.mu.Lock()
+	slots := p.slots
+	p.waiting++
+	p.mu.Unlock()
+
+	queued := time.Now()
+	slots <- struct{}{}
+	wait := time.Since(queued)
+
+	p.mu.Lock()
+	p.waiting--
+	p.running++
+	p.mu.Unlock()
+
+	start := time.Now()
+	solution, ok := SolvePow(seed, difficulty, configList)
+	elapsed := time.Since(start)
+	<-slots
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running--
+	p.solves++
+	if !ok {
+		p.failures++
+	}
+	p.totalTime += elapsed
+	p.totalWait += wait
+	p.lastTime = elapsed
+	if elapsed > p.maxTime {
+		p.maxTime = elapsed
+	}
+	return solution, ok
+}
+
+// Stats returns the pool's metrics
+func (p *PowPool) Stats() PowStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := PowStats{
+		Workers:  cap(p.slots),
+		Running:  p.running,
+		Waiting:  p.waiting,
+		Solves:   p.solves,
+		Failures: p.failures,
+		MaxMs:    durationMs(p.maxTime),
+		LastMs:   durationMs(p.lastTime),
+	}
+	if p.solves > 0 {
+		stats.AvgMs = durationMs(p.totalTime) / float64(p.solves)
+		stats.AvgWaitMs = durationMs(p.totalWait) / float64(p.solves)
+	}
+	return stats
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+var defaultPowPool = NewPowPool(0)
+
+// DefaultPowPool returns the process-wide pool used for sentinel proofs of work
+func DefaultPowPool() *PowPool {
+	return defaultPowPool
+}
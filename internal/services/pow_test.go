@@ -113,3 +113,23 @@ func TestHashSHA3_512(t *testing.T) {
 		t.Error("Same input should produce same hash")
 	}
 }
+
+func TestPowPool_Stats(t *testing.T) {
+	pool := NewPowPool(2)
+	config := GetPowConfig("Mozilla/5.0")
+	for i := 0; i < 3; i++ {
+		if _, ok := pool.Solve("seed", "0fffff", config); !ok {
+			t.Fatal("Expected easy difficulty to be solved")
+		}
+	}
+
+	stats := pool.Stats()
+	if stats.Workers != 2 || stats.Solves != 3 || stats.Failures != 0 || stats.Running != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	pool.SetWorkers(0)
+	if stats := pool.Stats(); stats.Workers < 1 {
+		t.Errorf("Expected one worker per CPU, got %d", stats.Workers)
+	}
+}
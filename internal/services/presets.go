@@ -0,0 +1,335 @@
+package services
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// defaultPresets holds the templates and styles the web UI shipped with
+//
+//go:embed default_presets.json
+var defaultPresets []byte
+
+// presetsSeededKey marks that the default presets were stored, so deleted ones stay deleted
+const presetsSeededKey = "_seeded.presets"
+
+// PresetBundleVersion is the version of the import/export format
+const PresetBundleVersion = 1
+
+// StyleNone is the style ID that disables the default style of a template
+const StyleNone = "none"
+
+// presetIDPattern matches template and style IDs
+var presetIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// templateVariablePattern matches a {name} placeholder of a template
+var templateVariablePattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// PresetBundle is the import/export format of templates and styles
+type PresetBundle struct {
+	Version   int                      `json:"version"`
+	Templates []*models.PromptTemplate `json:"templates"`
+	Styles    []*models.StylePreset    `json:"styles"`
+}
+
+// DefaultPresets returns the built-in templates and styles
+func DefaultPresets() (*PresetBundle, error) {
+	var bundle PresetBundle
+	if err := json.Unmarshal(defaultPresets, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid default presets: %w", err)
+	}
+	return &bundle, nil
+}
+
+// SeedPresets stores the built-in templates and styles in a new database. Runs once.
+func SeedPresets(db *database.DB) error {
+	overrides, err := db.GetConfigOverrides()
+	if err != nil {
+		return err
+	}
+	if _, done := overrides[presetsSeededKey]; done {
+		return nil
+	}
+
+	bundle, err := DefaultPresets()
+	if err != nil {
+		return err
+	}
+	result, err := ImportPresets(db, bundle, false)
+	if err != nil {
+		return err
+	}
+	log.Printf("[Presets] Stored %d default templates and %d styles", result.Templates, result.Styles)
+	return db.SetConfigOverride(presetsSeededKey, "true")
+}
+
+// ValidateTemplate checks a template before it is stored
+func ValidateTemplate(tpl *models.PromptTemplate) error {
+	if !presetIDPattern.MatchString(tpl.ID) {
+		return fmt.Errorf("无效的模板 ID %q，只能包含小写字母、数字、- 和 _", tpl.ID)
+	}
+	if strings.TrimSpace(tpl.Name) == "" {
+		return errors.New("模板名称不能为空")
+	}
+	if len(tpl.Shots) == 0 {
+		return errors.New("模板至少需要一个镜头")
+	}
+	for i, shot := range tpl.Shots {
+		if strings.TrimSpace(shot.Prompt) == "" {
+			return fmt.Errorf("第 %d 个镜头缺少描述", i+1)
+		}
+		if shot.Duration <= 0 {
+			return fmt.Errorf("第 %d 个镜头的时长必须大于 0", i+1)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, v := range tpl.Variables {
+		if !templateVariablePattern.MatchString("{" + v.Name + "}") {
+			return fmt.Errorf("无效的变量名 %q", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("变量 %s 重复", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	if tpl.Style != "" && tpl.Style != StyleNone && !presetIDPattern.MatchString(tpl.Style) {
+		return fmt.Errorf("无效的风格 ID %q", tpl.Style)
+	}
+	return nil
+}
+
+// ValidateStyle checks a style preset before it is stored
+func ValidateStyle(style *models.StylePreset) error {
+	if !presetIDPattern.MatchString(style.ID) || style.ID == StyleNone {
+		return fmt.Errorf("无效的风格 ID %q，只能包含小写字母、数字、- 和 _", style.ID)
+	}
+	if strings.TrimSpace(style.Name) == "" {
+		return errors.New("风格名称不能为空")
+	}
+	if strings.TrimSpace(style.PromptSuffix) == "" {
+		return errors.New("风格提示词不能为空")
+	}
+	return nil
+}
+
+// PresetImportError is a template or style that was not imported
+type PresetImportError struct {
+	Kind  string `json:"kind"` // template or style
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// PresetImportResult counts the imported templates and styles
+type PresetImportResult struct {
+	Templates int                 `json:"templates"`
+	Styles    int                 `json:"styles"`
+	Failed    []PresetImportError `json:"failed,omitempty"`
+}
+
+// ImportPresets stores the templates and styles of a bundle, replacing those
+// with the same IDs. Invalid entries are reported and skipped. With replace,
+// the existing templates and styles are deleted first. Nothing is stored when
+// saving fails.
+func ImportPresets(db *database.DB, bundle *PresetBundle, replace bool) (*PresetImportResult, error) {
+	if bundle.Version > PresetBundleVersion {
+		return nil, fmt.Errorf("不支持的预设版本 %d", bundle.Version)
+	}
+
+	result := &PresetImportResult{}
+	var styles []*models.StylePreset
+	for _, style := range bundle.Styles {
+		if err := ValidateStyle(style); err != nil {
+			result.Failed = append(result.Failed, PresetImportError{Kind: "style", ID: style.ID, Error: err.Error()})
+			continue
+		}
+		styles = append(styles, style)
+	}
+	var templates []*models.PromptTemplate
+	for _, tpl := range bundle.Templates {
+		if err := ValidateTemplate(tpl); err != nil {
+			result.Failed = append(result.Failed, PresetImportError{Kind: "template", ID: tpl.ID, Error: err.Error()})
+			continue
+		}
+		templates = append(templates, tpl)
+	}
+
+	if err := db.SavePresets(templates, styles, replace); err != nil {
+		return nil, err
+	}
+	result.Templates = len(templates)
+	result.Styles = len(styles)
+	return result, nil
+}
+
+// ExportPresets returns all templates and styles as a bundle
+func ExportPresets(db *database.DB) (*PresetBundle, error) {
+	templates, err := db.GetPromptTemplates()
+	if err != nil {
+		return nil, err
+	}
+	styles, err := db.GetStylePresets()
+	if err != nil {
+		return nil, err
+	}
+	if templates == nil {
+		templates = []*models.PromptTemplate{}
+	}
+	if styles == nil {
+		styles = []*models.StylePreset{}
+	}
+	return &PresetBundle{Version: PresetBundleVersion, Templates: templates, Styles: styles}, nil
+}
+
+// presetError returns the error of an unusable template or style, a client error
+func presetError(code, format string, args ...interface{}) *SoraError {
+	return &SoraError{Kind: SoraErrorInvalidRequest, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ExpandTemplate builds the storyboard of a template. Placeholders take the
+// value of the variable, then the variable default; {character} also takes the
+// character, which joins every shot when the template has no such placeholder.
+func ExpandTemplate(tpl *models.PromptTemplate, variables map[string]string, character string) (*Storyboard, error) {
+	character = strings.TrimPrefix(strings.TrimSpace(character), "@")
+	values := make(map[string]string)
+	for _, v := range tpl.Variables {
+		if v.Default != "" {
+			values[v.Name] = v.Default
+		}
+	}
+	if character != "" {
+		values["character"] = character
+	}
+	for name, value := range variables {
+		values[name] = value
+	}
+	if value, ok := values["character"]; ok {
+		values["character"] = strings.TrimPrefix(strings.TrimSpace(value), "@")
+	}
+
+	missing := make(map[string]bool)
+	usesCharacter := false
+	expand := func(text string) string {
+		return templateVariablePattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+			name := placeholder[1 : len(placeholder)-1]
+			if name == "character" {
+				usesCharacter = true
+			}
+			value, ok := values[name]
+			if !ok {
+				missing[name] = true
+			}
+			return value
+		})
+	}
+
+	sb := &Storyboard{Title: tpl.Name}
+	for _, shot := range tpl.Shots {
+		expanded := StoryboardShot{Duration: shot.Duration, Prompt: expand(shot.Prompt)}
+		for _, username := range shot.Characters {
+			if username = strings.TrimPrefix(expand(username), "@"); username != "" {
+				expanded.Characters = append(expanded.Characters, username)
+			}
+		}
+		sb.Shots = append(sb.Shots, expanded)
+	}
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, presetError("missing_variables", "模板 %s 缺少变量: %s", tpl.ID, strings.Join(names, ", "))
+	}
+	if character != "" && !usesCharacter {
+		for i := range sb.Shots {
+			sb.Shots[i].Characters = append(sb.Shots[i].Characters, character)
+		}
+	}
+	return sb, nil
+}
+
+// ApplyStyle appends the prompt suffix of a style; an empty prompt becomes the suffix
+func ApplyStyle(prompt string, style *models.StylePreset) string {
+	if style == nil || style.PromptSuffix == "" {
+		return prompt
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return style.PromptSuffix
+	}
+	return prompt + ", " + style.PromptSuffix
+}
+
+// PresetOptions are the template and style parameters of the generation APIs
+type PresetOptions struct {
+	TemplateID string            `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"` // Values of the template placeholders
+	Character  string            `json:"character,omitempty"` // Username for {character}, or added to every shot
+	Style      string            `json:"style,omitempty"`     // Style preset ID; "none" disables the template style
+}
+
+// ApplyPresets expands the template and style of the options. A template
+// replaces the storyboard; a style is appended to the storyboard instructions,
+// or to the prompt without a storyboard. Unknown templates and styles are
+// client errors.
+func ApplyPresets(db *database.DB, opts *PresetOptions, prompt string, sb *Storyboard) (string, *Storyboard, error) {
+	styleID := opts.Style
+	if opts.TemplateID != "" {
+		if sb != nil && len(sb.Shots) > 0 {
+			return "", nil, presetError("invalid_template", "模板和分镜镜头不能同时使用")
+		}
+		tpl, err := db.GetPromptTemplate(opts.TemplateID)
+		if errors.Is(err, database.ErrNotFound) {
+			return "", nil, presetError("unknown_template", "模板 %s 不存在", opts.TemplateID)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		expanded, err := ExpandTemplate(tpl, opts.Variables, opts.Character)
+		if err != nil {
+			return "", nil, err
+		}
+		if sb != nil {
+			// Title and instructions of the request override those of the template
+			if sb.Title != "" {
+				expanded.Title = sb.Title
+			}
+			expanded.Instructions = sb.Instructions
+		}
+		sb = expanded
+		if styleID == "" && tpl.Style != "" {
+			styleID = tpl.Style
+			if _, err := db.GetStylePreset(styleID); errors.Is(err, database.ErrNotFound) {
+				log.Printf("[Presets] Template %s uses unknown style %s, ignored", tpl.ID, styleID)
+				styleID = ""
+			}
+		}
+	}
+
+	if styleID == "" || styleID == StyleNone {
+		return prompt, sb, nil
+	}
+	style, err := db.GetStylePreset(styleID)
+	if errors.Is(err, database.ErrNotFound) {
+		return "", nil, presetError("unknown_style", "风格 %s 不存在", styleID)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if sb != nil {
+		sb.Instructions = ApplyStyle(sb.Instructions, style)
+		return prompt, sb, nil
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return prompt, nil, nil
+	}
+	return ApplyStyle(prompt, style), nil, nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"soranow/internal/models"
+)
+
+func TestSeedPresets(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := SeedPresets(db); err != nil {
+		t.Fatalf("SeedPresets failed: %v", err)
+	}
+	templates, _ := db.GetPromptTemplates()
+	styles, _ := db.GetStylePresets()
+	if len(templates) < 20 || len(styles) < 9 {
+		t.Fatalf("Expected the default templates and styles, got %d and %d", len(templates), len(styles))
+	}
+	for _, tpl := range templates {
+		if err := ValidateTemplate(tpl); err != nil {
+			t.Errorf("Default template %s is invalid: %v", tpl.ID, err)
+		}
+	}
+
+	// Deleted defaults are not seeded again
+	db.DeleteStylePreset("anime")
+	if err := SeedPresets(db); err != nil {
+		t.Fatalf("SeedPresets failed: %v", err)
+	}
+	if _, err := db.GetStylePreset("anime"); err == nil {
+		t.Error("Expected deleted default style to stay deleted")
+	}
+}
+
+func TestDefaultTemplatesFitVideos(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if err := SeedPresets(db); err != nil {
+		t.Fatalf("SeedPresets failed: %v", err)
+	}
+
+	templates, _ := db.GetPromptTemplates()
+	for _, tpl := range templates {
+		variables := map[string]string{}
+		for _, v := range tpl.Variables {
+			variables[v.Name] = "x"
+		}
+		_, sb, err := ApplyPresets(db, &PresetOptions{TemplateID: tpl.ID, Variables: variables, Character: "kitty"}, "", nil)
+		if err != nil {
+			t.Errorf("%s: ApplyPresets failed: %v", tpl.ID, err)
+			continue
+		}
+		nFrames := ClipFrames(sb.Frames())
+		sb.Fill(nFrames)
+		if err := sb.Validate(nFrames); err != nil {
+			t.Errorf("%s: expected a valid %d-frame storyboard, got %v", tpl.ID, nFrames, err)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tpl := &models.PromptTemplate{
+		ID: "intro", Name: "Intro",
+		Shots: []models.TemplateShot{
+			{Duration: 5, Prompt: "@{character} walks into {place}"},
+			{Duration: 5, Prompt: "{place} at {time}"},
+		},
+		Variables: []models.TemplateVariable{{Name: "place"}, {Name: "time", Default: "night"}},
+	}
+
+	sb, err := ExpandTemplate(tpl, map[string]string{"place": "Paris"}, "@kitty")
+	if err != nil {
+		t.Fatalf("ExpandTemplate failed: %v", err)
+	}
+	if sb.Title != "Intro" || sb.Shots[0].Prompt != "@kitty walks into Paris" || sb.Shots[1].Prompt != "Paris at night" {
+		t.Errorf("Unexpected storyboard %+v", sb)
+	}
+	if got := sb.Characters(); !reflect.DeepEqual(got, []string{"kitty"}) {
+		t.Errorf("Expected character kitty, got %v", got)
+	}
+
+	var soraErr *SoraError
+	if _, err := ExpandTemplate(tpl, nil, ""); !errors.As(err, &soraErr) || soraErr.Code != "missing_variables" ||
+		!strings.Contains(err.Error(), "character, place") {
+		t.Errorf("Expected missing character and place, got %v", err)
+	}
+
+	// Without a placeholder the character joins every shot
+	plain := &models.PromptTemplate{ID: "plain", Name: "Plain", Shots: []models.TemplateShot{{Duration: 5, Prompt: "a beach"}}}
+	sb, err = ExpandTemplate(plain, nil, "kitty")
+	if err != nil {
+		t.Fatalf("ExpandTemplate failed: %v", err)
+	}
+	if !reflect.DeepEqual(sb.Shots[0].Characters, []string{"kitty"}) || sb.Shots[0].Prompt != "a beach" {
+		t.Errorf("Expected kitty in the shot, got %+v", sb.Shots[0])
+	}
+}
+
+func TestApplyPresets(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.SaveStylePreset(&models.StylePreset{ID: "anime", Name: "Anime", PromptSuffix: "anime style"})
+	db.SaveStylePreset(&models.StylePreset{ID: "retro", Name: "Retro", PromptSuffix: "retro 80s"})
+	db.SavePromptTemplate(&models.PromptTemplate{
+		ID: "beach", Name: "Beach", Style: "anime",
+		Shots: []models.TemplateShot{{Duration: 5, Prompt: "waves"}, {Duration: 5, Prompt: "sunset"}},
+	})
+
+	prompt, sb, err := ApplyPresets(db, &PresetOptions{Style: "retro"}, "a city", nil)
+	if err != nil || prompt != "a city, retro 80s" || sb != nil {
+		t.Errorf("Expected styled prompt, got %q %+v %v", prompt, sb, err)
+	}
+
+	_, sb, err = ApplyPresets(db, &PresetOptions{TemplateID: "beach"}, "", &Storyboard{Instructions: "calm"})
+	if err != nil {
+		t.Fatalf("ApplyPresets failed: %v", err)
+	}
+	if len(sb.Shots) != 2 || sb.Instructions != "calm, anime style" || sb.Title != "Beach" {
+		t.Errorf("Expected template storyboard with its style, got %+v", sb)
+	}
+	if _, sb, _ = ApplyPresets(db, &PresetOptions{TemplateID: "beach", Style: StyleNone}, "", nil); sb.Instructions != "" {
+		t.Errorf("Expected style none to drop the template style, got %q", sb.Instructions)
+	}
+	if _, sb, _ = ApplyPresets(db, &PresetOptions{TemplateID: "beach", Style: "retro"}, "", nil); sb.Instructions != "retro 80s" {
+		t.Errorf("Expected request style to win, got %q", sb.Instructions)
+	}
+
+	var soraErr *SoraError
+	for _, opts := range []*PresetOptions{{TemplateID: "missing"}, {Style: "missing"}} {
+		if _, _, err := ApplyPresets(db, opts, "x", nil); !errors.As(err, &soraErr) || soraErr.Kind != SoraErrorInvalidRequest {
+			t.Errorf("Expected invalid request for %+v, got %v", opts, err)
+		}
+	}
+	shots := &Storyboard{Shots: []StoryboardShot{{Duration: 10, Prompt: "x"}}}
+	if _, _, err := ApplyPresets(db, &PresetOptions{TemplateID: "beach"}, "", shots); !errors.As(err, &soraErr) {
+		t.Errorf("Expected template and shots to conflict, got %v", err)
+	}
+}
+
+func TestImportExportPresets(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.SaveStylePreset(&models.StylePreset{ID: "old", Name: "Old", PromptSuffix: "old"})
+	bundle := &PresetBundle{
+		Version: PresetBundleVersion,
+		Styles:  []*models.StylePreset{{ID: "anime", Name: "Anime", PromptSuffix: "anime style"}, {ID: "Bad ID", Name: "x", PromptSuffix: "x"}},
+		Templates: []*models.PromptTemplate{
+			{ID: "beach", Name: "Beach", Shots: []models.TemplateShot{{Duration: 5, Prompt: "waves"}}},
+			{ID: "empty", Name: "Empty"},
+		},
+	}
+	result, err := ImportPresets(db, bundle, true)
+	if err != nil {
+		t.Fatalf("ImportPresets failed: %v", err)
+	}
+	if result.Templates != 1 || result.Styles != 1 || len(result.Failed) != 2 {
+		t.Errorf("Unexpected import result %+v", result)
+	}
+	if _, err := db.GetStylePreset("old"); err == nil {
+		t.Error("Expected replace to delete existing styles")
+	}
+
+	exported, err := ExportPresets(db)
+	if err != nil {
+		t.Fatalf("ExportPresets failed: %v", err)
+	}
+	if len(exported.Templates) != 1 || exported.Templates[0].ID != "beach" || len(exported.Styles) != 1 {
+		t.Errorf("Unexpected export %+v", exported)
+	}
+
+	if _, err := ImportPresets(db, &PresetBundle{Version: PresetBundleVersion + 1}, false); err == nil {
+		t.Error("Expected newer bundle versions to be rejected")
+	}
+}
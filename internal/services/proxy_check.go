@@ -0,0 +1,175 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	http2 "github.com/bogdanfinn/fhttp"
+	tls_client "github.com/bogdanfinn/tls-client"
+)
+
+// Proxy check defaults
+const (
+	DefaultProxyTestURL   = "https://sora.chatgpt.com"
+	proxyCheckTimeout     = 20
+	maxProxyCheckBodySize = 64 * 1024
+)
+
+// ProxyExitIPURL returns the caller's public IP as JSON ({"ip": "..."})
+var ProxyExitIPURL = "https://api.ipify.org?format=json"
+
+// ProxyCheckResult is the outcome of a connectivity test through a proxy
+type ProxyCheckResult struct {
+	ProxyURL            string `json:"proxy_url"` // Credentials redacted
+	TestURL             string `json:"test_url"`
+	Reachable           bool   `json:"reachable"`
+	StatusCode          int    `json:"status_code,omitempty"`
+	LatencyMs           int64  `json:"latency_ms"`
+	ExitIP              string `json:"exit_ip,omitempty"`
+	TLSOK               bool   `json:"tls_ok"`
+	CloudflareChallenge bool   `json:"cloudflare_challenge"`
+	Error               string `json:"error,omitempty"`
+}
+
+// CheckProxy dials testURL through the proxy with the default fingerprint used by SoraClient,
+// and looks up the exit IP. proxyLine accepts any format supported by ParseProxyLine;
+// empty tests a direct connection.
+func CheckProxy(proxyLine, testURL string) *ProxyCheckResult {
+	if testURL == "" {
+		testURL = DefaultProxyTestURL
+	}
+	result := &ProxyCheckResult{TestURL: testURL}
+
+	proxyURL := ParseProxyLine(proxyLine)
+	if proxyURL != "" {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil || parsed.Host == "" {
+			result.ProxyURL = proxyLine
+			result.Error = fmt.Sprintf("invalid proxy URL: %s", proxyLine)
+			return result
+		}
+		result.ProxyURL = parsed.Redacted()
+		switch parsed.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			result.Error = fmt.Sprintf("unsupported proxy scheme: %s", parsed.Scheme)
+			return result
+		}
+	}
+
+	fp := DefaultFingerprint()
+	client, err := newProxyCheckClient(proxyURL, fp)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	statusCode, header, body, err := proxyCheckGet(client, fp, testURL)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		// The tunnel was up if the failure happened during or after the TLS handshake
+		result.Reachable = isTLSError(err)
+		return result
+	}
+
+	result.Reachable = true
+	result.StatusCode = statusCode
+	result.TLSOK = strings.HasPrefix(testURL, "https://")
+	result.CloudflareChallenge = isCloudflareChallenge(statusCode, header, body)
+
+	if ip, err := lookupExitIP(client, fp); err == nil {
+		result.ExitIP = ip
+	} else if result.Error == "" {
+		result.Error = fmt.Sprintf("exit IP lookup failed: %v", err)
+	}
+
+	return result
+}
+
+// newProxyCheckClient creates a one-off TLS client so tests do not share cookies or connections
+func newProxyCheckClient(proxyURL string, fp *Fingerprint) (tls_client.HttpClient, error) {
+	options := []tls_client.HttpClientOption{
+		tls_client.WithTimeoutSeconds(proxyCheckTimeout),
+		tls_client.WithClientProfile(fp.ClientProfile()),
+		tls_client.WithNotFollowRedirects(),
+	}
+	if proxyURL != "" {
+		options = append(options, tls_client.WithProxyUrl(proxyURL))
+	}
+	return tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
+}
+
+// proxyCheckGet performs a browser-like GET and returns a bounded body
+func proxyCheckGet(client tls_client.HttpClient, fp *Fingerprint, urlStr string) (int, http2.Header, []byte, error) {
+	req, err := http2.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	req.Header = http2.Header{
+		"accept":             {"text/html,application/json;q=0.9,*/*;q=0.8"},
+		"accept-language":    {"en-US,en;q=0.9"},
+		"user-agent":         {fp.UserAgents[0]},
+		http2.HeaderOrderKey: fp.HeaderOrder,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProxyCheckBodySize))
+	if err != nil {
+		return resp.StatusCode, resp.Header, nil, err
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
+
+// lookupExitIP returns the public IP seen by ProxyExitIPURL
+func lookupExitIP(client tls_client.HttpClient, fp *Fingerprint) (string, error) {
+	statusCode, _, body, err := proxyCheckGet(client, fp, ProxyExitIPURL)
+	if err != nil {
+		return "", err
+	}
+	if statusCode != 200 {
+		return "", fmt.Errorf("status %d", statusCode)
+	}
+
+	var resp struct {
+		IP string `json:"ip"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.IP == "" {
+		// Plain-text services
+		if ip := strings.TrimSpace(string(body)); ip != "" && !strings.ContainsAny(ip, " <{") {
+			return ip, nil
+		}
+		return "", fmt.Errorf("unexpected response")
+	}
+	return resp.IP, nil
+}
+
+// isCloudflareChallenge reports whether the response is a Cloudflare bot challenge
+func isCloudflareChallenge(statusCode int, header http2.Header, body []byte) bool {
+	if header.Get("cf-mitigated") == "challenge" {
+		return true
+	}
+	if statusCode != 403 && statusCode != 503 {
+		return false
+	}
+	text := string(body)
+	return strings.Contains(text, "challenge-platform") ||
+		strings.Contains(text, "cf_chl_") ||
+		strings.Contains(text, "Just a moment...")
+}
+
+// isTLSError reports whether the error happened in the TLS layer (after the proxy tunnel was established)
+func isTLSError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "tls") || strings.Contains(msg, "x509") || strings.Contains(msg, "certificate")
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	http2 "github.com/bogdanfinn/fhttp"
+)
+
+func newProxyCheckServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ip":"203.0.113.7"}`))
+	})
+	mux.HandleFunc("/challenge", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<html><title>Just a moment...</title></html>`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	prev := ProxyExitIPURL
+	ProxyExitIPURL = server.URL + "/ip"
+	t.Cleanup(func() { ProxyExitIPURL = prev })
+	return server
+}
+
+func TestCheckProxy_Direct(t *testing.T) {
+	server := newProxyCheckServer(t)
+
+	result := CheckProxy("", server.URL)
+	if !result.Reachable || result.Error != "" {
+		t.Fatalf("Expected reachable without error, got %+v", result)
+	}
+	if result.StatusCode != 200 {
+		t.Errorf("Expected status 200, got %d", result.StatusCode)
+	}
+	if result.ExitIP != "203.0.113.7" {
+		t.Errorf("Expected exit IP 203.0.113.7, got %s", result.ExitIP)
+	}
+	if result.CloudflareChallenge {
+		t.Error("Expected no Cloudflare challenge")
+	}
+}
+
+func TestCheckProxy_CloudflareChallenge(t *testing.T) {
+	server := newProxyCheckServer(t)
+
+	result := CheckProxy("", server.URL+"/challenge")
+	if !result.Reachable || result.StatusCode != 403 {
+		t.Fatalf("Expected reachable 403, got %+v", result)
+	}
+	if !result.CloudflareChallenge {
+		t.Error("Expected Cloudflare challenge to be detected")
+	}
+}
+
+func TestCheckProxy_UnreachableProxy(t *testing.T) {
+	server := newProxyCheckServer(t)
+
+	// Reserve a port and close it so the dial is refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	host, port, _ := net.SplitHostPort(addr)
+	result := CheckProxy(host+":"+port+":user:secret", server.URL)
+	if result.Reachable {
+		t.Errorf("Expected unreachable, got %+v", result)
+	}
+	if result.Error == "" {
+		t.Error("Expected raw error to be reported")
+	}
+	if result.ProxyURL != "http://user:xxxxx@"+addr {
+		t.Errorf("Expected redacted proxy URL, got %s", result.ProxyURL)
+	}
+}
+
+func TestCheckProxy_UnsupportedScheme(t *testing.T) {
+	result := CheckProxy("ftp://127.0.0.1:21", "")
+	if result.Reachable || result.Error == "" {
+		t.Errorf("Expected unsupported scheme error, got %+v", result)
+	}
+	if result.TestURL != DefaultProxyTestURL {
+		t.Errorf("Expected default test URL, got %s", result.TestURL)
+	}
+}
+
+func TestIsCloudflareChallenge(t *testing.T) {
+	header := http2.Header{}
+	header.Set("cf-mitigated", "challenge")
+	if !isCloudflareChallenge(200, header, nil) {
+		t.Error("Expected cf-mitigated header to indicate a challenge")
+	}
+	if isCloudflareChallenge(403, http2.Header{}, []byte("forbidden")) {
+		t.Error("Plain 403 should not be a challenge")
+	}
+}
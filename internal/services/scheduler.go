@@ -14,6 +14,7 @@ type scheduledTask struct {
 	interval time.Duration
 	task     TaskFunc
 	ticker   *time.Ticker
+	clock    *Clock // set for daily tasks that run at midnight
 	stopCh   chan struct{}
 }
 
@@ -55,6 +56,52 @@ func (s *Scheduler) AddTask(name string, interval time.Duration, task TaskFunc)
 	go s.runTask(st)
 }
 
+// AddDailyTask adds a task that runs at every midnight in the clock's timezone
+func (s *Scheduler) AddDailyTask(name string, clock *Clock, task TaskFunc) {
+	if clock == nil {
+		clock = DefaultClock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Remove existing task with same name
+	if existing, ok := s.tasks[name]; ok {
+		close(existing.stopCh)
+	}
+
+	st := &scheduledTask{
+		name:     name,
+		interval: 24 * time.Hour,
+		task:     task,
+		clock:    clock,
+		stopCh:   make(chan struct{}),
+	}
+
+	s.tasks[name] = st
+
+	s.wg.Add(1)
+	go s.runDailyTask(st)
+}
+
+// runDailyTask runs a task at each midnight of the task's clock
+func (s *Scheduler) runDailyTask(st *scheduledTask) {
+	defer s.wg.Done()
+
+	for {
+		now := st.clock.Now()
+		timer := time.NewTimer(st.clock.NextMidnight(now).Sub(now))
+
+		select {
+		case <-timer.C:
+			st.task()
+		case <-st.stopCh:
+			timer.Stop()
+			return
+		}
+	}
+}
+
 // runTask runs a scheduled task
 func (s *Scheduler) runTask(st *scheduledTask) {
 	defer s.wg.Done()
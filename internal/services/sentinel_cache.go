@@ -0,0 +1,155 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSentinelTTL is the longest a sentinel token is reused
+const DefaultSentinelTTL = 5 * time.Minute
+
+// SentinelCacheStats reports sentinel token reuse
+type SentinelCacheStats struct {
+	TTLSeconds    int   `json:"ttl_seconds"`
+	Entries       int   `json:"entries"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+type sentinelKey struct {
+	token string
+	flow  string
+}
+
+type sentinelEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// sentinelCall is a fetch shared by concurrent misses of the same key
+type sentinelCall struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// SentinelCache reuses sentinel tokens per access token and flow until they
+// expire, so only one request in a while pays for the sentinel call and PoW.
+// Concurrent misses of the same key share a single fetch.
+type SentinelCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	entries  map[sentinelKey]sentinelEntry
+	inflight map[sentinelKey]*sentinelCall
+	now      func() time.Time
+
+	hits          int64
+	misses        int64
+	invalidations int64
+}
+
+// NewSentinelCache creates a cache keeping tokens for at most ttl (0: no caching)
+func NewSentinelCache(ttl time.Duration) *SentinelCache {
+	return &SentinelCache{
+		ttl:      ttl,
+		entries:  make(map[sentinelKey]sentinelEntry),
+		inflight: make(map[sentinelKey]*sentinelCall),
+		now:      time.Now,
+	}
+}
+
+// SetTTL sets the longest a token is reused (0: no caching); cached tokens are dropped
+func (c *SentinelCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl != c.ttl {
+		c.ttl = ttl
+		c.entries = make(map[sentinelKey]sentinelEntry)
+	}
+}
+
+// Get returns the cached token of the access token and flow, or fetches one.
+// fetch returns the token and how long the upstream allows it to be used
+// (0 if unknown); the cache keeps it for the shorter of that and its TTL.
+func (c *SentinelCache) Get(token, flow string, fetch func() (string, time.Duration, error)) (string, error) {
+	key := sentinelKey{token: token, flow: flow}
+
+	c.mu.Lock()
+	if c.ttl <= 0 {
+		c.misses++
+		c.mu.Unlock()
+		value, _, err := fetch()
+		return value, err
+	}
+	if entry, ok := c.entries[key]; ok {
+		if c.now().Before(entry.expiresAt) {
+			c.hits++
+			c.mu.Unlock()
+			return entry.value, nil
+		}
+		delete(c.entries, key)
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.hits++
+		c.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &sentinelCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.misses++
+	c.mu.Unlock()
+
+	value, expiresIn, err := fetch()
+	call.value, call.err = value, err
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if err == nil && c.ttl > 0 {
+		ttl := c.ttl
+		if expiresIn > 0 && expiresIn < ttl {
+			ttl = expiresIn
+		}
+		now := c.now()
+		c.pruneLocked(now)
+		c.entries[key] = sentinelEntry{value: value, expiresAt: now.Add(ttl)}
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return value, err
+}
+
+// Invalidate drops the cached token of the access token and flow, e.g. after Sora rejected it
+func (c *SentinelCache) Invalidate(token, flow string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := sentinelKey{token: token, flow: flow}
+	if _, ok := c.entries[key]; ok {
+		delete(c.entries, key)
+		c.invalidations++
+	}
+}
+
+// pruneLocked drops expired entries; the caller holds c.mu
+func (c *SentinelCache) pruneLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Stats returns the cache's metrics
+func (c *SentinelCache) Stats() SentinelCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(c.now())
+	return SentinelCacheStats{
+		TTLSeconds:    int(c.ttl / time.Second),
+		Entries:       len(c.entries),
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Invalidations: c.invalidations,
+	}
+}
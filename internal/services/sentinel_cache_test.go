@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSentinelCache_ReusesUntilExpiry(t *testing.T) {
+	cache := NewSentinelCache(time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	fetches := 0
+	fetch := func() (string, time.Duration, error) {
+		fetches++
+		return "sentinel", 0, nil
+	}
+
+	cache.Get("at_1", "sora_2_create_task", fetch)
+	cache.Get("at_1", "sora_2_create_task", fetch)
+	if fetches != 1 {
+		t.Errorf("Expected cached token to be reused, got %d fetches", fetches)
+	}
+
+	// Different tokens and flows are cached separately
+	cache.Get("at_2", "sora_2_create_task", fetch)
+	cache.Get("at_1", "sora_create_character", fetch)
+	if fetches != 3 {
+		t.Errorf("Expected 3 fetches, got %d", fetches)
+	}
+
+	now = now.Add(time.Minute)
+	cache.Get("at_1", "sora_2_create_task", fetch)
+	if fetches != 4 {
+		t.Errorf("Expected expired token to be refetched, got %d fetches", fetches)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 4 || stats.Entries != 1 || stats.TTLSeconds != 60 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestSentinelCache_UpstreamExpiryShortensTTL(t *testing.T) {
+	cache := NewSentinelCache(5 * time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	fetches := 0
+	fetch := func() (string, time.Duration, error) {
+		fetches++
+		return "sentinel", 30 * time.Second, nil
+	}
+
+	cache.Get("at_1", "flow", fetch)
+	now = now.Add(31 * time.Second)
+	cache.Get("at_1", "flow", fetch)
+	if fetches != 2 {
+		t.Errorf("Expected token to expire after the upstream expiry, got %d fetches", fetches)
+	}
+}
+
+func TestSentinelCache_InvalidateAndErrors(t *testing.T) {
+	cache := NewSentinelCache(time.Minute)
+	fetches := 0
+	fetch := func() (string, time.Duration, error) {
+		fetches++
+		return "sentinel", 0, nil
+	}
+
+	cache.Get("at_1", "flow", fetch)
+	cache.Invalidate("at_1", "flow")
+	cache.Get("at_1", "flow", fetch)
+	if fetches != 2 {
+		t.Errorf("Expected invalidated token to be refetched, got %d fetches", fetches)
+	}
+	if stats := cache.Stats(); stats.Invalidations != 1 {
+		t.Errorf("Expected 1 invalidation, got %d", stats.Invalidations)
+	}
+
+	// Failed fetches are not cached
+	failing := func() (string, time.Duration, error) {
+		fetches++
+		return "", 0, errors.New("sentinel unavailable")
+	}
+	if _, err := cache.Get("at_2", "flow", failing); err == nil {
+		t.Error("Expected fetch error")
+	}
+	cache.Get("at_2", "flow", fetch)
+	if fetches != 4 {
+		t.Errorf("Expected failed fetch to be retried, got %d fetches", fetches)
+	}
+}
+
+func TestSentinelCache_ZeroTTLDisablesCaching(t *testing.T) {
+	cache := NewSentinelCache(time.Minute)
+	cache.SetTTL(0)
+	fetches := 0
+	fetch := func() (string, time.Duration, error) {
+		fetches++
+		return "sentinel", 0, nil
+	}
+	cache.Get("at_1", "flow", fetch)
+	cache.Get("at_1", "flow", fetch)
+	if fetches != 2 {
+		t.Errorf("Expected no caching with TTL 0, got %d fetches", fetches)
+	}
+}
+
+func TestSentinelCache_ConcurrentMissesShareFetch(t *testing.T) {
+	cache := NewSentinelCache(time.Minute)
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() (string, time.Duration, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return "sentinel", 0, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cache.Get("at_1", "flow", fetch)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if fetches != 1 {
+		t.Errorf("Expected concurrent misses to share 1 fetch, got %d", fetches)
+	}
+	for _, result := range results {
+		if result != "sentinel" {
+			t.Errorf("Expected shared token, got %q", result)
+		}
+	}
+}
@@ -5,15 +5,18 @@ import (
 	"time"
 
 	tls_client "github.com/bogdanfinn/tls-client"
-	"github.com/bogdanfinn/tls-client/profiles"
 )
 
-// SessionManager manages TLS client sessions per token for cookie persistence
+// SessionManager manages TLS client sessions per token for cookie persistence.
+// Every session owns its TLS client, so the proxy of a session never changes
+// once created and concurrent requests for different tokens cannot interfere.
 type SessionManager struct {
 	mu       sync.RWMutex
 	sessions map[string]*ManagedSession
 	timeout  int
 	maxAge   time.Duration
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
 // ManagedSession holds a TLS client with metadata
@@ -22,6 +25,8 @@ type ManagedSession struct {
 	CreatedAt time.Time
 	LastUsed  time.Time
 	ProxyURL  string
+	Profile   string // TLS profile of the client
+	Timeout   int    // Request timeout of the client in seconds
 }
 
 // NewSessionManager creates a new session manager
@@ -30,6 +35,7 @@ func NewSessionManager(timeout int) *SessionManager {
 		sessions: make(map[string]*ManagedSession),
 		timeout:  timeout,
 		maxAge:   30 * time.Minute,
+		stopCh:   make(chan struct{}),
 	}
 
 	// Start cleanup goroutine
@@ -38,21 +44,45 @@ func NewSessionManager(timeout int) *SessionManager {
 	return sm
 }
 
-// GetSession returns or creates a TLS client for the given token
-func (sm *SessionManager) GetSession(token string, proxyURL string) (tls_client.HttpClient, error) {
+// SetTimeout sets the request timeout in seconds; sessions pick it up on their next use
+func (sm *SessionManager) SetTimeout(timeout int) {
+	if timeout <= 0 {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.timeout = timeout
+}
+
+// anonymousSessionKey is the session key of requests without a token (one per proxy)
+func anonymousSessionKey(proxyURL string) string {
+	return "anonymous|" + proxyURL
+}
+
+// GetSession returns or creates a TLS client for the given token with the fingerprint's
+// TLS profile (nil uses the default fingerprint). An empty token returns a session
+// shared by token-less requests through the same proxy.
+func (sm *SessionManager) GetSession(token string, proxyURL string, fp *Fingerprint) (tls_client.HttpClient, error) {
+	if token == "" {
+		token = anonymousSessionKey(proxyURL)
+	}
+	if fp == nil {
+		fp = DefaultFingerprint()
+	}
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	session, exists := sm.sessions[token]
 
-	// Reuse existing session if proxy hasn't changed
-	if exists && session.ProxyURL == proxyURL {
+	// Reuse existing session if neither proxy, TLS profile nor timeout has changed
+	if exists && session.ProxyURL == proxyURL && session.Profile == fp.Profile && session.Timeout == sm.timeout {
 		session.LastUsed = time.Now()
 		return session.Client, nil
 	}
 
 	// Create new TLS client
-	client, err := sm.createTLSClient(proxyURL)
+	client, err := sm.createTLSClient(proxyURL, fp)
 	if err != nil {
 		return nil, err
 	}
@@ -62,33 +92,27 @@ func (sm *SessionManager) GetSession(token string, proxyURL string) (tls_client.
 		CreatedAt: time.Now(),
 		LastUsed:  time.Now(),
 		ProxyURL:  proxyURL,
+		Profile:   fp.Profile,
+		Timeout:   sm.timeout,
 	}
 
 	return client, nil
 }
 
-// createTLSClient creates a new TLS client with Firefox profile
-func (sm *SessionManager) createTLSClient(proxyURL string) (tls_client.HttpClient, error) {
+// createTLSClient creates a new TLS client with the fingerprint's browser profile
+func (sm *SessionManager) createTLSClient(proxyURL string, fp *Fingerprint) (tls_client.HttpClient, error) {
 	jar := tls_client.NewCookieJar()
 	options := []tls_client.HttpClientOption{
 		tls_client.WithTimeoutSeconds(sm.timeout),
-		tls_client.WithClientProfile(profiles.Firefox_132),
+		tls_client.WithClientProfile(fp.ClientProfile()),
 		tls_client.WithNotFollowRedirects(),
 		tls_client.WithCookieJar(jar),
 	}
-
-	client, err := tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
-	if err != nil {
-		return nil, err
-	}
-
 	if proxyURL != "" {
-		if err := client.SetProxy(proxyURL); err != nil {
-			return nil, err
-		}
+		options = append(options, tls_client.WithProxyUrl(proxyURL))
 	}
 
-	return client, nil
+	return tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
 }
 
 // InvalidateSession removes a session for the given token
@@ -103,11 +127,27 @@ func (sm *SessionManager) cleanupLoop() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		sm.cleanup()
+	for {
+		select {
+		case <-ticker.C:
+			sm.cleanup()
+		case <-sm.stopCh:
+			return
+		}
 	}
 }
 
+// Stop stops the cleanup loop and drops all sessions
+func (sm *SessionManager) Stop() {
+	sm.stopOnce.Do(func() {
+		close(sm.stopCh)
+	})
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.sessions = make(map[string]*ManagedSession)
+}
+
 // cleanup removes sessions that haven't been used recently
 func (sm *SessionManager) cleanup() {
 	sm.mu.Lock()
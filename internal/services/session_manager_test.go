@@ -0,0 +1,63 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSessionManager_PerTokenIsolation(t *testing.T) {
+	sm := NewSessionManager(30)
+	defer sm.Stop()
+
+	// Concurrent requests for different tokens must each keep their own proxy
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := fmt.Sprintf("token-%d", i%4)
+			proxy := fmt.Sprintf("http://10.0.0.%d:8080", i%4)
+			client, err := sm.GetSession(token, proxy, nil)
+			if err != nil {
+				t.Errorf("GetSession failed: %v", err)
+				return
+			}
+			if client.GetProxy() != proxy {
+				t.Errorf("Expected %s to use %s, got %s", token, proxy, client.GetProxy())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if sm.GetSessionCount() != 4 {
+		t.Errorf("Expected 4 sessions, got %d", sm.GetSessionCount())
+	}
+
+	first, _ := sm.GetSession("token-0", "http://10.0.0.0:8080", nil)
+	again, _ := sm.GetSession("token-0", "http://10.0.0.0:8080", nil)
+	if first != again {
+		t.Error("Expected session to be reused for the same proxy")
+	}
+	moved, _ := sm.GetSession("token-0", "http://10.0.0.9:8080", nil)
+	if moved == first || moved.GetProxy() != "http://10.0.0.9:8080" {
+		t.Error("Expected a new session when the token's proxy changes")
+	}
+	if first.GetProxy() != "http://10.0.0.0:8080" {
+		t.Error("Replacing a session must not change the old client's proxy")
+	}
+}
+
+func TestSessionManager_AnonymousSessionPerProxy(t *testing.T) {
+	sm := NewSessionManager(30)
+	defer sm.Stop()
+
+	a, _ := sm.GetSession("", "http://1.1.1.1:8080", nil)
+	b, _ := sm.GetSession("", "http://2.2.2.2:8080", nil)
+	if a == b {
+		t.Error("Expected separate token-less sessions per proxy")
+	}
+	if again, _ := sm.GetSession("", "http://1.1.1.1:8080", nil); again != a {
+		t.Error("Expected token-less session to be reused for the same proxy")
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"io"
+
+	"soranow/internal/models"
+)
+
+// SoraAPI is the Sora upstream used by the generation and character handlers.
+// SoraClient is the real implementation; tests and local development can point
+// a SoraClient at cmd/mocksora or inject their own implementation.
+type SoraAPI interface {
+	// Generation
+	GenerateImage(prompt, token string, width, height int, mediaID string, proxyURL string) (string, error)
+	GenerateVideo(prompt, token, orientation, mediaID string, nFrames int, styleID, model, size string, proxyURL string) (string, error)
+	GenerateVideoWithCameo(prompt, token, orientation, mediaID string, nFrames int, styleID, model, size string, cameoIDs []string, proxyURL string) (string, error)
+	RemixVideo(prompt, token, orientation, remixTargetID string, nFrames int, model string, proxyURL string) (string, error)
+	ExtendVideo(prompt, token, orientation, generationID string, nFrames int, proxyURL string) (string, error)
+	GenerateStoryboard(prompt, token, orientation, mediaID string, nFrames int, proxyURL string) (string, error)
+	GenerateStructuredStoryboard(sb *Storyboard, token, orientation string, nFrames int, cameoIDs []string, proxyURL string) (string, error)
+
+	// Task status
+	GetPendingTasks(token string, proxyURL string) ([]PendingTask, error)
+	GetImageTasks(token string, limit int, proxyURL string) ([]map[string]interface{}, error)
+	GetVideoDrafts(token string, limit int, proxyURL string) ([]VideoDraft, error)
+	FindTaskInPending(taskID, token string, proxyURL string) (*PendingTask, error)
+	FindTaskInImageTasks(taskID, token string, proxyURL string) (map[string]interface{}, error)
+	FindTaskInVideoDrafts(taskID, token string, proxyURL string) (*VideoDraft, error)
+
+	// Posts
+	PublishVideo(draftID, token string, proxyURL string) (string, string, error)
+	DeletePost(postID, token string, proxyURL string) error
+
+	// Characters (cameos)
+	UploadCharacterVideo(videoData []byte, token string, timestamps string, proxyURL string) (string, error)
+	UploadCharacterFile(video io.Reader, size int64, contentType, token, timestamps, proxyURL string) (string, error)
+	GetCameoStatus(cameoID, token string, proxyURL string) (string, string, error)
+	GetCameo(cameoID, token string, proxyURL string) (*models.CameoStatus, error)
+	CheckUsernameAvailable(username, token string, proxyURL string) (bool, error)
+	FinalizeCharacter(cameoID, username, displayName, instructionSet, safetyInstructionSet, visibility, token string, proxyURL string) (string, string, error)
+	SearchCharacter(username, token string, proxyURL string) ([]map[string]interface{}, error)
+	DeleteCharacter(characterID, token string, proxyURL string) error
+	GetMyCharacters(token string, proxyURL string) ([]map[string]interface{}, error)
+}
+
+var _ SoraAPI = (*SoraClient)(nil)
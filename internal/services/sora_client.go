@@ -6,17 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	http2 "github.com/bogdanfinn/fhttp"
-	tls_client "github.com/bogdanfinn/tls-client"
-	"github.com/bogdanfinn/tls-client/profiles"
 	"github.com/google/uuid"
+	"soranow/internal/config"
+	"soranow/internal/models"
 )
 
 const (
@@ -51,37 +51,11 @@ func IsStoryboardPrompt(prompt string) bool {
 // Input: 猫猫的奇妙冒险\n[5.0s]猫猫从飞机上跳伞 [5.0s]猫猫降落
 // Output: current timeline:\nShot 1:...\n\ninstructions:\n猫猫的奇妙冒险
 func FormatStoryboardPrompt(prompt string) string {
-	// Match [time]content pattern
-	pattern := regexp.MustCompile(`\[(\d+(?:\.\d+)?)s\]\s*([^\[]+)`)
-	matches := pattern.FindAllStringSubmatch(prompt, -1)
-
-	if len(matches) == 0 {
+	sb := ParseStoryboardPrompt(prompt)
+	if len(sb.Shots) == 0 {
 		return prompt
 	}
-
-	// Extract instructions (content before first [time])
-	firstBracketPos := strings.Index(prompt, "[")
-	instructions := ""
-	if firstBracketPos > 0 {
-		instructions = strings.TrimSpace(prompt[:firstBracketPos])
-	}
-
-	// Format shots
-	var formattedShots []string
-	for idx, match := range matches {
-		duration := match[1]
-		scene := strings.TrimSpace(match[2])
-		shot := fmt.Sprintf("Shot %d:\nduration: %ssec\nScene: %s", idx+1, duration, scene)
-		formattedShots = append(formattedShots, shot)
-	}
-
-	timeline := strings.Join(formattedShots, "\n\n")
-
-	// If there are instructions, add them
-	if instructions != "" {
-		return fmt.Sprintf("current timeline:\n%s\n\ninstructions:\n%s", timeline, instructions)
-	}
-	return timeline
+	return sb.Prompt()
 }
 
 // TaskStatus represents the status of a generation task
@@ -109,15 +83,43 @@ type VideoDraft struct {
 	Thumbnail string `json:"thumbnail_url"`
 }
 
+// SoraClientConfig holds the upstream settings of a SoraClient
+type SoraClientConfig struct {
+	BaseURL     string // Sora backend, e.g. https://sora.chatgpt.com/backend
+	SentinelURL string // Sentinel endpoint (chatgpt.com, outside the base URL)
+	Timeout     int    // Request timeout in seconds
+	MaxRetries  int    // Retries of GET requests failing with a network error or 5xx
+	SentinelTTL int    // Longest reuse of a sentinel token in seconds (0: fetch one per request)
+}
+
+// SoraClientConfigFrom returns the client settings of the layered configuration
+func SoraClientConfigFrom(cfg *config.Config) SoraClientConfig {
+	return SoraClientConfig{
+		BaseURL:     cfg.Sora.BaseURL,
+		SentinelURL: cfg.Sora.SentinelURL,
+		Timeout:     cfg.Sora.Timeout,
+		MaxRetries:  cfg.Sora.MaxRetries,
+		SentinelTTL: cfg.Sora.SentinelTTL,
+	}
+}
+
+// retryBackoff is the wait before the first retry of a GET request, doubled on each retry
+const retryBackoff = 500 * time.Millisecond
+
 // SoraClient handles communication with the Sora API
 type SoraClient struct {
+	mu             sync.RWMutex // Guards baseURL, sentinelURL, timeout and maxRetries
 	baseURL        string
+	sentinelURL    string
 	timeout        int
+	maxRetries     int
 	httpClient     *http.Client
-	tlsClient      tls_client.HttpClient
 	proxyURL       string
 	sessionManager *SessionManager
+	ownsSessions   bool // sessionManager was created by this client
+	sentinels      *SentinelCache
 	proxyManager   *ProxyManager
+	fingerprints   FingerprintResolver
 }
 
 // NewSoraClient creates a new Sora API client
@@ -136,22 +138,64 @@ func NewSoraClient(baseURL string, timeout int, httpClient *http.Client) *SoraCl
 		}
 	}
 
-	// Create TLS client with Firefox profile to bypass Cloudflare
-	jar := tls_client.NewCookieJar()
-	options := []tls_client.HttpClientOption{
-		tls_client.WithTimeoutSeconds(timeout),
-		tls_client.WithClientProfile(profiles.Firefox_132),
-		tls_client.WithNotFollowRedirects(),
-		tls_client.WithCookieJar(jar),
-	}
-	tlsClient, _ := tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
-
+	// TLS clients (Firefox profile to bypass Cloudflare) are created per token by the session manager
 	return &SoraClient{
 		baseURL:        baseURL,
+		sentinelURL:    SentinelReqURL,
 		timeout:        timeout,
 		httpClient:     httpClient,
-		tlsClient:      tlsClient,
 		sessionManager: NewSessionManager(timeout),
+		ownsSessions:   true,
+		sentinels:      NewSentinelCache(DefaultSentinelTTL),
+	}
+}
+
+// SetSentinelURL sets the sentinel endpoint (chatgpt.com, outside the Sora base URL)
+func (c *SoraClient) SetSentinelURL(sentinelURL string) {
+	if sentinelURL == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sentinelURL = sentinelURL
+}
+
+// Configure applies upstream settings; empty URLs and a zero timeout keep the current
+// ones. Requests already in flight finish with the previous settings.
+func (c *SoraClient) Configure(cfg SoraClientConfig) {
+	c.mu.Lock()
+	if cfg.BaseURL != "" {
+		c.baseURL = strings.TrimRight(cfg.BaseURL, "/")
+	}
+	if cfg.SentinelURL != "" {
+		c.sentinelURL = cfg.SentinelURL
+	}
+	if cfg.Timeout > 0 {
+		c.timeout = cfg.Timeout
+	}
+	if cfg.MaxRetries >= 0 {
+		c.maxRetries = cfg.MaxRetries
+	}
+	c.mu.Unlock()
+	c.sentinels.SetTTL(time.Duration(cfg.SentinelTTL) * time.Second)
+
+	// A shared session manager is configured by its owner
+	if c.ownsSessions && cfg.Timeout > 0 {
+		c.sessionManager.SetTimeout(cfg.Timeout)
+	}
+}
+
+// endpoints returns the base and sentinel URLs
+func (c *SoraClient) endpoints() (baseURL, sentinelURL string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.baseURL, c.sentinelURL
+}
+
+// SetSentinelCache shares a sentinel cache, e.g. so its stats can be reported
+func (c *SoraClient) SetSentinelCache(cache *SentinelCache) {
+	if cache != nil {
+		c.sentinels = cache
 	}
 }
 
@@ -160,9 +204,36 @@ func (c *SoraClient) SetProxyManager(pm *ProxyManager) {
 	c.proxyManager = pm
 }
 
+// SetFingerprintResolver sets how the TLS profile and user agents of a token are chosen
+// (defaults to DefaultFingerprint for every token)
+func (c *SoraClient) SetFingerprintResolver(resolver FingerprintResolver) {
+	c.fingerprints = resolver
+}
+
+// fingerprint returns the fingerprint to use for the access token
+func (c *SoraClient) fingerprint(token string) *Fingerprint {
+	if c.fingerprints != nil {
+		if fp := c.fingerprints(token); fp != nil {
+			return fp
+		}
+	}
+	return DefaultFingerprint()
+}
+
 // SetSessionManager sets the session manager for the client
 func (c *SoraClient) SetSessionManager(sm *SessionManager) {
+	if c.ownsSessions && c.sessionManager != nil && c.sessionManager != sm {
+		c.sessionManager.Stop()
+	}
 	c.sessionManager = sm
+	c.ownsSessions = false
+}
+
+// Close stops the client's own session manager (a shared one is left to its owner)
+func (c *SoraClient) Close() {
+	if c.ownsSessions && c.sessionManager != nil {
+		c.sessionManager.Stop()
+	}
 }
 
 // SetProxy sets the proxy URL for the client
@@ -181,10 +252,6 @@ func (c *SoraClient) SetProxy(proxyURL string) {
 				},
 			}
 		}
-		// Update TLS client with proxy
-		if c.tlsClient != nil {
-			c.tlsClient.SetProxy(proxyURL)
-		}
 	}
 }
 
@@ -213,75 +280,51 @@ func (c *SoraClient) getClientWithProxy(proxyURL string) *http.Client {
 	}
 }
 
-// getTLSClientWithProxy returns a TLS client with optional proxy
-// If token is provided, uses session manager for cookie persistence
-func (c *SoraClient) getTLSClientWithProxy(proxyURL string) tls_client.HttpClient {
-	if proxyURL == "" {
-		proxyURL = c.proxyURL
-	}
-	// If proxy manager is set, get proxy from pool
-	if proxyURL == "" && c.proxyManager != nil {
-		proxyURL = c.proxyManager.GetProxyURL()
-	}
-	if proxyURL != "" && c.tlsClient != nil {
-		c.tlsClient.SetProxy(proxyURL)
+// doTLSRequest performs an HTTP request using the TLS client (bypasses Cloudflare)
+func (c *SoraClient) doTLSRequest(method, urlStr string, body []byte, headers map[string]string, proxyURL string) ([]byte, int, error) {
+	return c.doTLSRequestWithToken(method, urlStr, body, headers, proxyURL, "")
+}
+
+// doTLSRequestWithToken performs an HTTP request with session persistence for the given token.
+// Requests without a token use a session per proxy.
+func (c *SoraClient) doTLSRequestWithToken(method, urlStr string, body []byte, headers map[string]string, proxyURL string, token string) ([]byte, int, error) {
+	respBody, statusCode, _, err := c.doTLSRequestWithHeaders(method, urlStr, body, headers, proxyURL, token)
+	return respBody, statusCode, err
+}
+
+// doTLSRequestWithHeaders is doTLSRequestWithToken also returning the response headers
+func (c *SoraClient) doTLSRequestWithHeaders(method, urlStr string, body []byte, headers map[string]string, proxyURL string, token string) ([]byte, int, http2.Header, error) {
+	if body == nil {
+		return c.doTLSStream(method, urlStr, nil, 0, headers, proxyURL, token)
 	}
-	return c.tlsClient
+	return c.doTLSStream(method, urlStr, bytes.NewReader(body), int64(len(body)), headers, proxyURL, token)
 }
 
-// getTLSClientForToken returns a TLS client with session persistence for the given token
-func (c *SoraClient) getTLSClientForToken(token string, proxyURL string) (tls_client.HttpClient, error) {
+// doTLSStream sends size bytes read from body, so large uploads are not held in memory
+func (c *SoraClient) doTLSStream(method, urlStr string, body io.Reader, size int64, headers map[string]string, proxyURL string, token string) ([]byte, int, http2.Header, error) {
+	// Resolve the pool proxy here so the outcome can be reported against it
 	if proxyURL == "" {
 		proxyURL = c.proxyURL
 	}
-	// If proxy manager is set, get proxy from pool
 	if proxyURL == "" && c.proxyManager != nil {
 		proxyURL = c.proxyManager.GetProxyURL()
 	}
 
-	// Use session manager for cookie persistence
-	if c.sessionManager != nil {
-		return c.sessionManager.GetSession(token, proxyURL)
-	}
-
-	// Fallback to default TLS client
-	if proxyURL != "" && c.tlsClient != nil {
-		c.tlsClient.SetProxy(proxyURL)
+	if c.sessionManager == nil {
+		return nil, 0, nil, errors.New("TLS client not initialized")
 	}
-	return c.tlsClient, nil
-}
-
-// doTLSRequest performs an HTTP request using the TLS client (bypasses Cloudflare)
-func (c *SoraClient) doTLSRequest(method, urlStr string, body []byte, headers map[string]string, proxyURL string) ([]byte, int, error) {
-	return c.doTLSRequestWithToken(method, urlStr, body, headers, proxyURL, "")
-}
-
-// doTLSRequestWithToken performs an HTTP request with session persistence for the given token
-func (c *SoraClient) doTLSRequestWithToken(method, urlStr string, body []byte, headers map[string]string, proxyURL string, token string) ([]byte, int, error) {
-	var tlsClient tls_client.HttpClient
-	var err error
-
-	if token != "" && c.sessionManager != nil {
-		tlsClient, err = c.getTLSClientForToken(token, proxyURL)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to get session: %w", err)
-		}
-	} else {
-		tlsClient = c.getTLSClientWithProxy(proxyURL)
+	fp := c.fingerprint(token)
+	tlsClient, err := c.sessionManager.GetSession(token, proxyURL, fp)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to get session: %w", err)
 	}
 
-	if tlsClient == nil {
-		return nil, 0, errors.New("TLS client not initialized")
+	req, err := http2.NewRequest(method, urlStr, body)
+	if err != nil {
+		return nil, 0, nil, err
 	}
-
-	var bodyReader io.Reader
 	if body != nil {
-		bodyReader = bytes.NewReader(body)
-	}
-
-	req, err := http2.NewRequest(method, urlStr, bodyReader)
-	if err != nil {
-		return nil, 0, err
+		req.ContentLength = size
 	}
 
 	// Set default headers for Cloudflare bypass
@@ -293,19 +336,7 @@ func (c *SoraClient) doTLSRequestWithToken(method, urlStr string, body []byte, h
 		"sec-fetch-dest":  {"empty"},
 		"sec-fetch-mode":  {"cors"},
 		"sec-fetch-site":  {"same-origin"},
-		http2.HeaderOrderKey: {
-			"accept",
-			"accept-language",
-			"authorization",
-			"content-type",
-			"origin",
-			"referer",
-			"sec-fetch-dest",
-			"sec-fetch-mode",
-			"sec-fetch-site",
-			"user-agent",
-			"openai-sentinel-token",
-		},
+		http2.HeaderOrderKey: fp.HeaderOrder,
 	}
 
 	// Override with provided headers
@@ -313,24 +344,42 @@ func (c *SoraClient) doTLSRequestWithToken(method, urlStr string, body []byte, h
 		req.Header.Set(k, v)
 	}
 
+	start := time.Now()
 	resp, err := tlsClient.Do(req)
 	if err != nil {
-		return nil, 0, err
+		c.proxyManager.ReportResult(proxyURL, time.Since(start), err)
+		return nil, 0, nil, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
+	c.proxyManager.ReportResult(proxyURL, time.Since(start), err)
 	if err != nil {
-		return nil, resp.StatusCode, err
+		return nil, resp.StatusCode, resp.Header, err
 	}
 
-	return respBody, resp.StatusCode, nil
+	return respBody, resp.StatusCode, resp.Header, nil
 }
 
-// GenerateSentinelToken generates openai-sentinel-token by calling /backend-api/sentinel/req
+// GenerateSentinelToken returns an openai-sentinel-token for the access token, reusing
+// a cached one until it expires
 func (c *SoraClient) GenerateSentinelToken(accessToken string, proxyURL string) (string, error) {
+	return c.sentinels.Get(accessToken, SentinelFlow, func() (string, time.Duration, error) {
+		return c.fetchSentinelToken(accessToken, proxyURL)
+	})
+}
+
+// InvalidateSentinel drops the cached sentinel token of the access token
+func (c *SoraClient) InvalidateSentinel(accessToken string) {
+	c.sentinels.Invalidate(accessToken, SentinelFlow)
+}
+
+// fetchSentinelToken generates openai-sentinel-token by calling /backend-api/sentinel/req.
+// It also returns how long the token may be used, if the response says.
+func (c *SoraClient) fetchSentinelToken(accessToken string, proxyURL string) (string, time.Duration, error) {
 	reqID := uuid.New().String()
-	userAgent := DesktopUserAgents[rand.Intn(len(DesktopUserAgents))]
+	// The PoW and the request must carry a browser UA matching the TLS profile
+	userAgent := c.fingerprint(accessToken).UserAgent(accessToken)
 	powToken := GetPowToken(userAgent)
 
 	// Build request payload
@@ -342,7 +391,7 @@ func (c *SoraClient) GenerateSentinelToken(accessToken string, proxyURL string)
 
 	jsonBody, err := json.Marshal(payload)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	headers := map[string]string{
@@ -357,23 +406,56 @@ func (c *SoraClient) GenerateSentinelToken(accessToken string, proxyURL string)
 	}
 
 	// Use token for session persistence
-	body, statusCode, err := c.doTLSRequestWithToken("POST", SentinelReqURL, jsonBody, headers, proxyURL, accessToken)
+	_, sentinelURL := c.endpoints()
+	body, statusCode, respHeader, err := c.doTLSRequestWithHeaders("POST", sentinelURL, jsonBody, headers, proxyURL, accessToken)
 	if err != nil {
-		return "", fmt.Errorf("sentinel request failed: %v", err)
+		return "", 0, fmt.Errorf("sentinel request failed: %v", err)
 	}
 
 	if statusCode != 200 {
-		return "", fmt.Errorf("sentinel request failed with status %d: %s", statusCode, string(body))
+		return "", 0, fmt.Errorf("sentinel request failed: %w", NewSoraError(statusCode, respHeader, body))
 	}
 
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse sentinel response: %v", err)
+		return "", 0, fmt.Errorf("failed to parse sentinel response: %v", err)
 	}
 
 	// Build final sentinel token
 	sentinelToken := BuildSentinelToken(SentinelFlow, reqID, powToken, result, userAgent)
-	return sentinelToken, nil
+	return sentinelToken, sentinelExpiry(result), nil
+}
+
+// sentinelExpiry returns how long a sentinel response may be used (0 if it does not say),
+// with a margin so a token is not sent just as it expires
+func sentinelExpiry(resp map[string]interface{}) time.Duration {
+	var expiresIn time.Duration
+	if after, ok := resp["expire_after"].(float64); ok && after > 0 {
+		expiresIn = time.Duration(after * float64(time.Second))
+	} else if at, ok := resp["expire_at"].(float64); ok && at > 0 {
+		expiresIn = time.Until(time.Unix(int64(at), 0))
+	}
+	if expiresIn <= 0 {
+		return 0
+	}
+	return expiresIn * 9 / 10
+}
+
+// postWithSentinel makes a POST that requires a sentinel token. A rejected
+// sentinel is dropped from the cache and the request retried once with a new one.
+func (c *SoraClient) postWithSentinel(endpoint, token string, payload interface{}, proxyURL string) ([]byte, int, error) {
+	for attempt := 0; ; attempt++ {
+		sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to generate sentinel token: %w", err)
+		}
+
+		respBody, statusCode, err := c.makeRequest("POST", endpoint, token, payload, sentinelToken, proxyURL)
+		if attempt > 0 || !IsSoraError(err, SoraErrorSentinel) {
+			return respBody, statusCode, err
+		}
+		c.InvalidateSentinel(token)
+	}
 }
 
 // BuildImagePayload builds the payload for image generation
@@ -465,7 +547,7 @@ func (c *SoraClient) BuildStoryboardPayload(prompt, orientation, mediaID string,
 	return map[string]interface{}{
 		"kind":               "video",
 		"prompt":             prompt,
-		"title":              "Draft your video",
+		"title":              DefaultStoryboardTitle,
 		"orientation":        orientation,
 		"size":               "small",
 		"n_frames":           nFrames,
@@ -483,7 +565,52 @@ func (c *SoraClient) BuildStoryboardPayload(prompt, orientation, mediaID string,
 	}
 }
 
-// makeRequest makes an HTTP request to the Sora API using TLS client with session persistence
+// BuildStructuredStoryboardPayload builds the storyboard payload of a structured
+// storyboard: the shots become the timeline prompt, each reference image an
+// inpaint item at the first frame of its shot, and the characters cameo references
+func (c *SoraClient) BuildStructuredStoryboardPayload(sb *Storyboard, orientation string, nFrames int, cameoIDs []string) map[string]interface{} {
+	payload := c.BuildStoryboardPayload(sb.Prompt(), orientation, "", nFrames)
+	if sb.Title != "" {
+		payload["title"] = sb.Title
+	}
+
+	inpaintItems := []map[string]interface{}{}
+	frame := 0
+	for _, shot := range sb.Shots {
+		if shot.ReferenceImage != "" {
+			inpaintItems = append(inpaintItems, map[string]interface{}{
+				"kind":        "upload",
+				"upload_id":   shot.ReferenceImage,
+				"frame_index": frame,
+			})
+		}
+		frame += shotFrames(shot.Duration)
+	}
+	payload["inpaint_items"] = inpaintItems
+
+	if len(cameoIDs) > 0 {
+		payload["cameo_ids"] = cameoIDs
+		payload["cameo_replacements"] = map[string]interface{}{}
+	}
+	return payload
+}
+
+// BuildExtendPayload builds the storyboard payload continuing an existing video:
+// the source generation opens the timeline and the prompt describes what follows
+func (c *SoraClient) BuildExtendPayload(prompt, orientation, generationID string, nFrames int) map[string]interface{} {
+	payload := c.BuildStoryboardPayload(prompt, orientation, "", nFrames)
+	payload["inpaint_items"] = []map[string]interface{}{
+		{
+			"kind":          "generation",
+			"generation_id": generationID,
+			"frame_index":   0,
+		},
+	}
+	return payload
+}
+
+// makeRequest makes an HTTP request to the Sora API using TLS client with session persistence.
+// Responses with a status of 400 or above are returned along with a *SoraError.
 func (c *SoraClient) makeRequest(method, endpoint, token string, body interface{}, sentinelToken string, proxyURL string) ([]byte, int, error) {
 	var jsonBody []byte
 	var err error
@@ -494,11 +621,12 @@ func (c *SoraClient) makeRequest(method, endpoint, token string, body interface{
 		}
 	}
 
-	reqURL := c.baseURL + endpoint
+	baseURL, _ := c.endpoints()
+	reqURL := baseURL + endpoint
 	headers := map[string]string{
 		"Authorization": "Bearer " + token,
 		"Content-Type":  "application/json",
-		"User-Agent":    MobileUserAgents[rand.Intn(len(MobileUserAgents))],
+		"User-Agent":    c.fingerprint(token).AppUserAgent(token),
 		"Origin":        "https://sora.chatgpt.com",
 		"Referer":       "https://sora.chatgpt.com/",
 	}
@@ -507,103 +635,105 @@ func (c *SoraClient) makeRequest(method, endpoint, token string, body interface{
 		headers["openai-sentinel-token"] = sentinelToken
 	}
 
-	// Use token for session persistence
-	return c.doTLSRequestWithToken(method, reqURL, jsonBody, headers, proxyURL, token)
+	// Use token for session persistence. Only GETs are retried: a retried POST
+	// could start a second generation.
+	c.mu.RLock()
+	retries := c.maxRetries
+	c.mu.RUnlock()
+	if method != "GET" {
+		retries = 0
+	}
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		respBody, statusCode, header, err := c.doTLSRequestWithHeaders(method, reqURL, jsonBody, headers, proxyURL, token)
+		if err == nil && statusCode >= 400 {
+			err = NewSoraError(statusCode, header, respBody)
+		}
+		if attempt >= retries || (statusCode > 0 && statusCode < 500) {
+			return respBody, statusCode, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
 }
 
 // GenerateImage starts an image generation task
 func (c *SoraClient) GenerateImage(prompt, token string, width, height int, mediaID string, proxyURL string) (string, error) {
-	// Generate sentinel token
-	sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate sentinel token: %v", err)
-	}
-
 	payload := c.BuildImagePayload(prompt, width, height, mediaID)
 
-	respBody, statusCode, err := c.makeRequest("POST", "/video_gen", token, payload, sentinelToken, proxyURL)
+	respBody, _, err := c.postWithSentinel("/video_gen", token, payload, proxyURL)
 	if err != nil {
 		return "", err
 	}
 
-	if statusCode >= 400 {
-		return "", fmt.Errorf("API error %d: %s", statusCode, string(respBody))
-	}
-
 	return ParseTaskResponse(respBody)
 }
 
 // GenerateVideo starts a video generation task
 func (c *SoraClient) GenerateVideo(prompt, token, orientation, mediaID string, nFrames int, styleID, model, size string, proxyURL string) (string, error) {
-	// Generate sentinel token
-	sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate sentinel token: %v", err)
-	}
-
 	payload := c.BuildVideoPayload(prompt, orientation, mediaID, nFrames, styleID, model, size)
 
-	respBody, statusCode, err := c.makeRequest("POST", "/nf/create", token, payload, sentinelToken, proxyURL)
+	respBody, _, err := c.postWithSentinel("/nf/create", token, payload, proxyURL)
 	if err != nil {
 		return "", err
 	}
 
-	if statusCode >= 400 {
-		return "", fmt.Errorf("API error %d: %s", statusCode, string(respBody))
-	}
-
 	return ParseTaskResponse(respBody)
 }
 
 // RemixVideo starts a remix video generation task based on existing video
 func (c *SoraClient) RemixVideo(prompt, token, orientation, remixTargetID string, nFrames int, model string, proxyURL string) (string, error) {
-	// Generate sentinel token
-	sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate sentinel token: %v", err)
-	}
-
 	if model == "" {
 		model = "sy_8"
 	}
 
 	payload := c.BuildRemixPayload(prompt, orientation, remixTargetID, nFrames, model)
 
-	respBody, statusCode, err := c.makeRequest("POST", "/nf/create", token, payload, sentinelToken, proxyURL)
+	respBody, _, err := c.postWithSentinel("/nf/create", token, payload, proxyURL)
 	if err != nil {
 		return "", err
 	}
 
-	if statusCode >= 400 {
-		return "", fmt.Errorf("API error %d: %s", statusCode, string(respBody))
-	}
-
 	return ParseTaskResponse(respBody)
 }
 
 // GenerateStoryboard starts a storyboard video generation task
 func (c *SoraClient) GenerateStoryboard(prompt, token, orientation, mediaID string, nFrames int, proxyURL string) (string, error) {
-	// Generate sentinel token
-	sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
+	payload := c.BuildStoryboardPayload(prompt, orientation, mediaID, nFrames)
+
+	respBody, _, err := c.postWithSentinel("/nf/create/storyboard", token, payload, proxyURL)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate sentinel token: %v", err)
+		return "", err
 	}
 
-	payload := c.BuildStoryboardPayload(prompt, orientation, mediaID, nFrames)
+	return ParseTaskResponse(respBody)
+}
 
-	respBody, statusCode, err := c.makeRequest("POST", "/nf/create/storyboard", token, payload, sentinelToken, proxyURL)
+// GenerateStructuredStoryboard starts a storyboard video generation task from structured shots
+func (c *SoraClient) GenerateStructuredStoryboard(sb *Storyboard, token, orientation string, nFrames int, cameoIDs []string, proxyURL string) (string, error) {
+	payload := c.BuildStructuredStoryboardPayload(sb, orientation, nFrames, cameoIDs)
+
+	respBody, _, err := c.postWithSentinel("/nf/create/storyboard", token, payload, proxyURL)
 	if err != nil {
 		return "", err
 	}
 
-	if statusCode >= 400 {
-		return "", fmt.Errorf("API error %d: %s", statusCode, string(respBody))
+	return ParseTaskResponse(respBody)
+}
+
+// ExtendVideo starts a storyboard video generation task continuing an existing video
+func (c *SoraClient) ExtendVideo(prompt, token, orientation, generationID string, nFrames int, proxyURL string) (string, error) {
+	payload := c.BuildExtendPayload(prompt, orientation, generationID, nFrames)
+
+	respBody, _, err := c.postWithSentinel("/nf/create/storyboard", token, payload, proxyURL)
+	if err != nil {
+		return "", err
 	}
 
 	return ParseTaskResponse(respBody)
 }
 
-// ParseTaskResponse parses the task creation response
+// ParseTaskResponse parses the task creation response; an error body becomes a *SoraError
 func ParseTaskResponse(body []byte) (string, error) {
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
@@ -612,13 +742,15 @@ func ParseTaskResponse(body []byte) (string, error) {
 
 	id, ok := result["id"].(string)
 	if !ok || id == "" {
-		if errMsg, ok := result["error"].(string); ok {
-			return "", errors.New(errMsg)
-		}
-		if detail, ok := result["detail"].(string); ok {
-			return "", errors.New(detail)
+		if result["error"] != nil || result["detail"] != nil {
+			code, message, _ := parseErrorBody(body)
+			kind := SoraErrorInvalidRequest
+			if isContentPolicyMessage(strings.ToLower(code + " " + message)) {
+				kind = SoraErrorContentPolicy
+			}
+			return "", &SoraError{Kind: kind, Code: code, Message: message}
 		}
-		return "", errors.New("no task ID in response")
+		return "", &SoraError{Kind: SoraErrorUpstream, Message: "no task ID in response"}
 	}
 
 	return id, nil
@@ -626,15 +758,11 @@ func ParseTaskResponse(body []byte) (string, error) {
 
 // GetPendingTasks gets the list of pending tasks
 func (c *SoraClient) GetPendingTasks(token string, proxyURL string) ([]PendingTask, error) {
-	respBody, statusCode, err := c.makeRequest("GET", "/nf/pending/v2", token, nil, "", proxyURL)
+	respBody, _, err := c.makeRequest("GET", "/nf/pending/v2", token, nil, "", proxyURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if statusCode >= 400 {
-		return nil, fmt.Errorf("API error %d: %s", statusCode, string(respBody))
-	}
-
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, err
@@ -666,15 +794,11 @@ func (c *SoraClient) GetPendingTasks(token string, proxyURL string) ([]PendingTa
 func (c *SoraClient) GetImageTasks(token string, limit int, proxyURL string) ([]map[string]interface{}, error) {
 	endpoint := fmt.Sprintf("/v2/recent_tasks?limit=%d", limit)
 
-	respBody, statusCode, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
+	respBody, _, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if statusCode >= 400 {
-		return nil, fmt.Errorf("API error %d: %s", statusCode, string(respBody))
-	}
-
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, err
@@ -703,15 +827,11 @@ func (c *SoraClient) GetImageTasks(token string, limit int, proxyURL string) ([]
 func (c *SoraClient) GetVideoDrafts(token string, limit int, proxyURL string) ([]VideoDraft, error) {
 	endpoint := fmt.Sprintf("/project_y/profile/drafts?limit=%d", limit)
 
-	respBody, statusCode, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
+	respBody, _, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if statusCode >= 400 {
-		return nil, fmt.Errorf("API error %d: %s", statusCode, string(respBody))
-	}
-
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, err
@@ -830,15 +950,11 @@ func (c *SoraClient) PublishVideo(draftID, token string, proxyURL string) (strin
 		"visibility":  "private",
 	}
 
-	respBody, statusCode, err := c.makeRequest("POST", "/project_y/post", token, payload, "", proxyURL)
+	respBody, _, err := c.makeRequest("POST", "/project_y/post", token, payload, "", proxyURL)
 	if err != nil {
 		return "", "", err
 	}
 
-	if statusCode >= 400 {
-		return "", "", fmt.Errorf("publish failed with status %d: %s", statusCode, string(respBody))
-	}
-
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return "", "", err
@@ -856,41 +972,39 @@ func (c *SoraClient) PublishVideo(draftID, token string, proxyURL string) (strin
 // DeletePost deletes a published post
 func (c *SoraClient) DeletePost(postID, token string, proxyURL string) error {
 	endpoint := fmt.Sprintf("/project_y/post/%s", postID)
-	_, statusCode, err := c.makeRequest("DELETE", endpoint, token, nil, "", proxyURL)
+	_, _, err := c.makeRequest("DELETE", endpoint, token, nil, "", proxyURL)
 	if err != nil {
 		return err
 	}
 
-	if statusCode >= 400 {
-		return fmt.Errorf("delete failed with status %d", statusCode)
-	}
-
 	return nil
 }
 
 // ========== Character (Cameo) API Methods ==========
 
-// UploadCharacterVideo uploads a video for character creation and returns cameo_id
+// UploadCharacterVideo uploads a video for character creation and returns cameo_id.
+// The content type is sniffed from the data, defaulting to MP4.
 func (c *SoraClient) UploadCharacterVideo(videoData []byte, token string, timestamps string, proxyURL string) (string, error) {
-	// Generate sentinel token
-	sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
+	contentType, err := SniffVideoType(videoData)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate sentinel token: %v", err)
+		contentType = VideoTypeMP4
 	}
+	return c.UploadCharacterFile(bytes.NewReader(videoData), int64(len(videoData)), contentType, token, timestamps, proxyURL)
+}
 
+// UploadCharacterFile streams size bytes of a video of the content type to Sora
+// for character creation and returns cameo_id. The video is not closed, so a
+// file can be rewound and uploaded again.
+func (c *SoraClient) UploadCharacterFile(video io.Reader, size int64, contentType, token, timestamps, proxyURL string) (string, error) {
 	// First, upload the video file
 	uploadPayload := map[string]interface{}{
-		"file_size": len(videoData),
-		"file_type": "video/mp4",
+		"file_size": size,
+		"file_type": contentType,
 	}
 
-	respBody, statusCode, err := c.makeRequest("POST", "/cameo/upload/init", token, uploadPayload, sentinelToken, proxyURL)
+	respBody, _, err := c.postWithSentinel("/cameo/upload/init", token, uploadPayload, proxyURL)
 	if err != nil {
-		return "", fmt.Errorf("upload init failed: %v", err)
-	}
-
-	if statusCode >= 400 {
-		return "", fmt.Errorf("upload init failed with status %d: %s", statusCode, string(respBody))
+		return "", fmt.Errorf("upload init failed: %w", err)
 	}
 
 	var initResult map[string]interface{}
@@ -907,15 +1021,15 @@ func (c *SoraClient) UploadCharacterVideo(videoData []byte, token string, timest
 
 	// Upload the actual video data
 	headers := map[string]string{
-		"Content-Type": "video/mp4",
+		"Content-Type": contentType,
 	}
-	_, statusCode, err = c.doTLSRequest("PUT", uploadURL, videoData, headers, proxyURL)
+	uploadBody, statusCode, _, err := c.doTLSStream("PUT", uploadURL, io.NopCloser(video), size, headers, proxyURL, "")
 	if err != nil {
 		return "", fmt.Errorf("video upload failed: %v", err)
 	}
 
 	if statusCode >= 400 {
-		return "", fmt.Errorf("video upload failed with status %d", statusCode)
+		return "", fmt.Errorf("video upload failed: %w", NewSoraError(statusCode, nil, uploadBody))
 	}
 
 	// Create the cameo with the uploaded video
@@ -924,13 +1038,9 @@ func (c *SoraClient) UploadCharacterVideo(videoData []byte, token string, timest
 		"timestamps": timestamps,
 	}
 
-	respBody, statusCode, err = c.makeRequest("POST", "/cameo/create", token, cameoPayload, sentinelToken, proxyURL)
+	respBody, _, err = c.postWithSentinel("/cameo/create", token, cameoPayload, proxyURL)
 	if err != nil {
-		return "", fmt.Errorf("cameo create failed: %v", err)
-	}
-
-	if statusCode >= 400 {
-		return "", fmt.Errorf("cameo create failed with status %d: %s", statusCode, string(respBody))
+		return "", fmt.Errorf("cameo create failed: %w", err)
 	}
 
 	var cameoResult map[string]interface{}
@@ -954,41 +1064,43 @@ func (c *SoraClient) UploadCharacterVideo(videoData []byte, token string, timest
 
 // GetCameoStatus gets the processing status of a cameo
 func (c *SoraClient) GetCameoStatus(cameoID, token string, proxyURL string) (string, string, error) {
-	endpoint := fmt.Sprintf("/cameo/%s", cameoID)
-
-	respBody, statusCode, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
+	status, err := c.GetCameo(cameoID, token, proxyURL)
 	if err != nil {
 		return "", "", err
 	}
+	return status.Status, status.ProfileURL, nil
+}
 
-	if statusCode >= 400 {
-		return "", "", fmt.Errorf("get cameo status failed with status %d: %s", statusCode, string(respBody))
+// GetCameo gets the processing status of a cameo, with the error of a failed one
+func (c *SoraClient) GetCameo(cameoID, token string, proxyURL string) (*models.CameoStatus, error) {
+	endpoint := fmt.Sprintf("/cameo/%s", cameoID)
+
+	respBody, _, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
+	if err != nil {
+		return nil, err
 	}
 
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	status, _ := result["status"].(string)
-	profileURL, _ := result["profile_url"].(string)
-
-	return status, profileURL, nil
+	status := &models.CameoStatus{CameoID: cameoID}
+	status.Status, _ = result["status"].(string)
+	status.ProfileURL, _ = result["profile_url"].(string)
+	status.Error, _ = result["error_message"].(string)
+	return status, nil
 }
 
 // CheckUsernameAvailable checks if a username is available for character creation
 func (c *SoraClient) CheckUsernameAvailable(username, token string, proxyURL string) (bool, error) {
 	endpoint := fmt.Sprintf("/cameo/username/check?username=%s", url.QueryEscape(username))
 
-	respBody, statusCode, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
+	respBody, _, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
 	if err != nil {
 		return false, err
 	}
 
-	if statusCode >= 400 {
-		return false, fmt.Errorf("username check failed with status %d: %s", statusCode, string(respBody))
-	}
-
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return false, err
@@ -1000,12 +1112,6 @@ func (c *SoraClient) CheckUsernameAvailable(username, token string, proxyURL str
 
 // FinalizeCharacter finalizes a cameo into a character with username and settings
 func (c *SoraClient) FinalizeCharacter(cameoID, username, displayName, instructionSet, safetyInstructionSet, visibility, token string, proxyURL string) (string, string, error) {
-	// Generate sentinel token
-	sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to generate sentinel token: %v", err)
-	}
-
 	payload := map[string]interface{}{
 		"cameo_id":               cameoID,
 		"username":               username,
@@ -1015,13 +1121,9 @@ func (c *SoraClient) FinalizeCharacter(cameoID, username, displayName, instructi
 		"visibility":             visibility,
 	}
 
-	respBody, statusCode, err := c.makeRequest("POST", "/cameo/finalize", token, payload, sentinelToken, proxyURL)
+	respBody, _, err := c.postWithSentinel("/cameo/finalize", token, payload, proxyURL)
 	if err != nil {
-		return "", "", fmt.Errorf("finalize failed: %v", err)
-	}
-
-	if statusCode >= 400 {
-		return "", "", fmt.Errorf("finalize failed with status %d: %s", statusCode, string(respBody))
+		return "", "", fmt.Errorf("finalize failed: %w", err)
 	}
 
 	var result map[string]interface{}
@@ -1042,15 +1144,11 @@ func (c *SoraClient) FinalizeCharacter(cameoID, username, displayName, instructi
 func (c *SoraClient) SearchCharacter(username, token string, proxyURL string) ([]map[string]interface{}, error) {
 	endpoint := fmt.Sprintf("/cameo/search?q=%s&intent=use", url.QueryEscape(username))
 
-	respBody, statusCode, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
+	respBody, _, err := c.makeRequest("GET", endpoint, token, nil, "", proxyURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if statusCode >= 400 {
-		return nil, fmt.Errorf("search failed with status %d: %s", statusCode, string(respBody))
-	}
-
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, err
@@ -1075,29 +1173,21 @@ func (c *SoraClient) SearchCharacter(username, token string, proxyURL string) ([
 func (c *SoraClient) DeleteCharacter(characterID, token string, proxyURL string) error {
 	endpoint := fmt.Sprintf("/cameo/%s", characterID)
 
-	_, statusCode, err := c.makeRequest("DELETE", endpoint, token, nil, "", proxyURL)
+	_, _, err := c.makeRequest("DELETE", endpoint, token, nil, "", proxyURL)
 	if err != nil {
 		return err
 	}
 
-	if statusCode >= 400 {
-		return fmt.Errorf("delete character failed with status %d", statusCode)
-	}
-
 	return nil
 }
 
 // GetMyCharacters gets all characters owned by the current user
 func (c *SoraClient) GetMyCharacters(token string, proxyURL string) ([]map[string]interface{}, error) {
-	respBody, statusCode, err := c.makeRequest("GET", "/cameo/mine", token, nil, "", proxyURL)
+	respBody, _, err := c.makeRequest("GET", "/cameo/mine", token, nil, "", proxyURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if statusCode >= 400 {
-		return nil, fmt.Errorf("get my characters failed with status %d: %s", statusCode, string(respBody))
-	}
-
 	var result map[string]interface{}
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, err
@@ -1131,22 +1221,12 @@ func (c *SoraClient) BuildVideoPayloadWithCameo(prompt, orientation, mediaID str
 
 // GenerateVideoWithCameo starts a video generation task with character references
 func (c *SoraClient) GenerateVideoWithCameo(prompt, token, orientation, mediaID string, nFrames int, styleID, model, size string, cameoIDs []string, proxyURL string) (string, error) {
-	// Generate sentinel token
-	sentinelToken, err := c.GenerateSentinelToken(token, proxyURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate sentinel token: %v", err)
-	}
-
 	payload := c.BuildVideoPayloadWithCameo(prompt, orientation, mediaID, nFrames, styleID, model, size, cameoIDs)
 
-	respBody, statusCode, err := c.makeRequest("POST", "/nf/create", token, payload, sentinelToken, proxyURL)
+	respBody, _, err := c.postWithSentinel("/nf/create", token, payload, proxyURL)
 	if err != nil {
 		return "", err
 	}
 
-	if statusCode >= 400 {
-		return "", fmt.Errorf("API error %d: %s", statusCode, string(respBody))
-	}
-
 	return ParseTaskResponse(respBody)
 }
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"soranow/internal/mocksora"
 )
 
 func TestSoraClient_NewClient(t *testing.T) {
@@ -108,6 +110,11 @@ func TestSoraClient_ParseTaskResponse_Error(t *testing.T) {
 func TestSoraClient_MockGenerateImage(t *testing.T) {
 	// Create mock server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sentinel" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"token": "sentinel_abc"})
+			return
+		}
 		if r.URL.Path == "/video_gen" {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{"id": "img_task_123"})
@@ -118,6 +125,7 @@ func TestSoraClient_MockGenerateImage(t *testing.T) {
 	defer server.Close()
 
 	client := NewSoraClient(server.URL, 30, nil)
+	client.SetSentinelURL(server.URL + "/sentinel")
 
 	taskID, err := client.GenerateImage("test prompt", "fake_token", 360, 360, "", "")
 	if err != nil {
@@ -161,3 +169,64 @@ func TestSoraClient_FindTaskInImageTasks(t *testing.T) {
 		t.Errorf("Expected status 'succeeded', got '%v'", task["status"])
 	}
 }
+
+func TestSoraClient_ConfigureRetriesStatusRequests(t *testing.T) {
+	var gets, posts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "POST" {
+			posts++
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		gets++
+		if gets == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"task_responses": []interface{}{}})
+	}))
+	defer server.Close()
+
+	client := NewSoraClient("https://unused.example.com", 30, nil)
+	defer client.Close()
+	client.Configure(SoraClientConfig{BaseURL: server.URL + "/", MaxRetries: 1})
+
+	if _, err := client.GetImageTasks("fake_token", 20, ""); err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	if gets != 2 {
+		t.Errorf("Expected 2 GET attempts, got %d", gets)
+	}
+
+	// Task creation is never retried
+	client.makeRequest("POST", "/nf/create", "fake_token", map[string]string{}, "", "")
+	if posts != 1 {
+		t.Errorf("Expected 1 POST attempt, got %d", posts)
+	}
+}
+
+func TestSoraClient_SentinelCachedAndRefreshedOnRejection(t *testing.T) {
+	client, mock := newMockSora(t, nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GenerateImage("a cat", "at_test", 360, 360, "", ""); err != nil {
+			t.Fatalf("GenerateImage failed: %v", err)
+		}
+	}
+	if calls := mock.Calls("POST " + mocksora.SentinelPath); calls != 1 {
+		t.Errorf("Expected sentinel token to be reused, got %d sentinel calls", calls)
+	}
+
+	// A rejected token is dropped and the request retried once with a fresh one
+	mock.RejectSentinels(1)
+	if _, err := client.GenerateImage("a dog", "at_test", 360, 360, "", ""); err != nil {
+		t.Fatalf("Expected retry with a fresh sentinel token, got %v", err)
+	}
+	if calls := mock.Calls("POST " + mocksora.SentinelPath); calls != 2 {
+		t.Errorf("Expected sentinel token to be refreshed, got %d sentinel calls", calls)
+	}
+	if calls := mock.Calls("POST " + mocksora.BasePath + "/video_gen"); calls != 4 {
+		t.Errorf("Expected 4 creation attempts, got %d", calls)
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	http2 "github.com/bogdanfinn/fhttp"
+)
+
+// SoraErrorKind classifies a failed Sora request
+type SoraErrorKind string
+
+const (
+	SoraErrorAuth           SoraErrorKind = "auth"            // Access token invalid, expired or revoked
+	SoraErrorForbidden      SoraErrorKind = "forbidden"       // Account not allowed to use the feature
+	SoraErrorQuota          SoraErrorKind = "quota"           // Generation quota used up
+	SoraErrorRateLimit      SoraErrorKind = "rate_limit"      // Too many requests
+	SoraErrorContentPolicy  SoraErrorKind = "content_policy"  // Prompt or media refused by moderation
+	SoraErrorCloudflare     SoraErrorKind = "cloudflare"      // Blocked by a Cloudflare challenge
+	SoraErrorSentinel       SoraErrorKind = "sentinel"        // Sentinel token rejected
+	SoraErrorNotFound       SoraErrorKind = "not_found"       // Task, post or character does not exist
+	SoraErrorInvalidRequest SoraErrorKind = "invalid_request" // Any other 4xx
+	SoraErrorUpstream       SoraErrorKind = "upstream"        // 5xx or unreadable response
+	SoraErrorTaskFailed     SoraErrorKind = "task_failed"     // Task accepted but failed while generating
+)
+
+// SoraError is a failed Sora request or generation
+type SoraError struct {
+	Kind       SoraErrorKind
+	StatusCode int           // HTTP status, 0 for failed tasks
+	Code       string        // Upstream error code, if any
+	Message    string        // Upstream error message, or the raw body
+	RetryAfter time.Duration // From the Retry-After header or the body, 0 if not given
+}
+
+func (e *SoraError) Error() string {
+	if e.StatusCode > 0 {
+		return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
+	}
+	return e.Message
+}
+
+// Retryable reports whether the same request may succeed later, possibly on another token
+func (e *SoraError) Retryable() bool {
+	switch e.Kind {
+	case SoraErrorRateLimit, SoraErrorCloudflare, SoraErrorSentinel, SoraErrorUpstream:
+		return true
+	}
+	return false
+}
+
+// AsSoraError returns the SoraError in err's chain, if any
+func AsSoraError(err error) (*SoraError, bool) {
+	var soraErr *SoraError
+	if errors.As(err, &soraErr) {
+		return soraErr, true
+	}
+	return nil, false
+}
+
+// IsSoraError reports whether err is a SoraError of the kind
+func IsSoraError(err error, kind SoraErrorKind) bool {
+	soraErr, ok := AsSoraError(err)
+	return ok && soraErr.Kind == kind
+}
+
+// maxErrorMessage caps how much of an unparsed body ends up in an error message
+const maxErrorMessage = 300
+
+// NewSoraError classifies a failed response from its status, headers (may be nil) and body
+func NewSoraError(statusCode int, header http2.Header, body []byte) *SoraError {
+	code, message, bodyRetry := parseErrorBody(body)
+	e := &SoraError{
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    message,
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+	}
+	if e.RetryAfter == 0 {
+		e.RetryAfter = bodyRetry
+	}
+
+	lower := strings.ToLower(code + " " + message)
+	raw := strings.ToLower(string(body))
+	switch {
+	case isCloudflareChallenge(statusCode, header, body):
+		e.Kind = SoraErrorCloudflare
+		e.Message = "Cloudflare challenge"
+	case (statusCode == 400 || statusCode == 403) && strings.Contains(raw, "sentinel"):
+		e.Kind = SoraErrorSentinel
+	case isContentPolicyMessage(lower):
+		e.Kind = SoraErrorContentPolicy
+	case statusCode == 429 && isQuotaMessage(lower):
+		e.Kind = SoraErrorQuota
+	case statusCode == 429:
+		e.Kind = SoraErrorRateLimit
+	case statusCode == 401:
+		e.Kind = SoraErrorAuth
+	case statusCode == 403:
+		e.Kind = SoraErrorForbidden
+	case statusCode == 404:
+		e.Kind = SoraErrorNotFound
+	case statusCode >= 500:
+		e.Kind = SoraErrorUpstream
+	default:
+		e.Kind = SoraErrorInvalidRequest
+	}
+	return e
+}
+
+// newTaskError classifies the error message of a task that failed while generating
+func newTaskError(message string) *SoraError {
+	kind := SoraErrorTaskFailed
+	if isContentPolicyMessage(strings.ToLower(message)) {
+		kind = SoraErrorContentPolicy
+	}
+	return &SoraError{Kind: kind, Message: message}
+}
+
+// parseErrorBody extracts the code, message and retry hint from an error body.
+// Sora answers with {"error": {"code", "message"}}, {"error": "..."} or {"detail": "..."}.
+func parseErrorBody(body []byte) (code, message string, retryAfter time.Duration) {
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", truncateMessage(string(body)), 0
+	}
+
+	switch e := result["error"].(type) {
+	case string:
+		message = e
+	case map[string]interface{}:
+		message, _ = e["message"].(string)
+		code, _ = e["code"].(string)
+		if code == "" {
+			code, _ = e["type"].(string)
+		}
+	}
+	if message == "" {
+		switch d := result["detail"].(type) {
+		case string:
+			message = d
+		case map[string]interface{}:
+			message, _ = d["message"].(string)
+			if code == "" {
+				code, _ = d["code"].(string)
+			}
+		}
+	}
+	if code == "" {
+		code, _ = result["code"].(string)
+	}
+	if seconds, ok := result["retry_after"].(float64); ok && seconds > 0 {
+		retryAfter = time.Duration(seconds * float64(time.Second))
+	}
+	if message == "" {
+		message = truncateMessage(string(body))
+	}
+	return code, message, retryAfter
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := time.Parse(time.RFC1123, value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isQuotaMessage reports an exhausted quota; plain rate limits ("Rate limit
+// exceeded", rate_limit_exceeded) are not quota and only cool down briefly
+func isQuotaMessage(lower string) bool {
+	return strings.Contains(lower, "quota") || strings.Contains(lower, "daily_limit") ||
+		strings.Contains(lower, "daily limit") || strings.Contains(lower, "daily generation limit") ||
+		strings.Contains(lower, "usage_limit") || strings.Contains(lower, "usage limit")
+}
+
+func isContentPolicyMessage(lower string) bool {
+	return strings.Contains(lower, "content_policy") || strings.Contains(lower, "content polic") ||
+		strings.Contains(lower, "moderation") || strings.Contains(lower, "guardrail")
+}
+
+func truncateMessage(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxErrorMessage {
+		return s[:maxErrorMessage] + "..."
+	}
+	return s
+}
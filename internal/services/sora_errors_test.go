@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	http2 "github.com/bogdanfinn/fhttp"
+)
+
+func TestNewSoraError_Classification(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		header    http2.Header
+		body      string
+		kind      SoraErrorKind
+		retryable bool
+	}{
+		{"expired token", 401, nil, `{"detail":"Unauthorized"}`, SoraErrorAuth, false},
+		{"forbidden", 403, nil, `{"error":{"message":"Not available in your region"}}`, SoraErrorForbidden, false},
+		{"quota", 429, nil, `{"error":{"code":"daily_limit","message":"You've reached your daily generation limit"}}`, SoraErrorQuota, false},
+		{"insufficient quota", 429, nil, `{"error":{"code":"insufficient_quota","message":"Out of credits"}}`, SoraErrorQuota, false},
+		{"usage limit", 429, nil, `{"error":{"message":"Usage limit reached, try again tomorrow"}}`, SoraErrorQuota, false},
+		{"rate limit", 429, nil, `{"error":{"message":"Too many requests"}}`, SoraErrorRateLimit, true},
+		{"rate limit exceeded", 429, nil, `{"error":{"message":"Rate limit exceeded"}}`, SoraErrorRateLimit, true},
+		{"rate limit code", 429, nil, `{"error":{"code":"rate_limit_exceeded","message":"Slow down"}}`, SoraErrorRateLimit, true},
+		{"content policy", 400, nil, `{"error":{"code":"content_policy_violation","message":"Prompt rejected"}}`, SoraErrorContentPolicy, false},
+		{"cloudflare", 403, nil, `<html><title>Just a moment...</title></html>`, SoraErrorCloudflare, true},
+		{"cloudflare header", 403, http2.Header{"Cf-Mitigated": {"challenge"}}, ``, SoraErrorCloudflare, true},
+		{"sentinel", 403, nil, `{"detail":"Invalid openai-sentinel-token"}`, SoraErrorSentinel, true},
+		{"not found", 404, nil, `{"detail":"Not found"}`, SoraErrorNotFound, false},
+		{"bad request", 400, nil, `{"error":"n_frames out of range"}`, SoraErrorInvalidRequest, false},
+		{"upstream", 502, nil, `Bad Gateway`, SoraErrorUpstream, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewSoraError(tt.status, tt.header, []byte(tt.body))
+			if err.Kind != tt.kind {
+				t.Errorf("Expected kind %s, got %s", tt.kind, err.Kind)
+			}
+			if err.Retryable() != tt.retryable {
+				t.Errorf("Expected retryable %v, got %v", tt.retryable, err.Retryable())
+			}
+		})
+	}
+}
+
+func TestNewSoraError_Details(t *testing.T) {
+	err := NewSoraError(429, http2.Header{"Retry-After": {"30"}}, []byte(`{"error":{"code":"too_many_requests","message":"Slow down"}}`))
+	if err.Code != "too_many_requests" || err.Message != "Slow down" || err.RetryAfter != 30*time.Second {
+		t.Errorf("Unexpected details: %+v", err)
+	}
+	if err.Error() != "API error 429: Slow down" {
+		t.Errorf("Unexpected message: %s", err.Error())
+	}
+
+	// The body's retry hint is used without a header
+	err = NewSoraError(429, nil, []byte(`{"detail":"Too many requests","retry_after":5}`))
+	if err.RetryAfter != 5*time.Second {
+		t.Errorf("Expected retry after 5s, got %v", err.RetryAfter)
+	}
+
+	// Wrapped errors keep their kind
+	wrapped := fmt.Errorf("failed to start generation: %w", err)
+	if !IsSoraError(wrapped, SoraErrorRateLimit) {
+		t.Error("Expected wrapped rate limit error")
+	}
+}
+
+func TestParseTaskResponse_ContentPolicy(t *testing.T) {
+	_, err := ParseTaskResponse([]byte(`{"error":{"message":"This prompt may violate our content policies."}}`))
+	if !IsSoraError(err, SoraErrorContentPolicy) {
+		t.Errorf("Expected content policy error, got %v", err)
+	}
+	if newTaskError("This content may violate our content policies.").Kind != SoraErrorContentPolicy {
+		t.Error("Expected failed task to be classified as content policy")
+	}
+	if newTaskError("Internal error").Kind != SoraErrorTaskFailed {
+		t.Error("Expected failed task")
+	}
+}
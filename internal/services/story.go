@@ -0,0 +1,565 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// storyClipFrames are the clip lengths Sora generates, shortest first
+var storyClipFrames = []int{300, 450, 750}
+
+// storyDurationSuffix matches the duration of a video model name, e.g. "-15s"
+var storyDurationSuffix = regexp.MustCompile(`-\d+s$`)
+
+// StoryClipPlan is one Sora generation of a story
+type StoryClipPlan struct {
+	Model      string      `json:"model"`
+	Storyboard *Storyboard `json:"storyboard"`
+}
+
+// storyClipModel returns the model generating a clip of nFrames frames: the
+// story model with the clip duration, e.g. sora2-landscape-10s
+func storyClipModel(model string, nFrames int) string {
+	return fmt.Sprintf("%s-%ds", storyDurationSuffix.ReplaceAllString(model, ""), nFrames/StoryboardFPS)
+}
+
+// PlanStory splits a storyboard into clips Sora can generate. Consecutive shots
+// are packed into clips of at most 25 seconds (15 for HD models) without
+// splitting a shot; the last shot of each clip is extended so the clip fills
+// the next clip length Sora offers. The instructions apply to every clip.
+func PlanStory(sb *Storyboard, model string) ([]StoryClipPlan, error) {
+	modelCfg := ParseModel(model)
+	if !modelCfg.IsVideo {
+		return nil, storyboardError("故事需要使用视频模型，当前模型: %s", model)
+	}
+	if err := sb.validateShots(); err != nil {
+		return nil, err
+	}
+
+	lengths := storyClipFrames
+	if strings.Contains(strings.ToLower(model), "hd") {
+		lengths = lengths[:2]
+	}
+	maxFrames := lengths[len(lengths)-1]
+
+	var plans []StoryClipPlan
+	var shots []StoryboardShot
+	frames := 0
+	flush := func() {
+		target := maxFrames
+		for _, length := range lengths {
+			if length >= frames {
+				target = length
+				break
+			}
+		}
+		shots[len(shots)-1].Duration += float64(target-frames) / StoryboardFPS
+		plans = append(plans, StoryClipPlan{
+			Model:      storyClipModel(model, target),
+			Storyboard: &Storyboard{Instructions: sb.Instructions, Shots: shots},
+		})
+		shots, frames = nil, 0
+	}
+	for i, shot := range sb.Shots {
+		shotLength := shotFrames(shot.Duration)
+		if shotLength > maxFrames {
+			return nil, storyboardError("第 %d 个镜头超过单个片段上限 %d 秒", i+1, maxFrames/StoryboardFPS)
+		}
+		if frames+shotLength > maxFrames {
+			flush()
+		}
+		shots = append(shots, shot)
+		frames += shotLength
+	}
+	flush()
+
+	for i, plan := range plans {
+		if sb.Title != "" {
+			plan.Storyboard.Title = fmt.Sprintf("%s (%d/%d)", sb.Title, i+1, len(plans))
+		}
+	}
+	return plans, nil
+}
+
+// storyProgressShare is the part of the job progress taken by the clip generations,
+// the rest is joining
+const storyProgressShare = 90
+
+// StoryRenderer renders story jobs: the clips of a story are generated as
+// storyboard tasks, then downloaded and joined into one MP4 in the file cache
+type StoryRenderer struct {
+	db         *database.DB
+	generation *GenerationHandler
+	cache      *FileCache
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	maxParallel int
+	ffmpegPath  string
+	running     map[string]bool // Job IDs rendering in this process
+	wg          sync.WaitGroup
+}
+
+// NewStoryRenderer creates a story renderer generating clips with the generation handler
+func NewStoryRenderer(db *database.DB, generation *GenerationHandler, cache *FileCache) *StoryRenderer {
+	return &StoryRenderer{
+		db:          db,
+		generation:  generation,
+		cache:       cache,
+		httpClient:  &http.Client{Timeout: 10 * time.Minute},
+		maxParallel: 2,
+		running:     make(map[string]bool),
+	}
+}
+
+// Configure sets how many clips of a job are generated at once and the ffmpeg
+// binary joining them; without ffmpeg the built-in MP4 muxer is used
+func (r *StoryRenderer) Configure(maxParallel int, ffmpegPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if maxParallel > 0 {
+		r.maxParallel = maxParallel
+	}
+	r.ffmpegPath = ffmpegPath
+}
+
+// Start plans a story job and renders it in the background
+func (r *StoryRenderer) Start(sb *Storyboard, model string) (*models.StoryJob, error) {
+	plans, err := PlanStory(sb, model)
+	if err != nil {
+		return nil, err
+	}
+	storyboard, _ := json.Marshal(sb)
+
+	job := &models.StoryJob{
+		JobID:      "story_" + uuid.New().String(),
+		Model:      model,
+		Title:      sb.Title,
+		Storyboard: string(storyboard),
+		Status:     models.StoryStatusPending,
+	}
+	if job.ID, err = r.db.CreateStoryJob(job); err != nil {
+		return nil, err
+	}
+	for i, plan := range plans {
+		storyboard, _ := json.Marshal(plan.Storyboard)
+		clip := &models.StoryClip{
+			JobID:      job.ID,
+			Index:      i,
+			Model:      plan.Model,
+			Storyboard: string(storyboard),
+			Status:     models.StoryClipPending,
+		}
+		if clip.ID, err = r.db.CreateStoryClip(clip); err != nil {
+			r.db.DeleteStoryJob(job.ID)
+			return nil, err
+		}
+		job.Clips = append(job.Clips, clip)
+	}
+
+	// The rendering goroutine updates its own copy
+	r.launch(cloneStoryJob(job))
+	return job, nil
+}
+
+// cloneStoryJob copies a job and its clips
+func cloneStoryJob(job *models.StoryJob) *models.StoryJob {
+	clone := *job
+	clone.Clips = make([]*models.StoryClip, len(job.Clips))
+	for i, clip := range job.Clips {
+		c := *clip
+		clone.Clips[i] = &c
+	}
+	return &clone
+}
+
+// Job returns a story job with its clips
+func (r *StoryRenderer) Job(jobID string) (*models.StoryJob, error) {
+	job, err := r.db.GetStoryJobByJobID(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Clips, err = r.db.GetStoryClips(job.ID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Resume continues story jobs left unfinished by a previous run. Generated clips
+// are kept; clips whose task was checkpointed wait for the resumed task. Call it
+// after GenerationHandler.ResumeTasks. Returns the number of resumed jobs.
+func (r *StoryRenderer) Resume() (int, error) {
+	jobs, err := r.db.GetUnfinishedStoryJobs()
+	if err != nil {
+		return 0, err
+	}
+	resumed := 0
+	for _, job := range jobs {
+		if job.Clips, err = r.db.GetStoryClips(job.ID); err != nil {
+			return resumed, err
+		}
+		if r.launch(job) {
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		log.Printf("Resumed %d story jobs", resumed)
+	}
+	return resumed, nil
+}
+
+// Wait waits for the jobs rendering in the background
+func (r *StoryRenderer) Wait() {
+	r.wg.Wait()
+}
+
+// launch renders a job in the background unless it is already rendering
+func (r *StoryRenderer) launch(job *models.StoryJob) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[job.JobID] {
+		return false
+	}
+	r.running[job.JobID] = true
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() {
+			r.mu.Lock()
+			delete(r.running, job.JobID)
+			r.mu.Unlock()
+		}()
+		r.render(job)
+	}()
+	return true
+}
+
+// render generates the missing clips of a job and joins them
+func (r *StoryRenderer) render(job *models.StoryJob) {
+	job.Status = models.StoryStatusRendering
+	r.db.UpdateStoryJob(job)
+
+	if err := r.renderClips(job); err != nil {
+		if errors.Is(err, ErrShuttingDown) {
+			// Left unfinished for Resume on the next start
+			return
+		}
+		r.fail(job, err)
+		return
+	}
+
+	job.Status = models.StoryStatusConcatting
+	job.Progress = storyProgressShare
+	r.db.UpdateStoryJob(job)
+
+	filename := "stories/" + job.JobID + ".mp4"
+	if err := r.join(job.Clips, filename); err != nil {
+		if errors.Is(err, ErrShuttingDown) {
+			return
+		}
+		r.fail(job, fmt.Errorf("拼接片段失败: %w", err))
+		return
+	}
+
+	now := time.Now()
+	job.Status = models.StoryStatusCompleted
+	job.Progress = 100
+	job.ResultFile = filename
+	job.ResultURL = r.cache.GetURL(filename)
+	job.CompletedAt = &now
+	r.db.UpdateStoryJob(job)
+	log.Printf("[Story] %s completed with %d clips", job.JobID, len(job.Clips))
+}
+
+// fail records the failure of a job
+func (r *StoryRenderer) fail(job *models.StoryJob, err error) {
+	now := time.Now()
+	job.Status = models.StoryStatusFailed
+	job.ErrorMessage = err.Error()
+	job.CompletedAt = &now
+	r.db.UpdateStoryJob(job)
+	log.Printf("[Story] %s failed: %v", job.JobID, err)
+}
+
+// renderClips generates the clips not generated yet, a few at a time. The first
+// failure cancels the other clips as cancelled tasks, so their tokens are not charged.
+func (r *StoryRenderer) renderClips(job *models.StoryJob) error {
+	r.mu.Lock()
+	maxParallel := r.maxParallel
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	var mu sync.Mutex // Guards the job progress and failure
+	var failure error
+	progress := make([]float64, len(job.Clips))
+	for i, clip := range job.Clips {
+		if clip.Status == models.StoryClipCompleted {
+			progress[i] = 100
+		}
+	}
+	report := func(index int, p float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress[index] = p
+		total := 0.0
+		for _, p := range progress {
+			total += p
+		}
+		job.Progress = total / float64(len(progress)) * storyProgressShare / 100
+		r.db.UpdateStoryJob(job)
+	}
+
+	// Slots are taken in clip order, so the first clips start first
+	slots := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+launch:
+	for i, clip := range job.Clips {
+		if clip.Status == models.StoryClipCompleted {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break launch
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, clip *models.StoryClip) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := r.renderClip(ctx, clip, func(p float64) { report(i, p) }); err != nil {
+				mu.Lock()
+				if failure == nil && ctx.Err() == nil {
+					failure = err
+				}
+				mu.Unlock()
+				if errors.Is(err, ErrShuttingDown) {
+					cancel(err)
+				} else {
+					cancel(ErrTaskCancelled)
+				}
+				return
+			}
+			report(i, 100)
+		}(i, clip)
+	}
+	wg.Wait()
+	return failure
+}
+
+// renderClip generates one clip, or waits for the task of a clip checkpointed by a previous run
+func (r *StoryRenderer) renderClip(ctx context.Context, clip *models.StoryClip, report func(float64)) error {
+	var result *GenerationResult
+	var err error
+	if clip.TaskID != "" && clip.Status == models.StoryClipProcessing {
+		result, err = r.waitForTask(ctx, clip.TaskID)
+	} else {
+		var sb Storyboard
+		if err := json.Unmarshal([]byte(clip.Storyboard), &sb); err != nil {
+			return fmt.Errorf("片段 %d 的分镜无效: %w", clip.Index+1, err)
+		}
+		clip.Status = models.StoryClipProcessing
+		clip.TaskID = ""
+		clip.ErrorMessage = ""
+		r.db.UpdateStoryClip(clip)
+
+		// The task ID is stored once the task exists, so a checkpointed clip can be resumed
+		taskCtx := WithTaskCreated(ctx, func(taskID string) {
+			clip.TaskID = taskID
+			r.db.UpdateStoryClip(clip)
+		})
+		events := make(chan StreamEvent, 16)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for event := range events {
+				if event.Type == "progress" {
+					report(event.Progress)
+				}
+			}
+		}()
+		result, err = r.generation.GenerateStoryboard(taskCtx, &sb, clip.Model, true, events)
+		close(events)
+		<-done
+	}
+
+	if errors.Is(err, ErrShuttingDown) {
+		return err
+	}
+	if err == nil && len(result.URLs) == 0 {
+		err = errors.New("no video URL")
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// Cancelled by the failure of another clip
+			clip.Status = models.StoryClipPending
+			r.db.UpdateStoryClip(clip)
+			return ctx.Err()
+		}
+		clip.Status = models.StoryClipFailed
+		clip.ErrorMessage = err.Error()
+		r.db.UpdateStoryClip(clip)
+		return fmt.Errorf("片段 %d 生成失败: %w", clip.Index+1, err)
+	}
+
+	clip.TaskID = result.TaskID
+	clip.Status = models.StoryClipCompleted
+	clip.ResultURL = result.URLs[0]
+	r.db.UpdateStoryClip(clip)
+	return nil
+}
+
+// waitForTask waits for a task resumed by GenerationHandler.ResumeTasks to finish
+func (r *StoryRenderer) waitForTask(ctx context.Context, taskID string) (*GenerationResult, error) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		task, err := r.db.GetTaskByTaskID(taskID)
+		if err != nil {
+			return nil, err
+		}
+		switch task.Status {
+		case models.TaskStatusCompleted:
+			var urls []string
+			json.Unmarshal([]byte(task.ResultURLs), &urls)
+			return &GenerationResult{TaskID: taskID, Status: task.Status, Progress: 100, URLs: urls}, nil
+		case models.TaskStatusProcessing:
+			if r.generation.IsDraining() {
+				return nil, ErrShuttingDown
+			}
+		default:
+			if task.ErrorMessage != "" {
+				return nil, errors.New(task.ErrorMessage)
+			}
+			return nil, fmt.Errorf("task %s", task.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// join downloads the clips and joins them into a cache file. It stops with
+// ErrShuttingDown once the server drains; the job is joined again on Resume.
+func (r *StoryRenderer) join(clips []*models.StoryClip, filename string) error {
+	if r.generation.IsDraining() {
+		return ErrShuttingDown
+	}
+	dir, err := os.MkdirTemp("", "story-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	paths := make([]string, len(clips))
+	for i, clip := range clips {
+		if r.generation.IsDraining() {
+			return ErrShuttingDown
+		}
+		paths[i] = filepath.Join(dir, fmt.Sprintf("clip%03d.mp4", i))
+		if err := r.download(clip.ResultURL, paths[i]); err != nil {
+			return fmt.Errorf("下载片段 %d 失败: %w", i+1, err)
+		}
+	}
+
+	r.mu.Lock()
+	ffmpegPath := r.ffmpegPath
+	r.mu.Unlock()
+	if ffmpegPath != "" {
+		return r.joinWithFFmpeg(ffmpegPath, dir, paths, filename)
+	}
+
+	inputs := make([]*io.SectionReader, len(paths))
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		inputs[i] = io.NewSectionReader(f, 0, info.Size())
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(ConcatMP4(pw, inputs))
+	}()
+	_, err = r.cache.SaveFrom(filename, pr)
+	pr.Close()
+	return err
+}
+
+// joinWithFFmpeg joins the clips with the ffmpeg concat demuxer without re-encoding
+func (r *StoryRenderer) joinWithFFmpeg(ffmpegPath, dir string, paths []string, filename string) error {
+	var list strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(path, "'", `'\''`))
+	}
+	listPath := filepath.Join(dir, "clips.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+
+	output := filepath.Join(dir, "story.mp4")
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-loglevel", "error", "-y",
+		"-f", "concat", "-safe", "0", "-i", listPath, "-c", "copy", "-movflags", "+faststart", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = r.cache.SaveFrom(filename, f)
+	return err
+}
+
+// download saves a clip video to a file
+func (r *StoryRenderer) download(url, path string) error {
+	resp, err := r.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
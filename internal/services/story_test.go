@@ -0,0 +1,274 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+func TestPlanStory(t *testing.T) {
+	sb := &Storyboard{Title: "Cats", Instructions: "pixel art", Shots: []StoryboardShot{
+		{Duration: 10, Prompt: "a"},
+		{Duration: 10, Prompt: "b"},
+		{Duration: 10, Prompt: "c"},
+		{Duration: 3.5, Prompt: "d"},
+	}}
+	plans, err := PlanStory(sb, "sora2-landscape-15s")
+	if err != nil {
+		t.Fatalf("PlanStory failed: %v", err)
+	}
+	if len(plans) != 2 {
+		t.Fatalf("Expected 2 clips, got %d", len(plans))
+	}
+
+	// 20s fill a 25s clip, 13.5s a 15s one
+	if plans[0].Model != "sora2-landscape-25s" || plans[1].Model != "sora2-landscape-15s" {
+		t.Errorf("Expected 25s and 15s clips, got %s and %s", plans[0].Model, plans[1].Model)
+	}
+	if shots := plans[0].Storyboard.Shots; len(shots) != 2 || shots[1].Duration != 15 {
+		t.Errorf("Expected the last shot of clip 1 extended to 15s, got %+v", shots)
+	}
+	if shots := plans[1].Storyboard.Shots; len(shots) != 2 || shots[1].Duration != 5 {
+		t.Errorf("Expected the last shot of clip 2 extended to 5s, got %+v", shots)
+	}
+	for i, plan := range plans {
+		if err := plan.Storyboard.Validate(ParseModel(plan.Model).NFrames); err != nil {
+			t.Errorf("Clip %d does not fill its model: %v", i+1, err)
+		}
+		if plan.Storyboard.Instructions != "pixel art" {
+			t.Errorf("Expected instructions on clip %d, got %q", i+1, plan.Storyboard.Instructions)
+		}
+	}
+	if plans[1].Storyboard.Title != "Cats (2/2)" {
+		t.Errorf("Expected numbered title, got %q", plans[1].Storyboard.Title)
+	}
+	if sb.Shots[1].Duration != 10 {
+		t.Error("Expected the requested storyboard unchanged")
+	}
+
+	// HD models top out at 15s clips
+	if _, err := PlanStory(&Storyboard{Shots: []StoryboardShot{{Duration: 20, Prompt: "a"}}}, "sora2pro-hd-landscape"); err == nil || !strings.Contains(err.Error(), "15 秒") {
+		t.Errorf("Expected shot over the HD clip limit rejected, got %v", err)
+	}
+	var soraErr *SoraError
+	if _, err := PlanStory(sb, "sora-image"); !errors.As(err, &soraErr) || soraErr.Kind != SoraErrorInvalidRequest {
+		t.Errorf("Expected image model rejected, got %v", err)
+	}
+}
+
+func newTestStoryRenderer(t *testing.T, opts *mocksora.Options) (*StoryRenderer, *mocksora.Server, *FileCache) {
+	t.Helper()
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+
+	token := &models.Token{Token: "at_story", Email: "story@example.com", IsActive: true, VideoEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, mock := newMockSora(t, opts)
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+	cache := NewFileCache(t.TempDir(), 0, "http://localhost:8000")
+	return NewStoryRenderer(db, h, cache), mock, cache
+}
+
+func TestStoryRenderer_RendersAndJoinsClips(t *testing.T) {
+	r, mock, cache := newTestStoryRenderer(t, &mocksora.Options{Polls: 1})
+
+	job, err := r.Start(&Storyboard{Shots: []StoryboardShot{
+		{Duration: 10, Prompt: "a cat jumps"},
+		{Duration: 10, Prompt: "the cat flies"},
+		{Duration: 10, Prompt: "the cat lands"},
+	}}, "sora2-landscape")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if len(job.Clips) != 2 || job.Clips[0].Model != "sora2-landscape-25s" || job.Clips[1].Model != "sora2-landscape-10s" {
+		t.Fatalf("Unexpected clips %+v", job.Clips)
+	}
+	r.Wait()
+
+	job, err = r.Job(job.JobID)
+	if err != nil {
+		t.Fatalf("Job failed: %v", err)
+	}
+	if job.Status != models.StoryStatusCompleted || job.Progress != 100 {
+		t.Fatalf("Expected completed job, got %s (%s)", job.Status, job.ErrorMessage)
+	}
+	if job.ResultURL != "http://localhost:8000/cache/stories/"+job.JobID+".mp4" {
+		t.Errorf("Unexpected result URL %s", job.ResultURL)
+	}
+	for _, clip := range job.Clips {
+		if clip.Status != models.StoryClipCompleted || clip.TaskID == "" {
+			t.Errorf("Expected completed clip with a task, got %+v", clip)
+		}
+		if task, err := r.db.GetTaskByTaskID(clip.TaskID); err != nil || task.Model != clip.Model {
+			t.Errorf("Expected child task of clip %d, got %+v (%v)", clip.Index, task, err)
+		}
+	}
+	if got := mock.Calls("POST /backend/nf/create/storyboard"); got != 2 {
+		t.Errorf("Expected 2 storyboard generations, got %d", got)
+	}
+
+	// Both clip videos are in the joined file
+	f, err := cache.Open(job.ResultFile)
+	if err != nil {
+		t.Fatalf("Result not in the cache: %v", err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	clip, err := parseMP4Clip(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		t.Fatalf("Result is not a valid MP4: %v", err)
+	}
+	if got := clip.tracks[0].samples(); got != 2*mocksora.MP4FPS {
+		t.Errorf("Expected %d samples, got %d", 2*mocksora.MP4FPS, got)
+	}
+}
+
+func TestStoryRenderer_FailedClipFailsJob(t *testing.T) {
+	r, mock, _ := newTestStoryRenderer(t, &mocksora.Options{Polls: 1, Scenarios: []mocksora.Scenario{
+		{Match: "crashes", Fail: "content policy"},
+	}})
+	r.Configure(1, "")
+
+	job, err := r.Start(&Storyboard{Shots: []StoryboardShot{
+		{Duration: 20, Prompt: "the cat crashes"},
+		{Duration: 10, Prompt: "the cat lands"},
+	}}, "sora2-landscape")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	r.Wait()
+
+	job, _ = r.Job(job.JobID)
+	if job.Status != models.StoryStatusFailed || !strings.Contains(job.ErrorMessage, "片段 1") {
+		t.Errorf("Expected job failed by clip 1, got %s (%s)", job.Status, job.ErrorMessage)
+	}
+	if job.Clips[0].Status != models.StoryClipFailed || job.Clips[1].Status != models.StoryClipPending {
+		t.Errorf("Expected clip 1 failed and clip 2 not started, got %s and %s", job.Clips[0].Status, job.Clips[1].Status)
+	}
+	if got := mock.Calls("POST /backend/nf/create/storyboard"); got != 1 {
+		t.Errorf("Expected 1 storyboard generation, got %d", got)
+	}
+}
+
+func TestStoryRenderer_FailureDoesNotChargeSiblings(t *testing.T) {
+	r, _, _ := newTestStoryRenderer(t, &mocksora.Options{Polls: 1, Scenarios: []mocksora.Scenario{
+		{Match: "crashes", Polls: 3, Fail: "internal error"},
+		{Match: "lands", Polls: 1000},
+	}})
+	r.Configure(2, "")
+
+	job, err := r.Start(&Storyboard{Shots: []StoryboardShot{
+		{Duration: 20, Prompt: "the cat crashes"},
+		{Duration: 10, Prompt: "the cat lands"},
+	}}, "sora2-landscape")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	r.Wait()
+
+	job, _ = r.Job(job.JobID)
+	if job.Status != models.StoryStatusFailed || !strings.Contains(job.ErrorMessage, "片段 1") {
+		t.Errorf("Expected job failed by clip 1, got %s (%s)", job.Status, job.ErrorMessage)
+	}
+	if job.Clips[1].Status != models.StoryClipPending {
+		t.Errorf("Expected the cancelled clip pending, got %s", job.Clips[1].Status)
+	}
+	// Only the failed clip counts against the token
+	tokens, _ := r.db.GetAllTokens()
+	if tokens[0].TotalErrorCount != 1 {
+		t.Errorf("Expected 1 token error, got %d", tokens[0].TotalErrorCount)
+	}
+}
+
+func TestStoryRenderer_FFmpeg(t *testing.T) {
+	r, _, cache := newTestStoryRenderer(t, &mocksora.Options{Polls: 1})
+
+	// A stand-in for ffmpeg recording its arguments and writing the output file
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ffmpeg")
+	os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+filepath.Join(dir, "args")+"\nfor last; do :; done\necho joined > \"$last\"\n"), 0755)
+	r.Configure(1, script)
+
+	job, err := r.Start(&Storyboard{Shots: []StoryboardShot{{Duration: 20, Prompt: "a"}, {Duration: 10, Prompt: "b"}}}, "sora2-portrait-10s")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	r.Wait()
+
+	job, _ = r.Job(job.JobID)
+	if job.Status != models.StoryStatusCompleted {
+		t.Fatalf("Expected completed job, got %s (%s)", job.Status, job.ErrorMessage)
+	}
+	if content, _ := cache.Get(job.ResultFile); string(content) != "joined\n" {
+		t.Errorf("Expected the ffmpeg output cached, got %q", content)
+	}
+	if args, _ := os.ReadFile(filepath.Join(dir, "args")); !strings.Contains(string(args), "-f concat") || !strings.Contains(string(args), "-c copy") {
+		t.Errorf("Unexpected ffmpeg arguments %q", args)
+	}
+}
+
+func TestStoryRenderer_ResumeKeepsGeneratedClips(t *testing.T) {
+	r, mock, _ := newTestStoryRenderer(t, &mocksora.Options{Polls: 1})
+
+	// A job interrupted after its first clip
+	first := &Storyboard{Shots: []StoryboardShot{{Duration: 10, Prompt: "a"}}}
+	result, err := r.generation.GenerateStoryboard(t.Context(), first, "sora2-landscape-10s", false, nil)
+	if err != nil {
+		t.Fatalf("GenerateStoryboard failed: %v", err)
+	}
+	job := &models.StoryJob{JobID: "story_resume", Model: "sora2-landscape", Storyboard: "{}", Status: models.StoryStatusRendering}
+	job.ID, _ = r.db.CreateStoryJob(job)
+	r.db.CreateStoryClip(&models.StoryClip{JobID: job.ID, Index: 0, Model: "sora2-landscape-10s", Storyboard: `{"shots":[{"duration":10,"prompt":"a"}]}`,
+		TaskID: result.TaskID, Status: models.StoryClipCompleted, ResultURL: result.URLs[0]})
+	r.db.CreateStoryClip(&models.StoryClip{JobID: job.ID, Index: 1, Model: "sora2-landscape-10s", Storyboard: `{"shots":[{"duration":10,"prompt":"b"}]}`,
+		Status: models.StoryClipProcessing})
+
+	if resumed, err := r.Resume(); err != nil || resumed != 1 {
+		t.Fatalf("Expected 1 resumed job, got %d (%v)", resumed, err)
+	}
+	r.Wait()
+
+	job, _ = r.Job("story_resume")
+	if job.Status != models.StoryStatusCompleted {
+		t.Fatalf("Expected completed job, got %s (%s)", job.Status, job.ErrorMessage)
+	}
+	if job.Clips[0].TaskID != result.TaskID {
+		t.Errorf("Expected the generated clip kept, got task %s", job.Clips[0].TaskID)
+	}
+	if got := mock.Calls("POST /backend/nf/create/storyboard"); got != 2 {
+		t.Errorf("Expected only the missing clip generated, got %d generations", got)
+	}
+}
+
+func TestStoryRenderer_DrainSkipsJoin(t *testing.T) {
+	r, _, _ := newTestStoryRenderer(t, &mocksora.Options{Polls: 1})
+
+	// A job whose clips are all generated, resumed while the server drains
+	job := &models.StoryJob{JobID: "story_drain", Model: "sora2-landscape", Storyboard: "{}", Status: models.StoryStatusConcatting}
+	job.ID, _ = r.db.CreateStoryJob(job)
+	r.db.CreateStoryClip(&models.StoryClip{JobID: job.ID, Index: 0, Model: "sora2-landscape-10s", Storyboard: "{}",
+		Status: models.StoryClipCompleted, ResultURL: "http://localhost:1/clip.mp4"})
+
+	r.generation.BeginDrain()
+	if resumed, err := r.Resume(); err != nil || resumed != 1 {
+		t.Fatalf("Expected 1 resumed job, got %d (%v)", resumed, err)
+	}
+	r.Wait()
+
+	job, _ = r.Job("story_drain")
+	if job.Status == models.StoryStatusFailed || job.Status == models.StoryStatusCompleted {
+		t.Errorf("Expected the job left unfinished for the next start, got %s (%s)", job.Status, job.ErrorMessage)
+	}
+}
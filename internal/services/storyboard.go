@@ -0,0 +1,168 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StoryboardFPS is the frame rate Sora counts storyboard durations in
+const StoryboardFPS = 30
+
+// DefaultStoryboardTitle is the title Sora gives new storyboard drafts
+const DefaultStoryboardTitle = "Draft your video"
+
+// StoryboardShot is one shot of a storyboard
+type StoryboardShot struct {
+	Duration       float64  `json:"duration"`                  // Seconds
+	Prompt         string   `json:"prompt"`                    // Scene description
+	ReferenceImage string   `json:"reference_image,omitempty"` // Sora upload ID of an image starting the shot
+	Characters     []string `json:"characters,omitempty"`      // Usernames of characters appearing in the shot
+}
+
+// Storyboard is a video described shot by shot
+type Storyboard struct {
+	Title        string           `json:"title,omitempty"`
+	Instructions string           `json:"instructions,omitempty"` // Applies to every shot
+	Shots        []StoryboardShot `json:"shots"`
+}
+
+// storyboardShotPattern matches one [5.0s]scene of a bracket storyboard prompt
+var storyboardShotPattern = regexp.MustCompile(`\[(\d+(?:\.\d+)?)s\]\s*([^\[]+)`)
+
+// ParseStoryboardPrompt converts a bracket storyboard prompt, e.g.
+// "猫猫的奇妙冒险\n[5.0s]猫猫从飞机上跳伞 [5.0s]猫猫降落", into a Storyboard.
+// Text before the first shot becomes the instructions.
+func ParseStoryboardPrompt(prompt string) *Storyboard {
+	sb := &Storyboard{}
+	if i := strings.Index(prompt, "["); i > 0 {
+		sb.Instructions = strings.TrimSpace(prompt[:i])
+	}
+	for _, m := range storyboardShotPattern.FindAllStringSubmatch(prompt, -1) {
+		duration, _ := strconv.ParseFloat(m[1], 64)
+		sb.Shots = append(sb.Shots, StoryboardShot{Duration: duration, Prompt: strings.TrimSpace(m[2])})
+	}
+	return sb
+}
+
+// storyboardError returns the error of an invalid storyboard, a client error
+func storyboardError(format string, args ...interface{}) *SoraError {
+	return &SoraError{Kind: SoraErrorInvalidRequest, Code: "invalid_storyboard", Message: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks the storyboard for a video of nFrames frames: every shot needs
+// a prompt and a positive duration, and the durations must fill the video.
+// The error is a *SoraError of kind SoraErrorInvalidRequest.
+func (sb *Storyboard) Validate(nFrames int) error {
+	if err := sb.validateShots(); err != nil {
+		return err
+	}
+	if total := sb.Frames(); total != nFrames {
+		return storyboardError("分镜总时长 %s 秒与模型时长 %s 秒不符",
+			formatSeconds(float64(total)/StoryboardFPS), formatSeconds(float64(nFrames)/StoryboardFPS))
+	}
+	return nil
+}
+
+// validateShots checks every shot has a prompt and a positive duration
+func (sb *Storyboard) validateShots() error {
+	if len(sb.Shots) == 0 {
+		return storyboardError("分镜至少需要一个镜头")
+	}
+	for i, shot := range sb.Shots {
+		if strings.TrimSpace(shot.Prompt) == "" {
+			return storyboardError("第 %d 个镜头缺少描述", i+1)
+		}
+		if shot.Duration <= 0 {
+			return storyboardError("第 %d 个镜头的时长必须大于 0", i+1)
+		}
+		if shot.ReferenceImage != "" && strings.Contains(shot.ReferenceImage, ":") {
+			return storyboardError("第 %d 个镜头的参考图需为已上传到 Sora 的图片 ID", i+1)
+		}
+	}
+	return nil
+}
+
+// Prompt renders the storyboard in the timeline format of the storyboard API
+func (sb *Storyboard) Prompt() string {
+	shots := make([]string, len(sb.Shots))
+	for i, shot := range sb.Shots {
+		shots[i] = fmt.Sprintf("Shot %d:\nduration: %ssec\nScene: %s", i+1, formatSeconds(shot.Duration), strings.TrimSpace(shot.Prompt))
+	}
+	timeline := strings.Join(shots, "\n\n")
+
+	if instructions := strings.TrimSpace(sb.Instructions); instructions != "" {
+		return fmt.Sprintf("current timeline:\n%s\n\ninstructions:\n%s", timeline, instructions)
+	}
+	return timeline
+}
+
+// Characters returns the usernames of the characters in the shots, including
+// those @mentioned in the prompts, without duplicates
+func (sb *Storyboard) Characters() []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	add := func(username string) {
+		username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		if key := strings.ToLower(username); username != "" && !seen[key] {
+			seen[key] = true
+			usernames = append(usernames, username)
+		}
+	}
+	for _, shot := range sb.Shots {
+		for _, username := range shot.Characters {
+			add(username)
+		}
+		for _, username := range ParseMentions(shot.Prompt) {
+			add(username)
+		}
+	}
+	return usernames
+}
+
+// Frames returns the length of the shots in frames
+func (sb *Storyboard) Frames() int {
+	total := 0
+	for _, shot := range sb.Shots {
+		total += shotFrames(shot.Duration)
+	}
+	return total
+}
+
+// Fill extends the last shot so the shots fill a video of nFrames frames.
+// Storyboards as long or longer are left unchanged.
+func (sb *Storyboard) Fill(nFrames int) {
+	if len(sb.Shots) == 0 {
+		return
+	}
+	if total := sb.Frames(); total < nFrames {
+		sb.Shots[len(sb.Shots)-1].Duration += float64(nFrames-total) / StoryboardFPS
+	}
+}
+
+// ClipFrames returns the shortest video length Sora generates holding frames,
+// or the longest one
+func ClipFrames(frames int) int {
+	for _, length := range storyClipFrames {
+		if length >= frames {
+			return length
+		}
+	}
+	return storyClipFrames[len(storyClipFrames)-1]
+}
+
+// shotFrames converts a shot duration to frames
+func shotFrames(duration float64) int {
+	return int(math.Round(duration * StoryboardFPS))
+}
+
+// formatSeconds formats seconds with at least one decimal, e.g. 5.0 or 2.25
+func formatSeconds(seconds float64) string {
+	s := strconv.FormatFloat(seconds, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+func TestParseStoryboardPrompt(t *testing.T) {
+	sb := ParseStoryboardPrompt("猫猫的奇妙冒险\n[5.0s]猫猫从飞机上跳伞 [5.0s]猫猫降落")
+	if sb.Instructions != "猫猫的奇妙冒险" {
+		t.Errorf("Expected instructions, got %q", sb.Instructions)
+	}
+	want := []StoryboardShot{{Duration: 5, Prompt: "猫猫从飞机上跳伞"}, {Duration: 5, Prompt: "猫猫降落"}}
+	if !reflect.DeepEqual(sb.Shots, want) {
+		t.Errorf("Expected shots %+v, got %+v", want, sb.Shots)
+	}
+
+	expected := "current timeline:\nShot 1:\nduration: 5.0sec\nScene: 猫猫从飞机上跳伞\n\nShot 2:\nduration: 5.0sec\nScene: 猫猫降落\n\ninstructions:\n猫猫的奇妙冒险"
+	if got := sb.Prompt(); got != expected {
+		t.Errorf("Prompt() = %q, want %q", got, expected)
+	}
+	if got := FormatStoryboardPrompt("猫猫的奇妙冒险\n[5.0s]猫猫从飞机上跳伞 [5.0s]猫猫降落"); got != expected {
+		t.Errorf("FormatStoryboardPrompt() = %q, want %q", got, expected)
+	}
+	if got := FormatStoryboardPrompt("a cat"); got != "a cat" {
+		t.Errorf("Expected plain prompts unchanged, got %q", got)
+	}
+}
+
+func TestStoryboard_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		shots []StoryboardShot
+		want  string
+	}{
+		{"valid", []StoryboardShot{{Duration: 2.5, Prompt: "a"}, {Duration: 7.5, Prompt: "b"}}, ""},
+		{"no shots", nil, "至少需要一个镜头"},
+		{"missing prompt", []StoryboardShot{{Duration: 10, Prompt: " "}}, "第 1 个镜头缺少描述"},
+		{"zero duration", []StoryboardShot{{Duration: 10, Prompt: "a"}, {Prompt: "b"}}, "第 2 个镜头的时长"},
+		{"image URL", []StoryboardShot{{Duration: 10, Prompt: "a", ReferenceImage: "https://example.com/a.png"}}, "图片 ID"},
+		{"too short", []StoryboardShot{{Duration: 4, Prompt: "a"}, {Duration: 5, Prompt: "b"}}, "分镜总时长 9.0 秒与模型时长 10.0 秒不符"},
+	}
+	for _, tt := range tests {
+		err := (&Storyboard{Shots: tt.shots}).Validate(300)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		var soraErr *SoraError
+		if !errors.As(err, &soraErr) || soraErr.Kind != SoraErrorInvalidRequest || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected invalid request error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestStoryboard_Fill(t *testing.T) {
+	sb := &Storyboard{Shots: []StoryboardShot{{Duration: 2.5, Prompt: "a"}, {Duration: 2, Prompt: "b"}}}
+	nFrames := ClipFrames(sb.Frames())
+	if nFrames != 300 {
+		t.Fatalf("Expected a 10s video, got %d frames", nFrames)
+	}
+	sb.Fill(nFrames)
+	if sb.Shots[1].Duration != 7.5 || sb.Validate(nFrames) != nil {
+		t.Errorf("Expected the last shot extended to 7.5s, got %+v", sb.Shots)
+	}
+	if got := ClipFrames(600); got != 750 {
+		t.Errorf("Expected a 20s storyboard in a 25s video, got %d frames", got)
+	}
+}
+
+func TestStoryboard_Characters(t *testing.T) {
+	sb := &Storyboard{Shots: []StoryboardShot{
+		{Prompt: "@kitty jumps", Characters: []string{"@doggo"}},
+		{Prompt: "@Kitty lands next to @doggo", Characters: []string{"bird"}},
+	}}
+	if got := sb.Characters(); !reflect.DeepEqual(got, []string{"doggo", "kitty", "bird"}) {
+		t.Errorf("Expected [doggo kitty bird], got %v", got)
+	}
+}
+
+func TestBuildStructuredStoryboardPayload(t *testing.T) {
+	client := NewSoraClient("https://example.com", 120, nil)
+	sb := &Storyboard{Title: "Cats", Shots: []StoryboardShot{
+		{Duration: 2.5, Prompt: "a", ReferenceImage: "media_1"},
+		{Duration: 5, Prompt: "b"},
+		{Duration: 2.5, Prompt: "c", ReferenceImage: "media_2"},
+	}}
+	payload := client.BuildStructuredStoryboardPayload(sb, "landscape", 300, []string{"cameo_1"})
+
+	if payload["title"] != "Cats" || payload["n_frames"] != 300 {
+		t.Errorf("Unexpected payload %+v", payload)
+	}
+	items := payload["inpaint_items"].([]map[string]interface{})
+	if len(items) != 2 || items[0]["frame_index"] != 0 || items[1]["frame_index"] != 225 || items[1]["upload_id"] != "media_2" {
+		t.Errorf("Unexpected inpaint items %+v", items)
+	}
+	if !reflect.DeepEqual(payload["cameo_ids"], []string{"cameo_1"}) {
+		t.Errorf("Expected cameo IDs, got %v", payload["cameo_ids"])
+	}
+}
+
+func TestGenerationHandler_GenerateStoryboard(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	token := &models.Token{Token: "at_owner", Email: "owner@example.com", IsActive: true, VideoEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, mock := newMockSora(t, &mocksora.Options{Polls: 1})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 10 * time.Millisecond,
+	}, client)
+	db.CreateCharacter(&models.Character{CameoID: "cameo_kitty", Username: "kitty", Visibility: models.CharacterVisibilityPrivate, Status: models.CharacterStatusFinalized, TokenID: token.ID})
+
+	sb := &Storyboard{Shots: []StoryboardShot{
+		{Duration: 5, Prompt: "a cat jumps", Characters: []string{"kitty"}},
+		{Duration: 5, Prompt: "the cat lands"},
+	}}
+	result, err := h.GenerateStoryboard(context.Background(), sb, "sora-video-10s", false, nil)
+	if err != nil {
+		t.Fatalf("GenerateStoryboard failed: %v", err)
+	}
+	if len(result.URLs) == 0 {
+		t.Error("Expected video URLs")
+	}
+	if got := mock.TaskCameos(result.TaskID); !reflect.DeepEqual(got, []string{"cameo_kitty"}) {
+		t.Errorf("Expected cameo IDs [cameo_kitty], got %v", got)
+	}
+	if got := mock.Calls("POST /backend/nf/create/storyboard"); got != 1 {
+		t.Errorf("Expected 1 storyboard request, got %d", got)
+	}
+
+	// Invalid storyboards never reach Sora
+	var soraErr *SoraError
+	if _, err := h.GenerateStoryboard(context.Background(), sb, "sora-video-15s", false, nil); !errors.As(err, &soraErr) || soraErr.Kind != SoraErrorInvalidRequest {
+		t.Errorf("Expected duration mismatch, got %v", err)
+	}
+	if _, err := h.GenerateStoryboard(context.Background(), sb, "sora-image", false, nil); err == nil || !strings.Contains(err.Error(), "视频模型") {
+		t.Errorf("Expected video model error, got %v", err)
+	}
+	if got := mock.Calls("POST /backend/nf/create/storyboard"); got != 1 {
+		t.Errorf("Expected rejected storyboards to create no tasks, got %d requests", got)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Task event types
+const (
+	TaskEventStatus   = "status"
+	TaskEventProgress = "progress"
+	TaskEventDone     = "done"
+	TaskEventError    = "error"
+)
+
+// taskSubscriberBuffer is the per-subscriber event buffer; slow viewers drop intermediate progress
+const taskSubscriberBuffer = 32
+
+// TaskEvent is a progress or status update of a generation task
+type TaskEvent struct {
+	TaskID   string    `json:"task_id"`
+	Type     string    `json:"type"` // status, progress, done, error
+	Status   string    `json:"status"`
+	Progress float64   `json:"progress"`
+	Message  string    `json:"message,omitempty"`
+	URLs     []string  `json:"urls,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// IsTerminal reports whether this is the last event of the task
+func (e TaskEvent) IsTerminal() bool {
+	return e.Type == TaskEventDone || e.Type == TaskEventError
+}
+
+// TaskHub is an in-process pub/sub of task events so several viewers can watch one task
+type TaskHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan TaskEvent]struct{}
+	last map[string]TaskEvent
+}
+
+// NewTaskHub creates a new task hub
+func NewTaskHub() *TaskHub {
+	return &TaskHub{
+		subs: make(map[string]map[chan TaskEvent]struct{}),
+		last: make(map[string]TaskEvent),
+	}
+}
+
+// Subscribe registers a subscriber for the task. The returned snapshot is the latest
+// event published so far (ok is false if none). Call unsubscribe when done.
+func (h *TaskHub) Subscribe(taskID string) (events <-chan TaskEvent, snapshot TaskEvent, ok bool, unsubscribe func()) {
+	ch := make(chan TaskEvent, taskSubscriberBuffer)
+
+	h.mu.Lock()
+	if h.subs[taskID] == nil {
+		h.subs[taskID] = make(map[chan TaskEvent]struct{})
+	}
+	h.subs[taskID][ch] = struct{}{}
+	snapshot, ok = h.last[taskID]
+	h.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe = func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs[taskID], ch)
+			if len(h.subs[taskID]) == 0 {
+				delete(h.subs, taskID)
+			}
+			h.mu.Unlock()
+		})
+	}
+	return ch, snapshot, ok, unsubscribe
+}
+
+// Publish sends the event to all subscribers of the task. Terminal events forget the task.
+func (h *TaskHub) Publish(event TaskEvent) {
+	if h == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if event.IsTerminal() {
+		delete(h.last, event.TaskID)
+	} else {
+		h.last[event.TaskID] = event
+	}
+
+	for ch := range h.subs[event.TaskID] {
+		select {
+		case ch <- event:
+		default:
+			if event.IsTerminal() {
+				// Make room so the final event is never lost
+				select {
+				case <-ch:
+				default:
+				}
+				select {
+				case ch <- event:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// SubscriberCount returns the number of subscribers watching the task
+func (h *TaskHub) SubscriberCount(taskID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[taskID])
+}
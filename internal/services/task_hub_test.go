@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestTaskHub_MultipleSubscribers(t *testing.T) {
+	hub := NewTaskHub()
+
+	ch1, _, _, unsub1 := hub.Subscribe("task_1")
+	defer unsub1()
+	ch2, _, _, unsub2 := hub.Subscribe("task_1")
+	defer unsub2()
+	other, _, _, unsubOther := hub.Subscribe("task_2")
+	defer unsubOther()
+
+	hub.Publish(TaskEvent{TaskID: "task_1", Type: TaskEventProgress, Progress: 50})
+
+	for i, ch := range []<-chan TaskEvent{ch1, ch2} {
+		select {
+		case e := <-ch:
+			if e.Progress != 50 {
+				t.Errorf("Subscriber %d: expected progress 50, got %v", i, e.Progress)
+			}
+		default:
+			t.Errorf("Subscriber %d: expected an event", i)
+		}
+	}
+	if len(other) != 0 {
+		t.Error("Expected subscriber of another task to receive nothing")
+	}
+}
+
+func TestTaskHub_Snapshot(t *testing.T) {
+	hub := NewTaskHub()
+
+	hub.Publish(TaskEvent{TaskID: "task_1", Type: TaskEventProgress, Progress: 30})
+
+	_, snapshot, ok, unsub := hub.Subscribe("task_1")
+	unsub()
+	if !ok || snapshot.Progress != 30 {
+		t.Errorf("Expected snapshot with progress 30, got %+v (ok=%v)", snapshot, ok)
+	}
+
+	// Terminal events clear the snapshot
+	hub.Publish(TaskEvent{TaskID: "task_1", Type: TaskEventDone})
+	_, _, ok, unsub = hub.Subscribe("task_1")
+	unsub()
+	if ok {
+		t.Error("Expected no snapshot after terminal event")
+	}
+}
+
+func TestTaskHub_TerminalEventNotDropped(t *testing.T) {
+	hub := NewTaskHub()
+
+	ch, _, _, unsub := hub.Subscribe("task_1")
+	defer unsub()
+
+	// Fill the buffer without reading
+	for i := 0; i < taskSubscriberBuffer+5; i++ {
+		hub.Publish(TaskEvent{TaskID: "task_1", Type: TaskEventProgress, Progress: float64(i)})
+	}
+	hub.Publish(TaskEvent{TaskID: "task_1", Type: TaskEventDone})
+
+	var last TaskEvent
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	if !last.IsTerminal() {
+		t.Errorf("Expected last event to be terminal, got %+v", last)
+	}
+}
+
+func TestTaskHub_Unsubscribe(t *testing.T) {
+	hub := NewTaskHub()
+
+	_, _, _, unsub := hub.Subscribe("task_1")
+	if hub.SubscriberCount("task_1") != 1 {
+		t.Fatalf("Expected 1 subscriber, got %d", hub.SubscriberCount("task_1"))
+	}
+	unsub()
+	unsub() // idempotent
+	if hub.SubscriberCount("task_1") != 0 {
+		t.Errorf("Expected 0 subscribers, got %d", hub.SubscriberCount("task_1"))
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultPollLimit is how many recent tasks are fetched per status request
+const defaultPollLimit = 20
+
+// TaskPollerStats reports how many status requests the poller shared
+type TaskPollerStats struct {
+	Tokens   int   `json:"tokens"`   // Tokens with tasks being polled
+	Watching int   `json:"watching"` // Tasks being polled
+	Lookups  int64 `json:"lookups"`  // Task status lookups
+	Fetches  int64 `json:"fetches"`  // Upstream requests made for them
+}
+
+// pollSnapshot is one fetch of a token's recent_tasks or pending list
+type pollSnapshot struct {
+	done      chan struct{} // Closed when the fetch finished
+	fetchedAt time.Time
+	recent    []map[string]interface{}
+	pending   []PendingTask
+	err       error
+}
+
+// pollGroup holds the latest snapshots of a token
+type pollGroup struct {
+	watchers int
+	recent   *pollSnapshot
+	pending  *pollSnapshot
+}
+
+// fetching reports whether a fetch of the group is in flight
+func (g *pollGroup) fetching() bool {
+	for _, s := range []*pollSnapshot{g.recent, g.pending} {
+		if s == nil {
+			continue
+		}
+		select {
+		case <-s.done:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// TaskPoller shares recent_tasks and pending requests between the tasks polled
+// on the same token. A lookup reuses the token's latest snapshot while it is
+// younger than the caller's poll interval, and concurrent lookups share a
+// single fetch, so parallel jobs on one account cost one request per interval.
+type TaskPoller struct {
+	client SoraAPI
+	mu     sync.Mutex
+	groups map[string]*pollGroup
+	now    func() time.Time
+
+	lookups int64
+	fetches int64
+}
+
+// NewTaskPoller creates a poller fetching task status through client
+func NewTaskPoller(client SoraAPI) *TaskPoller {
+	return &TaskPoller{
+		client: client,
+		groups: make(map[string]*pollGroup),
+		now:    time.Now,
+	}
+}
+
+// Watch registers a task polled on the token until release is called. The
+// number of watched tasks sizes the recent_tasks request, so none of them falls
+// off the shared list.
+func (p *TaskPoller) Watch(token string) (release func()) {
+	p.mu.Lock()
+	p.groupLocked(token).watchers++
+	p.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if g, ok := p.groups[token]; ok {
+				g.watchers--
+			}
+			p.pruneLocked()
+		})
+	}
+}
+
+// FindRecentTask finds a task in the token's recent_tasks, fetched at most once per maxAge
+func (p *TaskPoller) FindRecentTask(taskID, token, proxyURL string, maxAge time.Duration) (map[string]interface{}, error) {
+	s := p.load(token, func(g *pollGroup) **pollSnapshot { return &g.recent }, maxAge, func(s *pollSnapshot, limit int) {
+		s.recent, s.err = p.client.GetImageTasks(token, limit, proxyURL)
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+	for _, task := range s.recent {
+		if id, ok := task["id"].(string); ok && id == taskID {
+			return task, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindPendingTask finds a task in the token's pending list, fetched at most once per maxAge
+func (p *TaskPoller) FindPendingTask(taskID, token, proxyURL string, maxAge time.Duration) (*PendingTask, error) {
+	s := p.load(token, func(g *pollGroup) **pollSnapshot { return &g.pending }, maxAge, func(s *pollSnapshot, _ int) {
+		s.pending, s.err = p.client.GetPendingTasks(token, proxyURL)
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+	for i := range s.pending {
+		if s.pending[i].ID == taskID {
+			return &s.pending[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// load returns a snapshot of the token no older than maxAge, waiting for the
+// fetch in flight or starting one. Failed fetches are shared by their waiters
+// but never reused.
+func (p *TaskPoller) load(token string, slot func(*pollGroup) **pollSnapshot, maxAge time.Duration, fetch func(s *pollSnapshot, limit int)) *pollSnapshot {
+	p.mu.Lock()
+	p.lookups++
+	g := p.groupLocked(token)
+	ref := slot(g)
+	if s := *ref; s != nil {
+		select {
+		case <-s.done:
+			if s.err == nil && p.now().Sub(s.fetchedAt) < maxAge {
+				p.mu.Unlock()
+				return s
+			}
+		default:
+			p.mu.Unlock()
+			<-s.done
+			return s
+		}
+	}
+
+	s := &pollSnapshot{done: make(chan struct{})}
+	*ref = s
+	p.fetches++
+	limit := defaultPollLimit
+	if g.watchers > limit {
+		limit = g.watchers
+	}
+	p.mu.Unlock()
+
+	fetch(s, limit)
+	s.fetchedAt = p.now()
+	close(s.done)
+	return s
+}
+
+// groupLocked returns the group of the token, creating it; the caller holds p.mu
+func (p *TaskPoller) groupLocked(token string) *pollGroup {
+	g, ok := p.groups[token]
+	if !ok {
+		p.pruneLocked()
+		g = &pollGroup{}
+		p.groups[token] = g
+	}
+	return g
+}
+
+// pruneLocked drops groups without watched tasks or fetches in flight; the caller holds p.mu
+func (p *TaskPoller) pruneLocked() {
+	for token, g := range p.groups {
+		if g.watchers <= 0 && !g.fetching() {
+			delete(p.groups, token)
+		}
+	}
+}
+
+// Stats returns the poller's metrics
+func (p *TaskPoller) Stats() TaskPollerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := TaskPollerStats{Lookups: p.lookups, Fetches: p.fetches}
+	for _, g := range p.groups {
+		if g.watchers > 0 {
+			stats.Tokens++
+			stats.Watching += g.watchers
+		}
+	}
+	return stats
+}
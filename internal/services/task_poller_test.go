@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"soranow/internal/mocksora"
+	"soranow/internal/models"
+)
+
+func TestTaskPoller_SharesSnapshots(t *testing.T) {
+	client, mock := newMockSora(t, &mocksora.Options{Polls: 5})
+	poller := NewTaskPoller(client)
+
+	var ids []string
+	for _, prompt := range []string{"a cat", "a dog", "a fox"} {
+		id, err := client.GenerateVideo(prompt, "at_test", "landscape", "", 300, "", "", "", "")
+		if err != nil {
+			t.Fatalf("GenerateVideo failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		task, err := poller.FindRecentTask(id, "at_test", "", time.Minute)
+		if err != nil || task == nil {
+			t.Fatalf("Expected task %s in the shared snapshot, got %v, %v", id, task, err)
+		}
+	}
+	if calls := mock.Calls("GET /backend/v2/recent_tasks"); calls != 1 {
+		t.Errorf("Expected 1 recent_tasks request for 3 tasks, got %d", calls)
+	}
+
+	// A snapshot older than the caller's interval is refetched
+	poller.FindRecentTask(ids[0], "at_test", "", 0)
+	if calls := mock.Calls("GET /backend/v2/recent_tasks"); calls != 2 {
+		t.Errorf("Expected stale snapshot to be refetched, got %d requests", calls)
+	}
+
+	for _, id := range ids {
+		if task, err := poller.FindPendingTask(id, "at_test", "", time.Minute); err != nil || task == nil {
+			t.Fatalf("Expected task %s in the pending snapshot, got %v, %v", id, task, err)
+		}
+	}
+	if calls := mock.Calls("GET /backend/nf/pending/v2"); calls != 1 {
+		t.Errorf("Expected 1 pending request for 3 tasks, got %d", calls)
+	}
+
+	if stats := poller.Stats(); stats.Lookups != 7 || stats.Fetches != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestTaskPoller_WatchSizesLimit(t *testing.T) {
+	client, _ := newMockSora(t, nil)
+	poller := NewTaskPoller(client)
+
+	var releases []func()
+	for i := 0; i < defaultPollLimit+5; i++ {
+		releases = append(releases, poller.Watch("at_test"))
+	}
+	poller.Watch("at_other")()
+	if stats := poller.Stats(); stats.Tokens != 1 || stats.Watching != defaultPollLimit+5 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	for _, release := range releases {
+		release()
+		release() // Releasing twice is harmless
+	}
+	if stats := poller.Stats(); stats.Tokens != 0 || stats.Watching != 0 {
+		t.Errorf("Expected no watched tasks after release, got %+v", stats)
+	}
+}
+
+func TestGenerationHandler_ParallelTasksSharePolls(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	token := &models.Token{Token: "at_mock", Email: "a@example.com", IsActive: true, ImageEnabled: true, VideoEnabled: true}
+	token.ID, _ = db.CreateToken(token)
+	lb := NewLoadBalancer()
+	lb.SetTokens([]*models.Token{token})
+
+	client, mock := newMockSora(t, &mocksora.Options{Polls: 2})
+	h := NewGenerationHandler(db, lb, NewTokenManager(db, lb, nil), &GenerationConfig{
+		ImageTimeout: 10, VideoTimeout: 10, PollInterval: 50 * time.Millisecond,
+	}, client)
+
+	const jobs = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, jobs)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := h.Generate(context.Background(), "a cat", "sora-image", false, nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+	}
+
+	if polls := mock.Calls("GET /backend/v2/recent_tasks"); polls >= jobs*2 {
+		t.Errorf("Expected parallel tasks to share polls, got %d requests for %d tasks", polls, jobs)
+	}
+}
@@ -7,12 +7,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	http2 "github.com/bogdanfinn/fhttp"
-	tls_client "github.com/bogdanfinn/tls-client"
-	"github.com/bogdanfinn/tls-client/profiles"
 	"soranow/internal/database"
+	"soranow/internal/models"
 )
 
 const (
@@ -30,21 +30,16 @@ type TokenManager struct {
 	loadBalancer *LoadBalancer
 	concurrency  *ConcurrencyManager
 	httpClient   *http.Client
-	tlsClient    tls_client.HttpClient
+	sessions     *SessionManager
+	ownsSessions bool // sessions was created by this manager
+	clock        *Clock
+	webhooks     *WebhookDispatcher
+	proxyManager *ProxyManager
+	baseURL      atomic.Value // string; Sora backend for token tests
 }
 
 // NewTokenManager creates a new token manager
 func NewTokenManager(db *database.DB, lb *LoadBalancer, cm *ConcurrencyManager) *TokenManager {
-	// Create TLS client with Firefox profile (bypasses Cloudflare better)
-	jar := tls_client.NewCookieJar()
-	options := []tls_client.HttpClientOption{
-		tls_client.WithTimeoutSeconds(30),
-		tls_client.WithClientProfile(profiles.Firefox_132),
-		tls_client.WithNotFollowRedirects(),
-		tls_client.WithCookieJar(jar),
-	}
-	tlsClient, _ := tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
-
 	return &TokenManager{
 		db:           db,
 		loadBalancer: lb,
@@ -52,18 +47,81 @@ func NewTokenManager(db *database.DB, lb *LoadBalancer, cm *ConcurrencyManager)
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		tlsClient: tlsClient,
+		// TLS clients with Firefox profile (bypasses Cloudflare better), one per credential
+		sessions:     NewSessionManager(30),
+		ownsSessions: true,
+	}
+}
+
+// SetSessionManager shares a session manager, so a token uses the same TLS session
+// (cookies and proxy) for account management as for generation
+func (m *TokenManager) SetSessionManager(sm *SessionManager) {
+	if m.ownsSessions && m.sessions != nil && m.sessions != sm {
+		m.sessions.Stop()
+	}
+	m.sessions = sm
+	m.ownsSessions = false
+}
+
+// SetBaseURL sets the Sora backend used to test tokens (defaults to SoraBackendURL)
+func (m *TokenManager) SetBaseURL(baseURL string) {
+	if baseURL != "" {
+		m.baseURL.Store(strings.TrimRight(baseURL, "/"))
+	}
+}
+
+// backendURL returns the Sora backend used to test tokens
+func (m *TokenManager) backendURL() string {
+	if u, ok := m.baseURL.Load().(string); ok {
+		return u
+	}
+	return SoraBackendURL
+}
+
+// SetClock sets the clock used for daily counters (defaults to DefaultClock)
+func (m *TokenManager) SetClock(c *Clock) {
+	m.clock = c
+}
+
+// SetWebhookDispatcher sets the dispatcher used for token health notifications
+func (m *TokenManager) SetWebhookDispatcher(d *WebhookDispatcher) {
+	m.webhooks = d
+}
+
+// SetProxyManager sets the proxy pool used when no proxy is given
+func (m *TokenManager) SetProxyManager(pm *ProxyManager) {
+	m.proxyManager = pm
+}
+
+// notify sends a token health event to the webhook dispatcher, if configured
+func (m *TokenManager) notify(event string, token *models.Token, reason string) {
+	if m.webhooks == nil {
+		return
 	}
+	m.webhooks.Dispatch(event, TokenWebhookData(token, reason))
 }
 
-// doTLSRequest performs an HTTP request using the TLS client
-func (m *TokenManager) doTLSRequest(method, urlStr string, body string, headers map[string]string, proxyURL string) ([]byte, int, error) {
-	if m.tlsClient == nil {
+// getClock returns the configured clock or the process-wide default
+func (m *TokenManager) getClock() *Clock {
+	if m.clock != nil {
+		return m.clock
+	}
+	return DefaultClock()
+}
+
+// doTLSRequest performs an HTTP request using the TLS session of the credential (access,
+// session or refresh token) that authenticates it
+func (m *TokenManager) doTLSRequest(method, urlStr string, body string, headers map[string]string, proxyURL string, sessionKey string, fp *Fingerprint) ([]byte, int, error) {
+	if m.sessions == nil {
 		return nil, 0, fmt.Errorf("TLS client not initialized")
 	}
 
-	if proxyURL != "" {
-		m.tlsClient.SetProxy(proxyURL)
+	if proxyURL == "" && m.proxyManager != nil {
+		proxyURL = m.proxyManager.GetProxyURL()
+	}
+	tlsClient, err := m.sessions.GetSession(sessionKey, proxyURL, fp)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get session: %w", err)
 	}
 
 	var bodyReader io.Reader
@@ -85,18 +143,7 @@ func (m *TokenManager) doTLSRequest(method, urlStr string, body string, headers
 		"sec-fetch-dest":  {"empty"},
 		"sec-fetch-mode":  {"cors"},
 		"sec-fetch-site":  {"same-origin"},
-		http2.HeaderOrderKey: {
-			"accept",
-			"accept-language",
-			"authorization",
-			"content-type",
-			"origin",
-			"referer",
-			"sec-fetch-dest",
-			"sec-fetch-mode",
-			"sec-fetch-site",
-			"user-agent",
-		},
+		http2.HeaderOrderKey: fp.HeaderOrder,
 	}
 
 	// Override with provided headers
@@ -104,7 +151,9 @@ func (m *TokenManager) doTLSRequest(method, urlStr string, body string, headers
 		req.Header.Set(k, v)
 	}
 
-	resp, err := m.tlsClient.Do(req)
+	start := time.Now()
+	resp, err := tlsClient.Do(req)
+	m.proxyManager.ReportResult(proxyURL, time.Since(start), err)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -153,15 +202,16 @@ type TokenTestResult struct {
 
 // ConvertSTToAT converts Session Token to Access Token
 func (m *TokenManager) ConvertSTToAT(sessionToken string, proxyURL string) (*STToATResult, error) {
+	fp := DefaultFingerprint()
 	headers := map[string]string{
 		"Cookie":     fmt.Sprintf("__Secure-next-auth.session-token=%s", sessionToken),
 		"Accept":     "application/json",
 		"Origin":     "https://sora.chatgpt.com",
 		"Referer":    "https://sora.chatgpt.com/",
-		"User-Agent": MobileUserAgents[0],
+		"User-Agent": fp.AppUserAgent(sessionToken),
 	}
 
-	body, statusCode, err := m.doTLSRequest("GET", SoraSessionURL, "", headers, proxyURL)
+	body, statusCode, err := m.doTLSRequest("GET", SoraSessionURL, "", headers, proxyURL, sessionToken, fp)
 	if err != nil {
 		return &STToATResult{Success: false, Message: fmt.Sprintf("请求失败: %v", err)}, err
 	}
@@ -208,12 +258,13 @@ func (m *TokenManager) ConvertRTToAT(refreshToken string, clientID string, proxy
 	formData.Set("redirect_uri", DefaultRedirectURI)
 	formData.Set("refresh_token", refreshToken)
 
+	fp := DefaultFingerprint()
 	headers := map[string]string{
 		"Content-Type": "application/x-www-form-urlencoded",
-		"User-Agent":   MobileUserAgents[0],
+		"User-Agent":   fp.AppUserAgent(refreshToken),
 	}
 
-	body, statusCode, err := m.doTLSRequest("POST", OpenAIOAuthURL, formData.Encode(), headers, proxyURL)
+	body, statusCode, err := m.doTLSRequest("POST", OpenAIOAuthURL, formData.Encode(), headers, proxyURL, refreshToken, fp)
 	if err != nil {
 		return &RTToATResult{Success: false, Message: fmt.Sprintf("请求失败: %v", err)}, err
 	}
@@ -257,28 +308,34 @@ func (m *TokenManager) TestToken(tokenID int64, proxyURL string) (*TokenTestResu
 		return &TokenTestResult{Success: false, Status: "error", Message: "Token 不存在"}, err
 	}
 
-	// Use token's proxy if no global proxy
+	// Use the token's own or bound proxy unless one is given
 	effectiveProxy := proxyURL
-	if effectiveProxy == "" && token.ProxyURL != "" {
-		effectiveProxy = token.ProxyURL
+	if effectiveProxy == "" {
+		effectiveProxy = m.proxyManager.ProxyForToken(token)
 	}
 
+	fp := FingerprintForToken(token)
+	backendURL := m.backendURL()
 	headers := map[string]string{
 		"Authorization": "Bearer " + token.Token,
-		"User-Agent":    MobileUserAgents[0],
+		"User-Agent":    fp.AppUserAgent(token.Token),
 		"Accept":        "application/json",
 	}
 
 	// Test by getting user info from /me endpoint
-	body, statusCode, err := m.doTLSRequest("GET", SoraBackendURL+"/me", "", headers, effectiveProxy)
+	body, statusCode, err := m.doTLSRequest("GET", backendURL+"/me", "", headers, effectiveProxy, token.Token, fp)
 	if err != nil {
 		return &TokenTestResult{Success: false, Status: "error", Message: fmt.Sprintf("请求失败: %v", err)}, err
 	}
 
 	if statusCode == 401 {
 		// Token is invalid/expired
+		wasExpired := token.IsExpired
 		token.IsExpired = true
 		m.db.UpdateToken(token)
+		if !wasExpired {
+			m.notify(models.WebhookEventTokenExpired, token, "401 Unauthorized")
+		}
 		return &TokenTestResult{Success: false, Status: "expired", Message: "Token 已过期或无效"}, nil
 	}
 
@@ -302,7 +359,7 @@ func (m *TokenManager) TestToken(tokenID int64, proxyURL string) (*TokenTestResu
 	planTitle := ""
 	subscriptionEnd := ""
 
-	subBody, subStatus, err := m.doTLSRequest("GET", SoraBackendURL+"/billing/subscriptions", "", headers, effectiveProxy)
+	subBody, subStatus, err := m.doTLSRequest("GET", backendURL+"/billing/subscriptions", "", headers, effectiveProxy, token.Token, fp)
 	if err == nil && subStatus == 200 {
 		var subInfo map[string]interface{}
 		if json.Unmarshal(subBody, &subInfo) == nil {
@@ -324,7 +381,7 @@ func (m *TokenManager) TestToken(tokenID int64, proxyURL string) (*TokenTestResu
 	sora2Used := 0
 	sora2Remaining := 0
 
-	sora2Body, sora2Status, err := m.doTLSRequest("GET", SoraBackendURL+"/project_y/invite/mine", "", headers, effectiveProxy)
+	sora2Body, sora2Status, err := m.doTLSRequest("GET", backendURL+"/project_y/invite/mine", "", headers, effectiveProxy, token.Token, fp)
 	if err == nil && sora2Status == 200 {
 		var sora2Info map[string]interface{}
 		if json.Unmarshal(sora2Body, &sora2Info) == nil {
@@ -341,7 +398,7 @@ func (m *TokenManager) TestToken(tokenID int64, proxyURL string) (*TokenTestResu
 	}
 
 	// Get remaining count
-	checkBody, checkStatus, err := m.doTLSRequest("GET", SoraBackendURL+"/nf/check", "", headers, effectiveProxy)
+	checkBody, checkStatus, err := m.doTLSRequest("GET", backendURL+"/nf/check", "", headers, effectiveProxy, token.Token, fp)
 	if err == nil && checkStatus == 200 {
 		var checkInfo map[string]interface{}
 		if json.Unmarshal(checkBody, &checkInfo) == nil {
@@ -381,15 +438,8 @@ func (m *TokenManager) RecordUsage(tokenID int64, isVideo bool) error {
 		return err
 	}
 
-	today := time.Now().Format("2006-01-02")
-
-	// Reset daily counters if new day
-	if token.TodayDate != today {
-		token.TodayDate = today
-		token.TodayImageCount = 0
-		token.TodayVideoCount = 0
-		token.TodayErrorCount = 0
-	}
+	clock := m.getClock()
+	resetDailyCounters(token, clock.Today())
 
 	if isVideo {
 		token.TotalVideoCount++
@@ -399,7 +449,7 @@ func (m *TokenManager) RecordUsage(tokenID int64, isVideo bool) error {
 		token.TodayImageCount++
 	}
 
-	now := time.Now()
+	now := clock.Now()
 	token.LastUsedAt = &now
 
 	return m.db.UpdateToken(token)
@@ -412,26 +462,76 @@ func (m *TokenManager) RecordError(tokenID int64) error {
 		return err
 	}
 
-	today := time.Now().Format("2006-01-02")
-
-	// Reset daily counters if new day
-	if token.TodayDate != today {
-		token.TodayDate = today
-		token.TodayImageCount = 0
-		token.TodayVideoCount = 0
-		token.TodayErrorCount = 0
-	}
+	clock := m.getClock()
+	resetDailyCounters(token, clock.Today())
 
 	token.TotalErrorCount++
 	token.TodayErrorCount++
 	token.ConsecutiveErrors++
 
-	now := time.Now()
+	now := clock.Now()
 	token.LastErrorAt = &now
 
 	return m.db.UpdateToken(token)
 }
 
+// rateLimitCooldown is how long a rate-limited token rests when Sora gives no Retry-After
+const rateLimitCooldown = time.Minute
+
+// RecordSoraError updates a token's health after a failed Sora request or task.
+// Content policy rejections are the prompt's fault and do not count against the
+// token; a used-up quota cools it down until the quota resets, a rate limit for
+// the Retry-After period, and a rejected access token disables it when
+// auto_disable_on_401 is on.
+func (m *TokenManager) RecordSoraError(tokenID int64, err error) error {
+	soraErr, ok := AsSoraError(err)
+	if !ok {
+		return m.RecordError(tokenID)
+	}
+
+	switch soraErr.Kind {
+	case SoraErrorContentPolicy:
+		return nil
+	case SoraErrorQuota:
+		duration := soraErr.RetryAfter
+		if duration <= 0 {
+			now := m.getClock().Now()
+			duration = m.getClock().NextMidnight(now).Sub(now)
+		}
+		m.RecordError(tokenID)
+		return m.MarkQuotaExhausted(tokenID, duration)
+	case SoraErrorRateLimit:
+		duration := soraErr.RetryAfter
+		if duration <= 0 {
+			duration = rateLimitCooldown
+		}
+		m.RecordError(tokenID)
+		return m.CooldownToken(tokenID, duration)
+	case SoraErrorAuth:
+		m.RecordError(tokenID)
+		if cfg, err := m.db.GetSystemConfig(); err == nil && !cfg.AutoDisable401 {
+			return nil
+		}
+		return m.disableToken(tokenID, "auth failed: "+soraErr.Message)
+	}
+	return m.RecordError(tokenID)
+}
+
+// resetDailyCounters resets today's counters if the token's date is not today
+func resetDailyCounters(token *models.Token, today string) {
+	if token.TodayDate != today {
+		token.TodayDate = today
+		token.TodayImageCount = 0
+		token.TodayVideoCount = 0
+		token.TodayErrorCount = 0
+	}
+}
+
+// ResetDailyCounters resets today's counters for all tokens when the day rolls over
+func (m *TokenManager) ResetDailyCounters() (int64, error) {
+	return m.db.ResetDailyCounters(m.getClock().Today())
+}
+
 // RecordSuccess records a successful operation and resets consecutive errors
 func (m *TokenManager) RecordSuccess(tokenID int64, isVideo bool) error {
 	token, err := m.db.GetTokenByID(tokenID)
@@ -446,11 +546,17 @@ func (m *TokenManager) RecordSuccess(tokenID int64, isVideo bool) error {
 
 // DisableToken disables a token
 func (m *TokenManager) DisableToken(tokenID int64) error {
+	return m.disableToken(tokenID, "manual")
+}
+
+// disableToken disables a token, notifying webhooks with the reason
+func (m *TokenManager) disableToken(tokenID int64, reason string) error {
 	token, err := m.db.GetTokenByID(tokenID)
 	if err != nil {
 		return err
 	}
 
+	wasActive := token.IsActive
 	token.IsActive = false
 
 	if err := m.db.UpdateToken(token); err != nil {
@@ -460,6 +566,10 @@ func (m *TokenManager) DisableToken(tokenID int64) error {
 	// Refresh load balancer
 	m.RefreshLoadBalancer()
 
+	if wasActive {
+		m.notify(models.WebhookEventTokenDisabled, token, reason)
+	}
+
 	return nil
 }
 
@@ -500,6 +610,29 @@ func (m *TokenManager) CooldownToken(tokenID int64, duration time.Duration) erro
 	// Refresh load balancer
 	m.RefreshLoadBalancer()
 
+	m.notify(models.WebhookEventTokenCooled, token, fmt.Sprintf("cooldown %v", duration))
+
+	return nil
+}
+
+// MarkQuotaExhausted cools a token down after the upstream reports its quota is used up
+func (m *TokenManager) MarkQuotaExhausted(tokenID int64, duration time.Duration) error {
+	token, err := m.db.GetTokenByID(tokenID)
+	if err != nil {
+		return err
+	}
+
+	cooldownUntil := time.Now().Add(duration)
+	token.CooledUntil = &cooldownUntil
+
+	if err := m.db.UpdateToken(token); err != nil {
+		return err
+	}
+
+	m.RefreshLoadBalancer()
+
+	m.notify(models.WebhookEventQuotaExhausted, token, "quota exhausted")
+
 	return nil
 }
 
@@ -561,6 +694,7 @@ func (m *TokenManager) CheckAndDisableErrorTokens(threshold int) (int, error) {
 			token.IsActive = false
 			if err := m.db.UpdateToken(token); err == nil {
 				disabled++
+				m.notify(models.WebhookEventTokenDisabled, token, fmt.Sprintf("%d consecutive errors", token.ConsecutiveErrors))
 			}
 		}
 	}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -215,3 +216,109 @@ func TestTokenManager_RefreshLoadBalancer(t *testing.T) {
 		t.Errorf("Expected 2 active tokens in load balancer, got %d", lb.GetTokenCount())
 	}
 }
+
+func TestTokenManager_RecordUsage_DayRollover(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	manager := NewTokenManager(db, NewLoadBalancer(), NewConcurrencyManager())
+
+	clock := NewClock(8)
+	current := time.Date(2026, 1, 1, 15, 0, 0, 0, time.UTC) // 23:00 in UTC+8
+	clock.now = func() time.Time { return current }
+	manager.SetClock(clock)
+
+	id, _ := db.CreateToken(&models.Token{Token: "test_token", Email: "test@example.com", IsActive: true})
+
+	manager.RecordUsage(id, false)
+	updated, _ := db.GetTokenByID(id)
+	if updated.TodayDate != "2026-01-01" || updated.TodayImageCount != 1 {
+		t.Fatalf("Expected 1 image on 2026-01-01, got %d on %s", updated.TodayImageCount, updated.TodayDate)
+	}
+
+	// 01:00 next day in UTC+8, still Jan 1 in UTC
+	current = time.Date(2026, 1, 1, 17, 0, 0, 0, time.UTC)
+	manager.RecordUsage(id, false)
+	updated, _ = db.GetTokenByID(id)
+	if updated.TodayDate != "2026-01-02" {
+		t.Errorf("Expected today_date 2026-01-02, got %s", updated.TodayDate)
+	}
+	if updated.TodayImageCount != 1 {
+		t.Errorf("Expected TodayImageCount reset to 1, got %d", updated.TodayImageCount)
+	}
+	if updated.TotalImageCount != 2 {
+		t.Errorf("Expected TotalImageCount 2, got %d", updated.TotalImageCount)
+	}
+
+	// Daily reset job zeroes counters for tokens not used today
+	current = time.Date(2026, 1, 2, 17, 0, 0, 0, time.UTC)
+	reset, err := manager.ResetDailyCounters()
+	if err != nil {
+		t.Fatalf("ResetDailyCounters failed: %v", err)
+	}
+	if reset != 1 {
+		t.Errorf("Expected 1 token reset, got %d", reset)
+	}
+	updated, _ = db.GetTokenByID(id)
+	if updated.TodayImageCount != 0 || updated.TodayDate != "2026-01-03" {
+		t.Errorf("Expected counters reset for 2026-01-03, got %d on %s", updated.TodayImageCount, updated.TodayDate)
+	}
+}
+
+func TestTokenManager_RecordSoraError(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	lb := NewLoadBalancer()
+	manager := NewTokenManager(db, lb, NewConcurrencyManager())
+	count := 0
+	newToken := func() int64 {
+		count++
+		id, _ := db.CreateToken(&models.Token{Token: fmt.Sprintf("at_%d", count), IsActive: true})
+		return id
+	}
+
+	// Content policy rejections do not count against the token
+	id := newToken()
+	manager.RecordSoraError(id, &SoraError{Kind: SoraErrorContentPolicy, Message: "rejected"})
+	if token, _ := db.GetTokenByID(id); token.ConsecutiveErrors != 0 {
+		t.Errorf("Expected no error recorded, got %d", token.ConsecutiveErrors)
+	}
+
+	// Rate limits cool the token down for Retry-After
+	id = newToken()
+	manager.RecordSoraError(id, &SoraError{Kind: SoraErrorRateLimit, StatusCode: 429, RetryAfter: time.Hour})
+	token, _ := db.GetTokenByID(id)
+	if token.ConsecutiveErrors != 1 || token.CooledUntil == nil || time.Until(*token.CooledUntil) < 59*time.Minute {
+		t.Errorf("Expected an hour cooldown, got %+v", token.CooledUntil)
+	}
+
+	// Quota exhaustion cools the token down until the quota resets
+	id = newToken()
+	manager.RecordSoraError(id, &SoraError{Kind: SoraErrorQuota, StatusCode: 429})
+	if token, _ := db.GetTokenByID(id); token.CooledUntil == nil {
+		t.Error("Expected quota cooldown")
+	}
+
+	// Rejected access tokens are disabled
+	id = newToken()
+	manager.RecordSoraError(id, &SoraError{Kind: SoraErrorAuth, StatusCode: 401, Message: "Unauthorized"})
+	if token, _ := db.GetTokenByID(id); token.IsActive {
+		t.Error("Expected token to be disabled")
+	}
+	cfg, _ := db.GetSystemConfig()
+	cfg.AutoDisable401 = false
+	db.UpdateSystemConfig(cfg)
+	id = newToken()
+	manager.RecordSoraError(id, &SoraError{Kind: SoraErrorAuth, StatusCode: 401, Message: "Unauthorized"})
+	if token, _ := db.GetTokenByID(id); !token.IsActive {
+		t.Error("Expected token to stay active with auto_disable_on_401 off")
+	}
+
+	// Other errors are recorded as before
+	id = newToken()
+	manager.RecordSoraError(id, fmt.Errorf("network down"))
+	if token, _ := db.GetTokenByID(id); token.ConsecutiveErrors != 1 || !token.IsActive {
+		t.Errorf("Expected a recorded error, got %d", token.ConsecutiveErrors)
+	}
+}
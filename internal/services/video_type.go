@@ -0,0 +1,60 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Character video container types accepted by Sora
+const (
+	VideoTypeMP4       = "video/mp4"
+	VideoTypeQuickTime = "video/quicktime"
+	VideoTypeWebM      = "video/webm"
+)
+
+// VideoSniffLen is how many leading bytes SniffVideoType needs to look at
+const VideoSniffLen = 64
+
+// ErrUnsupportedVideo is returned for data that is not an MP4, MOV or WebM video
+var ErrUnsupportedVideo = errors.New("unsupported video format, expected mp4, mov or webm")
+
+// ebmlMagic starts every Matroska and WebM file
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// mp4VideoBrands are the ftyp major brands of MP4 videos; other ISO base media
+// files, e.g. HEIF images (heic) or audio (M4A ), are rejected
+var mp4VideoBrands = map[string]bool{
+	"isom": true, "iso2": true, "iso3": true, "iso4": true, "iso5": true, "iso6": true,
+	"mp41": true, "mp42": true, "avc1": true, "M4V ": true, "dash": true,
+}
+
+// SniffVideoType returns the content type of a video from its leading bytes
+func SniffVideoType(header []byte) (string, error) {
+	if len(header) > VideoSniffLen {
+		header = header[:VideoSniffLen]
+	}
+
+	// ISO base media files (MP4, MOV) start with a box: 4-byte size then the type
+	if len(header) >= 12 {
+		switch string(header[4:8]) {
+		case "ftyp":
+			brand := string(header[8:12])
+			if brand == "qt  " {
+				return VideoTypeQuickTime, nil
+			}
+			if mp4VideoBrands[brand] {
+				return VideoTypeMP4, nil
+			}
+			return "", ErrUnsupportedVideo
+		case "moov", "mdat", "wide", "free", "skip":
+			// Older QuickTime files have no ftyp box
+			return VideoTypeQuickTime, nil
+		}
+	}
+
+	// WebM is Matroska with the "webm" DocType in the EBML header
+	if bytes.HasPrefix(header, ebmlMagic) && bytes.Contains(header, []byte("webm")) {
+		return VideoTypeWebM, nil
+	}
+	return "", ErrUnsupportedVideo
+}
@@ -0,0 +1,28 @@
+package services
+
+import "testing"
+
+func TestSniffVideoType(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), VideoTypeMP4},
+		{"m4v", []byte("\x00\x00\x00\x1cftypM4V \x00\x00\x00\x01"), VideoTypeMP4},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), ""},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00"), ""},
+		{"mov", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"), VideoTypeQuickTime},
+		{"legacy mov", []byte("\x00\x00\x00\x08wide\x00\x00\x00\x00"), VideoTypeQuickTime},
+		{"webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), VideoTypeWebM},
+		{"matroska", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x88matroska"), ""},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), ""},
+		{"short", []byte("ftyp"), ""},
+	}
+	for _, tt := range tests {
+		got, err := SniffVideoType(tt.header)
+		if got != tt.want || (tt.want == "") != (err == ErrUnsupportedVideo) {
+			t.Errorf("%s: SniffVideoType = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -6,16 +6,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // WatermarkRemover handles watermark removal from videos
 type WatermarkRemover struct {
+	mu               sync.RWMutex
 	parseMethod      string
 	customParseURL   string
 	customParseToken string
 	fallbackEnabled  bool
 	httpClient       *http.Client
+	proxyManager     *ProxyManager
 }
 
 // NewWatermarkRemover creates a new watermark remover
@@ -31,9 +34,34 @@ func NewWatermarkRemover(parseMethod, customParseURL, customParseToken string, f
 	}
 }
 
+// Configure replaces the parse settings
+func (w *WatermarkRemover) Configure(parseMethod, customParseURL, customParseToken string, fallbackEnabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.parseMethod = parseMethod
+	w.customParseURL = customParseURL
+	w.customParseToken = customParseToken
+	w.fallbackEnabled = fallbackEnabled
+}
+
+// SetProxyManager routes parse requests through the proxy pool
+func (w *WatermarkRemover) SetProxyManager(pm *ProxyManager) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.proxyManager = pm
+}
+
+// settings returns a consistent copy of the parse settings
+func (w *WatermarkRemover) settings() (parseMethod, customParseURL, customParseToken string, fallbackEnabled bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.parseMethod, w.customParseURL, w.customParseToken, w.fallbackEnabled
+}
+
 // IsEnabled returns whether watermark removal is enabled
 func (w *WatermarkRemover) IsEnabled() bool {
-	return w.parseMethod != "" && w.customParseURL != ""
+	parseMethod, customParseURL, _, _ := w.settings()
+	return parseMethod != "" && customParseURL != ""
 }
 
 // RemoveWatermark removes watermark from a video URL
@@ -42,7 +70,8 @@ func (w *WatermarkRemover) RemoveWatermark(videoURL string) (string, error) {
 		return videoURL, nil
 	}
 
-	switch w.parseMethod {
+	parseMethod, _, _, _ := w.settings()
+	switch parseMethod {
 	case "third_party":
 		return w.removeWatermarkThirdParty(videoURL)
 	default:
@@ -52,6 +81,8 @@ func (w *WatermarkRemover) RemoveWatermark(videoURL string) (string, error) {
 
 // removeWatermarkThirdParty uses third-party service to remove watermark
 func (w *WatermarkRemover) removeWatermarkThirdParty(videoURL string) (string, error) {
+	_, customParseURL, customParseToken, _ := w.settings()
+
 	// Prepare request body
 	reqBody := map[string]string{
 		"url": videoURL,
@@ -62,18 +93,33 @@ func (w *WatermarkRemover) removeWatermarkThirdParty(videoURL string) (string, e
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", w.customParseURL, bytes.NewReader(jsonBody))
+	req, err := http.NewRequest("POST", customParseURL, bytes.NewReader(jsonBody))
 	if err != nil {
 		return w.handleFallback(videoURL, err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if w.customParseToken != "" {
-		req.Header.Set("Authorization", "Bearer "+w.customParseToken)
+	if customParseToken != "" {
+		req.Header.Set("Authorization", "Bearer "+customParseToken)
+	}
+
+	// Send request, through the proxy pool if configured
+	w.mu.RLock()
+	client, pm := w.httpClient, w.proxyManager
+	w.mu.RUnlock()
+
+	proxyURL := pm.GetProxyURL()
+	if proxyURL != "" {
+		transport, err := pm.Transport(proxyURL)
+		if err != nil {
+			return w.handleFallback(videoURL, err)
+		}
+		client = &http.Client{Timeout: client.Timeout, Transport: transport}
 	}
 
-	// Send request
-	resp, err := w.httpClient.Do(req)
+	start := time.Now()
+	resp, err := client.Do(req)
+	pm.ReportResult(proxyURL, time.Since(start), err)
 	if err != nil {
 		return w.handleFallback(videoURL, err)
 	}
@@ -111,7 +157,7 @@ func (w *WatermarkRemover) removeWatermarkThirdParty(videoURL string) (string, e
 
 // handleFallback handles errors with optional fallback to original URL
 func (w *WatermarkRemover) handleFallback(originalURL string, err error) (string, error) {
-	if w.fallbackEnabled {
+	if _, _, _, fallbackEnabled := w.settings(); fallbackEnabled {
 		return originalURL, nil
 	}
 	return "", fmt.Errorf("watermark removal failed: %w", err)
@@ -126,5 +172,7 @@ func (w *WatermarkRemover) ParseVideoURL(url string) string {
 
 // SetHTTPClient sets a custom HTTP client (useful for testing)
 func (w *WatermarkRemover) SetHTTPClient(client *http.Client) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.httpClient = client
 }
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"soranow/internal/database"
+	"soranow/internal/models"
+)
+
+// Webhook request headers
+const (
+	WebhookHeaderEvent     = "X-Soranow-Event"
+	WebhookHeaderDelivery  = "X-Soranow-Delivery"
+	WebhookHeaderTimestamp = "X-Soranow-Timestamp"
+	WebhookHeaderSignature = "X-Soranow-Signature"
+)
+
+const (
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookBackoff     = 2 * time.Second
+	defaultWebhookTimeout     = 10 * time.Second
+	maxWebhookResponseBody    = 2048
+)
+
+// WebhookPayload is the JSON body posted to webhook receivers
+type WebhookPayload struct {
+	ID        string                 `json:"id"`
+	Event     string                 `json:"event"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// WebhookDispatcher delivers events to the configured webhooks
+type WebhookDispatcher struct {
+	db *database.DB
+	wg sync.WaitGroup
+
+	mu          sync.RWMutex
+	httpClient  *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewWebhookDispatcher creates a new webhook dispatcher
+func NewWebhookDispatcher(db *database.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db: db,
+		httpClient: &http.Client{
+			Timeout: defaultWebhookTimeout,
+		},
+		maxAttempts: defaultWebhookMaxAttempts,
+		backoff:     defaultWebhookBackoff,
+	}
+}
+
+// SetHTTPClient sets the HTTP client used for deliveries
+func (d *WebhookDispatcher) SetHTTPClient(client *http.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.httpClient = client
+}
+
+// SetRetryPolicy sets the maximum attempts and the base backoff (doubled after each failure)
+func (d *WebhookDispatcher) SetRetryPolicy(maxAttempts int, backoff time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if maxAttempts > 0 {
+		d.maxAttempts = maxAttempts
+	}
+	if backoff >= 0 {
+		d.backoff = backoff
+	}
+}
+
+// SignWebhookPayload computes the hex HMAC-SHA256 of "timestamp.body" with the secret
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks a signature header value ("sha256=<hex>") against the body
+func VerifyWebhookSignature(secret string, timestamp int64, body []byte, signature string) bool {
+	expected := "sha256=" + SignWebhookPayload(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// Dispatch sends the event to all active webhooks subscribed to it in the background
+func (d *WebhookDispatcher) Dispatch(event string, data map[string]interface{}) {
+	if d == nil {
+		return
+	}
+
+	hooks, err := d.db.GetActiveWebhooks()
+	if err != nil {
+		log.Printf("[Webhook] Failed to load webhooks: %v", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !hook.Accepts(event) {
+			continue
+		}
+		payload := d.newPayload(event, data)
+		d.wg.Add(1)
+		go func(hook *models.Webhook) {
+			defer d.wg.Done()
+			d.Deliver(hook, payload)
+		}(hook)
+	}
+}
+
+// SendTest delivers a single test event synchronously without retries
+func (d *WebhookDispatcher) SendTest(hook *models.Webhook) *models.WebhookDelivery {
+	payload := d.newPayload(models.WebhookEventTest, map[string]interface{}{
+		"webhook_id": hook.ID,
+		"message":    "This is a test webhook delivery",
+	})
+	return d.attempt(hook, payload, 1)
+}
+
+// Deliver posts the payload to the webhook, retrying with exponential backoff.
+// Network errors, 429 and 5xx responses are retried; other 4xx responses are not.
+func (d *WebhookDispatcher) Deliver(hook *models.Webhook, payload *WebhookPayload) *models.WebhookDelivery {
+	var delivery *models.WebhookDelivery
+	d.mu.RLock()
+	maxAttempts, backoff := d.maxAttempts, d.backoff
+	d.mu.RUnlock()
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		delivery = d.attempt(hook, payload, attempt)
+		if delivery.Success || !isRetryableWebhookStatus(delivery.StatusCode) {
+			return delivery
+		}
+		if attempt < maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	log.Printf("[Webhook] Delivery %s of %s to %s failed after %d attempts", payload.ID, payload.Event, hook.URL, maxAttempts)
+	return delivery
+}
+
+// Wait blocks until all in-flight deliveries have finished
+func (d *WebhookDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *WebhookDispatcher) newPayload(event string, data map[string]interface{}) *WebhookPayload {
+	return &WebhookPayload{
+		ID:        uuid.New().String(),
+		Event:     event,
+		CreatedAt: DefaultClock().Now(),
+		Data:      data,
+	}
+}
+
+// attempt performs a single delivery and records it in the delivery log
+func (d *WebhookDispatcher) attempt(hook *models.Webhook, payload *WebhookPayload, attempt int) *models.WebhookDelivery {
+	body, _ := json.Marshal(payload)
+	delivery := &models.WebhookDelivery{
+		WebhookID:  hook.ID,
+		DeliveryID: payload.ID,
+		Event:      payload.Event,
+		Payload:    string(body),
+		Attempt:    attempt,
+	}
+
+	start := time.Now()
+	statusCode, respBody, err := d.post(hook, payload, body)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.StatusCode = statusCode
+	delivery.ResponseBody = respBody
+	if err != nil {
+		delivery.Error = err.Error()
+	} else if statusCode < 200 || statusCode >= 300 {
+		delivery.Error = fmt.Sprintf("unexpected status %d", statusCode)
+	} else {
+		delivery.Success = true
+	}
+
+	if id, err := d.db.CreateWebhookDelivery(delivery); err == nil {
+		delivery.ID = id
+	}
+	return delivery
+}
+
+func (d *WebhookDispatcher) post(hook *models.Webhook, payload *WebhookPayload, body []byte) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "soranow-webhook/1.0")
+	req.Header.Set(WebhookHeaderEvent, payload.Event)
+	req.Header.Set(WebhookHeaderDelivery, payload.ID)
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if hook.Secret != "" {
+		req.Header.Set(WebhookHeaderSignature, "sha256="+SignWebhookPayload(hook.Secret, timestamp, body))
+	}
+
+	d.mu.RLock()
+	client := d.httpClient
+	d.mu.RUnlock()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBody))
+	return resp.StatusCode, string(respBody), nil
+}
+
+// isRetryableWebhookStatus reports whether a delivery with this status should be retried
+func isRetryableWebhookStatus(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// TaskWebhookData builds the webhook data for a task event
+func TaskWebhookData(task *models.Task) map[string]interface{} {
+	data := map[string]interface{}{
+		"task_id":  task.TaskID,
+		"token_id": task.TokenID,
+		"model":    task.Model,
+		"prompt":   task.Prompt,
+		"status":   task.Status,
+		"progress": task.Progress,
+	}
+	if task.ResultURLs != "" {
+		var urls []string
+		if json.Unmarshal([]byte(task.ResultURLs), &urls) == nil {
+			data["urls"] = urls
+		}
+	}
+	if task.ErrorMessage != "" {
+		data["error"] = task.ErrorMessage
+	}
+	return data
+}
+
+// TokenWebhookData builds the webhook data for a token health event
+func TokenWebhookData(token *models.Token, reason string) map[string]interface{} {
+	data := map[string]interface{}{
+		"token_id":   token.ID,
+		"email":      token.Email,
+		"name":       token.Name,
+		"is_active":  token.IsActive,
+		"is_expired": token.IsExpired,
+		"reason":     reason,
+	}
+	if token.CooledUntil != nil {
+		data["cooled_until"] = token.CooledUntil
+	}
+	return data
+}
+
+// CharacterWebhookData builds the webhook data for a character event
+func CharacterWebhookData(char *models.Character) map[string]interface{} {
+	data := map[string]interface{}{
+		"character_id": char.ID,
+		"cameo_id":     char.CameoID,
+		"username":     char.Username,
+		"token_id":     char.TokenID,
+		"status":       char.Status,
+	}
+	if char.CharacterID != "" {
+		data["sora_character_id"] = char.CharacterID
+	}
+	if char.ProfileURL != "" {
+		data["profile_url"] = char.ProfileURL
+	}
+	if char.ErrorMessage != "" {
+		data["error"] = char.ErrorMessage
+	}
+	return data
+}
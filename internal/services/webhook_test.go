@@ -0,0 +1,191 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"soranow/internal/models"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"event":"task.completed"}`)
+	sig := SignWebhookPayload("secret", 1700000000, body)
+
+	if len(sig) != 64 {
+		t.Errorf("Expected 64 hex chars, got %d", len(sig))
+	}
+	if !VerifyWebhookSignature("secret", 1700000000, body, "sha256="+sig) {
+		t.Error("Expected signature to verify")
+	}
+	if VerifyWebhookSignature("other", 1700000000, body, "sha256="+sig) {
+		t.Error("Expected signature with wrong secret to fail")
+	}
+	if VerifyWebhookSignature("secret", 1700000001, body, "sha256="+sig) {
+		t.Error("Expected signature with wrong timestamp to fail")
+	}
+}
+
+func TestWebhook_Accepts(t *testing.T) {
+	all := &models.Webhook{}
+	if !all.Accepts(models.WebhookEventTaskFailed) {
+		t.Error("Expected empty filter to accept all events")
+	}
+
+	filtered := &models.Webhook{Events: []string{models.WebhookEventTaskCompleted}}
+	if !filtered.Accepts(models.WebhookEventTaskCompleted) {
+		t.Error("Expected subscribed event to be accepted")
+	}
+	if filtered.Accepts(models.WebhookEventTokenDisabled) {
+		t.Error("Expected unsubscribed event to be rejected")
+	}
+	if !filtered.Accepts(models.WebhookEventTest) {
+		t.Error("Expected test event to always be accepted")
+	}
+}
+
+func TestWebhookDispatcher_DispatchSigned(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	received := make(chan *http.Request, 1)
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedBody, _ = io.ReadAll(r.Body)
+		received <- r
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	hookID, _ := db.CreateWebhook(&models.Webhook{URL: server.URL, Secret: "s3cret", IsActive: true,
+		Events: []string{models.WebhookEventTaskCompleted}})
+	db.CreateWebhook(&models.Webhook{URL: server.URL, IsActive: true, Events: []string{models.WebhookEventTokenDisabled}})
+
+	d := NewWebhookDispatcher(db)
+	d.Dispatch(models.WebhookEventTaskCompleted, map[string]interface{}{"task_id": "task_1"})
+	d.Wait()
+
+	var req *http.Request
+	select {
+	case req = <-received:
+	default:
+		t.Fatal("Expected webhook to be delivered")
+	}
+	if len(received) != 0 {
+		t.Error("Expected only the subscribed webhook to be called")
+	}
+
+	if req.Header.Get(WebhookHeaderEvent) != models.WebhookEventTaskCompleted {
+		t.Errorf("Expected event header, got '%s'", req.Header.Get(WebhookHeaderEvent))
+	}
+	ts, _ := strconv.ParseInt(req.Header.Get(WebhookHeaderTimestamp), 10, 64)
+	if !VerifyWebhookSignature("s3cret", ts, receivedBody, req.Header.Get(WebhookHeaderSignature)) {
+		t.Error("Expected valid signature")
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(receivedBody, &payload); err != nil {
+		t.Fatalf("Failed to parse payload: %v", err)
+	}
+	if payload.Data["task_id"] != "task_1" {
+		t.Errorf("Expected task_id 'task_1', got %v", payload.Data["task_id"])
+	}
+
+	deliveries, _ := db.GetWebhookDeliveries(hookID, 10)
+	if len(deliveries) != 1 || !deliveries[0].Success {
+		t.Errorf("Expected 1 successful delivery, got %+v", deliveries)
+	}
+}
+
+func TestWebhookDispatcher_RetryOnServerError(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	hook := &models.Webhook{URL: server.URL, IsActive: true}
+	hook.ID, _ = db.CreateWebhook(hook)
+
+	d := NewWebhookDispatcher(db)
+	d.SetRetryPolicy(5, 0)
+
+	delivery := d.Deliver(hook, d.newPayload(models.WebhookEventTaskFailed, nil))
+	if !delivery.Success {
+		t.Errorf("Expected eventual success, got %+v", delivery)
+	}
+	if delivery.Attempt != 3 {
+		t.Errorf("Expected success on attempt 3, got %d", delivery.Attempt)
+	}
+
+	deliveries, _ := db.GetWebhookDeliveries(hook.ID, 10)
+	if len(deliveries) != 3 {
+		t.Errorf("Expected 3 logged attempts, got %d", len(deliveries))
+	}
+}
+
+func TestWebhookDispatcher_NoRetryOnClientError(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	hook := &models.Webhook{URL: server.URL, IsActive: true}
+	hook.ID, _ = db.CreateWebhook(hook)
+
+	d := NewWebhookDispatcher(db)
+	d.SetRetryPolicy(5, 0)
+
+	delivery := d.Deliver(hook, d.newPayload(models.WebhookEventTaskFailed, nil))
+	if delivery.Success {
+		t.Error("Expected delivery to fail")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+}
+
+func TestTokenManager_DisableToken_Webhook(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	events := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- r.Header.Get(WebhookHeaderEvent)
+	}))
+	defer server.Close()
+	db.CreateWebhook(&models.Webhook{URL: server.URL, IsActive: true})
+
+	id, _ := db.CreateToken(&models.Token{Token: "t1", Email: "a@example.com", IsActive: true})
+
+	d := NewWebhookDispatcher(db)
+	tm := NewTokenManager(db, nil, nil)
+	tm.SetWebhookDispatcher(d)
+
+	tm.DisableToken(id)
+	tm.DisableToken(id) // already disabled, no second event
+	d.Wait()
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if e := <-events; e != models.WebhookEventTokenDisabled {
+		t.Errorf("Expected '%s', got '%s'", models.WebhookEventTokenDisabled, e)
+	}
+}
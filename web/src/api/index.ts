@@ -394,7 +394,7 @@ export const api = {
 
   // Sync characters from Sora API
   syncCharacters: (tokenId: number) =>
-    request<{ success: boolean; synced: number; total: number }>('/api/characters/sync', {
+    request<{ success: boolean; synced: number; total: number; result: Record<string, unknown> }>('/api/characters/sync', {
       method: 'POST',
       body: JSON.stringify({ token_id: tokenId }),
     }),